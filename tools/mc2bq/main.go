@@ -16,14 +16,19 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/export"
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/telemetry"
 )
 
 type cliAction string
@@ -34,12 +39,34 @@ const (
 	actionDumpSchema  = "dump-schema"
 	actionExport      = "export"
 	actionExitFailure = "exit"
+	// actionDescribeSchema is also the name of the command, its flags are
+	// parsed by parseDescribeSchemaFlags.
+	actionDescribeSchema = "describe-schema"
 )
 
+// stringList is a flag.Value that collects the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func parseFlags(params *export.Params, argv []string) (cliAction, error) {
+	if len(argv) > 0 && argv[0] == actionDescribeSchema {
+		return actionDescribeSchema, nil
+	}
+
 	var schemaPath string
 	var fs flag.FlagSet
 
+	// The output is set first so the errors of the flags are printed in
+	// the requested format, see main.
+	params.Output, _ = messages.ParseOutput(os.Getenv("MC2BQ_OUTPUT"))
 	params.DatasetID = os.Getenv("MC2BQ_DATASET")
 	params.TablePrefix = os.Getenv("MC2BQ_TABLE_PREFIX")
 
@@ -52,14 +79,92 @@ func parseFlags(params *export.Params, argv []string) (cliAction, error) {
 		defaultRegion = gcloudRegion
 	}
 
+	// set default asset view from env
+	defaultAssetView := string(export.AssetViewFull)
+	if envAssetView := os.Getenv("MC2BQ_ASSET_VIEW"); envAssetView != "" {
+		defaultAssetView = envAssetView
+	}
+
+	// set default mode from env
+	defaultMode := string(export.ModeFull)
+	if envMode := os.Getenv("MC2BQ_MODE"); envMode != "" {
+		defaultMode = envMode
+	}
+
+	// set default output format from env
+	defaultOutputFormat := string(export.OutputFormatNDJSON)
+	if envFormat := os.Getenv("MC2BQ_OUTPUT_FORMAT"); envFormat != "" {
+		defaultOutputFormat = envFormat
+	}
+
+	// set default write method from env
+	defaultWriteMethod := string(export.WriteMethodLoad)
+	if envMethod := os.Getenv("MC2BQ_WRITE_METHOD"); envMethod != "" {
+		defaultWriteMethod = envMethod
+	}
+
 	// set default project from env
 	params.ProjectID = os.Getenv("PROJECT")
 	if projectFromEnv := os.Getenv("MC2BQ_PROJECT"); projectFromEnv != "" {
 		params.ProjectID = projectFromEnv
 	}
 
+	// set default project concurrency from env
+	defaultProjectConcurrency := export.DefaultProjectConcurrency
+	if envConcurrency := os.Getenv("MC2BQ_PROJECT_CONCURRENCY"); envConcurrency != "" {
+		concurrency, err := strconv.Atoi(envConcurrency)
+		if err != nil {
+			return actionInvalid, fmt.Errorf("MC2BQ_PROJECT_CONCURRENCY: %w", err)
+		}
+		defaultProjectConcurrency = concurrency
+	}
+
+	// set default asset shards from env
+	defaultAssetShards := 1
+	if envShards := os.Getenv("MC2BQ_ASSET_SHARDS"); envShards != "" {
+		shards, err := strconv.Atoi(envShards)
+		if err != nil {
+			return actionInvalid, fmt.Errorf("MC2BQ_ASSET_SHARDS: %w", err)
+		}
+		defaultAssetShards = shards
+	}
+
+	// set default snapshot retention from env
+	defaultRetentionDays := 0
+	if envRetention := os.Getenv("MC2BQ_SNAPSHOT_RETENTION_DAYS"); envRetention != "" {
+		days, err := strconv.Atoi(envRetention)
+		if err != nil {
+			return actionInvalid, fmt.Errorf("MC2BQ_SNAPSHOT_RETENTION_DAYS: %w", err)
+		}
+		defaultRetentionDays = days
+	}
+
+	// set default table expiration from env
+	defaultExpirationDays := 0
+	if envExpiration := os.Getenv("MC2BQ_TABLE_EXPIRATION_DAYS"); envExpiration != "" {
+		days, err := strconv.Atoi(envExpiration)
+		if err != nil {
+			return actionInvalid, fmt.Errorf("MC2BQ_TABLE_EXPIRATION_DAYS: %w", err)
+		}
+		defaultExpirationDays = days
+	}
+
+	// set default reconcile tolerance from env
+	defaultReconcileTolerance := 0.0
+	if envTolerance := os.Getenv("MC2BQ_RECONCILE_TOLERANCE"); envTolerance != "" {
+		tolerance, err := strconv.ParseFloat(envTolerance, 64)
+		if err != nil {
+			return actionInvalid, fmt.Errorf("MC2BQ_RECONCILE_TOLERANCE: %w", err)
+		}
+		defaultReconcileTolerance = tolerance
+	}
+
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [FLAGS...] <PROJECT> <DATASET> [TABLE-PREFIX]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [FLAGS...] -project <PROJECT>... <DATASET> [TABLE-PREFIX]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [FLAGS...] -output-dir <DIR> <PROJECT> [TABLE-PREFIX]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [FLAGS...] -output-db <URL> <PROJECT> [TABLE-PREFIX]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s %s [FLAGS...]\n", os.Args[0], actionDescribeSchema)
 		fmt.Fprintln(os.Stderr, messages.ExportCmdDescription.String())
 		fmt.Fprintln(os.Stderr, "")
 		fs.PrintDefaults()
@@ -69,11 +174,116 @@ func parseFlags(params *export.Params, argv []string) (cliAction, error) {
 		"target-project",
 		"",
 		messages.ParamDescriptionTargetProject.String())
+	var projects stringList
+	fs.Var(
+		&projects,
+		"project",
+		messages.ParamDescriptionProject.String())
+	var projectsPath string
+	fs.StringVar(
+		&projectsPath,
+		"projects-file",
+		os.Getenv("MC2BQ_PROJECTS_FILE"),
+		messages.ParamDescriptionProjectsFile.String())
+	fs.IntVar(
+		&params.ProjectConcurrency,
+		"project-concurrency",
+		defaultProjectConcurrency,
+		messages.ParamDescriptionProjectConcurrency.String())
 	fs.StringVar(
 		&params.Region,
 		"region",
 		defaultRegion,
 		messages.ParamDescriptionRegion.String())
+	var assetView string
+	fs.StringVar(
+		&assetView,
+		"asset-view",
+		defaultAssetView,
+		messages.ParamDescriptionAssetView.String())
+	var assetFields string
+	fs.StringVar(
+		&assetFields,
+		"asset-fields",
+		os.Getenv("MC2BQ_ASSET_FIELDS"),
+		messages.ParamDescriptionAssetFields.String())
+	fs.IntVar(
+		&params.AssetShards,
+		"asset-shards",
+		defaultAssetShards,
+		messages.ParamDescriptionAssetShards.String())
+	fs.StringVar(
+		&params.AssetFilter.Expression,
+		"asset-filter",
+		os.Getenv("MC2BQ_ASSET_FILTER"),
+		messages.ParamDescriptionAssetFilter.String())
+	var groups stringList
+	fs.Var(
+		&groups,
+		"group",
+		messages.ParamDescriptionGroup.String())
+	var labels stringList
+	fs.Var(
+		&labels,
+		"label",
+		messages.ParamDescriptionLabel.String())
+	var sources stringList
+	fs.Var(
+		&sources,
+		"source",
+		messages.ParamDescriptionSource.String())
+	var mode string
+	fs.StringVar(
+		&mode,
+		"mode",
+		defaultMode,
+		messages.ParamDescriptionMode.String())
+	var writeMethod string
+	fs.StringVar(
+		&writeMethod,
+		"write-method",
+		defaultWriteMethod,
+		messages.ParamDescriptionWriteMethod.String())
+	var retentionDays int
+	fs.IntVar(
+		&retentionDays,
+		"snapshot-retention-days",
+		defaultRetentionDays,
+		messages.ParamDescriptionSnapshotRetention.String())
+	fs.StringVar(
+		&params.OutputDir,
+		"output-dir",
+		os.Getenv("MC2BQ_OUTPUT_DIR"),
+		messages.ParamDescriptionOutputDir.String())
+	fs.StringVar(
+		&params.OutputDB,
+		"output-db",
+		os.Getenv("MC2BQ_OUTPUT_DB"),
+		messages.ParamDescriptionOutputDB.String())
+	var outputFormat string
+	fs.StringVar(
+		&outputFormat,
+		"output-format",
+		defaultOutputFormat,
+		messages.ParamDescriptionOutputFormat.String())
+	fs.BoolVar(
+		&params.AllRegions,
+		"all-regions",
+		false,
+		messages.ParamDescriptionAllRegions.String(),
+	)
+	fs.BoolVar(
+		&params.DryRun,
+		"dry-run",
+		false,
+		messages.ParamDescriptionDryRun.String(),
+	)
+	fs.BoolVar(
+		&params.Resume,
+		"resume",
+		false,
+		messages.ParamDescriptionResume.String(),
+	)
 	fs.BoolVar(
 		&params.Force,
 		"force",
@@ -86,11 +296,100 @@ func parseFlags(params *export.Params, argv []string) (cliAction, error) {
 		"",
 		messages.ParamDescriptionSchemaPath.String(),
 	)
+	fs.StringVar(
+		&params.Dataset.Location,
+		"dataset-location",
+		os.Getenv("MC2BQ_DATASET_LOCATION"),
+		messages.ParamDescriptionDatasetLocation.String(),
+	)
+	fs.StringVar(
+		&params.Dataset.KMSKeyName,
+		"kms-key",
+		os.Getenv("MC2BQ_KMS_KEY"),
+		messages.ParamDescriptionKMSKey.String(),
+	)
+	var datasetLabels stringList
+	fs.Var(
+		&datasetLabels,
+		"dataset-label",
+		messages.ParamDescriptionDatasetLabel.String(),
+	)
+	var tableLabels stringList
+	fs.Var(
+		&tableLabels,
+		"table-label",
+		messages.ParamDescriptionTableLabel.String(),
+	)
+	var expirationDays int
+	fs.IntVar(
+		&expirationDays,
+		"table-expiration-days",
+		defaultExpirationDays,
+		messages.ParamDescriptionTableExpiration.String(),
+	)
+	fs.BoolVar(
+		&params.Dataset.Update,
+		"update-dataset",
+		false,
+		messages.ParamDescriptionUpdateDataset.String(),
+	)
+	var reconcileMode string
+	fs.StringVar(
+		&reconcileMode,
+		"reconcile",
+		os.Getenv("MC2BQ_RECONCILE"),
+		messages.ParamDescriptionReconcile.String(),
+	)
+	fs.Float64Var(
+		&params.Reconcile.TolerancePercent,
+		"reconcile-tolerance",
+		defaultReconcileTolerance,
+		messages.ParamDescriptionReconcileTolerance.String(),
+	)
+	var reconcileFields string
+	fs.StringVar(
+		&reconcileFields,
+		"reconcile-fields",
+		os.Getenv("MC2BQ_RECONCILE_FIELDS"),
+		messages.ParamDescriptionReconcileFields.String(),
+	)
+	var output string
+	fs.StringVar(
+		&output,
+		"output",
+		os.Getenv("MC2BQ_OUTPUT"),
+		messages.ParamDescriptionOutput.String(),
+	)
+	var tracesExporter string
+	fs.StringVar(
+		&tracesExporter,
+		"traces-exporter",
+		os.Getenv("MC2BQ_TRACES_EXPORTER"),
+		messages.ParamDescriptionTracesExporter.String(),
+	)
+	var metricsExporter string
+	fs.StringVar(
+		&metricsExporter,
+		"metrics-exporter",
+		os.Getenv("MC2BQ_METRICS_EXPORTER"),
+		messages.ParamDescriptionMetricsExporter.String(),
+	)
+	var layoutPath string
+	fs.StringVar(
+		&layoutPath,
+		"table-layout",
+		os.Getenv("MC2BQ_TABLE_LAYOUT"),
+		messages.ParamDescriptionTableLayout.String(),
+	)
 	var versionFlag bool
 	fs.BoolVar(&versionFlag, "version", false, messages.ParamDescriptionVersion.String())
 	var dumpEmbeddedSchemaFlag bool
 	fs.BoolVar(&dumpEmbeddedSchemaFlag, "dump-embedded-schema", false, messages.ParamDescriptionDumpSchema.String())
 	err := fs.Parse(argv)
+	if out, outputErr := messages.ParseOutput(output); outputErr == nil {
+		// The flags before an invalid flag are parsed too.
+		params.Output = out
+	}
 	if err != nil {
 		return actionInvalid, err
 	}
@@ -104,20 +403,53 @@ func parseFlags(params *export.Params, argv []string) (cliAction, error) {
 		return actionDumpSchema, nil
 	}
 
+	if len(projects) == 0 {
+		for _, project := range strings.Split(os.Getenv("MC2BQ_PROJECTS"), ",") {
+			if project = strings.TrimSpace(project); project != "" {
+				projects = append(projects, project)
+			}
+		}
+	}
+	if projectsPath != "" {
+		fileProjects, err := loadProjects(projectsPath)
+		if err != nil {
+			return actionInvalid, err
+		}
+		projects = append(projects, fileProjects...)
+	}
+
 	args := fs.Args()
-	if len(args) > 0 {
-		params.ProjectID = fs.Arg(0)
+	if len(projects) > 0 {
+		// The project is passed via flags so the positional arguments
+		// start at the dataset.
+		params.ProjectID = ""
+		params.ProjectIDs = projects
+		args = append([]string{""}, args...)
+	}
+	if len(args) > 0 && args[0] != "" {
+		params.ProjectID = args[0]
+	}
+	if params.OutputDir != "" || params.OutputDB != "" {
+		// There is no dataset when exporting to files or a database.
+		params.DatasetID = ""
+		if len(args) > 0 {
+			args = append([]string{args[0], ""}, args[1:]...)
+		}
 	}
 	if len(args) > 1 {
-		params.DatasetID = fs.Arg(1)
+		params.DatasetID = args[1]
 	}
 	if len(args) > 2 {
-		params.TablePrefix = fs.Arg(2)
+		params.TablePrefix = args[2]
 	}
 
 	params.Force = params.Force || os.Getenv("MC2BQ_FORCE") != ""
+	params.AllRegions = params.AllRegions || os.Getenv("MC2BQ_ALL_REGIONS") != ""
+	params.Resume = params.Resume || os.Getenv("MC2BQ_RESUME") != ""
+	params.DryRun = params.DryRun || os.Getenv("MC2BQ_DRY_RUN") != ""
+	params.Dataset.Update = params.Dataset.Update || os.Getenv("MC2BQ_UPDATE_DATASET") != ""
 
-	if params.ProjectID == "" || params.DatasetID == "" {
+	if (params.ProjectID == "" && len(params.ProjectIDs) == 0) || (params.DatasetID == "" && params.OutputDir == "" && params.OutputDB == "") {
 		fs.Usage()
 		return actionExitFailure, nil
 	}
@@ -128,6 +460,90 @@ func parseFlags(params *export.Params, argv []string) (cliAction, error) {
 	if params.TargetProjectID == "" {
 		params.TargetProjectID = params.ProjectID
 	}
+	if params.TargetProjectID == "" {
+		params.TargetProjectID = params.ProjectIDs[0]
+	}
+
+	if len(groups) == 0 {
+		groups = envList("MC2BQ_GROUPS")
+	}
+	if len(labels) == 0 {
+		labels = envList("MC2BQ_LABELS")
+	}
+	if len(sources) == 0 {
+		sources = envList("MC2BQ_SOURCES")
+	}
+	for _, field := range strings.Split(assetFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			params.AssetFields = append(params.AssetFields, field)
+		}
+	}
+	params.AssetFilter.Groups = groups
+	params.AssetFilter.Sources = sources
+	params.AssetFilter.Labels, err = export.ParseLabels(labels)
+	if err != nil {
+		return actionInvalid, err
+	}
+
+	if len(datasetLabels) == 0 {
+		datasetLabels = envList("MC2BQ_DATASET_LABELS")
+	}
+	params.Dataset.Labels, err = export.ParseLabels(datasetLabels)
+	if err != nil {
+		return actionInvalid, err
+	}
+	if len(tableLabels) == 0 {
+		tableLabels = envList("MC2BQ_TABLE_LABELS")
+	}
+	params.Dataset.TableLabels, err = export.ParseLabels(tableLabels)
+	if err != nil {
+		return actionInvalid, err
+	}
+	if expirationDays > 0 {
+		params.Dataset.DefaultTableExpiration = time.Duration(expirationDays) * 24 * time.Hour
+	}
+
+	params.AssetView, err = export.ParseAssetView(assetView)
+	if err != nil {
+		return actionInvalid, err
+	}
+
+	params.Mode, err = export.ParseMode(mode)
+	if err != nil {
+		return actionInvalid, err
+	}
+	params.OutputFormat, err = export.ParseOutputFormat(outputFormat)
+	if err != nil {
+		return actionInvalid, err
+	}
+	params.WriteMethod, err = export.ParseWriteMethod(writeMethod)
+	if err != nil {
+		return actionInvalid, err
+	}
+	params.Output, err = messages.ParseOutput(output)
+	if err != nil {
+		return actionInvalid, err
+	}
+	params.Telemetry.Traces, err = telemetry.ParseExporter(tracesExporter)
+	if err != nil {
+		return actionInvalid, err
+	}
+	params.Telemetry.Metrics, err = telemetry.ParseExporter(metricsExporter)
+	if err != nil {
+		return actionInvalid, err
+	}
+	params.Reconcile.Mode, err = export.ParseReconcileMode(reconcileMode)
+	if err != nil {
+		return actionInvalid, err
+	}
+	for _, field := range strings.Split(reconcileFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			params.Reconcile.Fields = append(params.Reconcile.Fields, field)
+		}
+	}
+	if retentionDays > 0 {
+		params.SnapshotRetention = time.Duration(retentionDays) * 24 * time.Hour
+	}
 
 	if schemaPath == "" {
 		schemaPath = os.Getenv("MC2BQ_SCHEMA_PATH")
@@ -136,15 +552,36 @@ func parseFlags(params *export.Params, argv []string) (cliAction, error) {
 	if err != nil {
 		return actionInvalid, err
 	}
+	if layoutPath != "" {
+		params.TableLayouts, err = loadTableLayouts(layoutPath)
+		if err != nil {
+			return actionInvalid, err
+		}
+	}
 
 	return actionExport, nil
 }
 
+// envList returns the comma separated values of the environment variable
+// name.
+func envList(name string) []string {
+	var res []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			res = append(res, value)
+		}
+	}
+	return res
+}
+
 func main() {
 	var params export.Params
 	action, err := parseFlags(&params, os.Args[1:])
+	// Set before any error is printed so the errors of the flags and of
+	// the export are printed in the requested format.
+	messages.SetOutput(params.Output)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", messages.WrapError(messages.ErrorParsingFlags, err))
+		messages.PrintError(messages.WrapError(messages.ErrorParsingFlags, err))
 		os.Exit(1)
 	}
 
@@ -153,10 +590,16 @@ func main() {
 		fmt.Printf("mc2bq %s\n", messages.Version)
 	case actionDumpSchema:
 		_ = dumpEmbeddedSchema()
+	case actionDescribeSchema:
+		err = describeSchema(os.Args[2:])
+		if err != nil {
+			messages.PrintError(err)
+			os.Exit(1)
+		}
 	case actionExport:
 		err = export.Export(&params)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", messages.WrapError(messages.ErrorExportingData, err))
+			messages.PrintError(messages.WrapError(messages.ErrorExportingData, err))
 			os.Exit(1)
 		}
 	case actionExitFailure:
@@ -187,6 +630,107 @@ func loadSchemas(name string) (*schema.ExporterSchema, error) {
 	return &schemas, err
 }
 
+// loadTableLayouts reads the table layouts from the JSON file at name.
+func loadTableLayouts(name string) (export.TableLayouts, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, messages.WrapError(messages.ErrorLoadingTableLayout, err)
+	}
+	defer f.Close()
+
+	var layouts export.TableLayouts
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&layouts)
+	if err != nil {
+		return nil, messages.WrapError(messages.ErrorLoadingTableLayout, err)
+	}
+
+	return layouts, nil
+}
+
+// loadProjects reads the projects from the file at name, one project per line.
+// Empty lines and lines starting with # are ignored.
+func loadProjects(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, messages.WrapError(messages.ErrorLoadingProjects, err)
+	}
+	defer f.Close()
+
+	var projects []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		projects = append(projects, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, messages.WrapError(messages.ErrorLoadingProjects, err)
+	}
+
+	return projects, nil
+}
+
+// describeSchemaOptions are the flags of the describe-schema command.
+type describeSchemaOptions struct {
+	schema *schema.ExporterSchema
+	format schema.DictionaryFormat
+	// output is the format of the errors, it's set even if the flags are
+	// invalid.
+	output messages.Output
+}
+
+func parseDescribeSchemaFlags(argv []string) (*describeSchemaOptions, error) {
+	var fs flag.FlagSet
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [FLAGS...]\n", os.Args[0], actionDescribeSchema)
+		fmt.Fprintln(os.Stderr, messages.DescribeSchemaCmdDescription.String())
+		fmt.Fprintln(os.Stderr, "")
+		fs.PrintDefaults()
+	}
+	var schemaPath, format, output string
+	fs.StringVar(&schemaPath, "schema-path", os.Getenv("MC2BQ_SCHEMA_PATH"), messages.ParamDescriptionSchemaPath.String())
+	fs.StringVar(&format, "format", string(schema.DictionaryFormatMarkdown), messages.ParamDescriptionDictionaryFormat.String())
+	fs.StringVar(&output, "output", os.Getenv("MC2BQ_OUTPUT"), messages.ParamDescriptionOutput.String())
+	err := fs.Parse(argv)
+
+	var opts describeSchemaOptions
+	var outputErr error
+	opts.output, outputErr = messages.ParseOutput(output)
+	if err == nil {
+		err = outputErr
+	}
+	if err != nil {
+		return &opts, messages.WrapError(messages.ErrorParsingFlags, err)
+	}
+	opts.format, err = schema.ParseDictionaryFormat(format)
+	if err != nil {
+		return &opts, messages.WrapError(messages.ErrorParsingFlags, err)
+	}
+	opts.schema, err = loadSchemas(schemaPath)
+	if err != nil {
+		return &opts, err
+	}
+
+	return &opts, nil
+}
+
+// describeSchema prints the data dictionary of the schema selected by the
+// flags of the describe-schema command to stdout.
+func describeSchema(argv []string) error {
+	opts, err := parseDescribeSchemaFlags(argv)
+	// Set before the errors of the flags are returned so main prints
+	// them in the requested format.
+	messages.SetOutput(opts.output)
+	if err != nil {
+		return err
+	}
+	return opts.schema.WithDescriptions().WriteDictionary(os.Stdout, opts.format, "")
+}
+
 func dumpEmbeddedSchema() error {
 	out, err := json.MarshalIndent(&schema.EmbeddedSchema, "", "  ")
 	if err != nil {
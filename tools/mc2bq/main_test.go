@@ -15,9 +15,15 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/export"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/telemetry"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -37,6 +43,118 @@ func defaultParamsDiffOpts() cmp.Option {
 				return s
 			}),
 		),
+		// empty asset view means full
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".AssetView"
+			},
+			cmp.Transformer("default_asset_view", func(v export.AssetView) export.AssetView {
+				if v == "" {
+					return export.AssetViewFull
+				}
+
+				return v
+			}),
+		),
+		// empty mode means full
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".Mode"
+			},
+			cmp.Transformer("default_mode", func(m export.Mode) export.Mode {
+				if m == "" {
+					return export.ModeFull
+				}
+
+				return m
+			}),
+		),
+		// empty reconcile mode means none
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".Mode"
+			},
+			cmp.Transformer("default_reconcile_mode", func(m export.ReconcileMode) export.ReconcileMode {
+				if m == "" {
+					return export.ReconcileNone
+				}
+
+				return m
+			}),
+		),
+		// empty output means text
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".Output"
+			},
+			cmp.Transformer("default_output", func(o messages.Output) messages.Output {
+				if o == "" {
+					return messages.OutputText
+				}
+
+				return o
+			}),
+		),
+		// empty telemetry exporter means none
+		cmp.Transformer("default_telemetry_exporter", func(e telemetry.Exporter) telemetry.Exporter {
+			if e == "" {
+				return telemetry.ExporterNone
+			}
+
+			return e
+		}),
+		// empty output format means ndjson
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".OutputFormat"
+			},
+			cmp.Transformer("default_output_format", func(f export.OutputFormat) export.OutputFormat {
+				if f == "" {
+					return export.OutputFormatNDJSON
+				}
+
+				return f
+			}),
+		),
+		// empty write method means load
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".WriteMethod"
+			},
+			cmp.Transformer("default_write_method", func(m export.WriteMethod) export.WriteMethod {
+				if m == "" {
+					return export.WriteMethodLoad
+				}
+
+				return m
+			}),
+		),
+		// zero project concurrency means default
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".ProjectConcurrency"
+			},
+			cmp.Transformer("default_project_concurrency", func(n int) int {
+				if n == 0 {
+					return export.DefaultProjectConcurrency
+				}
+
+				return n
+			}),
+		),
+		// zero asset shards means a single iterator
+		cmp.FilterPath(
+			func(p cmp.Path) bool {
+				return p.Last().String() == ".AssetShards"
+			},
+			cmp.Transformer("default_asset_shards", func(n int) int {
+				if n == 0 {
+					return 1
+				}
+
+				return n
+			}),
+		),
 		// ignore schema
 		cmp.FilterPath(func(p cmp.Path) bool {
 			return p.Last().String() == ".Schema"
@@ -44,6 +162,131 @@ func defaultParamsDiffOpts() cmp.Option {
 	}
 }
 
+func TestParseFlagsProjectsFile(t *testing.T) {
+	projectsPath := filepath.Join(t.TempDir(), "projects.txt")
+	err := os.WriteFile(projectsPath, []byte("# production\np1\n\n  p2  \n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got export.Params
+	act, err := parseFlags(&got, []string{"-projects-file", projectsPath, "-project", "p0", "dataset"})
+	if err != nil {
+		t.Fatalf("parseFlags(): unexpected error: %v", err)
+	}
+	if act != actionExport {
+		t.Fatalf("parseFlags(): unexpected action want: %q got: %q", actionExport, act)
+	}
+
+	want := export.Params{
+		ProjectIDs:      []string{"p0", "p1", "p2"},
+		TargetProjectID: "p0",
+		DatasetID:       "dataset",
+	}
+	if diff := cmp.Diff(got, want, defaultParamsDiffOpts()); diff != "" {
+		t.Errorf("parseFlags(): diff in params (-want, +got):\n%s", diff)
+	}
+}
+
+func TestParseFlagsTableLayout(t *testing.T) {
+	layoutPath := filepath.Join(t.TempDir(), "layout.json")
+	err := os.WriteFile(layoutPath, []byte(`{
+		"assets": {
+			"partitioning": {"field": "update_time", "type": "MONTH"},
+			"clustering": ["name"],
+			"require_partition_filter": true
+		},
+		"groups": {"clustering": ["name"]}
+	}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got export.Params
+	_, err = parseFlags(&got, []string{"-table-layout", layoutPath, "project", "dataset"})
+	if err != nil {
+		t.Fatalf("parseFlags(): unexpected error: %v", err)
+	}
+	want := export.TableLayouts{
+		"assets": {
+			Partitioning:           &export.TablePartitioning{Field: "update_time", Type: "MONTH"},
+			Clustering:             []string{"name"},
+			RequirePartitionFilter: true,
+		},
+		"groups": {Clustering: []string{"name"}},
+	}
+	if diff := cmp.Diff(want, got.TableLayouts); diff != "" {
+		t.Errorf("parseFlags(): unexpected table layouts (-want, +got):\n%s", diff)
+	}
+
+	// Misspelled options are rejected.
+	err = os.WriteFile(layoutPath, []byte(`{"assets": {"clustring": ["name"]}}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parseFlags(&export.Params{}, []string{"-table-layout", layoutPath, "project", "dataset"})
+	if err == nil {
+		t.Errorf("parseFlags(): succeeded with an unknown layout option")
+	}
+}
+
+func TestParseDescribeSchemaFlags(t *testing.T) {
+	action, err := parseFlags(&export.Params{}, []string{"describe-schema", "-format", "html"})
+	if err != nil || action != actionDescribeSchema {
+		t.Fatalf("parseFlags() = %q, %v, want %q", action, err, actionDescribeSchema)
+	}
+
+	schemaPath := filepath.Join(t.TempDir(), "schema.json")
+	err = os.WriteFile(schemaPath, []byte(`{"asset_table": [{"name": "name", "type": "STRING"}]}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseDescribeSchemaFlags([]string{"-format", "html", "-schema-path", schemaPath})
+	if err != nil {
+		t.Fatalf("parseDescribeSchemaFlags(): unexpected error: %v", err)
+	}
+	if opts.format != schema.DictionaryFormatHTML {
+		t.Errorf("parseDescribeSchemaFlags(): format = %q, want %q", opts.format, schema.DictionaryFormatHTML)
+	}
+	if len(opts.schema.AssetTable) != 1 {
+		t.Errorf("parseDescribeSchemaFlags(): the schema at -schema-path wasn't loaded")
+	}
+
+	opts, err = parseDescribeSchemaFlags(nil)
+	if err != nil {
+		t.Fatalf("parseDescribeSchemaFlags(): unexpected error: %v", err)
+	}
+	if opts.format != schema.DictionaryFormatMarkdown || opts.schema != &schema.EmbeddedSchema {
+		t.Errorf("parseDescribeSchemaFlags(): want the embedded schema in markdown by default")
+	}
+
+	opts, err = parseDescribeSchemaFlags([]string{"-output", "json", "-format", "pdf"})
+	if err == nil {
+		t.Errorf("parseDescribeSchemaFlags(): succeeded with an unknown format")
+	}
+	if opts.output != messages.OutputJSON {
+		t.Errorf("parseDescribeSchemaFlags(): output = %q, want %q even if the flags are invalid", opts.output, messages.OutputJSON)
+	}
+}
+
+// TestParseFlagsErrorOutput checks that the output is set even if the flags are
+// invalid, so main prints the error in the requested format.
+func TestParseFlagsErrorOutput(t *testing.T) {
+	var params export.Params
+	_, err := parseFlags(&params, []string{"-output", "json", "-mode", "merge", "project", "dataset"})
+	if err == nil || params.Output != messages.OutputJSON {
+		t.Errorf("parseFlags(): output = %q, error = %v, want %q and an error", params.Output, err, messages.OutputJSON)
+	}
+
+	t.Setenv("MC2BQ_OUTPUT", "json")
+	t.Setenv("MC2BQ_PROJECT_CONCURRENCY", "many")
+	params = export.Params{}
+	_, err = parseFlags(&params, []string{"project", "dataset"})
+	if err == nil || params.Output != messages.OutputJSON {
+		t.Errorf("parseFlags(): output = %q, error = %v, want %q and an error", params.Output, err, messages.OutputJSON)
+	}
+}
+
 func TestParseFlags(t *testing.T) {
 	tCases := []struct {
 		Name       string
@@ -103,6 +346,505 @@ func TestParseFlags(t *testing.T) {
 			WantErr:    false,
 			wantAction: actionExport,
 		},
+		{Name: "all-regions",
+			Env:  nil,
+			Args: []string{"-all-regions", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AllRegions:      true,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "all-regions in env",
+			Env:  map[string]string{"MC2BQ_ALL_REGIONS": "1"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AllRegions:      true,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "asset-view",
+			Env:  nil,
+			Args: []string{"-asset-view", "basic", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AssetView:       export.AssetViewBasic,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "asset-view in env",
+			Env:  map[string]string{"MC2BQ_ASSET_VIEW": "basic"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AssetView:       export.AssetViewBasic,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid asset-view",
+			Env:        nil,
+			Args:       []string{"-asset-view", "everything", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "projects",
+			Env:  nil,
+			Args: []string{"-project", "p1", "-project", "p2", "dataset", "prefix"},
+			WantParams: export.Params{
+				ProjectIDs:      []string{"p1", "p2"},
+				TargetProjectID: "p1",
+				DatasetID:       "dataset",
+				TablePrefix:     "prefix",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "projects in env",
+			Env:  map[string]string{"MC2BQ_PROJECTS": "p1, p2", "PROJECT": "ignored"},
+			Args: []string{"dataset"},
+			WantParams: export.Params{
+				ProjectIDs:      []string{"p1", "p2"},
+				TargetProjectID: "p1",
+				DatasetID:       "dataset",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "projects with target-project and concurrency",
+			Env:  nil,
+			Args: []string{"-project", "p1", "-project", "p2", "-target-project", "tgt", "-project-concurrency", "2", "dataset"},
+			WantParams: export.Params{
+				ProjectIDs:         []string{"p1", "p2"},
+				ProjectConcurrency: 2,
+				TargetProjectID:    "tgt",
+				DatasetID:          "dataset",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "asset-shards",
+			Env:  nil,
+			Args: []string{"-asset-shards", "8", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				AssetShards:     8,
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "asset-shards in env",
+			Env:  map[string]string{"MC2BQ_ASSET_SHARDS": "4"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				AssetShards:     4,
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "asset filters",
+			Env:  nil,
+			Args: []string{"-asset-filter", `name = "a"`, "-group", "g1", "-group", "g2", "-label", "team=payments", "-label", "env = prod", "-source", "s1", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AssetFilter: export.AssetFilter{
+					Expression: `name = "a"`,
+					Groups:     []string{"g1", "g2"},
+					Labels:     map[string]string{"team": "payments", "env": "prod"},
+					Sources:    []string{"s1"},
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "asset-fields",
+			Env:  map[string]string{"MC2BQ_ASSET_FIELDS": "ignored"},
+			Args: []string{"-asset-fields", "name, machine_details.core_count,", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AssetFields:     []string{"name", "machine_details.core_count"},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "dataset options",
+			Env:  nil,
+			Args: []string{"-dataset-location", "europe-west1", "-kms-key", "key", "-dataset-label", "team=payments", "-table-label", "env=prod", "-table-expiration-days", "30", "-update-dataset", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Dataset: export.DatasetOptions{
+					Location:               "europe-west1",
+					KMSKeyName:             "key",
+					Labels:                 map[string]string{"team": "payments"},
+					TableLabels:            map[string]string{"env": "prod"},
+					DefaultTableExpiration: 30 * 24 * time.Hour,
+					Update:                 true,
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "dataset options in env",
+			Env: map[string]string{
+				"MC2BQ_DATASET_LOCATION":      "EU",
+				"MC2BQ_KMS_KEY":               "key",
+				"MC2BQ_DATASET_LABELS":        "team=payments,env=prod",
+				"MC2BQ_TABLE_LABELS":          "env=prod",
+				"MC2BQ_TABLE_EXPIRATION_DAYS": "7",
+				"MC2BQ_UPDATE_DATASET":        "1",
+			},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Dataset: export.DatasetOptions{
+					Location:               "EU",
+					KMSKeyName:             "key",
+					Labels:                 map[string]string{"team": "payments", "env": "prod"},
+					TableLabels:            map[string]string{"env": "prod"},
+					DefaultTableExpiration: 7 * 24 * time.Hour,
+					Update:                 true,
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "reconcile",
+			Env:  nil,
+			Args: []string{"-reconcile", "fail", "-reconcile-tolerance", "0.5", "-reconcile-fields", "machine_details.core_count, machine_details.disks.total_capacity_bytes", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Reconcile: export.ReconcileOptions{
+					Mode:             export.ReconcileFail,
+					TolerancePercent: 0.5,
+					Fields:           []string{"machine_details.core_count", "machine_details.disks.total_capacity_bytes"},
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "reconcile in env",
+			Env: map[string]string{
+				"MC2BQ_RECONCILE":           "warn",
+				"MC2BQ_RECONCILE_TOLERANCE": "1",
+			},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Reconcile: export.ReconcileOptions{
+					Mode:             export.ReconcileWarn,
+					TolerancePercent: 1,
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid reconcile",
+			Env:        nil,
+			Args:       []string{"-reconcile", "strict", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "json output",
+			Env:  nil,
+			Args: []string{"-output", "json", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Output:          messages.OutputJSON,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "json output in env",
+			Env:  map[string]string{"MC2BQ_OUTPUT": "JSON"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Output:          messages.OutputJSON,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid output",
+			Env:        nil,
+			Args:       []string{"-output", "yaml", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "telemetry exporters",
+			Env:  map[string]string{"MC2BQ_METRICS_EXPORTER": "otlp"},
+			Args: []string{"-traces-exporter", "stdout", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Telemetry: telemetry.Options{
+					Traces:  telemetry.ExporterStdout,
+					Metrics: telemetry.ExporterOTLP,
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid telemetry exporter",
+			Env:        nil,
+			Args:       []string{"-traces-exporter", "jaeger", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "asset filters in env",
+			Env: map[string]string{
+				"MC2BQ_ASSET_FILTER": `name = "a"`,
+				"MC2BQ_GROUPS":       "g1, g2",
+				"MC2BQ_LABELS":       "team=payments",
+				"MC2BQ_SOURCES":      "s1",
+			},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				AssetFilter: export.AssetFilter{
+					Expression: `name = "a"`,
+					Groups:     []string{"g1", "g2"},
+					Labels:     map[string]string{"team": "payments"},
+					Sources:    []string{"s1"},
+				},
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid label",
+			Env:        nil,
+			Args:       []string{"-label", "team", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "project-concurrency in env",
+			Env:  map[string]string{"MC2BQ_PROJECT_CONCURRENCY": "8"},
+			Args: []string{"-project", "p1", "dataset"},
+			WantParams: export.Params{
+				ProjectIDs:         []string{"p1"},
+				ProjectConcurrency: 8,
+				TargetProjectID:    "p1",
+				DatasetID:          "dataset",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "projects missing dataset",
+			Env:        nil,
+			Args:       []string{"-project", "p1"},
+			WantParams: export.Params{},
+			WantErr:    false,
+			wantAction: actionExitFailure,
+		},
+		{Name: "mode",
+			Env:  nil,
+			Args: []string{"-mode", "incremental", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Mode:            export.ModeIncremental,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "mode in env",
+			Env:  map[string]string{"MC2BQ_MODE": "incremental"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Mode:            export.ModeIncremental,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "snapshot mode with retention",
+			Env:  map[string]string{"MC2BQ_SNAPSHOT_RETENTION_DAYS": "30"},
+			Args: []string{"-mode", "snapshot", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:         "project",
+				TargetProjectID:   "project",
+				DatasetID:         "dataset",
+				Mode:              export.ModeSnapshot,
+				SnapshotRetention: 30 * 24 * time.Hour,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "snapshot retention",
+			Env:  nil,
+			Args: []string{"-mode", "snapshot", "-snapshot-retention-days", "7", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:         "project",
+				TargetProjectID:   "project",
+				DatasetID:         "dataset",
+				Mode:              export.ModeSnapshot,
+				SnapshotRetention: 7 * 24 * time.Hour,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "resume",
+			Env:  nil,
+			Args: []string{"-resume", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Resume:          true,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "resume in env",
+			Env:  map[string]string{"MC2BQ_RESUME": "1"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Resume:          true,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "dry-run",
+			Env:  nil,
+			Args: []string{"-dry-run", "-force", "project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				Force:           true,
+				DryRun:          true,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "dry-run in env",
+			Env:  map[string]string{"MC2BQ_DRY_RUN": "1"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				DryRun:          true,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "output-dir",
+			Env:  nil,
+			Args: []string{"-output-dir", "out", "-output-format", "parquet", "project", "prefix_"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				TablePrefix:     "prefix_",
+				OutputDir:       "out",
+				OutputFormat:    export.OutputFormatParquet,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "output-dir in env",
+			Env:  map[string]string{"MC2BQ_OUTPUT_DIR": "out", "MC2BQ_OUTPUT_FORMAT": "csv"},
+			Args: []string{"project"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				OutputDir:       "out",
+				OutputFormat:    export.OutputFormatCSV,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "output-db",
+			Env:  map[string]string{"MC2BQ_OUTPUT_DB": "postgres://localhost/planning"},
+			Args: []string{"project", "prefix_"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				TablePrefix:     "prefix_",
+				OutputDB:        "postgres://localhost/planning",
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid output-format",
+			Env:        nil,
+			Args:       []string{"-output-dir", "out", "-output-format", "xml", "project"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "storage-write",
+			Env:  map[string]string{"MC2BQ_WRITE_METHOD": "storage-write"},
+			Args: []string{"project", "dataset"},
+			WantParams: export.Params{
+				ProjectID:       "project",
+				TargetProjectID: "project",
+				DatasetID:       "dataset",
+				WriteMethod:     export.WriteMethodStorageWrite,
+			},
+			WantErr:    false,
+			wantAction: actionExport,
+		},
+		{Name: "invalid write-method",
+			Env:        nil,
+			Args:       []string{"-write-method", "insert", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
+		{Name: "invalid mode",
+			Env:        nil,
+			Args:       []string{"-mode", "partial", "project", "dataset"},
+			WantParams: export.Params{},
+			WantErr:    true,
+			wantAction: actionInvalid,
+		},
 		{Name: "project and dataset in env",
 			Env: map[string]string{
 				"PROJECT":       "project",
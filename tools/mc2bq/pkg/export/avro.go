@@ -0,0 +1,247 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroBlockRows is the number of rows in every block of an Avro file.
+const avroBlockRows = 1000
+
+// avroSchema returns the Avro schema of a record named name with the fields
+// of schema. Fields that aren't required are nullable and repeated fields are
+// arrays, the same way BigQuery exports tables to Avro.
+func avroSchema(name string, schema bigquery.Schema) map[string]any {
+	fields := make([]map[string]any, 0, len(schema))
+	for _, field := range schema {
+		fieldType := avroFieldType(name+"_"+field.Name, field)
+		f := map[string]any{"name": field.Name, "type": fieldType}
+		switch {
+		case field.Repeated:
+			f["default"] = []any{}
+		case !field.Required:
+			f["type"] = []any{"null", fieldType}
+			f["default"] = nil
+		}
+		fields = append(fields, f)
+	}
+
+	return map[string]any{
+		"type":   "record",
+		"name":   name,
+		"fields": fields,
+	}
+}
+
+// avroFieldType returns the Avro type of the values of field, name is the
+// name of the record type if the field is a record.
+func avroFieldType(name string, field *bigquery.FieldSchema) any {
+	var res any
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		res = "long"
+	case bigquery.FloatFieldType:
+		res = "double"
+	case bigquery.BooleanFieldType:
+		res = "boolean"
+	case bigquery.TimestampFieldType:
+		res = map[string]any{"type": "long", "logicalType": "timestamp-micros"}
+	case bigquery.RecordFieldType:
+		res = avroSchema(name, field.Schema)
+	default:
+		res = "string"
+	}
+
+	if field.Repeated {
+		return map[string]any{"type": "array", "items": res}
+	}
+	return res
+}
+
+// avroUnionName returns the name goavro uses for the non null branch of the
+// union of field, name is the name of the record type if the field is a
+// record.
+func avroUnionName(name string, field *bigquery.FieldSchema) string {
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		return "long"
+	case bigquery.FloatFieldType:
+		return "double"
+	case bigquery.BooleanFieldType:
+		return "boolean"
+	case bigquery.TimestampFieldType:
+		return "long.timestamp-micros"
+	case bigquery.RecordFieldType:
+		return name
+	}
+	return "string"
+}
+
+// avroWriter writes the rows as an Avro object container file with goavro,
+// the blocks are compressed with deflate.
+type avroWriter struct {
+	ocf    *goavro.OCFWriter
+	name   string
+	schema bigquery.Schema
+	block  []any
+}
+
+func newAvroWriter(w io.Writer, name string, schema bigquery.Schema) (*avroWriter, error) {
+	name = sanitizeTableID(name)
+	avroSchema, err := json.Marshal(avroSchema(name, schema))
+	if err != nil {
+		return nil, err
+	}
+
+	ocf, err := goavro.NewOCFWriter(goavro.OCFConfig{
+		W:               w,
+		Schema:          string(avroSchema),
+		CompressionName: goavro.CompressionDeflateLabel,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &avroWriter{ocf: ocf, name: name, schema: schema}, nil
+}
+
+func (w *avroWriter) writeRow(row []byte) error {
+	obj, err := decodeRow(row)
+	if err != nil {
+		return err
+	}
+
+	record, err := avroRecord(w.name, w.schema, obj)
+	if err != nil {
+		return err
+	}
+
+	w.block = append(w.block, record)
+	if len(w.block) >= avroBlockRows {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the rows of the current block.
+func (w *avroWriter) flush() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+
+	err := w.ocf.Append(w.block)
+	w.block = w.block[:0]
+	return err
+}
+
+func (w *avroWriter) close() error {
+	return w.flush()
+}
+
+// avroRecord converts the decoded JSON object obj to the goavro native form
+// of a record named name with schema.
+func avroRecord(name string, schema bigquery.Schema, obj map[string]any) (map[string]any, error) {
+	res := make(map[string]any, len(schema))
+	for _, field := range schema {
+		value := obj[field.Name]
+		childName := name + "_" + field.Name
+		if field.Repeated {
+			items, _ := value.([]any)
+			values := make([]any, 0, len(items))
+			for _, item := range items {
+				v, err := avroValue(childName, field, item)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", field.Name, err)
+				}
+				values = append(values, v)
+			}
+			res[field.Name] = values
+			continue
+		}
+
+		if value == nil {
+			res[field.Name] = nil
+			continue
+		}
+		v, err := avroValue(childName, field, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field.Name, err)
+		}
+		if !field.Required {
+			v = goavro.Union(avroUnionName(childName, field), v)
+		}
+		res[field.Name] = v
+	}
+
+	return res, nil
+}
+
+// avroValue converts a single value of field to its goavro native form.
+func avroValue(name string, field *bigquery.FieldSchema, value any) (any, error) {
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		return jsonInt(value)
+	case bigquery.FloatFieldType:
+		return jsonFloat(value)
+	case bigquery.BooleanFieldType:
+		b, _ := value.(bool)
+		return b, nil
+	case bigquery.TimestampFieldType:
+		return jsonTimestamp(value)
+	case bigquery.RecordFieldType:
+		obj, _ := value.(map[string]any)
+		return avroRecord(name, field.Schema, obj)
+	}
+
+	return csvValue(value)
+}
+
+// jsonInt converts a decoded JSON number to an integer.
+func jsonInt(value any) (int64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Int64()
+	case string:
+		return json.Number(v).Int64()
+	}
+	return 0, fmt.Errorf("invalid integer %v", value)
+}
+
+// jsonFloat converts a decoded JSON number to a float.
+func jsonFloat(value any) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return json.Number(v).Float64()
+	}
+	return 0, fmt.Errorf("invalid float %v", value)
+}
+
+// jsonTimestamp parses a timestamp serialized by the objectReader.
+func jsonTimestamp(value any) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid timestamp %v", value)
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,479 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/telemetry"
+)
+
+// bigQuerySink exports the data to tables in a BigQuery dataset.
+//
+// The data is loaded to staging tables and written to the tables only by
+// Commit in a single transaction, if the export fails the tables are left
+// untouched. The progress of the export is saved in a checkpoint so it can be
+// resumed, see Params.Resume.
+type bigQuerySink struct {
+	params      *Params
+	bq          *bigquery.Client
+	dataset     *bigquery.Dataset
+	checkpoints *checkpointer
+	// storage writes the objects if Params.WriteMethod is
+	// WriteMethodStorageWrite.
+	storage *storageWriter
+
+	mu sync.Mutex
+	// staged are the staging tables of the tables that aren't exported
+	// incrementally.
+	staged map[string]*stagedTable
+	// incremental are the tables that are exported incrementally.
+	incremental map[string]*incrementalTable
+	// promoters write the data of the staging tables to the tables.
+	promoters map[string]promoter
+	// reconciler checks the assets before the transaction that promotes
+	// them is committed, it's nil if Params.Reconcile is disabled.
+	reconciler *reconciler
+}
+
+// NewBigQuerySink returns a Sink that exports the data to the tables of
+// Params.DatasetID in Params.TargetProjectID, according to Params.Mode.
+func NewBigQuerySink() Sink {
+	return &bigQuerySink{
+		staged:      map[string]*stagedTable{},
+		incremental: map[string]*incrementalTable{},
+		promoters:   map[string]promoter{},
+	}
+}
+
+func (s *bigQuerySink) Create(ctx context.Context, params *Params) error {
+	s.params = params
+	bq, err := bigquery.NewClient(ctx, params.TargetProjectID, buildClientOptions(params)...)
+	if err != nil {
+		return fmt.Errorf("create bigquery client: %w", err)
+	}
+	s.bq = bq
+
+	s.dataset = bq.Dataset(params.DatasetID)
+	err = prepareDataset(ctx, s.dataset, params)
+	if err != nil {
+		bq.Close()
+		return err
+	}
+
+	s.checkpoints, err = newCheckpointer(ctx, bq, s.dataset, params)
+	if err != nil {
+		bq.Close()
+		return err
+	}
+
+	if params.WriteMethod == WriteMethodStorageWrite {
+		s.storage, err = newStorageWriter(ctx, params)
+		if err != nil {
+			bq.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *bigQuerySink) CreateTable(ctx context.Context, table *Table) error {
+	err := table.Layout.validate(table.Name, table.Schema, s.params.Mode, table.Incremental)
+	if err != nil {
+		return err
+	}
+
+	bqTable := s.dataset.Table(table.Name)
+	if s.params.Mode == ModeIncremental && table.Incremental {
+		incremental := newIncrementalTable(s.bq, bqTable, s.params)
+		incremental.writeObjects = s.loadObjects
+		incremental.layout = table.Layout
+		incremental.watermarks = s.dataset.Table(s.params.TablePrefix + watermarkTableSuffix)
+		s.addPromoter(table.Name, incremental)
+		s.mu.Lock()
+		s.incremental[table.Name] = incremental
+		s.mu.Unlock()
+		return incremental.prepare(ctx, table.Schema)
+	}
+
+	var staged *stagedTable
+	var p promoter
+	if s.params.Mode == ModeSnapshot {
+		var snapshot *snapshotTable
+		snapshot, err = prepareSnapshotTable(ctx, s.bq, bqTable, s.params, table.Schema, table.Layout, s.checkpoints.resumed)
+		if err == nil {
+			staged, p = snapshot.stagedTable, snapshot
+		}
+	} else {
+		staged, err = prepareTable(ctx, s.bq, bqTable, s.params, table.Schema, table.Layout, s.checkpoints.resumed)
+		p = staged
+	}
+	if err != nil {
+		return err
+	}
+
+	s.addPromoter(table.Name, p)
+	s.mu.Lock()
+	s.staged[table.Name] = staged
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *bigQuerySink) addPromoter(table string, p promoter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.promoters[table] = p
+}
+
+func (s *bigQuerySink) Stream(ctx context.Context, table *Table, scope mcutil.ProjectAndLocation) (*Stream, error) {
+	s.mu.Lock()
+	incremental, staged := s.incremental[table.Name], s.staged[table.Name]
+	s.mu.Unlock()
+
+	if incremental != nil {
+		src, load, partial := incremental.newSource(ctx, table.mc, scope)
+		return &Stream{Source: src, Write: load, Partial: partial}, nil
+	}
+
+	key := checkpointKey(staged.tbl, scope)
+	if s.checkpoints.done(key) {
+		projectID, location := s.params.displayScope(scope)
+		messages.Print(messages.ExportTableAlreadyExported{
+			TableName: table.Name,
+			ProjectID: projectID,
+			Location:  location,
+		})
+		return nil, nil
+	}
+
+	src := table.NewSource(ctx, scope)
+	if s.storage != nil {
+		// Streams can't be resumed, see Params.WriteMethod.
+		return &Stream{
+			Source: src,
+			Write: func(ctx context.Context) error {
+				return s.storage.write(ctx, staged.staging, src)
+			},
+		}, nil
+	}
+	return &Stream{
+		Source: src,
+		Write: func(ctx context.Context) error {
+			return s.checkpoints.exportObjects(ctx, staged.staging, src, key)
+		},
+	}, nil
+}
+
+// loadObjects writes the objects of src to tbl according to
+// Params.WriteMethod.
+func (s *bigQuerySink) loadObjects(ctx context.Context, tbl *bigquery.Table, src mcutil.ObjectSource) error {
+	if s.storage != nil {
+		return s.storage.write(ctx, tbl, src)
+	}
+	return exportObjects(ctx, tbl, src)
+}
+
+// Commit promotes the staging tables of all the tables in a single
+// transaction and then updates the layout and the labels of the tables. If
+// finishing the tables fails and the export is resumed, the data isn't
+// promoted twice, see checkpointer.commitPromoted.
+func (s *bigQuerySink) Commit(ctx context.Context, failed []string) error {
+	if s.storage != nil {
+		// The staging tables contain the rows only once their streams
+		// are committed.
+		err := s.storage.commit(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !s.checkpoints.promoted() {
+		err := s.promote(ctx, failed)
+		if err != nil {
+			return err
+		}
+	}
+
+	grp, grpCtx := errgroup.WithContext(ctx)
+	for table, p := range s.promoters {
+		table, p := table, p
+		grp.Go(func() error {
+			err := p.finish(grpCtx)
+			if err != nil {
+				return err
+			}
+			return applyTableLabels(grpCtx, s.dataset.Table(table), s.params.Dataset.TableLabels)
+		})
+	}
+	err := grp.Wait()
+	if err != nil {
+		return err
+	}
+
+	err = s.checkpoints.delete(ctx)
+	if err != nil {
+		return fmt.Errorf("delete checkpoint: %w", err)
+	}
+
+	s.cleanup()
+	s.closeStorage()
+	return s.bq.Close()
+}
+
+func (s *bigQuerySink) setReconciler(r *reconciler) {
+	s.reconciler = r
+}
+
+// promote writes the data of the staging tables to all the tables in a single
+// BigQuery transaction, readers see either the previous data of all the
+// tables or the new data. BigQuery doesn't allow DDL statements in
+// transactions, so the tables that don't exist are created and the missing
+// columns are added before the transaction begins, the created tables are
+// deleted if a statement of the transaction fails. The checkpoint is updated
+// in the same transaction and the exported assets are reconciled before the
+// transaction is committed. The data of the failed projects isn't promoted.
+func (s *bigQuerySink) promote(ctx context.Context, failed []string) error {
+	messages.Print(messages.ExportPromotingTables{TableCount: len(s.promoters)})
+	grp, grpCtx := errgroup.WithContext(ctx)
+	for _, p := range s.promoters {
+		p := p
+		grp.Go(func() error {
+			return p.apply(grpCtx)
+		})
+	}
+	err := grp.Wait()
+	if err == nil {
+		// The checkpoint table must exist to be updated in the
+		// transaction.
+		err = s.checkpoints.flush(ctx)
+	}
+	if err == nil && s.reconciler != nil {
+		// Migration Center is queried before the transaction begins
+		// to keep it short.
+		err = s.reconciler.fetchSums(ctx)
+	}
+
+	var tx *transaction
+	if err == nil {
+		tx, err = beginTransaction(ctx, s.bq, s.checkpoints.location)
+	}
+	if err == nil {
+		defer tx.end()
+		// The statements of a transaction run one at a time.
+		for _, table := range s.promoterTables() {
+			err = s.promoters[table].promote(ctx, tx, failed)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil && s.reconciler != nil {
+		err = s.reconciler.check(ctx, tx, s.dataset)
+	}
+	if err == nil {
+		err = s.checkpoints.commitPromoted(ctx, tx)
+	}
+	if err != nil {
+		for _, p := range s.promoters {
+			p.revert()
+		}
+		return err
+	}
+
+	return tx.commit(ctx)
+}
+
+// promoterTables returns the names of the tables that are promoted, sorted so
+// the statements of the transaction run in a stable order.
+func (s *bigQuerySink) promoterTables() []string {
+	var res []string
+	for table := range s.promoters {
+		res = append(res, table)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// Abort keeps the staging tables so the export can be resumed, except in
+// ModeIncremental which can't be resumed.
+func (s *bigQuerySink) Abort(ctx context.Context) {
+	defer s.bq.Close()
+	// The pending streams that weren't committed are discarded by BigQuery.
+	defer s.closeStorage()
+	if s.params.Mode == ModeIncremental {
+		s.cleanup()
+		return
+	}
+	if s.checkpoints.hasProgress() {
+		messages.Print(messages.ExportCanResume)
+	}
+}
+
+func (s *bigQuerySink) closeStorage() {
+	if s.storage != nil {
+		s.storage.close()
+	}
+}
+
+// cleanup deletes the staging tables.
+func (s *bigQuerySink) cleanup() {
+	for _, p := range s.promoters {
+		p.cleanup()
+	}
+}
+
+// newLoadSource returns a load source that reads the objects from src.
+func newLoadSource(src mcutil.ObjectSource) bigquery.LoadSource {
+	// Creating a full blown bigquery.LoadSource requires a lot of low level big query operations.
+	// To save on time we create a ReaderSource and feed it the assets as a json stream.
+	res := bigquery.NewReaderSource(src)
+	res.Schema = src.Schema()
+	res.SourceFormat = bigquery.JSON
+
+	return res
+}
+
+// prepareTable checks that tbl can be replaced and creates the staging table
+// that the data is loaded to, an existing table is replaced only if
+// params.Force is set. The staging table has the partitioning and clustering
+// of layout, an existing table whose partitioning differs from layout can't be
+// replaced in place and fails with a TableLayoutDrift error. The table itself
+// is only modified by promote.
+func prepareTable(ctx context.Context, bq *bigquery.Client, tbl *bigquery.Table, params *Params, schema bigquery.Schema, layout *TableLayout, resume bool) (*stagedTable, error) {
+	md, err := tbl.Metadata(ctx)
+	if err != nil && !gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		return nil, err
+	}
+	exists := err == nil
+	// Incremental exports replace the tables that aren't exported
+	// incrementally. The table of a resumed export may have been created
+	// by its promote.
+	if exists && !params.Force && !resume && params.Mode != ModeIncremental {
+		return nil, errTableExists
+	}
+
+	staged := &stagedTable{
+		tbl:          tbl,
+		md:           layout.metadata(schema),
+		partitioning: layout.timePartitioning(),
+		layout:       layout,
+	}
+	if exists {
+		err = layout.drift(md, staged.partitioning).err(tbl)
+		if err == nil {
+			err = checkSchema(tbl, md, schema)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	messages.Print(messages.ExportingDataToTable{TableName: tbl.TableID})
+	stagingMD := layout.metadata(schema)
+	// The partition filter is only required on the table, it doesn't
+	// affect promoting the staging table.
+	stagingMD.RequirePartitionFilter = false
+	staged.staging, err = createStagingTable(ctx, bq, tbl, "staging", stagingMD, resume)
+	if err != nil {
+		return nil, err
+	}
+
+	return staged, nil
+}
+
+// exportObjects appends the objects from src to the table.
+func exportObjects(ctx context.Context, tbl *bigquery.Table, src mcutil.ObjectSource) error {
+	loader := tbl.LoaderFrom(newLoadSource(src))
+	loader.WriteDisposition = bigquery.WriteAppend
+	return runLoad(ctx, tbl, loader.Run)
+}
+
+// runLoad starts a load job to tbl with run and waits for it to complete, the
+// job is traced by a span.
+func runLoad(ctx context.Context, tbl *bigquery.Table, run func(ctx context.Context) (*bigquery.Job, error)) error {
+	ctx, span := telemetry.Start(ctx, "mc2bq.load", telemetry.AttrTable.String(tbl.TableID))
+	job, err := run(ctx)
+	if err == nil {
+		err = waitForJob(ctx, job)
+	}
+	telemetry.End(span, err)
+	return err
+}
+
+// appendJSONRows appends rows, which are encoded as JSON, to tbl. The table is
+// created with schema if it doesn't exist.
+func appendJSONRows(ctx context.Context, tbl *bigquery.Table, schema bigquery.Schema, rows []any) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, row := range rows {
+		err := enc.Encode(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	src := bigquery.NewReaderSource(&buf)
+	src.Schema = schema
+	src.SourceFormat = bigquery.JSON
+	loader := tbl.LoaderFrom(src)
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+	loader.WriteDisposition = bigquery.WriteAppend
+	err := runLoad(ctx, tbl, loader.Run)
+	if err != nil {
+		return fmt.Errorf("append to %s: %w", tbl.TableID, err)
+	}
+	return nil
+}
+
+// waitForJob waits for job to complete and returns its errors, the job is
+// recorded in the jobRecorder of ctx.
+func waitForJob(ctx context.Context, job *bigquery.Job) error {
+	recordJob(ctx, job)
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = status.Err()
+	if err != nil {
+		if len(status.Errors) > 0 {
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "encountered errors during export:")
+			for _, err := range status.Errors {
+				fmt.Fprintf(&sb, "\n\t%v", err)
+			}
+
+			return errors.New(sb.String())
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,383 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+const (
+	// checkpointTableSuffix is the suffix of the table that stores the
+	// checkpoint of an export, the table prefix is added to it.
+	checkpointTableSuffix = "_mc2bq_checkpoint"
+	// checkpointChunkPages is the number of pages that are loaded by a
+	// single load job, a checkpoint is saved after every chunk.
+	checkpointChunkPages = 10
+)
+
+var errResumeIncremental = messages.NewError(messages.ErrMsgResumeIncremental)
+
+// checkpointSchema is the schema of the checkpoint table, it contains a single
+// row with the JSON encoded checkpointState.
+var checkpointSchema = bigquery.Schema{
+	{Name: "state", Type: bigquery.StringFieldType, Required: true},
+}
+
+// checkpointState is the progress of an export.
+type checkpointState struct {
+	// RunID identifies the export, it's part of the IDs of the load jobs.
+	RunID string `json:"run_id"`
+	// ExportTime is the export time of the export, see Params.ExportTime.
+	ExportTime time.Time `json:"export_time"`
+	// Loads is the progress of every table and scope, see checkpointKey.
+	Loads map[string]*loadCheckpoint `json:"loads"`
+	// Promoted is set once the staging tables were promoted, see
+	// bigQuerySink.promote.
+	Promoted bool `json:"promoted,omitempty"`
+}
+
+// loadCheckpoint is the progress of loading the objects of a scope to a table.
+type loadCheckpoint struct {
+	// Chunks is the number of chunks that were loaded.
+	Chunks int `json:"chunks"`
+	// PageToken is the token of the first page of the next chunk.
+	PageToken string `json:"page_token,omitempty"`
+	// ObjectsRead is the number of objects that were loaded.
+	ObjectsRead uint64 `json:"objects_read"`
+	// Done is set once all the objects were loaded.
+	Done bool `json:"done"`
+}
+
+// checkpointKey returns the key of the objects of pal that are loaded to tbl.
+func checkpointKey(tbl *bigquery.Table, pal mcutil.ProjectAndLocation) string {
+	return tbl.TableID + "/" + pal.Path()
+}
+
+// checkpointer persists the progress of an export in the checkpoint table so
+// the export can be resumed, see Params.Resume.
+type checkpointer struct {
+	bq       *bigquery.Client
+	tbl      *bigquery.Table
+	location string
+
+	// resumed is set if the export continues a previous export.
+	resumed bool
+
+	mu    sync.Mutex
+	state checkpointState
+}
+
+// newCheckpointer returns a checkpointer for the export described by params,
+// the checkpoint of the previous export is loaded if params.Resume is set.
+// params.ExportTime is set to the export time of the resumed export.
+func newCheckpointer(ctx context.Context, bq *bigquery.Client, dataset *bigquery.Dataset, params *Params) (*checkpointer, error) {
+	md, err := dataset.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checkpointer{
+		bq:       bq,
+		tbl:      dataset.Table(params.TablePrefix + checkpointTableSuffix),
+		location: md.Location,
+	}
+
+	if params.Resume {
+		state, err := c.load(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load checkpoint: %w", err)
+		}
+		if state != nil {
+			messages.Print(messages.ExportResuming{ExportTime: state.ExportTime})
+			c.resumed = true
+			c.state = *state
+			params.ExportTime = state.ExportTime
+			return c, nil
+		}
+		messages.Print(messages.ExportNoCheckpoint)
+	}
+
+	runID := make([]byte, 8)
+	_, err = rand.Read(runID)
+	if err != nil {
+		return nil, err
+	}
+	c.state = checkpointState{
+		RunID:      hex.EncodeToString(runID),
+		ExportTime: params.ExportTime,
+		Loads:      map[string]*loadCheckpoint{},
+	}
+	return c, nil
+}
+
+// load reads the checkpoint from the checkpoint table, it returns nil if there
+// is no checkpoint.
+func (c *checkpointer) load(ctx context.Context) (*checkpointState, error) {
+	rows := c.tbl.Read(ctx)
+	var row []bigquery.Value
+	err := rows.Next(&row)
+	if errors.Is(err, iterator.Done) || gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := row[0].(string)
+	var state checkpointState
+	err = json.Unmarshal([]byte(data), &state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Loads == nil {
+		state.Loads = map[string]*loadCheckpoint{}
+	}
+
+	return &state, nil
+}
+
+// save replaces the checkpoint in the checkpoint table, c.mu must be held.
+func (c *checkpointer) save(ctx context.Context) error {
+	data, err := json.Marshal(c.state)
+	if err != nil {
+		return err
+	}
+	row, err := json.Marshal(map[string]string{"state": string(data)})
+	if err != nil {
+		return err
+	}
+
+	src := bigquery.NewReaderSource(bytes.NewReader(row))
+	src.Schema = checkpointSchema
+	src.SourceFormat = bigquery.JSON
+	loader := c.tbl.LoaderFrom(src)
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+	loader.WriteDisposition = bigquery.WriteTruncate
+	err = runLoad(ctx, c.tbl, loader.Run)
+	if err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// delete deletes the checkpoint, it's called once the export completes.
+func (c *checkpointer) delete(ctx context.Context) error {
+	return gapiutil.IgnoreErrorWithCode(c.tbl.Delete(ctx), http.StatusNotFound)
+}
+
+// done returns true if all the objects of key were loaded by a previous export.
+func (c *checkpointer) done(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	load, ok := c.state.Loads[key]
+	return ok && load.Done
+}
+
+// hasProgress returns true if any objects were loaded.
+func (c *checkpointer) hasProgress() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.state.Loads) > 0
+}
+
+// commit records the progress of key and saves the checkpoint.
+func (c *checkpointer) commit(ctx context.Context, key string, load loadCheckpoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state.Loads[key] = &load
+	return c.save(ctx)
+}
+
+// promoted returns true if the staging tables were promoted by the resumed
+// export.
+func (c *checkpointer) promoted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state.Promoted
+}
+
+// flush saves the checkpoint.
+func (c *checkpointer) flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.save(ctx)
+}
+
+// commitPromoted records in tx that the staging tables were promoted, the
+// checkpoint is updated only if tx is committed. A resumed export doesn't
+// promote the staging tables again, so the data of a snapshot isn't appended
+// twice. The checkpoint must have been saved before tx began.
+func (c *checkpointer) commitPromoted(ctx context.Context, tx *transaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.state
+	state.Promoted = true
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.run(ctx, fmt.Sprintf("UPDATE %s SET state = @state WHERE TRUE", sqlTableName(c.tbl)),
+		[]bigquery.QueryParameter{{Name: "state", Value: string(data)}})
+	if err != nil {
+		return fmt.Errorf("save checkpoint: %w", err)
+	}
+	c.state.Promoted = true
+	return nil
+}
+
+// chunkedSource is an ObjectSource that can be read in chunks, see
+// objectReader.setChunkPages.
+type chunkedSource interface {
+	mcutil.ObjectSource
+	resumable() bool
+	setChunkPages(pages int)
+	nextChunk() bool
+	pageToken() string
+	resumeFrom(token string, objectsRead uint64)
+}
+
+// exportObjects appends the objects from src to tbl and records the progress
+// under key. Resumable sources are loaded in chunks, every chunk is loaded by
+// a separate load job and a checkpoint is saved after every chunk. If the
+// export is resumed the objects that were already loaded are skipped.
+func (c *checkpointer) exportObjects(ctx context.Context, tbl *bigquery.Table, src mcutil.ObjectSource, key string) error {
+	c.mu.Lock()
+	var load loadCheckpoint
+	if prev, ok := c.state.Loads[key]; ok {
+		load = *prev
+	}
+	c.mu.Unlock()
+
+	chunked, ok := src.(chunkedSource)
+	if !ok || !chunked.resumable() {
+		// The objects are loaded by a single load job.
+		err := c.loadChunk(ctx, tbl, src, key, 0)
+		if err != nil {
+			return err
+		}
+		return c.commit(ctx, key, loadCheckpoint{Chunks: 1, ObjectsRead: src.ObjectsRead(), Done: true})
+	}
+
+	chunked.setChunkPages(checkpointChunkPages)
+	if load.PageToken != "" {
+		chunked.resumeFrom(load.PageToken, load.ObjectsRead)
+	}
+	for {
+		err := c.loadChunk(ctx, tbl, src, key, load.Chunks)
+		if err != nil {
+			return err
+		}
+
+		load.Chunks++
+		load.ObjectsRead = src.ObjectsRead()
+		load.PageToken = chunked.pageToken()
+		load.Done = !chunked.nextChunk()
+		err = c.commit(ctx, key, load)
+		if err != nil {
+			return err
+		}
+		if load.Done {
+			return nil
+		}
+	}
+}
+
+// loadChunk loads the next chunk of src to tbl. The ID of the load job is
+// derived from the chunk and the attempt, if a previous export already loaded
+// the chunk (e.g. it was interrupted before the checkpoint was saved) the job
+// isn't run again so the objects aren't duplicated. The chunk is loaded again
+// by a job with a new ID if the jobs of the previous exports failed.
+func (c *checkpointer) loadChunk(ctx context.Context, tbl *bigquery.Table, src mcutil.ObjectSource, key string, chunk int) error {
+	attempt := 0
+	if c.resumed {
+		var loaded bool
+		var err error
+		attempt, loaded, err = c.previousAttempts(ctx, key, chunk)
+		if err != nil {
+			return err
+		}
+		if loaded {
+			// Skip the objects of the chunk.
+			_, err = io.Copy(io.Discard, src)
+			return err
+		}
+	}
+
+	loader := tbl.LoaderFrom(newLoadSource(src))
+	loader.WriteDisposition = bigquery.WriteAppend
+	loader.JobID = c.loadJobID(key, chunk, attempt)
+	loader.Location = c.location
+	return runLoad(ctx, tbl, func(ctx context.Context) (*bigquery.Job, error) {
+		job, err := loader.Run(ctx)
+		if gapiutil.IsErrorWithCode(err, http.StatusConflict) {
+			// The job was created by a retry of the request, skip the
+			// objects of the chunk in case it was rejected before they
+			// were uploaded.
+			_, err = io.Copy(io.Discard, src)
+			if err != nil {
+				return nil, err
+			}
+			return c.bq.JobFromIDLocation(ctx, loader.JobID, c.location)
+		}
+		return job, err
+	})
+}
+
+// previousAttempts returns the number of jobs that previous exports ran to
+// load the chunk, loaded is set if the last of them succeeded. The jobs that
+// are still running are waited for.
+func (c *checkpointer) previousAttempts(ctx context.Context, key string, chunk int) (attempts int, loaded bool, err error) {
+	for {
+		job, err := c.bq.JobFromIDLocation(ctx, c.loadJobID(key, chunk, attempts), c.location)
+		if gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+			return attempts, false, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("get load job: %w", err)
+		}
+		status, err := job.Wait(ctx)
+		if err != nil {
+			return 0, false, fmt.Errorf("get load job: %w", err)
+		}
+		if status.Err() == nil {
+			return attempts, true, nil
+		}
+		attempts++
+	}
+}
+
+// loadJobID returns the ID of the job that loads the chunk of key, attempt is
+// the number of jobs that failed to load it.
+func (c *checkpointer) loadJobID(key string, chunk int, attempt int) string {
+	return fmt.Sprintf("mc2bq_%s_%s_%d_%d", c.state.RunID, sanitizeTableID(key), chunk, attempt)
+}
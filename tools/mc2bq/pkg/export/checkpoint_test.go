@@ -0,0 +1,297 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+	bq "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// fakeGroupPager lists groups in pages, the token of a page is its index.
+type fakeGroupPager struct {
+	pages    [][]*migrationcenterpb.Group
+	items    []*migrationcenterpb.Group
+	pageInfo *iterator.PageInfo
+	nextFunc func() error
+}
+
+func newFakeGroupPager(pageCount int, pageSize int) *fakeGroupPager {
+	it := &fakeGroupPager{}
+	for i := 0; i < pageCount; i++ {
+		var page []*migrationcenterpb.Group
+		for j := 0; j < pageSize; j++ {
+			page = append(page, &migrationcenterpb.Group{Name: fmt.Sprintf("g-%d-%d", i, j)})
+		}
+		it.pages = append(it.pages, page)
+	}
+
+	fetch := func(pageSize int, pageToken string) (string, error) {
+		page := 0
+		if pageToken != "" {
+			page, _ = strconv.Atoi(pageToken)
+		}
+		it.items = append(it.items, it.pages[page]...)
+		if page+1 == len(it.pages) {
+			return "", nil
+		}
+		return strconv.Itoa(page + 1), nil
+	}
+	bufLen := func() int { return len(it.items) }
+	takeBuf := func() interface{} {
+		b := it.items
+		it.items = nil
+		return b
+	}
+	it.pageInfo, it.nextFunc = iterator.NewPageInfo(fetch, bufLen, takeBuf)
+	return it
+}
+
+func (it *fakeGroupPager) PageInfo() *iterator.PageInfo {
+	return it.pageInfo
+}
+
+func (it *fakeGroupPager) Next() (*migrationcenterpb.Group, error) {
+	if err := it.nextFunc(); err != nil {
+		return nil, err
+	}
+	group := it.items[0]
+	it.items = it.items[1:]
+	return group, nil
+}
+
+var groupNameSchema = bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}
+
+func TestObjectReaderChunks(t *testing.T) {
+	tCases := []struct {
+		name       string
+		pages      int
+		chunkPages int
+		resume     string
+		wantTokens []string
+		wantRead   uint64
+	}{
+		{"partial last chunk", 5, 2, "", []string{"2", "4", ""}, 10},
+		{"full last chunk", 4, 2, "", []string{"2", ""}, 8},
+		{"resume", 5, 2, "2", []string{"4", ""}, 10},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			r := newObjectReader[*migrationcenterpb.Group](newFakeGroupPager(tCase.pages, 2), "group", groupNameSchema)
+			if !r.resumable() {
+				t.Fatalf("resumable() = false, want true")
+			}
+			r.setChunkPages(tCase.chunkPages)
+			if tCase.resume != "" {
+				page, _ := strconv.Atoi(tCase.resume)
+				r.resumeFrom(tCase.resume, uint64(page*2))
+			}
+
+			var tokens []string
+			for {
+				_, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("read chunk: %v", err)
+				}
+				tokens = append(tokens, r.pageToken())
+				if !r.nextChunk() {
+					break
+				}
+			}
+
+			if fmt.Sprint(tokens) != fmt.Sprint(tCase.wantTokens) {
+				t.Errorf("unexpected page tokens after every chunk, want: %q got: %q", tCase.wantTokens, tokens)
+			}
+			if r.ObjectsRead() != tCase.wantRead {
+				t.Errorf("ObjectsRead() = %d, want %d", r.ObjectsRead(), tCase.wantRead)
+			}
+		})
+	}
+}
+
+func TestObjectReaderNotResumable(t *testing.T) {
+	it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{{Name: "g"}}}
+	r := newObjectReader[*migrationcenterpb.Group](it, "group", groupNameSchema)
+	if r.resumable() {
+		t.Errorf("resumable() = true, want false")
+	}
+}
+
+// fakeJobServer is a BigQuery API server that keeps the jobs in memory, the
+// load jobs that are inserted succeed.
+type fakeJobServer struct {
+	t    *testing.T
+	mu   sync.Mutex
+	jobs map[string]*bq.Job
+	// inserted are the IDs of the jobs that were inserted.
+	inserted []string
+}
+
+func (s *fakeJobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var job *bq.Job
+	switch {
+	case r.Method == http.MethodGet && strings.Contains(r.URL.Path, "/jobs/"):
+		job = s.jobs[path.Base(r.URL.Path)]
+		if job == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": 404, "message": "not found"}}`)
+			return
+		}
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/jobs"):
+		var err error
+		job, err = s.readInsert(r)
+		if err != nil {
+			s.t.Errorf("invalid insert request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if s.jobs[job.JobReference.JobId] != nil {
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"error": {"code": 409, "message": "already exists"}}`)
+			return
+		}
+		job.Status = &bq.JobStatus{State: "DONE"}
+		s.jobs[job.JobReference.JobId] = job
+		s.inserted = append(s.inserted, job.JobReference.JobId)
+	default:
+		s.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(job)
+}
+
+// readInsert returns the job of a multipart upload request, the uploaded
+// data is read.
+func (s *fakeJobServer) readInsert(r *http.Request) (*bq.Job, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+	part, err := parts.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	var job bq.Job
+	err = json.NewDecoder(part).Decode(&job)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(io.Discard, r.Body)
+	return &job, err
+}
+
+// loadJob returns a load job of the previous export with status.
+func loadJob(id string, status *bq.JobStatus) *bq.Job {
+	return &bq.Job{
+		JobReference: &bq.JobReference{ProjectId: "p", JobId: id, Location: "US"},
+		Configuration: &bq.JobConfiguration{Load: &bq.JobConfigurationLoad{
+			DestinationTable: &bq.TableReference{ProjectId: "p", DatasetId: "d", TableId: "groups"},
+		}},
+		Status: status,
+	}
+}
+
+func TestLoadChunkResumed(t *testing.T) {
+	failed := &bq.JobStatus{State: "DONE", ErrorResult: &bq.ErrorProto{Reason: "invalid", Message: "load failed"}}
+	done := &bq.JobStatus{State: "DONE"}
+	tCases := []struct {
+		name         string
+		previous     []*bq.JobStatus
+		wantInserted []string
+	}{
+		{
+			name:         "not loaded",
+			wantInserted: []string{"mc2bq_run_groups_0_0"},
+		},
+		{
+			name:     "loaded",
+			previous: []*bq.JobStatus{done},
+		},
+		{
+			name:         "failed",
+			previous:     []*bq.JobStatus{failed},
+			wantInserted: []string{"mc2bq_run_groups_0_1"},
+		},
+		{
+			name:     "loaded after a failure",
+			previous: []*bq.JobStatus{failed, done},
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			srv := &fakeJobServer{t: t, jobs: map[string]*bq.Job{}}
+			for i, status := range tCase.previous {
+				id := fmt.Sprintf("mc2bq_run_groups_0_%d", i)
+				srv.jobs[id] = loadJob(id, status)
+			}
+			httpSrv := httptest.NewServer(srv)
+			defer httpSrv.Close()
+
+			ctx := context.Background()
+			client, err := bigquery.NewClient(ctx, "p", option.WithEndpoint(httpSrv.URL), option.WithoutAuthentication())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			c := &checkpointer{
+				bq:       client,
+				tbl:      client.Dataset("d").Table(checkpointTableSuffix),
+				location: "US",
+				resumed:  true,
+				state:    checkpointState{RunID: "run"},
+			}
+			it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{{Name: "g1"}, {Name: "g2"}}}
+			src := newObjectReader[*migrationcenterpb.Group](it, "group", bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}})
+
+			err = c.loadChunk(ctx, client.Dataset("d").Table("groups"), src, "groups", 0)
+			if err != nil {
+				t.Fatalf("loadChunk(): unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tCase.wantInserted, srv.inserted); diff != "" {
+				t.Errorf("loadChunk(): unexpected load jobs (-want, +got):\n%s", diff)
+			}
+			if src.ObjectsRead() != 2 {
+				t.Errorf("loadChunk(): %d objects were read, want 2", src.ObjectsRead())
+			}
+		})
+	}
+}
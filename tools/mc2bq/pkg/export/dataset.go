@@ -0,0 +1,222 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// DatasetOptions are the settings of the BigQuery dataset the data is
+// exported to. They are applied when the dataset is created and an existing
+// dataset is checked against them before any data is loaded, the export fails
+// if they differ unless Update is set. The settings that aren't set are left
+// as they are.
+type DatasetOptions struct {
+	// Location is the location of the dataset, e.g. europe-west1 or EU. The
+	// location of an existing dataset can't be changed.
+	Location string
+	// KMSKeyName is the Cloud KMS key that encrypts the tables of the
+	// dataset by default (CMEK).
+	KMSKeyName string
+	// Labels are added to the dataset.
+	Labels map[string]string
+	// TableLabels are added to the exported tables.
+	TableLabels map[string]string
+	// DefaultTableExpiration is the default lifetime of the tables of the
+	// dataset, the tables never expire if it's zero.
+	DefaultTableExpiration time.Duration
+	// Update updates the settings of an existing dataset that differ,
+	// except its location, instead of failing the export.
+	Update bool
+}
+
+// metadata returns the metadata of a new dataset.
+func (o *DatasetOptions) metadata(name string) *bigquery.DatasetMetadata {
+	md := &bigquery.DatasetMetadata{
+		Name:                   name,
+		Location:               o.Location,
+		Labels:                 o.Labels,
+		DefaultTableExpiration: o.DefaultTableExpiration,
+	}
+	if o.KMSKeyName != "" {
+		md.DefaultEncryptionConfig = &bigquery.EncryptionConfig{KMSKeyName: o.KMSKeyName}
+	}
+	return md
+}
+
+// datasetDrift are the differences between an existing dataset and the
+// DatasetOptions.
+type datasetDrift struct {
+	differences []messages.LayoutDifference
+	// location is set if the dataset is in another location, which can't be
+	// changed.
+	location bool
+}
+
+// drift compares the settings of the dataset with md to o.
+func (o *DatasetOptions) drift(md *bigquery.DatasetMetadata) datasetDrift {
+	var res datasetDrift
+	if o.Location != "" && !strings.EqualFold(o.Location, md.Location) {
+		res.differences = append(res.differences, messages.LayoutDifference{Property: "location", Want: o.Location, Got: md.Location})
+		res.location = true
+	}
+	if o.KMSKeyName != "" {
+		got := "none"
+		if md.DefaultEncryptionConfig != nil && md.DefaultEncryptionConfig.KMSKeyName != "" {
+			got = md.DefaultEncryptionConfig.KMSKeyName
+		}
+		if got != o.KMSKeyName {
+			res.differences = append(res.differences, messages.LayoutDifference{Property: "default KMS key", Want: o.KMSKeyName, Got: got})
+		}
+	}
+	if diff, ok := labelsDrift(o.Labels, md.Labels); !ok {
+		res.differences = append(res.differences, diff)
+	}
+	if o.DefaultTableExpiration != 0 && o.DefaultTableExpiration != md.DefaultTableExpiration {
+		res.differences = append(res.differences, messages.LayoutDifference{
+			Property: "default table expiration",
+			Want:     o.DefaultTableExpiration.String(),
+			Got:      formatExpiration(md.DefaultTableExpiration),
+		})
+	}
+
+	return res
+}
+
+// labelsDrift returns false and the difference if got doesn't contain the
+// labels of want, labels that aren't in want are ignored.
+func labelsDrift(want map[string]string, got map[string]string) (messages.LayoutDifference, bool) {
+	var missing []string
+	for key, value := range want {
+		if gotValue, ok := got[key]; !ok || gotValue != value {
+			missing = append(missing, key+"="+value)
+		}
+	}
+	if len(missing) == 0 {
+		return messages.LayoutDifference{}, true
+	}
+
+	sort.Strings(missing)
+	return messages.LayoutDifference{Property: "labels", Want: strings.Join(missing, ","), Got: formatLabels(got)}, false
+}
+
+// formatLabels formats labels as sorted key=value pairs.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "none"
+	}
+	var res []string
+	for key, value := range labels {
+		res = append(res, key+"="+value)
+	}
+	sort.Strings(res)
+	return strings.Join(res, ",")
+}
+
+// formatExpiration formats an expiration, zero is never.
+func formatExpiration(d time.Duration) string {
+	if d == 0 {
+		return "never"
+	}
+	return d.String()
+}
+
+// prepareDataset creates the dataset with the DatasetOptions of params if it
+// doesn't exist. An error is returned if the settings of an existing dataset
+// differ, they are updated to match if DatasetOptions.Update is set except the
+// location, which can't be changed.
+func prepareDataset(ctx context.Context, dataset *bigquery.Dataset, params *Params) error {
+	opts := &params.Dataset
+	md, err := dataset.Metadata(ctx)
+	if gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		messages.Print(messages.ExportCreatingDataset{DatasetID: params.DatasetID})
+		err = dataset.Create(ctx, opts.metadata(params.DatasetID))
+		if err == nil {
+			return nil
+		}
+		if !gapiutil.IsErrorWithCode(err, http.StatusConflict) {
+			return fmt.Errorf("create dataset: %w", err)
+		}
+		// The dataset was created concurrently, check it.
+		md, err = dataset.Metadata(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("get dataset: %w", err)
+	}
+
+	drift := opts.drift(md)
+	if drift.location {
+		return messages.NewError(messages.DatasetLocationMismatch{DatasetID: params.DatasetID, Location: md.Location, WantLocation: opts.Location})
+	}
+	if len(drift.differences) == 0 {
+		return nil
+	}
+	if !opts.Update {
+		return messages.NewError(messages.DatasetSettingsMismatch{DatasetID: params.DatasetID, Differences: drift.differences})
+	}
+
+	messages.Print(messages.ExportUpdatingDataset{DatasetID: params.DatasetID, Differences: drift.differences})
+	var update bigquery.DatasetMetadataToUpdate
+	if opts.KMSKeyName != "" {
+		update.DefaultEncryptionConfig = &bigquery.EncryptionConfig{KMSKeyName: opts.KMSKeyName}
+	}
+	if opts.DefaultTableExpiration != 0 {
+		update.DefaultTableExpiration = opts.DefaultTableExpiration
+	}
+	for key, value := range opts.Labels {
+		update.SetLabel(key, value)
+	}
+	_, err = dataset.Update(ctx, update, md.ETag)
+	if err != nil {
+		return fmt.Errorf("update dataset: %w", err)
+	}
+	return nil
+}
+
+// applyTableLabels adds labels to tbl, the table isn't updated if it already
+// has them.
+func applyTableLabels(ctx context.Context, tbl *bigquery.Table, labels map[string]string) error {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	md, err := tbl.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("label %s: %w", tbl.TableID, err)
+	}
+	if _, ok := labelsDrift(labels, md.Labels); ok {
+		return nil
+	}
+
+	var update bigquery.TableMetadataToUpdate
+	for key, value := range labels {
+		update.SetLabel(key, value)
+	}
+	_, err = tbl.Update(ctx, update, md.ETag)
+	if err != nil {
+		return fmt.Errorf("label %s: %w", tbl.TableID, err)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDatasetOptionsMetadata(t *testing.T) {
+	opts := &DatasetOptions{
+		Location:               "europe-west1",
+		KMSKeyName:             "projects/p/locations/europe-west1/keyRings/r/cryptoKeys/k",
+		Labels:                 map[string]string{"team": "payments"},
+		DefaultTableExpiration: 30 * 24 * time.Hour,
+	}
+	want := &bigquery.DatasetMetadata{
+		Name:                    "dataset",
+		Location:                "europe-west1",
+		Labels:                  map[string]string{"team": "payments"},
+		DefaultTableExpiration:  30 * 24 * time.Hour,
+		DefaultEncryptionConfig: &bigquery.EncryptionConfig{KMSKeyName: "projects/p/locations/europe-west1/keyRings/r/cryptoKeys/k"},
+	}
+	if diff := cmp.Diff(want, opts.metadata("dataset")); diff != "" {
+		t.Errorf("metadata(): unexpected metadata (-want, +got):\n%s", diff)
+	}
+
+	// A dataset without options is created with the defaults of BigQuery.
+	if diff := cmp.Diff(&bigquery.DatasetMetadata{Name: "dataset"}, (&DatasetOptions{}).metadata("dataset")); diff != "" {
+		t.Errorf("metadata(): unexpected metadata (-want, +got):\n%s", diff)
+	}
+}
+
+func TestDatasetOptionsDrift(t *testing.T) {
+	opts := &DatasetOptions{
+		Location:               "EU",
+		KMSKeyName:             "key",
+		Labels:                 map[string]string{"team": "payments", "env": "prod"},
+		DefaultTableExpiration: 24 * time.Hour,
+	}
+	tests := []struct {
+		name         string
+		opts         *DatasetOptions
+		md           *bigquery.DatasetMetadata
+		wantDiffs    []string
+		wantLocation bool
+	}{
+		{name: "same", opts: opts, md: &bigquery.DatasetMetadata{
+			Location:                "eu",
+			DefaultEncryptionConfig: &bigquery.EncryptionConfig{KMSKeyName: "key"},
+			Labels:                  map[string]string{"team": "payments", "env": "prod", "other": "label"},
+			DefaultTableExpiration:  24 * time.Hour,
+		}},
+		{name: "no options", opts: &DatasetOptions{}, md: &bigquery.DatasetMetadata{Location: "US"}},
+		{name: "different", opts: opts,
+			md: &bigquery.DatasetMetadata{Location: "US", Labels: map[string]string{"team": "billing"}},
+			wantDiffs: []string{
+				"location is US instead of EU",
+				"default KMS key is none instead of key",
+				"labels is team=billing instead of env=prod,team=payments",
+				"default table expiration is never instead of 24h0m0s",
+			},
+			wantLocation: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			drift := tc.opts.drift(tc.md)
+			var got []string
+			for _, diff := range drift.differences {
+				got = append(got, diff.String())
+			}
+			if diff := cmp.Diff(tc.wantDiffs, got); diff != "" {
+				t.Errorf("drift(): unexpected differences (-want, +got):\n%s", diff)
+			}
+			if drift.location != tc.wantLocation {
+				t.Errorf("drift(): location = %v, want %v", drift.location, tc.wantLocation)
+			}
+		})
+	}
+}
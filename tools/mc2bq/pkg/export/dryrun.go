@@ -0,0 +1,254 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// dryRunSampleSize is the number of assets that are serialized to estimate
+// the size of the assets table.
+const dryRunSampleSize = 100
+
+// dryRun prints what exporting params would do to the BigQuery dataset without
+// writing anything: whether the dataset and the tables exist, the number of
+// objects of the tables that can be counted and the differences between the
+// schemas of the existing tables and params.Schema.
+func dryRun(ctx context.Context, params *Params) error {
+	messages.Print(messages.DryRunNothingWritten)
+
+	bq, err := bigquery.NewClient(ctx, params.TargetProjectID, buildClientOptions(params)...)
+	if err != nil {
+		return fmt.Errorf("create bigquery client: %w", err)
+	}
+	defer bq.Close()
+
+	dataset := bq.Dataset(params.DatasetID)
+	datasetMD, err := dataset.Metadata(ctx)
+	if err != nil && !gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		return fmt.Errorf("get dataset: %w", err)
+	}
+	datasetExists := err == nil
+	messages.Print(messages.DryRunDataset{DatasetID: params.DatasetID, Exists: datasetExists})
+	if datasetExists {
+		for _, diff := range params.Dataset.drift(datasetMD).differences {
+			messages.Print(messages.DryRunDatasetDifference{Difference: diff})
+		}
+	}
+
+	mc, err := MCFactory(ctx, params)
+	if err != nil {
+		return err
+	}
+	failures := projectErrors{multiProject: params.isMultiProject()}
+	scopes, err := discoverScopes(ctx, mc, params, &failures)
+	if err != nil {
+		return err
+	}
+
+	for _, tbl := range exportTables(mc, params.Schema) {
+		if len(tbl.schema) == 0 {
+			// The schema predates this table, skip it.
+			continue
+		}
+
+		name := params.TablePrefix + tbl.tableSuffix
+		schema := tbl.newSource(ctx, scopes[0].path).Schema()
+		layout := params.TableLayouts[tbl.tableSuffix]
+		err := layout.validate(name, schema, params.Mode, tbl.incremental)
+		if err != nil {
+			return err
+		}
+
+		var md *bigquery.TableMetadata
+		if datasetExists {
+			md, err = dataset.Table(name).Metadata(ctx)
+			if gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+				md, err = nil, nil
+			}
+			if err != nil {
+				return fmt.Errorf("get table %s: %w", name, err)
+			}
+		}
+
+		incremental := params.Mode == ModeIncremental && tbl.incremental
+		plan := messages.DryRunTable{
+			TableName: name,
+			Action:    dryRunAction(params, md, incremental),
+		}
+		switch tbl.tableSuffix {
+		case "assets":
+			plan.Counted = true
+			plan.Estimated = true
+			plan.RecordCount, plan.Bytes, err = estimateAssets(ctx, tbl, scopes)
+		case "groups", "preference_sets":
+			plan.Counted = true
+			plan.RecordCount, plan.Bytes, err = countObjects(ctx, tbl, scopes)
+		}
+		if err != nil {
+			return fmt.Errorf("count %s: %w", name, err)
+		}
+		messages.Print(plan)
+
+		if md == nil {
+			continue
+		}
+		if incremental {
+			// The column is added by incrementalTable.prepare.
+			schema = append(schema, &bigquery.FieldSchema{Name: deleteTimeColumn, Type: bigquery.TimestampFieldType})
+		}
+		for _, diff := range schemaDiff("", md.Schema, schema) {
+			messages.Print(diff)
+		}
+		partitioning := layout.timePartitioning()
+		if params.Mode == ModeSnapshot {
+			partitioning = &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: exportTimeColumn}
+		}
+		for _, diff := range layout.drift(md, partitioning).differences {
+			messages.Print(messages.DryRunLayoutDifference{Difference: diff})
+		}
+	}
+
+	return failures.err(len(params.projects()))
+}
+
+// dryRunAction returns what an export with params would do to a table with
+// md, md is nil if the table doesn't exist. incremental is set if the table is
+// exported incrementally.
+func dryRunAction(params *Params, md *bigquery.TableMetadata, incremental bool) messages.Message {
+	switch {
+	case md == nil:
+		return messages.DryRunActionCreate
+	case incremental:
+		return messages.DryRunActionMerge
+	case params.Mode == ModeSnapshot && isSnapshotTable(md):
+		return messages.DryRunActionAppend
+	case params.Mode == ModeSnapshot:
+		return messages.DryRunActionNotSnapshot
+	case params.Mode == ModeFull && !params.Force:
+		return messages.DryRunActionExists
+	}
+	// Incremental exports replace the tables that aren't exported
+	// incrementally.
+	return messages.DryRunActionReplace
+}
+
+// estimateAssets returns the number of assets of the scopes and an estimate
+// of their size, which is extrapolated from the size of the first
+// dryRunSampleSize assets of the first scope that has assets.
+func estimateAssets(ctx context.Context, tbl exportTable, scopes []exportScope) (count uint64, bytes uint64, err error) {
+	for _, scope := range scopes {
+		count += scope.assetCount
+	}
+
+	for _, scope := range scopes {
+		if scope.assetCount == 0 {
+			continue
+		}
+		// Stop listing the assets once the sample was read.
+		sampleCtx, cancel := context.WithCancel(ctx)
+		objects, sampleBytes, err := sampleObjects(tbl.newSource(sampleCtx, scope.path), dryRunSampleSize)
+		cancel()
+		if err != nil {
+			return 0, 0, err
+		}
+		if objects > 0 {
+			return count, sampleBytes * count / objects, nil
+		}
+	}
+
+	return count, 0, nil
+}
+
+// sampleObjects reads at most n objects from src and returns the number of
+// objects and bytes read.
+func sampleObjects(src io.Reader, n uint64) (objects uint64, bytes uint64, err error) {
+	r := bufio.NewReader(src)
+	for objects < n {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return objects, bytes, nil
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		objects++
+		bytes += uint64(len(line))
+	}
+
+	return objects, bytes, nil
+}
+
+// countObjects reads all the objects of tbl in the scopes and returns their
+// number and size.
+func countObjects(ctx context.Context, tbl exportTable, scopes []exportScope) (count uint64, bytes uint64, err error) {
+	for _, scope := range scopes {
+		src := tbl.newSource(ctx, scope.path)
+		_, err := io.Copy(io.Discard, src)
+		if err != nil {
+			return 0, 0, err
+		}
+		count += src.ObjectsRead()
+		bytes += src.BytesRead()
+	}
+
+	return count, bytes, nil
+}
+
+// schemaDiff returns the differences between the schema of an existing table
+// and the schema the export writes to it, the columns are prefixed with
+// prefix. The descriptions of the columns aren't compared.
+func schemaDiff(prefix string, existing bigquery.Schema, want bigquery.Schema) []messages.SchemaDifference {
+	var res []messages.SchemaDifference
+	for _, field := range want {
+		got := findColumn(existing, field.Name)
+		switch {
+		case got == nil:
+			res = append(res, messages.SchemaDifference{Column: prefix + field.Name, Want: fieldType(field)})
+		case fieldType(got) != fieldType(field):
+			res = append(res, messages.SchemaDifference{Column: prefix + field.Name, Want: fieldType(field), Got: fieldType(got)})
+		case field.Type == bigquery.RecordFieldType:
+			res = append(res, schemaDiff(prefix+field.Name+".", got.Schema, field.Schema)...)
+		}
+	}
+	for _, field := range existing {
+		if findColumn(want, field.Name) == nil {
+			res = append(res, messages.SchemaDifference{Column: prefix + field.Name, Got: fieldType(field)})
+		}
+	}
+
+	return res
+}
+
+// fieldType returns the type and mode of field, e.g. REPEATED STRING.
+func fieldType(field *bigquery.FieldSchema) string {
+	switch {
+	case field.Repeated:
+		return "REPEATED " + string(field.Type)
+	case field.Required:
+		return "REQUIRED " + string(field.Type)
+	}
+	return string(field.Type)
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+func TestSchemaDiff(t *testing.T) {
+	existing := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Description: "old description"},
+		{Name: "count", Type: bigquery.StringFieldType},
+		{Name: "removed", Type: bigquery.StringFieldType},
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "enabled", Type: bigquery.BooleanFieldType},
+		}},
+	}
+	want := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Description: "new description"},
+		{Name: "count", Type: bigquery.IntegerFieldType},
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "enabled", Type: bigquery.BooleanFieldType},
+			{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		}},
+	}
+
+	var got []string
+	for _, diff := range schemaDiff("", existing, want) {
+		got = append(got, diff.String())
+	}
+	wantDiffs := []string{
+		"  ~ count STRING -> INTEGER",
+		"  + details.tags REPEATED STRING",
+		"  - removed STRING",
+	}
+	if diff := cmp.Diff(wantDiffs, got); diff != "" {
+		t.Errorf("schemaDiff(): unexpected differences (-want, +got):\n%s", diff)
+	}
+
+	if diffs := schemaDiff("", want, want); len(diffs) != 0 {
+		t.Errorf("schemaDiff() of the same schema = %v, want no differences", diffs)
+	}
+}
+
+func TestDryRunAction(t *testing.T) {
+	table := &bigquery.TableMetadata{}
+	snapshots := &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{Field: exportTimeColumn}}
+	tests := []struct {
+		name        string
+		params      Params
+		md          *bigquery.TableMetadata
+		incremental bool
+		want        messages.Message
+	}{
+		{name: "new table", params: Params{Mode: ModeFull}, want: messages.DryRunActionCreate},
+		{name: "existing table", params: Params{Mode: ModeFull}, md: table, want: messages.DryRunActionExists},
+		{name: "forced", params: Params{Mode: ModeFull, Force: true}, md: table, want: messages.DryRunActionReplace},
+		{name: "incremental", params: Params{Mode: ModeIncremental}, md: table, incremental: true, want: messages.DryRunActionMerge},
+		{name: "incremental replaced", params: Params{Mode: ModeIncremental}, md: table, want: messages.DryRunActionReplace},
+		{name: "snapshot", params: Params{Mode: ModeSnapshot}, md: snapshots, want: messages.DryRunActionAppend},
+		{name: "not snapshot", params: Params{Mode: ModeSnapshot}, md: table, want: messages.DryRunActionNotSnapshot},
+		{name: "not snapshot forced", params: Params{Mode: ModeSnapshot, Force: true}, md: table, want: messages.DryRunActionNotSnapshot},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := dryRunAction(&tc.params, tc.md, tc.incremental)
+			if got != tc.want {
+				t.Errorf("dryRunAction() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSampleObjects(t *testing.T) {
+	data := `{"name":"a"}` + "\n" + `{"name":"bb"}` + "\n" + `{"name":"ccc"}` + "\n"
+	for _, tc := range []struct {
+		n           uint64
+		wantObjects uint64
+		wantBytes   uint64
+	}{
+		{n: 2, wantObjects: 2, wantBytes: 27},
+		{n: 10, wantObjects: 3, wantBytes: 42},
+	} {
+		objects, bytes, err := sampleObjects(strings.NewReader(data), tc.n)
+		if err != nil {
+			t.Fatalf("sampleObjects(): unexpected error: %v", err)
+		}
+		if objects != tc.wantObjects || bytes != tc.wantBytes {
+			t.Errorf("sampleObjects(%d) = %d, %d, want %d, %d", tc.n, objects, bytes, tc.wantObjects, tc.wantBytes)
+		}
+	}
+}
+
+func TestDryRunCounts(t *testing.T) {
+	schema := bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}
+	// Every scope has 200 groups named with 9 characters.
+	tbl := exportTable{schema: schema, newSource: func(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+		it := &sliceIterator[*migrationcenterpb.Group]{}
+		for i := 0; i < 200; i++ {
+			it.items = append(it.items, &migrationcenterpb.Group{Name: fmt.Sprintf("group-%03d", i)})
+		}
+		return newObjectReader[*migrationcenterpb.Group](it, "group", schema)
+	}}
+	scopes := []exportScope{
+		{path: mcutil.ProjectAndLocation{Project: "p", Location: "us-central1"}, assetCount: 200},
+		{path: mcutil.ProjectAndLocation{Project: "p", Location: "europe-west1"}, assetCount: 1000},
+	}
+	const objectBytes = uint64(len(`{"name":"group-000"}` + "\n"))
+
+	count, bytes, err := countObjects(context.Background(), tbl, scopes)
+	if err != nil {
+		t.Fatalf("countObjects(): unexpected error: %v", err)
+	}
+	if count != 400 || bytes != 400*objectBytes {
+		t.Errorf("countObjects() = %d, %d, want %d, %d", count, bytes, 400, 400*objectBytes)
+	}
+
+	// The size of the objects that weren't listed is extrapolated.
+	count, bytes, err = estimateAssets(context.Background(), tbl, scopes)
+	if err != nil {
+		t.Fatalf("estimateAssets(): unexpected error: %v", err)
+	}
+	if count != 1200 || bytes != 1200*objectBytes {
+		t.Errorf("estimateAssets() = %d, %d, want %d, %d", count, bytes, 1200, 1200*objectBytes)
+	}
+}
+
+func TestDryRunUnsupported(t *testing.T) {
+	params := &Params{ProjectID: "p", DryRun: true, OutputDir: t.TempDir()}
+	err := normalizeParams(params)
+	if err == nil || !strings.Contains(err.Error(), string(messages.ErrMsgDryRunUnsupported)) {
+		t.Errorf("normalizeParams() = %v, want %q", err, messages.ErrMsgDryRunUnsupported)
+	}
+}
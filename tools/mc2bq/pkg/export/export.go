@@ -18,49 +18,286 @@ package export
 import (
 	"context"
 	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	migrationcenter "cloud.google.com/go/migrationcenter/apiv1"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 	"google.golang.org/protobuf/reflect/protoreflect"
 
-	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
 	exporterschema "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/telemetry"
 )
 
 var errTableExists = messages.NewError(messages.ErrMsgExportTableExists)
 
+// AssetView is the view that is used when listing assets.
+type AssetView string
+
+// Supported asset views
+const (
+	// AssetViewFull exports all the metadata of the assets including
+	// performance data and insights.
+	AssetViewFull AssetView = "full"
+	// AssetViewBasic exports only the basic metadata of the assets.
+	AssetViewBasic AssetView = "basic"
+)
+
+// ParseAssetView parses an asset view name, an empty name is the full view.
+func ParseAssetView(name string) (AssetView, error) {
+	switch view := AssetView(strings.ToLower(name)); view {
+	case "":
+		return AssetViewFull, nil
+	case AssetViewFull, AssetViewBasic:
+		return view, nil
+	}
+
+	return "", messages.NewError(messages.InvalidAssetView{View: name})
+}
+
+func (view AssetView) proto() migrationcenterpb.AssetView {
+	if view == AssetViewBasic {
+		return migrationcenterpb.AssetView_ASSET_VIEW_BASIC
+	}
+
+	return migrationcenterpb.AssetView_ASSET_VIEW_FULL
+}
+
+// Mode controls how the data is written to tables that already contain data
+// from a previous export.
+type Mode string
+
+// Supported export modes
+const (
+	// ModeFull replaces the content of the tables with all the data.
+	ModeFull Mode = "full"
+	// ModeIncremental merges the assets that were updated since the
+	// previous export into the existing assets table and marks the assets
+	// that no longer exist as deleted. The other tables are replaced.
+	ModeIncremental Mode = "incremental"
+	// ModeSnapshot appends the data to the tables, every row contains the
+	// time it was exported in the export_time column.
+	ModeSnapshot Mode = "snapshot"
+)
+
+// ParseMode parses an export mode name, an empty name is the full mode.
+func ParseMode(name string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(name)); mode {
+	case "":
+		return ModeFull, nil
+	case ModeFull, ModeIncremental, ModeSnapshot:
+		return mode, nil
+	}
+
+	return "", messages.NewError(messages.InvalidMode{Mode: name})
+}
+
 // Params are the parameters for the Export function.
 type Params struct {
-	ProjectID       string
-	Region          string
-	TargetProjectID string
-	Force           bool
-	DatasetID       string
-	TablePrefix     string
-	Schema          *exporterschema.ExporterSchema
+	ProjectID string
+	Region    string
+	// AllRegions exports the data of all the regions that contain Migration
+	// Center data instead of only Region, each row will contain the region
+	// it was exported from in the location column.
+	AllRegions bool
+	// ProjectIDs exports the data of multiple projects instead of ProjectID,
+	// each row will contain the project it was exported from in the
+	// project_id column.
+	ProjectIDs []string
+	// ProjectConcurrency is the maximum number of projects that are
+	// exported concurrently when exporting multiple projects.
+	ProjectConcurrency int
+	TargetProjectID    string
+	Force              bool
+	DatasetID          string
+	TablePrefix        string
+	Schema             *exporterschema.ExporterSchema
+	AssetView          AssetView
+	// AssetFields are the dotted paths of the asset fields that are
+	// exported, all the fields are exported if it's empty.
+	AssetFields []string
+	Mode        Mode
+	// TableLayouts are the partitioning and clustering of the BigQuery
+	// tables.
+	TableLayouts TableLayouts
+	// Dataset are the settings of the BigQuery dataset and its tables.
+	Dataset DatasetOptions
+	// AssetShards is the number of iterators that list the assets of a
+	// project and region concurrently, each lists a range of create times.
+	// The assets are listed by a single iterator if it's 1 or less.
+	AssetShards int
+	// AssetFilter selects the assets that are exported, the groups and
+	// preference sets are narrowed to the matching assets.
+	AssetFilter AssetFilter
+	// Reconcile compares the exported assets with Migration Center after
+	// the export, only when exporting to BigQuery.
+	Reconcile ReconcileOptions
+	// SnapshotRetention is the time snapshots are kept for in ModeSnapshot,
+	// snapshots are kept forever if it's zero.
+	SnapshotRetention time.Duration
+	// ExportTime is the time that is stored in the export_time column in
+	// ModeSnapshot, the current time is used if it's not set.
+	ExportTime time.Time
+	// Resume continues the previous export if it didn't complete, the data
+	// that was already loaded to the staging tables is not exported again.
+	Resume bool
+	// DryRun prints what the export would do to the BigQuery dataset and
+	// its tables instead of exporting the data, nothing is written.
+	DryRun bool
+	// WriteMethod is the way the data is written to BigQuery, exports that
+	// use WriteMethodStorageWrite can't be resumed.
+	WriteMethod WriteMethod
+	// OutputDir writes the data to files in the directory instead of
+	// BigQuery, the dataset and the target project aren't used.
+	OutputDir string
+	// OutputFormat is the format of the files written to OutputDir.
+	OutputFormat OutputFormat
+	// OutputDB writes the data to a relational database instead of
+	// BigQuery, either a PostgreSQL URL (postgres://...) or the path of a
+	// SQLite database file prefixed with sqlite:.
+	OutputDB string
+	// Sink is the destination of the exported data, if it's not set the
+	// data is exported to BigQuery (or to OutputDir or OutputDB if set).
+	Sink Sink
+	// Output is the format of the messages that Export prints, see
+	// messages.SetOutput.
+	Output messages.Output
+	// Telemetry selects where the spans and metrics of Export are sent,
+	// see telemetry.Setup.
+	Telemetry       telemetry.Options
 	MCOptions       []option.ClientOption
 	UserAgentSuffix string
 }
 
-func normalizeParams(params *Params) {
+// DefaultProjectConcurrency is the default for Params.ProjectConcurrency.
+const DefaultProjectConcurrency = 4
+
+func normalizeParams(params *Params) error {
 	if params.TargetProjectID == "" {
-		params.TargetProjectID = params.ProjectID
+		params.TargetProjectID = params.projects()[0]
+	}
+
+	if params.ExportTime.IsZero() {
+		params.ExportTime = time.Now()
+	}
+
+	if params.ProjectConcurrency <= 0 {
+		params.ProjectConcurrency = DefaultProjectConcurrency
 	}
 
 	if params.Schema == nil {
 		params.Schema = &exporterschema.EmbeddedSchema
 	}
+	// Describe the columns of the tables that are created by the export.
+	params.Schema = params.Schema.WithDescriptions()
+
+	var err error
+	if len(params.AssetFields) > 0 {
+		params.Schema, err = params.Schema.ProjectAssetTable(params.AssetFields)
+		if err != nil {
+			return err
+		}
+	}
+	params.AssetView, err = ParseAssetView(string(params.AssetView))
+	if err != nil {
+		return err
+	}
+	params.Mode, err = ParseMode(string(params.Mode))
+	if err != nil {
+		return err
+	}
+	params.OutputFormat, err = ParseOutputFormat(string(params.OutputFormat))
+	if err != nil {
+		return err
+	}
+	params.WriteMethod, err = ParseWriteMethod(string(params.WriteMethod))
+	if err != nil {
+		return err
+	}
+	params.Output, err = messages.ParseOutput(string(params.Output))
+	if err != nil {
+		return err
+	}
+	params.Telemetry.Traces, err = telemetry.ParseExporter(string(params.Telemetry.Traces))
+	if err != nil {
+		return err
+	}
+	params.Telemetry.Metrics, err = telemetry.ParseExporter(string(params.Telemetry.Metrics))
+	if err != nil {
+		return err
+	}
+	if params.Output == messages.OutputJSON && params.Telemetry.Writer == nil {
+		// stdout only contains the events.
+		params.Telemetry.Writer = os.Stderr
+	}
+	err = params.TableLayouts.validate()
+	if err != nil {
+		return err
+	}
+	if params.Resume && params.Mode == ModeIncremental {
+		return errResumeIncremental
+	}
+	if params.Resume && params.WriteMethod == WriteMethodStorageWrite {
+		return messages.NewError(messages.ErrMsgResumeStorageWrite)
+	}
+	if params.DryRun && (params.Sink != nil || params.OutputDir != "" || params.OutputDB != "") {
+		return messages.NewError(messages.ErrMsgDryRunUnsupported)
+	}
+	params.Reconcile.Mode, err = ParseReconcileMode(string(params.Reconcile.Mode))
+	if err != nil {
+		return err
+	}
+	if params.Reconcile.TolerancePercent < 0 {
+		return messages.NewError(messages.ErrMsgReconcileTolerance)
+	}
+	if len(params.Reconcile.Fields) == 0 {
+		params.Reconcile.Fields = DefaultReconcileFields
+	}
+	if params.Reconcile.Mode != ReconcileNone && (params.Sink != nil || params.OutputDir != "" || params.OutputDB != "") {
+		return messages.NewError(messages.ErrMsgReconcileUnsupported)
+	}
+	if params.Sink == nil {
+		params.Sink, err = defaultSink(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.AssetView == AssetViewBasic {
+		// Don't create columns for fields that the basic view never returns.
+		params.Schema = params.Schema.BasicAssetView()
+	}
+
+	return nil
+}
+
+// isMultiProject returns true if params export more than a single project.
+func (params *Params) isMultiProject() bool {
+	return len(params.ProjectIDs) > 0
+}
+
+// projects returns the projects that should be exported.
+func (params *Params) projects() []string {
+	if params.isMultiProject() {
+		return params.ProjectIDs
+	}
+
+	return []string{params.ProjectID}
 }
 
 func buildClientOptions(params *Params) []option.ClientOption {
@@ -77,8 +314,172 @@ func buildMCClientOptions(params *Params) []option.ClientOption {
 	return append(buildClientOptions(params), params.MCOptions...)
 }
 
-func newExportTask(ctx context.Context, dataset *bigquery.Dataset, params *Params, src mcutil.ObjectSource, tableSuffix string, objectCount uint64) func() error {
-	tblName := params.TablePrefix + tableSuffix
+// snapshotExportTime returns the value of the export_time column, which is
+// only added in ModeSnapshot.
+func (params *Params) snapshotExportTime() time.Time {
+	if params.Mode != ModeSnapshot {
+		return time.Time{}
+	}
+
+	return params.ExportTime
+}
+
+// displayScope returns the project and location of pal that are reported in
+// messages, they are only reported when more than one is exported.
+func (params *Params) displayScope(pal mcutil.ProjectAndLocation) (projectID string, location string) {
+	if params.isMultiProject() {
+		projectID = pal.Project
+	}
+	if params.AllRegions {
+		location = pal.Location
+	}
+	return projectID, location
+}
+
+// scopeColumns returns the columns that identify the scope of the rows, the
+// project_id and location columns are only added when more than one project
+// or region is exported.
+func (params *Params) scopeColumns() []string {
+	var res []string
+	if params.isMultiProject() {
+		res = append(res, "project_id")
+	}
+	if params.AllRegions {
+		res = append(res, "location")
+	}
+	return res
+}
+
+// scopeKey returns the key of the rows of pal in the tables, the parts of pal
+// that aren't stored in the tables are omitted.
+func (params *Params) scopeKey(pal mcutil.ProjectAndLocation) mcutil.ProjectAndLocation {
+	var key mcutil.ProjectAndLocation
+	if params.isMultiProject() {
+		key.Project = pal.Project
+	}
+	if params.AllRegions {
+		key.Location = pal.Location
+	}
+	return key
+}
+
+// exportTable describes a table that is exported and how to obtain its objects.
+type exportTable struct {
+	schema      bigquery.Schema
+	tableSuffix string
+	newSource   func(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource
+	// counted is set if the number of objects is known in advance, which
+	// is used to report progress.
+	counted bool
+	// incremental is set if the table can be exported incrementally, see
+	// ModeIncremental.
+	incremental bool
+}
+
+func exportTables(mc mcutil.MC, schema *exporterschema.ExporterSchema) []exportTable {
+	return []exportTable{
+		{schema.GroupTable, "groups", mc.GroupSource, false, false},
+		{schema.AssetTable, "assets", mc.AssetSource, true, true},
+		{schema.PreferenceSetTable, "preference_sets", mc.PreferenceSetSource, false, false},
+		{schema.ErrorFrameTable, "error_frames", mc.ErrorFrameSource, false, false},
+		{schema.ReportConfigTable, "report_configs", mc.ReportConfigSource, false, false},
+		{schema.ReportTable, "reports", mc.ReportSource, false, false},
+		{schema.ReportSummaryTable, "report_summaries", mc.ReportSummarySource, false, false},
+		{schema.SourceTable, "sources", mc.SourceSource, false, false},
+		{schema.ImportJobTable, "import_jobs", mc.ImportJobSource, false, false},
+		{schema.ImportDataFileTable, "import_data_files", mc.ImportDataFileSource, false, false},
+	}
+}
+
+// exportScope is a single Migration Center location that is exported.
+type exportScope struct {
+	path       mcutil.ProjectAndLocation
+	assetCount uint64
+}
+
+// exportScopes returns the locations of project that should be exported according to params.
+func exportScopes(ctx context.Context, mc mcutil.MC, params *Params, project string) ([]exportScope, error) {
+	regions := []string{params.Region}
+	if params.AllRegions {
+		var err error
+		regions, err = mc.Locations(ctx, project)
+		if err != nil {
+			return nil, fmt.Errorf("list locations: %w", err)
+		}
+	}
+
+	var scopes []exportScope
+	for _, region := range regions {
+		path := mcutil.ProjectAndLocation{Project: project, Location: region}
+		assetCount, err := mc.AssetCount(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("fetch asset count for %s: %w", region, err)
+		}
+		if params.AllRegions && assetCount == 0 {
+			// Skip regions without data
+			continue
+		}
+		if params.AllRegions {
+			messages.Print(messages.ExportFoundRegion{ProjectID: project, Region: region, AssetCount: uint64(assetCount)})
+		}
+
+		scopes = append(scopes, exportScope{path: path, assetCount: uint64(assetCount)})
+	}
+
+	return scopes, nil
+}
+
+// projectErrors collects the errors of the projects that failed to export.
+// When exporting multiple projects a failing project doesn't stop the export
+// of the other projects.
+type projectErrors struct {
+	multiProject bool
+
+	mu   sync.Mutex
+	errs map[string]error
+}
+
+// handle handles err that occurred while exporting project. It returns err
+// if the export should be aborted.
+func (pe *projectErrors) handle(project string, err error) error {
+	if err == nil || !pe.multiProject {
+		return err
+	}
+
+	messages.Print(messages.ExportProjectFailed{ProjectID: project, Err: err})
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	if pe.errs == nil {
+		pe.errs = map[string]error{}
+	}
+	pe.errs[project] = err
+	return nil
+}
+
+// failed returns the sorted projects that failed.
+func (pe *projectErrors) failed() []string {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	var res []string
+	for project := range pe.errs {
+		res = append(res, project)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// err returns an error summarizing all the failed projects.
+func (pe *projectErrors) err(projectCount int) error {
+	failed := pe.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	return messages.NewError(messages.ExportProjectsFailed{ProjectIDs: failed, ProjectCount: projectCount})
+}
+
+// newExportTask returns a task that runs export and reports the progress of
+// reading the objects from src.
+func newExportTask(ctx context.Context, tblName string, src mcutil.ObjectSource, export func(ctx context.Context) error, projectID string, location string, objectCount uint64) func() error {
 	return func() error {
 		done := make(chan bool, 1)
 		defer close(done)
@@ -95,8 +496,10 @@ func newExportTask(ctx context.Context, dataset *bigquery.Dataset, params *Param
 						// Don't write progress if we haven't started or just finished
 						continue
 					}
-					fmt.Println(messages.ExportTableInProgress{
+					messages.Print(messages.ExportTableInProgress{
 						TableName:          tblName,
+						ProjectID:          projectID,
+						Location:           location,
 						RecordsTransferred: src.ObjectsRead(),
 						RecordCount:        objectCount,
 						BytesTransferred:   src.BytesRead(),
@@ -106,15 +509,20 @@ func newExportTask(ctx context.Context, dataset *bigquery.Dataset, params *Param
 			}
 		}()
 
-		err := exportObjects(ctx, dataset, params, src, tblName)
+		err := export(ctx)
 		if err != nil {
-			return fmt.Errorf("export %s: %w", tableSuffix, err)
+			if location != "" {
+				return fmt.Errorf("export %s from %s: %w", tblName, location, err)
+			}
+			return fmt.Errorf("export %s: %w", tblName, err)
 		}
 
 		done <- true
 
-		fmt.Println(messages.ExportTableComplete{
+		messages.Print(messages.ExportTableComplete{
 			TableName:        tblName,
+			ProjectID:        projectID,
+			Location:         location,
 			RecordCount:      src.ObjectsRead(),
 			BytesTransferred: src.BytesRead(),
 		})
@@ -123,93 +531,547 @@ func newExportTask(ctx context.Context, dataset *bigquery.Dataset, params *Param
 	}
 }
 
+// traceTask returns a task that runs task and ends span with the number of
+// objects read from src.
+func traceTask(span trace.Span, src mcutil.ObjectSource, task func() error) func() error {
+	return func() error {
+		err := task()
+		span.SetAttributes(telemetry.AttrRecords.Int64(int64(src.ObjectsRead())), telemetry.AttrBytes.Int64(int64(src.BytesRead())))
+		telemetry.End(span, err)
+		return err
+	}
+}
+
+// discoverScopes returns the locations of all the projects that should be
+// exported, the projects are discovered concurrently. Projects that fail are
+// reported to failures.
+func discoverScopes(ctx context.Context, mc mcutil.MC, params *Params, failures *projectErrors) ([]exportScope, error) {
+	projects := params.projects()
+	projectScopes := make([][]exportScope, len(projects))
+	grp := new(errgroup.Group)
+	grp.SetLimit(params.ProjectConcurrency)
+	for i, project := range projects {
+		i, project := i, project
+		grp.Go(func() error {
+			var err error
+			projectScopes[i], err = exportScopes(ctx, mc, params, project)
+			if err != nil {
+				err = fmt.Errorf("project %s: %w", project, err)
+			}
+			return failures.handle(project, err)
+		})
+	}
+	err := grp.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	var scopes []exportScope
+	for _, s := range projectScopes {
+		scopes = append(scopes, s...)
+	}
+	if len(scopes) == 0 {
+		if err := failures.err(len(projects)); err != nil {
+			return nil, err
+		}
+		return nil, messages.NewError(messages.ErrMsgNoRegionsWithData)
+	}
+
+	return scopes, nil
+}
+
 // MCFactory creates a an MC implementation accorording to params
 func MCFactory(ctx context.Context, params *Params) (mcutil.MC, error) {
 	svc, err := migrationcenter.NewClient(ctx, buildMCClientOptions(params)...)
 	if err != nil {
 		return nil, fmt.Errorf("create migration center client: %w", err)
 	}
+	countRetries(svc.CallOptions)
 
 	return &MCv1{
-		client: svc,
-		schema: params.Schema,
+		client:         svc,
+		schema:         params.Schema,
+		assetView:      params.AssetView.proto(),
+		projectColumn:  params.isMultiProject(),
+		locationColumn: params.AllRegions,
+		exportTime:     params.snapshotExportTime(),
+		assetShards:    params.AssetShards,
+		filter:         params.AssetFilter,
 	}, nil
 }
 
-// Export exports migration center data to BigQuery
+// Export exports migration center data to params.Sink, BigQuery is used if
+// it isn't set. Exports to BigQuery are recorded in the runs table of the
+// dataset.
 func Export(params *Params) error {
-	normalizeParams(params)
+	err := normalizeParams(params)
+	if err != nil {
+		return err
+	}
+	messages.SetOutput(params.Output)
 	// The operation never times out, the user can just kill the tool.
 	ctx := context.Background()
-
-	path := mcutil.ProjectAndLocation{Project: params.ProjectID, Location: params.Region}
-	bq, err := bigquery.NewClient(ctx, params.TargetProjectID, buildClientOptions(params)...)
+	shutdown, err := telemetry.Setup(ctx, params.Telemetry)
 	if err != nil {
-		return fmt.Errorf("create bigquery client: %w", err)
+		return err
+	}
+	defer func() {
+		err := shutdown(ctx)
+		if err != nil {
+			messages.Print(messages.TelemetryNotFlushed{Err: err})
+		}
+	}()
+	if params.DryRun {
+		return dryRun(ctx, params)
 	}
-	defer bq.Close()
 
-	fmt.Println(messages.ExportCreatingDataset{DatasetID: params.DatasetID})
-	dataset := bq.Dataset(params.DatasetID)
-	err = dataset.Create(ctx, &bigquery.DatasetMetadata{
-		Name: params.DatasetID,
-	})
-	err = gapiutil.IgnoreErrorWithCode(err, http.StatusConflict)
+	run := newRunRecord()
+	ctx = withJobRecorder(ctx, &run.jobs)
+	ctx, span := telemetry.Start(ctx, "mc2bq.export")
+	err = export(ctx, params, run)
+	telemetry.End(span, err)
+	if _, ok := params.Sink.(*bigQuerySink); ok {
+		// Exports to BigQuery are recorded in the dataset, including
+		// the ones that failed.
+		recordRun(ctx, params, run, err)
+	}
+	return err
+}
+
+// export exports the data to params.Sink, the tables and the objects that are
+// written to them are recorded in run.
+func export(ctx context.Context, params *Params, run *runRecord) error {
+	// The sink is created before the MC client because it may restore
+	// params.ExportTime.
+	sink := params.Sink
+	err := sink.Create(ctx, params)
 	if err != nil {
-		return fmt.Errorf("create dataset: %w", err)
+		return err
 	}
+	committed := false
+	defer func() {
+		if !committed {
+			sink.Abort(ctx)
+		}
+	}()
 
 	mc, err := MCFactory(ctx, params)
 	if err != nil {
 		return err
 	}
-	grp, ctx := errgroup.WithContext(ctx)
 
-	assetCount, err := mc.AssetCount(ctx, path)
+	projects := params.projects()
+	failures := projectErrors{multiProject: params.isMultiProject()}
+	scopes, err := discoverScopes(ctx, mc, params, &failures)
+	if err != nil {
+		return err
+	}
+
+	type task struct {
+		table       *Table
+		scope       exportScope
+		src         mcutil.ObjectSource
+		projectID   string
+		location    string
+		objectCount uint64
+	}
+	tasksByProject := map[string][]*task{}
+	createGrp, createCtx := errgroup.WithContext(ctx)
+	for _, tbl := range exportTables(mc, params.Schema) {
+		if len(tbl.schema) == 0 {
+			// The schema predates this table, skip it.
+			continue
+		}
+
+		// The source is only used to obtain the schema, it doesn't fetch
+		// any data.
+		table := &Table{
+			Name:        params.TablePrefix + tbl.tableSuffix,
+			Schema:      tbl.newSource(ctx, scopes[0].path).Schema(),
+			Incremental: tbl.incremental,
+			Layout:      params.TableLayouts[tbl.tableSuffix],
+			def:         tbl,
+			mc:          mc,
+		}
+		run.addTable(table.Name)
+		for _, scope := range scopes {
+			t := &task{table: table, scope: scope}
+			t.projectID, t.location = params.displayScope(scope.path)
+			if tbl.counted {
+				t.objectCount = scope.assetCount
+			}
+			tasksByProject[scope.path.Project] = append(tasksByProject[scope.path.Project], t)
+		}
+
+		// All the scopes share the same table so it is created once
+		// before any data is written to it.
+		createGrp.Go(func() error {
+			err := sink.CreateTable(createCtx, table)
+			if err != nil {
+				return fmt.Errorf("export %s: %w", table.Name, err)
+			}
+			return nil
+		})
+	}
+
+	err = createGrp.Wait()
 	if err != nil {
-		return fmt.Errorf("fetch asset count: %w", err)
+		return err
+	}
+
+	grp := new(errgroup.Group)
+	grp.SetLimit(params.ProjectConcurrency)
+	for _, project := range projects {
+		project := project
+		tasks := tasksByProject[project]
+		if len(tasks) == 0 {
+			continue
+		}
+
+		grp.Go(func() error {
+			projectGrp, ctx := errgroup.WithContext(ctx)
+			for _, t := range tasks {
+				// The objects of the table are listed with the
+				// context of its span.
+				taskCtx, span := telemetry.Start(ctx, "mc2bq.table",
+					telemetry.AttrTable.String(t.table.Name),
+					telemetry.AttrProjectID.String(t.scope.path.Project),
+					telemetry.AttrLocation.String(t.scope.path.Location))
+				stream, err := sink.Stream(taskCtx, t.table, t.scope.path)
+				if err != nil {
+					telemetry.End(span, err)
+					// Wait for the streams that were already started.
+					_ = projectGrp.Wait()
+					return failures.handle(project, fmt.Errorf("export %s: %w", t.table.Name, err))
+				}
+				if stream == nil {
+					span.End()
+					continue
+				}
+
+				t.src = stream.Source
+				run.addSource(t.table.Name, t.src)
+				objectCount := t.objectCount
+				if stream.Partial {
+					// The number of objects isn't known in advance.
+					objectCount = 0
+				}
+				projectGrp.Go(traceTask(span, t.src, newExportTask(taskCtx, t.table.Name, t.src, stream.Write, t.projectID, t.location, objectCount)))
+			}
+
+			return failures.handle(project, projectGrp.Wait())
+		})
 	}
-	assetSource := mc.AssetSource(ctx, path)
-	groupSource := mc.GroupSource(ctx, path)
-	preferenceSetSource := mc.PreferenceSetSource(ctx, path)
-	grp.Go(newExportTask(ctx, dataset, params, groupSource, "groups", 0))
-	grp.Go(newExportTask(ctx, dataset, params, assetSource, "assets", uint64(assetCount)))
-	grp.Go(newExportTask(ctx, dataset, params, preferenceSetSource, "preference_sets", 0))
 
 	err = grp.Wait()
 	if err != nil {
 		return err
 	}
+	// The data of the projects that succeeded is committed, the export
+	// still fails with the projects that failed.
+	failed := failures.failed()
+	if len(failed) == len(projects) {
+		return failures.err(len(projects))
+	}
 
-	fmt.Println(messages.ExportComplete{
-		BytesTransferred: assetSource.BytesRead() + groupSource.BytesRead() + preferenceSetSource.BytesRead(),
-	})
+	if params.Reconcile.Mode != ReconcileNone {
+		rs, ok := sink.(reconcilingSink)
+		if !ok {
+			return messages.NewError(messages.ErrMsgReconcileUnsupported)
+		}
+		var exported []exportScope
+		for _, scope := range scopes {
+			if !isFailed(failed, scope.path.Project) {
+				exported = append(exported, scope)
+			}
+		}
+		rs.setReconciler(newReconciler(mc, params, exported))
+	}
 
-	return nil
+	err = sink.Commit(ctx, failed)
+	if err != nil {
+		return err
+	}
+	committed = true
+
+	var bytesTransferred uint64
+	for _, tasks := range tasksByProject {
+		for _, t := range tasks {
+			if t.src != nil {
+				bytesTransferred += t.src.BytesRead()
+			}
+		}
+	}
+	messages.Print(messages.ExportComplete{
+		BytesTransferred: bytesTransferred,
+	})
+	return failures.err(len(projects))
 }
 
 type iterable[T any] interface {
 	Next() (T, error)
 }
 
-type objectReader[T protoreflect.ProtoMessage] struct {
-	schema     bigquery.Schema
+// nestedIterator iterates over the children of every parent returned by the
+// parents iterator, in order. It's used for resources that can only be listed
+// under another resource (e.g. error frames are listed per source).
+type nestedIterator[P, T any] struct {
+	parents  iterable[P]
+	children func(parent P) iterable[T]
+	current  iterable[T]
+}
+
+func newNestedIterator[P, T any](parents iterable[P], children func(parent P) iterable[T]) *nestedIterator[P, T] {
+	return &nestedIterator[P, T]{
+		parents:  parents,
+		children: children,
+	}
+}
+
+// Next returns the next child, iterator.Done is returned once all the children
+// of all the parents have been returned.
+func (it *nestedIterator[P, T]) Next() (T, error) {
+	for {
+		if it.current == nil {
+			parent, err := it.parents.Next()
+			if err != nil {
+				var zero T
+				return zero, err
+			}
+			it.current = it.children(parent)
+		}
+
+		obj, err := it.current.Next()
+		if errors.Is(err, iterator.Done) {
+			it.current = nil
+			continue
+		}
+
+		return obj, err
+	}
+}
+
+// sliceIterator iterates over the items of a slice.
+type sliceIterator[T any] struct {
+	items []T
+}
+
+func (it *sliceIterator[T]) Next() (T, error) {
+	if len(it.items) == 0 {
+		var zero T
+		return zero, iterator.Done
+	}
+
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, nil
+}
+
+// lazySliceIterator iterates over the items returned by load, which is called
+// by the first call to Next.
+type lazySliceIterator[T any] struct {
+	sliceIterator[T]
+	load   func() ([]T, error)
+	loaded bool
+	err    error
+}
+
+func (it *lazySliceIterator[T]) Next() (T, error) {
+	if !it.loaded {
+		it.loaded = true
+		it.items, it.err = it.load()
+	}
+	if it.err != nil {
+		var zero T
+		return zero, it.err
+	}
+	return it.sliceIterator.Next()
+}
+
+// collectItems returns all the items of it.
+func collectItems[T any](it iterable[T]) ([]T, error) {
+	var res []T
+	for {
+		obj, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, obj)
+	}
+}
+
+// pagedIterable is an iterable that lists the objects in pages, such as the
+// iterators of the Migration Center client.
+type pagedIterable[T any] interface {
+	iterable[T]
+	pager
+}
+
+// pageTracer traces the pages that are fetched by an iterator, a page is
+// fetched by the call to Next that follows the last object of the previous
+// page.
+type pageTracer[T any] struct {
+	ctx    context.Context
+	method string
+	it     pagedIterable[T]
+	// started is set once the first page was fetched.
+	started bool
+}
+
+// tracePages traces the pages fetched by it with spans that are children of
+// the span of ctx, method is the call that fetches them.
+func tracePages[T any](ctx context.Context, method string, it pagedIterable[T]) *pageTracer[T] {
+	return &pageTracer[T]{ctx: ctx, method: method, it: it}
+}
+
+func (it *pageTracer[T]) Next() (T, error) {
+	info := it.it.PageInfo()
+	if info.Remaining() > 0 || (it.started && info.Token == "") {
+		// The object is in the current page or there are no more
+		// pages.
+		return it.it.Next()
+	}
+
+	it.started = true
+	_, span := telemetry.Start(it.ctx, "mc2bq.page", telemetry.AttrMethod.String(it.method))
+	obj, err := it.it.Next()
+	if errors.Is(err, iterator.Done) {
+		telemetry.End(span, nil)
+		return obj, err
+	}
+	if err == nil {
+		// The object that was returned is part of the page too.
+		span.SetAttributes(telemetry.AttrRecords.Int(info.Remaining() + 1))
+	}
+	telemetry.End(span, err)
+	return obj, err
+}
+
+func (it *pageTracer[T]) PageInfo() *iterator.PageInfo {
+	return it.it.PageInfo()
+}
+
+type objectReader[T any] struct {
+	schema bigquery.Schema
+	// objectType is the attribute of the metrics of the objects, see
+	// telemetry.RecordObject.
+	objectType attribute.Set
 	it         iterable[T]
 	serializer func(obj T) ([]byte, error)
+	// normalizer returns the value of an object before it's serialized,
+	// see readEncoded.
+	normalizer func(obj T) (map[string]any, error)
+	// columns is a serialized list of JSON members that are added to every
+	// object, see withColumns.
+	columns []byte
+	// columnValues are the values of columns.
+	columnValues map[string]any
 
 	buf         []byte
 	objectsRead uint64
 	bytesRead   uint64
+
+	// chunkPages is the number of pages in a chunk, see setChunkPages.
+	chunkPages int
+	// chunkPagesRead is the number of pages read in the current chunk.
+	chunkPagesRead int
+	// chunkEnd is set when all the objects of the current chunk were read.
+	chunkEnd bool
+	// exhausted is set when all the objects were read.
+	exhausted bool
+}
+
+// pager is implemented by iterators that list objects in pages.
+type pager interface {
+	PageInfo() *iterator.PageInfo
 }
 
 func newObjectReader[T protoreflect.ProtoMessage](it iterable[T], root string, schema bigquery.Schema) *objectReader[T] {
 	return &objectReader[T]{
 		serializer: exporterschema.NewSerializer[T](root, schema),
+		normalizer: func(obj T) (map[string]any, error) {
+			return exporterschema.NormalizeObjectToBigQuery(obj.ProtoReflect(), root, schema)
+		},
+		it:         it,
+		schema:     schema,
+		objectType: attribute.NewSet(telemetry.AttrObjectType.String(root)),
+	}
+}
+
+// newRowReader creates an objectReader for synthetic rows, see exporterschema.Row.
+func newRowReader(it iterable[exporterschema.Row], root string, schema bigquery.Schema) *objectReader[exporterschema.Row] {
+	return &objectReader[exporterschema.Row]{
+		serializer: func(row exporterschema.Row) ([]byte, error) {
+			return exporterschema.SerializeRowToBigQuery(row, root, schema)
+		},
+		normalizer: func(row exporterschema.Row) (map[string]any, error) {
+			return exporterschema.NormalizeRowToBigQuery(row, root, schema)
+		},
 		it:         it,
 		schema:     schema,
+		objectType: attribute.NewSet(telemetry.AttrObjectType.String(root)),
 	}
 }
 
+// column is a column with a constant value that is added to every object.
+type column struct {
+	name  string
+	value string
+	// fieldType is the type of the column, STRING is used if it's empty.
+	fieldType bigquery.FieldType
+}
+
+// withColumns adds columns to every object read from r, the columns are
+// prepended to the schema.
+func (r *objectReader[T]) withColumns(columns []column) *objectReader[T] {
+	if len(columns) == 0 {
+		return r
+	}
+
+	var schema bigquery.Schema
+	if r.columnValues == nil {
+		r.columnValues = map[string]any{}
+	}
+	for _, col := range columns {
+		fieldType := col.fieldType
+		if fieldType == "" {
+			fieldType = bigquery.StringFieldType
+		}
+		schema = append(schema, &bigquery.FieldSchema{Name: col.name, Type: fieldType})
+		r.columnValues[col.name] = col.value
+		name, _ := json.Marshal(col.name)
+		value, _ := json.Marshal(col.value)
+		if len(r.columns) > 0 {
+			r.columns = append(r.columns, ',')
+		}
+		r.columns = append(r.columns, name...)
+		r.columns = append(r.columns, ':')
+		r.columns = append(r.columns, value...)
+	}
+	r.schema = append(schema, r.schema...)
+
+	return r
+}
+
+// addColumns inserts the columns of r to the serialized JSON object obj.
+func (r *objectReader[T]) addColumns(obj []byte) []byte {
+	if len(r.columns) == 0 {
+		return obj
+	}
+
+	res := make([]byte, 0, len(obj)+len(r.columns)+1)
+	res = append(res, '{')
+	res = append(res, r.columns...)
+	if obj[1] != '}' {
+		// The object isn't empty
+		res = append(res, ',')
+	}
+	return append(res, obj[1:]...)
+}
+
 func (r *objectReader[T]) Schema() bigquery.Schema {
 	return r.schema
 }
@@ -231,18 +1093,31 @@ func (r *objectReader[T]) Read(buf []byte) (int, error) {
 	}
 
 	if len(r.buf) == 0 {
+		if r.chunkEnd {
+			return 0, io.EOF
+		}
 		asset, err := r.it.Next()
 		if errors.Is(err, iterator.Done) {
+			r.exhausted = true
 			return 0, io.EOF
 		}
 		if err != nil {
 			return 0, err
 		}
+		start := time.Now()
 		r.buf, err = r.serializer(asset)
 		if err != nil {
 			return 0, err
 		}
+		r.buf = r.addColumns(r.buf)
 		r.objectsRead++
+		telemetry.RecordObject(context.Background(), r.objectType, len(r.buf), time.Since(start))
+
+		if p, ok := r.it.(pager); ok && r.chunkPages > 0 && p.PageInfo().Remaining() == 0 {
+			// This is the last object of the page.
+			r.chunkPagesRead++
+			r.chunkEnd = r.chunkPagesRead >= r.chunkPages
+		}
 	}
 
 	n := copy(buf, r.buf)
@@ -252,57 +1127,82 @@ func (r *objectReader[T]) Read(buf []byte) (int, error) {
 	return n, nil
 }
 
-func newMigrationCenterLoadSource[T protoreflect.ProtoMessage](r *objectReader[T]) bigquery.LoadSource {
-	// Creating a full blown bigquery.LoadSource requires a lot of low level big query operations.
-	// To save on time we create a ReaderSource and feed it the assets as a json stream.
-	src := bigquery.NewReaderSource(r)
-	src.Schema = r.Schema()
-	src.SourceFormat = bigquery.JSON
-
-	return src
-}
-
-func exportObjects(ctx context.Context, dataset *bigquery.Dataset, params *Params, src bigquery.LoadSource, tableName string) error {
-	tbl := dataset.Table(tableName)
-	_, err := tbl.Metadata(ctx)
-	if err != nil && !gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
-		return err
+// readEncoded returns the next object encoded by encode instead of JSON, the
+// object includes the columns of r. It returns io.EOF after the last object,
+// chunks aren't supported.
+func (r *objectReader[T]) readEncoded(encode func(row map[string]any) ([]byte, error)) ([]byte, error) {
+	obj, err := r.it.Next()
+	if errors.Is(err, iterator.Done) {
+		r.exhausted = true
+		return nil, io.EOF
 	}
-	if err == nil && !params.Force {
-		return errTableExists
+	if err != nil {
+		return nil, err
 	}
 
-	err = gapiutil.IgnoreErrorWithCode(tbl.Delete(ctx), http.StatusNotFound)
+	start := time.Now()
+	row, err := r.normalizer(obj)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	fmt.Println(messages.ExportingDataToTable{TableName: tableName})
-	loader := tbl.LoaderFrom(src)
-	loader.WriteDisposition = bigquery.WriteTruncate
-	job, err := loader.Run(ctx)
+	if row == nil {
+		row = map[string]any{}
+	}
+	for name, value := range r.columnValues {
+		row[name] = value
+	}
+	buf, err := encode(row)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	status, err := job.Wait(ctx)
-	if err != nil {
-		return err
+	r.objectsRead++
+	r.bytesRead += uint64(len(buf))
+	telemetry.RecordObject(context.Background(), r.objectType, len(buf), time.Since(start))
+	return buf, nil
+}
+
+// resumable returns true if the objects are listed in pages, which allows
+// reading them in chunks and resuming from a page token.
+func (r *objectReader[T]) resumable() bool {
+	_, ok := r.it.(pager)
+	return ok
+}
+
+// setChunkPages splits the objects of a resumable reader into chunks of
+// pages, Read returns io.EOF at the end of every chunk and nextChunk starts the
+// next chunk. Every chunk can be loaded by its own load job.
+func (r *objectReader[T]) setChunkPages(pages int) {
+	r.chunkPages = pages
+}
+
+// nextChunk starts reading the next chunk, it returns false if all the
+// objects were read.
+func (r *objectReader[T]) nextChunk() bool {
+	if r.exhausted || (r.chunkEnd && r.pageToken() == "") {
+		return false
 	}
 
-	err = status.Err()
-	if err != nil {
-		if len(status.Errors) > 0 {
-			var sb strings.Builder
-			fmt.Fprintf(&sb, "encountered errors during export:")
-			for _, err := range status.Errors {
-				fmt.Fprintf(&sb, "\n\t%v", err)
-			}
+	r.chunkEnd = false
+	r.chunkPagesRead = 0
+	return true
+}
 
-			return errors.New(sb.String())
-		}
-		return err
+// pageToken returns the token of the page after the last page that was read.
+func (r *objectReader[T]) pageToken() string {
+	p, ok := r.it.(pager)
+	if !ok {
+		return ""
 	}
+	return p.PageInfo().Token
+}
 
-	return nil
+// resumeFrom starts reading from the page with token, objectsRead is the
+// number of objects that were read before that page. It must be called
+// before reading any object.
+func (r *objectReader[T]) resumeFrom(token string, objectsRead uint64) {
+	if p, ok := r.it.(pager); ok {
+		p.PageInfo().Token = token
+		r.objectsRead = objectsRead
+	}
 }
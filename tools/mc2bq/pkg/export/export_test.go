@@ -0,0 +1,266 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	migrationcenter "cloud.google.com/go/migrationcenter/apiv1"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/telemetry"
+)
+
+// TestObjectReaderColumns checks that columns are added to both the schema and
+// every object, including objects that serialize to an empty JSON object.
+func TestObjectReaderColumns(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "key", Type: bigquery.StringFieldType},
+			{Name: "value", Type: bigquery.StringFieldType},
+		}},
+	}
+	it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{
+		{Labels: map[string]string{"foo": "bar"}},
+		{}, // empty maps are omitted
+	}}
+	r := newObjectReader[*migrationcenterpb.Group](it, "group", schema).withColumns([]column{
+		{name: "project_id", value: "project"},
+		{name: "location", value: "us-central1"},
+	})
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll(): unexpected error: %v", err)
+	}
+
+	want := `{"project_id":"project","location":"us-central1","labels":[{"key":"foo","value":"bar"}]}` + "\n" +
+		`{"project_id":"project","location":"us-central1"}` + "\n"
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("ReadAll(): unexpected data (-want, +got):\n%s", diff)
+	}
+
+	wantSchema := bigquery.Schema{
+		{Name: "project_id", Type: bigquery.StringFieldType},
+		{Name: "location", Type: bigquery.StringFieldType},
+		schema[0],
+	}
+	if diff := cmp.Diff(wantSchema, r.Schema()); diff != "" {
+		t.Errorf("Schema(): unexpected schema (-want, +got):\n%s", diff)
+	}
+	if r.ObjectsRead() != 2 {
+		t.Errorf("ObjectsRead() = %d, want 2", r.ObjectsRead())
+	}
+}
+
+// recordTelemetry installs global providers that record the spans and the
+// metrics in memory until the end of the test.
+func recordTelemetry(t *testing.T) (*tracetest.InMemoryExporter, *sdkmetric.ManualReader) {
+	t.Helper()
+	tp, mp := otel.GetTracerProvider(), otel.GetMeterProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(mp)
+	})
+
+	spans := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans)))
+	reader := sdkmetric.NewManualReader()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	return spans, reader
+}
+
+// collectSums returns the sums of the int64 counters collected by reader by
+// metric name.
+func collectSums(t *testing.T, reader *sdkmetric.ManualReader) map[string]int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect(): unexpected error: %v", err)
+	}
+	res := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					res[m.Name] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					res[m.Name] += int64(dp.Count)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func TestLazySliceIterator(t *testing.T) {
+	loads := 0
+	it := &lazySliceIterator[string]{load: func() ([]string, error) {
+		loads++
+		return []string{"a", "b"}, nil
+	}}
+	if loads != 0 {
+		t.Fatalf("the items were loaded before Next was called")
+	}
+	got, err := collectItems[string](it)
+	if err != nil {
+		t.Fatalf("Next(): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a", "b"}, got); diff != "" {
+		t.Errorf("Next(): unexpected items (-want, +got):\n%s", diff)
+	}
+	if loads != 1 {
+		t.Errorf("the items were loaded %d times, want 1", loads)
+	}
+
+	errLoad := errors.New("load failed")
+	it = &lazySliceIterator[string]{load: func() ([]string, error) { return nil, errLoad }}
+	for i := 0; i < 2; i++ {
+		if _, err := it.Next(); !errors.Is(err, errLoad) {
+			t.Errorf("Next() = %v, want %v", err, errLoad)
+		}
+	}
+}
+
+// TestReportScope checks that the reports of a scope are listed once for the
+// report and report summary tables.
+func TestReportScope(t *testing.T) {
+	pal := mcutil.ProjectAndLocation{Project: "p", Location: "us-central1"}
+	reports := []*migrationcenterpb.Report{{Name: "r1"}, {Name: "r2"}}
+	// The scope was already listed and the client is nil, listing the
+	// reports again would panic.
+	scope := &reportScope{reports: reports}
+	scope.once.Do(func() {})
+	mc := &MCv1{reportScopes: map[mcutil.ProjectAndLocation]*reportScope{pal: scope}}
+
+	for _, table := range []string{"report", "report_summary"} {
+		got, err := collectItems[*migrationcenterpb.Report](mc.listReports(context.Background(), pal))
+		if err != nil {
+			t.Fatalf("%s: Next(): unexpected error: %v", table, err)
+		}
+		if len(got) != len(reports) || got[0] != reports[0] || got[1] != reports[1] {
+			t.Errorf("%s: unexpected reports %v, want %v", table, got, reports)
+		}
+	}
+}
+
+func TestPageTracer(t *testing.T) {
+	spans, _ := recordTelemetry(t)
+	it := tracePages[*migrationcenterpb.Group](context.Background(), "ListGroups", newFakeGroupPager(3, 2))
+	var read int
+	for {
+		_, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next(): unexpected error: %v", err)
+		}
+		read++
+	}
+	if read != 6 {
+		t.Errorf("Next() returned %d groups, want %d", read, 6)
+	}
+
+	var records []int64
+	for _, span := range spans.GetSpans() {
+		if span.Name != "mc2bq.page" {
+			t.Errorf("unexpected span %q", span.Name)
+		}
+		for _, attr := range span.Attributes {
+			if attr.Key == telemetry.AttrRecords {
+				records = append(records, attr.Value.AsInt64())
+			}
+		}
+	}
+	if diff := cmp.Diff([]int64{2, 2, 2}, records); diff != "" {
+		t.Errorf("unexpected records of the page spans (-want, +got):\n%s", diff)
+	}
+}
+
+func TestObjectReaderMetrics(t *testing.T) {
+	_, reader := recordTelemetry(t)
+	r := newObjectReader[*migrationcenterpb.Group](newFakeGroupPager(2, 2), "group", groupNameSchema)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll(): unexpected error: %v", err)
+	}
+
+	want := map[string]int64{
+		"mc2bq.objects.read":           4,
+		"mc2bq.bytes.read":             int64(len(data)),
+		"mc2bq.serialization.duration": 4,
+	}
+	if diff := cmp.Diff(want, collectSums(t, reader)); diff != "" {
+		t.Errorf("unexpected metrics (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCountingRetryer(t *testing.T) {
+	_, reader := recordTelemetry(t)
+	r := &countingRetryer{Retryer: gax.OnCodes([]codes.Code{codes.Unavailable}, gax.Backoff{}), method: "ListAssets"}
+	if _, retry := r.Retry(status.Error(codes.Unavailable, "unavailable")); !retry {
+		t.Errorf("Retry(Unavailable) = false, want true")
+	}
+	if _, retry := r.Retry(status.Error(codes.NotFound, "not found")); retry {
+		t.Errorf("Retry(NotFound) = true, want false")
+	}
+
+	if diff := cmp.Diff(map[string]int64{"mc2bq.retries": 1}, collectSums(t, reader)); diff != "" {
+		t.Errorf("unexpected metrics (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCountRetries(t *testing.T) {
+	retryer := gax.OnCodes([]codes.Code{codes.Unavailable}, gax.Backoff{})
+	opts := &migrationcenter.CallOptions{
+		ListAssets: []gax.CallOption{gax.WithRetry(func() gax.Retryer { return retryer })},
+		ListGroups: []gax.CallOption{gax.WithTimeout(time.Minute)},
+	}
+	countRetries(opts)
+
+	var assets, groups gax.CallSettings
+	for _, opt := range opts.ListAssets {
+		opt.Resolve(&assets)
+	}
+	for _, opt := range opts.ListGroups {
+		opt.Resolve(&groups)
+	}
+	if r, ok := assets.Retry().(*countingRetryer); !ok || r.Retryer != retryer {
+		t.Errorf("countRetries() didn't wrap the retryer of ListAssets: %#v", assets.Retry())
+	}
+	if groups.Retry != nil {
+		t.Errorf("countRetries() added a retryer to ListGroups, which isn't retried")
+	}
+}
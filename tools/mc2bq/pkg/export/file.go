@@ -0,0 +1,745 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// OutputFormat is the format of the files written when exporting to a
+// directory, see Params.OutputDir.
+type OutputFormat string
+
+// Supported output formats
+const (
+	// OutputFormatNDJSON writes the rows as newline delimited JSON, the
+	// same format that is loaded to BigQuery.
+	OutputFormatNDJSON OutputFormat = "ndjson"
+	// OutputFormatCSV writes the rows as CSV, nested records are flattened
+	// to a column per field and repeated fields are written to child files.
+	OutputFormatCSV OutputFormat = "csv"
+	// OutputFormatParquet writes the rows as Parquet, nested records are
+	// written as groups and repeated fields as lists.
+	OutputFormatParquet OutputFormat = "parquet"
+	// OutputFormatAvro writes the rows as an Avro object container file.
+	OutputFormatAvro OutputFormat = "avro"
+)
+
+// ParseOutputFormat parses an output format name, an empty name is NDJSON.
+func ParseOutputFormat(name string) (OutputFormat, error) {
+	switch format := OutputFormat(strings.ToLower(name)); format {
+	case "":
+		return OutputFormatNDJSON, nil
+	case OutputFormatNDJSON, OutputFormatCSV, OutputFormatParquet, OutputFormatAvro:
+		return format, nil
+	}
+
+	return "", messages.NewError(messages.InvalidOutputFormat{Format: name})
+}
+
+const (
+	// manifestFileName is the name of the manifest that describes the
+	// files written to the output directory.
+	manifestFileName = "manifest.json"
+	// partialDirPattern is the pattern of the directory in the output
+	// directory that the files are written to, the files are moved to the
+	// output directory only after all the files were written.
+	partialDirPattern = ".mc2bq-partial-*"
+)
+
+var errFileExists = messages.NewError(messages.ErrMsgExportFileExists)
+
+// manifest describes the files written by an export to a directory. The
+// manifest is written last and is authoritative, files that it doesn't list
+// are leftovers of an export that didn't complete.
+type manifest struct {
+	ExportTime time.Time      `json:"export_time"`
+	Format     OutputFormat   `json:"format"`
+	Files      []manifestFile `json:"files"`
+}
+
+// manifestFile describes a single file of the export.
+type manifestFile struct {
+	Path      string `json:"path"`
+	Table     string `json:"table"`
+	ProjectID string `json:"project_id,omitempty"`
+	Location  string `json:"location,omitempty"`
+	Rows      uint64 `json:"rows"`
+	Bytes     int64  `json:"bytes"`
+	// SHA256 is the hex encoded SHA-256 checksum of the file.
+	SHA256 string `json:"sha256"`
+	// ExportTime is set on the files of a project that failed to export,
+	// which are kept from the previous export that exported them.
+	ExportTime *time.Time `json:"export_time,omitempty"`
+}
+
+// rowWriter writes the rows of a table to a file.
+type rowWriter interface {
+	// writeRow writes a single row, row is a serialized JSON object as
+	// returned by the objectReader.
+	writeRow(row []byte) error
+	// close flushes the rows that weren't written yet, it doesn't close
+	// the underlying writer.
+	close() error
+}
+
+// childFile is a file with the values of a repeated field that is written
+// next to the file of the table, rows is the number of rows written to it.
+type childFile struct {
+	w    io.Writer
+	rows uint64
+}
+
+// newChildFunc creates the child file of the repeated field at path, it's
+// used by formats that can't represent repeated fields in a single file.
+type newChildFunc func(path []string) (*childFile, error)
+
+// newRowWriter returns a rowWriter that writes rows with schema to w in
+// format, newChild creates the child files of the repeated fields.
+func newRowWriter(format OutputFormat, w io.Writer, table string, schema bigquery.Schema, newChild newChildFunc) (rowWriter, error) {
+	switch format {
+	case OutputFormatNDJSON:
+		return &ndjsonWriter{w: w}, nil
+	case OutputFormatCSV:
+		return newCSVWriter(w, schema, newChild)
+	case OutputFormatParquet:
+		return newParquetWriter(w, schema)
+	case OutputFormatAvro:
+		return newAvroWriter(w, table, schema)
+	}
+
+	return nil, messages.NewError(messages.InvalidOutputFormat{Format: string(format)})
+}
+
+// fileName returns the name of the file that contains the objects of table
+// from projectID and location, the project and location are empty when
+// exporting a single project or region respectively. The table of a child
+// file is the name of the table followed by the path of the repeated field,
+// e.g. assets__labels.
+func fileName(table string, projectID string, location string, format OutputFormat) string {
+	name := table
+	for _, part := range []string{projectID, location} {
+		if part != "" {
+			name += "." + sanitizeTableID(part)
+		}
+	}
+	return name + "." + string(format)
+}
+
+// fileSink exports the data to files in Params.OutputDir, a file per table
+// and scope. The files are written to a partial directory in the output
+// directory and moved to the output directory by Commit once all of them were
+// written, followed by the manifest.
+type fileSink struct {
+	params     *Params
+	partialDir string
+
+	mu    sync.Mutex
+	files []*manifestFile
+}
+
+// NewFileSink returns a Sink that writes the data to files in
+// Params.OutputDir in Params.OutputFormat.
+func NewFileSink() Sink {
+	return &fileSink{}
+}
+
+func (s *fileSink) Create(ctx context.Context, params *Params) error {
+	if params.Mode == ModeIncremental || params.Resume {
+		return messages.NewError(messages.ErrMsgOutputDirUnsupported)
+	}
+	s.params = params
+
+	err := os.MkdirAll(params.OutputDir, 0o755)
+	if err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	_, err = os.Stat(filepath.Join(params.OutputDir, manifestFileName))
+	if err == nil && !params.Force {
+		return errFileExists
+	}
+
+	s.partialDir, err = os.MkdirTemp(params.OutputDir, partialDirPattern)
+	if err != nil {
+		return fmt.Errorf("create output directory: %w", err)
+	}
+	return nil
+}
+
+func (s *fileSink) CreateTable(ctx context.Context, table *Table) error {
+	return nil
+}
+
+func (s *fileSink) Stream(ctx context.Context, table *Table, scope mcutil.ProjectAndLocation) (*Stream, error) {
+	projectID, location := s.params.displayScope(scope)
+	src := table.NewSource(ctx, scope)
+	return &Stream{
+		Source: src,
+		Write: func(ctx context.Context) error {
+			files, err := writeFile(s.partialDir, table.Name, projectID, location, s.params.OutputFormat, src)
+			if err != nil {
+				return err
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			for _, mf := range files {
+				mf.ProjectID = scope.Project
+				mf.Location = scope.Location
+				s.files = append(s.files, mf)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// Commit moves the files to the output directory and writes the manifest.
+// The manifest of a previous export is replaced once all the files were moved
+// and only then are the files that it lists and the new manifest doesn't
+// deleted, so the previous export stays complete until the new one is. The
+// files of the failed projects are deleted with the partial directory, the
+// files of the previous export of these projects are kept and listed in the
+// manifest.
+func (s *fileSink) Commit(ctx context.Context, failed []string) error {
+	m := &manifest{
+		ExportTime: s.params.ExportTime.UTC(),
+		Format:     s.params.OutputFormat,
+	}
+	var files []*manifestFile
+	for _, mf := range s.files {
+		if !isFailed(failed, mf.ProjectID) {
+			files = append(files, mf)
+		}
+	}
+	s.files = files
+
+	previous, err := readManifest(s.params.OutputDir)
+	if err != nil {
+		return fmt.Errorf("read previous manifest: %w", err)
+	}
+	for _, mf := range s.files {
+		m.Files = append(m.Files, *mf)
+	}
+	m.Files = append(m.Files, keptFiles(previous, m.Format, failed)...)
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+
+	for _, mf := range s.files {
+		err = os.Rename(filepath.Join(s.partialDir, mf.Path), filepath.Join(s.params.OutputDir, mf.Path))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeManifest(filepath.Join(s.partialDir, manifestFileName), m)
+	if err == nil {
+		err = os.Rename(filepath.Join(s.partialDir, manifestFileName), filepath.Join(s.params.OutputDir, manifestFileName))
+	}
+	if err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	err = removeStaleFiles(s.params.OutputDir, previous, m.Files)
+	if err != nil {
+		return fmt.Errorf("remove previous export: %w", err)
+	}
+	err = os.RemoveAll(s.partialDir)
+	if err != nil {
+		return err
+	}
+
+	messages.Print(messages.ExportFilesComplete{
+		FileCount: len(s.files),
+		OutputDir: s.params.OutputDir,
+	})
+	return nil
+}
+
+// Abort deletes the files that weren't moved to the output directory yet.
+func (s *fileSink) Abort(ctx context.Context) {
+	if s.partialDir != "" {
+		_ = os.RemoveAll(s.partialDir)
+	}
+}
+
+// readManifest reads the manifest in dir, it returns nil if there is no
+// manifest.
+func readManifest(dir string) (*manifest, error) {
+	name := filepath.Join(dir, manifestFileName)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &m, nil
+}
+
+// keptFiles returns the files of the failed projects in the previous
+// manifest, they are kept if they have the format of the export.
+func keptFiles(previous *manifest, format OutputFormat, failed []string) []manifestFile {
+	if previous == nil || previous.Format != format {
+		return nil
+	}
+	var res []manifestFile
+	for _, mf := range previous.Files {
+		if !isFailed(failed, mf.ProjectID) {
+			continue
+		}
+		if mf.ExportTime == nil {
+			exportTime := previous.ExportTime
+			mf.ExportTime = &exportTime
+		}
+		res = append(res, mf)
+	}
+	return res
+}
+
+// removeStaleFiles deletes the files of previous, the replaced manifest of
+// dir, that aren't in files. It does nothing if previous is nil.
+func removeStaleFiles(dir string, previous *manifest, files []manifestFile) error {
+	if previous == nil {
+		return nil
+	}
+	for _, mf := range previous.Files {
+		if containsFile(files, mf.Path) {
+			continue
+		}
+		// The manifest only lists files in dir.
+		err := os.Remove(filepath.Join(dir, filepath.Base(mf.Path)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// containsFile returns true if files contains a file with path.
+func containsFile(files []manifestFile, path string) bool {
+	for _, mf := range files {
+		if mf.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// outputFile is a file that is written by writeFile, the size and checksum
+// of the file are computed while it's written.
+type outputFile struct {
+	f       *os.File
+	buf     *bufio.Writer
+	hash    hash.Hash
+	counter countingWriter
+}
+
+func createOutputFile(name string) (*outputFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &outputFile{f: f, hash: sha256.New()}
+	res.buf = bufio.NewWriter(io.MultiWriter(f, res.hash, &res.counter))
+	return res, nil
+}
+
+func (f *outputFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+// close flushes and closes the file, the size and checksum are added to mf.
+func (f *outputFile) close(mf *manifestFile) error {
+	err := f.buf.Flush()
+	if err != nil {
+		f.f.Close()
+		return err
+	}
+	err = f.f.Close()
+	if err != nil {
+		return err
+	}
+
+	mf.Bytes = f.counter.n
+	mf.SHA256 = hex.EncodeToString(f.hash.Sum(nil))
+	return nil
+}
+
+// writeFile writes the objects of src to a new file in dir in format, the
+// file is named after table, projectID and location as returned by
+// fileName. The file of the table is returned first, followed by its child
+// files, the rows of the file of the table are the objects read from src.
+func writeFile(dir string, table string, projectID string, location string, format OutputFormat, src mcutil.ObjectSource) ([]*manifestFile, error) {
+	var files []*manifestFile
+	var outputs []*outputFile
+	defer func() {
+		for _, out := range outputs {
+			out.f.Close()
+		}
+	}()
+	create := func(table string) (*outputFile, error) {
+		mf := &manifestFile{
+			Path:  fileName(table, projectID, location, format),
+			Table: table,
+		}
+		out, err := createOutputFile(filepath.Join(dir, mf.Path))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, mf)
+		outputs = append(outputs, out)
+		return out, nil
+	}
+
+	out, err := create(table)
+	if err != nil {
+		return nil, err
+	}
+	var children []*childFile
+	newChild := func(path []string) (*childFile, error) {
+		out, err := create(table + sqlNameSeparator + strings.Join(path, sqlNameSeparator))
+		if err != nil {
+			return nil, err
+		}
+		child := &childFile{w: out}
+		children = append(children, child)
+		return child, nil
+	}
+	w, err := newRowWriter(format, out, table, src.Schema(), newChild)
+	if err != nil {
+		return nil, err
+	}
+
+	err = copyRows(w, src)
+	if err != nil {
+		return nil, err
+	}
+	err = w.close()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, out := range outputs {
+		err = out.close(files[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	files[0].Rows = src.ObjectsRead()
+	for i, child := range children {
+		files[i+1].Rows = child.rows
+	}
+	return files, nil
+}
+
+// copyRows writes the newline delimited JSON rows read from r to w.
+func copyRows(w rowWriter, r io.Reader) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			werr := w.writeRow(line)
+			if werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func writeManifest(name string, m *manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(name, append(data, '\n'), 0o644)
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// decodeRow decodes a serialized JSON row, numbers are kept as json.Number so
+// integers don't lose precision.
+func decodeRow(row []byte) (map[string]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(row))
+	dec.UseNumber()
+	var res map[string]any
+	err := dec.Decode(&res)
+	return res, err
+}
+
+// ndjsonWriter writes the rows as they are serialized for BigQuery.
+type ndjsonWriter struct {
+	w io.Writer
+}
+
+func (w *ndjsonWriter) writeRow(row []byte) error {
+	_, err := w.w.Write(row)
+	return err
+}
+
+func (w *ndjsonWriter) close() error {
+	return nil
+}
+
+// csvColumn is a column of a CSV file, path is the path of the field in the
+// nested records of the row.
+type csvColumn struct {
+	path []string
+}
+
+// csvFile is a CSV file with the rows of a table or with the values of a
+// repeated field. Files that have child files start with an _id column, child
+// files start with the _parent_id and _index columns that reference the row
+// of the parent file the same way the child tables of sqlSink do.
+type csvFile struct {
+	w        *csv.Writer
+	child    *childFile
+	columns  []csvColumn
+	children []*csvChild
+	nextID   int64
+}
+
+// csvChild is the child file of a repeated field, path is the path of the
+// field in the nested records of the parent file.
+type csvChild struct {
+	path []string
+	file *csvFile
+}
+
+// csvWriter writes the rows as CSV with a header row. Nested records are
+// flattened to a column per field named after the path of the field (e.g.
+// hardware_details.cpu_count), repeated fields are written to child files
+// with a row per value.
+type csvWriter struct {
+	root *csvFile
+}
+
+func newCSVWriter(w io.Writer, schema bigquery.Schema, newChild newChildFunc) (*csvWriter, error) {
+	root, err := newCSVFile(w, nil, nil, schema, newChild)
+	if err != nil {
+		return nil, err
+	}
+	return &csvWriter{root: root}, nil
+}
+
+// newCSVFile creates the CSV file of the records with schema and its child
+// files, prefix is the path of the repeated field of a child file. The values
+// of a repeated field that isn't a record are in a column named after the
+// field.
+func newCSVFile(w io.Writer, child *childFile, prefix []string, schema bigquery.Schema, newChild newChildFunc) (*csvFile, error) {
+	res := &csvFile{w: csv.NewWriter(w), child: child}
+	var repeated []*bigquery.FieldSchema
+	var repeatedPaths [][]string
+	if schema == nil {
+		res.columns = []csvColumn{{}}
+	} else {
+		res.columns = csvColumns(nil, schema, func(path []string, field *bigquery.FieldSchema) {
+			repeated = append(repeated, field)
+			repeatedPaths = append(repeatedPaths, path)
+		})
+	}
+
+	for i, field := range repeated {
+		path := append(append([]string{}, prefix...), repeatedPaths[i]...)
+		cf, err := newChild(path)
+		if err != nil {
+			return nil, err
+		}
+		var childSchema bigquery.Schema
+		if field.Type == bigquery.RecordFieldType {
+			childSchema = field.Schema
+		}
+		file, err := newCSVFile(cf.w, cf, path, childSchema, newChild)
+		if err != nil {
+			return nil, err
+		}
+		res.children = append(res.children, &csvChild{path: repeatedPaths[i], file: file})
+	}
+
+	var header []string
+	if len(res.children) > 0 {
+		header = append(header, sqlIDColumn)
+	}
+	if child != nil {
+		header = append(header, sqlParentIDColumn, sqlIndexColumn)
+	}
+	for _, col := range res.columns {
+		header = append(header, strings.Join(append(append([]string{}, prefix...), col.path...), "."))
+	}
+	return res, res.w.Write(header)
+}
+
+// csvColumns returns the columns of schema, prefix is the path of the record
+// that contains schema. repeated is called with the path of every repeated
+// field, which doesn't have a column.
+func csvColumns(prefix []string, schema bigquery.Schema, repeated func(path []string, field *bigquery.FieldSchema)) []csvColumn {
+	var res []csvColumn
+	for _, field := range schema {
+		path := append(append([]string{}, prefix...), field.Name)
+		switch {
+		case field.Repeated:
+			repeated(path, field)
+		case field.Type == bigquery.RecordFieldType && len(field.Schema) > 0:
+			res = append(res, csvColumns(path, field.Schema, repeated)...)
+		default:
+			res = append(res, csvColumn{path: path})
+		}
+	}
+
+	return res
+}
+
+func (w *csvWriter) writeRow(row []byte) error {
+	obj, err := decodeRow(row)
+	if err != nil {
+		return err
+	}
+
+	return w.root.write(obj, nil)
+}
+
+// write writes value to the file followed by its repeated fields to the child
+// files, key is the _parent_id and _index of a row of a child file.
+func (f *csvFile) write(value any, key []string) error {
+	var record []string
+	var id string
+	if len(f.children) > 0 {
+		f.nextID++
+		id = strconv.FormatInt(f.nextID, 10)
+		record = append(record, id)
+	}
+	record = append(record, key...)
+	for _, col := range f.columns {
+		s, err := csvValue(csvLookup(value, col.path))
+		if err != nil {
+			return err
+		}
+		record = append(record, s)
+	}
+
+	err := f.w.Write(record)
+	if err != nil {
+		return err
+	}
+	if f.child != nil {
+		f.child.rows++
+	}
+
+	for _, child := range f.children {
+		items, _ := csvLookup(value, child.path).([]any)
+		for i, item := range items {
+			err = child.file.write(item, []string{id, strconv.Itoa(i)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// csvLookup returns the value at path in the nested records of value.
+func csvLookup(value any, path []string) any {
+	for _, name := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = m[name]
+	}
+	return value
+}
+
+// csvValue formats a decoded JSON value as a CSV cell, missing values are
+// empty cells.
+func csvValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		if v {
+			return "true", nil
+		}
+		return "false", nil
+	}
+
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+func (w *csvWriter) close() error {
+	return w.root.flush()
+}
+
+// flush flushes the file and its child files.
+func (f *csvFile) flush() error {
+	f.w.Flush()
+	err := f.w.Error()
+	if err != nil {
+		return err
+	}
+	for _, child := range f.children {
+		err = child.file.flush()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,671 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/apache/arrow/go/v12/parquet/file"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+	"github.com/google/go-cmp/cmp"
+	"github.com/linkedin/goavro/v2"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+)
+
+var fileTestSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType},
+	{Name: "count", Type: bigquery.IntegerFieldType},
+	{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "enabled", Type: bigquery.BooleanFieldType},
+		{Name: "update_time", Type: bigquery.TimestampFieldType},
+	}},
+	{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "key", Type: bigquery.StringFieldType},
+		{Name: "value", Type: bigquery.StringFieldType},
+	}},
+}
+
+var fileTestRows = []string{
+	`{"name":"a","count":1,"details":{"enabled":true,"update_time":"2023-10-01T10:30:00Z"},"labels":[{"key":"env","value":"prod"}]}` + "\n",
+	`{"name":"b"}` + "\n",
+}
+
+// writeTestRows writes fileTestRows in format, the child files are returned
+// by the path of their field.
+func writeTestRows(t *testing.T, format OutputFormat) ([]byte, map[string]*bytes.Buffer) {
+	t.Helper()
+	var buf bytes.Buffer
+	children := map[string]*bytes.Buffer{}
+	newChild := func(path []string) (*childFile, error) {
+		child := &bytes.Buffer{}
+		children[strings.Join(path, ".")] = child
+		return &childFile{w: child}, nil
+	}
+	w, err := newRowWriter(format, &buf, "assets", fileTestSchema, newChild)
+	if err != nil {
+		t.Fatalf("newRowWriter(%s): unexpected error: %v", format, err)
+	}
+	for _, row := range fileTestRows {
+		err = w.writeRow([]byte(row))
+		if err != nil {
+			t.Fatalf("writeRow(%s): unexpected error: %v", format, err)
+		}
+	}
+	err = w.close()
+	if err != nil {
+		t.Fatalf("close(%s): unexpected error: %v", format, err)
+	}
+	return buf.Bytes(), children
+}
+
+func readCSV(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	res, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("read CSV: %v", err)
+	}
+	return res
+}
+
+func TestCSVWriter(t *testing.T) {
+	got, children := writeTestRows(t, OutputFormatCSV)
+	want := [][]string{
+		{"_id", "name", "count", "details.enabled", "details.update_time"},
+		{"1", "a", "1", "true", "2023-10-01T10:30:00Z"},
+		{"2", "b", "", "", ""},
+	}
+	if diff := cmp.Diff(want, readCSV(t, got)); diff != "" {
+		t.Errorf("unexpected CSV (-want, +got):\n%s", diff)
+	}
+
+	wantChildren := map[string][][]string{
+		"labels": {
+			{"_parent_id", "_index", "labels.key", "labels.value"},
+			{"1", "0", "env", "prod"},
+		},
+	}
+	gotChildren := map[string][][]string{}
+	for path, data := range children {
+		gotChildren[path] = readCSV(t, data.Bytes())
+	}
+	if diff := cmp.Diff(wantChildren, gotChildren); diff != "" {
+		t.Errorf("unexpected child files (-want, +got):\n%s", diff)
+	}
+}
+
+func TestCSVWriterNestedRepeated(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "disks", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "label", Type: bigquery.StringFieldType},
+			{Name: "partitions", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+				{Name: "type", Type: bigquery.StringFieldType},
+			}},
+		}},
+	}
+	children := map[string]*bytes.Buffer{}
+	newChild := func(path []string) (*childFile, error) {
+		child := &bytes.Buffer{}
+		children[strings.Join(path, ".")] = child
+		return &childFile{w: child}, nil
+	}
+	var buf bytes.Buffer
+	w, err := newCSVWriter(&buf, schema, newChild)
+	if err != nil {
+		t.Fatalf("newCSVWriter(): unexpected error: %v", err)
+	}
+	for _, row := range []string{
+		`{"name":"a","tags":["x","y"],"disks":[{"label":"d0","partitions":[{"type":"ext4"}]},{"label":"d1","partitions":[{"type":"xfs"},{"type":"swap"}]}]}`,
+		`{"name":"b","disks":[{"label":"d2"}]}`,
+	} {
+		err = w.writeRow([]byte(row))
+		if err != nil {
+			t.Fatalf("writeRow(): unexpected error: %v", err)
+		}
+	}
+	err = w.close()
+	if err != nil {
+		t.Fatalf("close(): unexpected error: %v", err)
+	}
+
+	want := map[string][][]string{
+		"": {
+			{"_id", "name"},
+			{"1", "a"},
+			{"2", "b"},
+		},
+		"tags": {
+			{"_parent_id", "_index", "tags"},
+			{"1", "0", "x"},
+			{"1", "1", "y"},
+		},
+		"disks": {
+			{"_id", "_parent_id", "_index", "disks.label"},
+			{"1", "1", "0", "d0"},
+			{"2", "1", "1", "d1"},
+			{"3", "2", "0", "d2"},
+		},
+		"disks.partitions": {
+			{"_parent_id", "_index", "disks.partitions.type"},
+			{"1", "0", "ext4"},
+			{"2", "0", "xfs"},
+			{"2", "1", "swap"},
+		},
+	}
+	got := map[string][][]string{"": readCSV(t, buf.Bytes())}
+	for path, data := range children {
+		got[path] = readCSV(t, data.Bytes())
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected CSV files (-want, +got):\n%s", diff)
+	}
+}
+
+func TestAvroWriter(t *testing.T) {
+	got, _ := writeTestRows(t, OutputFormatAvro)
+	r, err := goavro.NewOCFReader(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("NewOCFReader(): unexpected error: %v", err)
+	}
+	if codec := r.CompressionName(); codec != goavro.CompressionDeflateLabel {
+		t.Errorf("compression = %s, want %s", codec, goavro.CompressionDeflateLabel)
+	}
+
+	var rows []any
+	for r.Scan() {
+		row, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read(): unexpected error: %v", err)
+		}
+		rows = append(rows, row)
+	}
+	if r.Err() != nil {
+		t.Fatalf("Scan(): unexpected error: %v", r.Err())
+	}
+
+	// Optional fields are ["null", type] unions, repeated fields are arrays.
+	want := []any{
+		map[string]any{
+			"name":  map[string]any{"string": "a"},
+			"count": map[string]any{"long": int64(1)},
+			"details": map[string]any{"assets_details": map[string]any{
+				"enabled":     map[string]any{"boolean": true},
+				"update_time": map[string]any{"long.timestamp-micros": time.Date(2023, 10, 1, 10, 30, 0, 0, time.UTC)},
+			}},
+			"labels": []any{
+				map[string]any{"key": map[string]any{"string": "env"}, "value": map[string]any{"string": "prod"}},
+			},
+		},
+		map[string]any{
+			"name":    map[string]any{"string": "b"},
+			"count":   nil,
+			"details": nil,
+			"labels":  []any{},
+		},
+	}
+	if diff := cmp.Diff(want, rows); diff != "" {
+		t.Errorf("unexpected rows (-want, +got):\n%s", diff)
+	}
+}
+
+func TestParquetWriter(t *testing.T) {
+	got, _ := writeTestRows(t, OutputFormatParquet)
+	r, err := file.NewParquetReader(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("NewParquetReader(): unexpected error: %v", err)
+	}
+	// Repeated fields are LIST columns.
+	var columns []string
+	schema := r.MetaData().Schema
+	for i := 0; i < schema.NumColumns(); i++ {
+		col := schema.Column(i)
+		columns = append(columns, fmt.Sprintf("%s %d", col.Path(), col.MaxRepetitionLevel()))
+	}
+	wantColumns := []string{
+		"name 0",
+		"count 0",
+		"details.enabled 0",
+		"details.update_time 0",
+		"labels.list.element.key 1",
+		"labels.list.element.value 1",
+	}
+	if diff := cmp.Diff(wantColumns, columns); diff != "" {
+		t.Errorf("unexpected columns (-want, +got):\n%s", diff)
+	}
+
+	tbl, err := pqarrow.ReadTable(context.Background(), bytes.NewReader(got), nil, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatalf("ReadTable(): unexpected error: %v", err)
+	}
+	defer tbl.Release()
+
+	// Without the Arrow schema in the file the timestamps are read back
+	// without their UTC time zone.
+	var rows bytes.Buffer
+	tr := array.NewTableReader(tbl, tbl.NumRows())
+	defer tr.Release()
+	for tr.Next() {
+		err = array.RecordToJSON(tr.Record(), &rows)
+		if err != nil {
+			t.Fatalf("RecordToJSON(): unexpected error: %v", err)
+		}
+	}
+	want := `{"count":1,"details":{"enabled":true,"update_time":"2023-10-01 10:30:00"},"labels":[{"key":"env","value":"prod"}],"name":"a"}` + "\n" +
+		`{"count":null,"details":null,"labels":[],"name":"b"}` + "\n"
+	if diff := cmp.Diff(want, rows.String()); diff != "" {
+		t.Errorf("unexpected rows (-want, +got):\n%s", diff)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{
+		{Name: "g1"},
+		{Name: "g2"},
+	}}
+	schema := bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}
+	src := newObjectReader[*migrationcenterpb.Group](it, "group", schema)
+
+	dir := t.TempDir()
+	files, err := writeFile(dir, "groups", "", "us-central1", OutputFormatNDJSON, src)
+	if err != nil {
+		t.Fatalf("writeFile(): unexpected error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("writeFile() returned %d files, want 1", len(files))
+	}
+	mf := files[0]
+	if mf.Path != "groups.us_central1.ndjson" || mf.Table != "groups" || mf.Rows != 2 {
+		t.Errorf("unexpected file %+v", mf)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, mf.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"name\":\"g1\"}\n{\"name\":\"g2\"}\n"; string(data) != want {
+		t.Errorf("unexpected file content %q, want %q", data, want)
+	}
+	sum := sha256.Sum256(data)
+	if mf.SHA256 != hex.EncodeToString(sum[:]) || mf.Bytes != int64(len(data)) {
+		t.Errorf("writeFile() = %+v, want %d bytes with checksum %x", mf, len(data), sum)
+	}
+	if src.ObjectsRead() != 2 {
+		t.Errorf("ObjectsRead() = %d, want 2", src.ObjectsRead())
+	}
+}
+
+func newGroupTestTable(names ...string) *Table {
+	schema := bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}
+	return &Table{
+		Name:   "groups",
+		Schema: schema,
+		def: exportTable{
+			schema:      schema,
+			tableSuffix: "groups",
+			newSource: func(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+				it := &sliceIterator[*migrationcenterpb.Group]{}
+				for _, name := range names {
+					it.items = append(it.items, &migrationcenterpb.Group{Name: name})
+				}
+				return newObjectReader[*migrationcenterpb.Group](it, "group", schema)
+			},
+		},
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	params := &Params{
+		ProjectID:    "project",
+		Region:       "us-central1",
+		OutputDir:    dir,
+		OutputFormat: OutputFormatNDJSON,
+	}
+	sink := NewFileSink()
+	err := sink.Create(ctx, params)
+	if err != nil {
+		t.Fatalf("Create(): unexpected error: %v", err)
+	}
+	table := newGroupTestTable("g1", "g2")
+	stream, err := sink.Stream(ctx, table, mcutil.ProjectAndLocation{Project: "project", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("Stream(): unexpected error: %v", err)
+	}
+	err = stream.Write(ctx)
+	if err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, manifestFileName)); !os.IsNotExist(err) {
+		t.Errorf("manifest was written before Commit()")
+	}
+
+	err = sink.Commit(ctx, nil)
+	if err != nil {
+		t.Fatalf("Commit(): unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 1 || m.Files[0].Path != "groups.ndjson" || m.Files[0].Rows != 2 {
+		t.Errorf("unexpected manifest files %+v", m.Files)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "groups.ndjson")); err != nil {
+		t.Errorf("file wasn't committed: %v", err)
+	}
+
+	// The manifest exists, a second export requires Force.
+	err = NewFileSink().Create(ctx, params)
+	if !errors.Is(err, errFileExists) {
+		t.Errorf("Create() = %v, want %v", err, errFileExists)
+	}
+}
+
+// readDir returns the names of the files in dir.
+func readDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, entry := range entries {
+		res = append(res, entry.Name())
+	}
+	return res
+}
+
+func TestFileSinkForce(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	export := func(params *Params, scopes ...mcutil.ProjectAndLocation) {
+		t.Helper()
+		sink := NewFileSink()
+		err := sink.Create(ctx, params)
+		if err != nil {
+			t.Fatalf("Create(): unexpected error: %v", err)
+		}
+		for _, scope := range scopes {
+			stream, err := sink.Stream(ctx, newGroupTestTable("g1"), scope)
+			if err != nil {
+				t.Fatalf("Stream(): unexpected error: %v", err)
+			}
+			err = stream.Write(ctx)
+			if err != nil {
+				t.Fatalf("Write(): unexpected error: %v", err)
+			}
+		}
+		err = sink.Commit(ctx, nil)
+		if err != nil {
+			t.Fatalf("Commit(): unexpected error: %v", err)
+		}
+	}
+
+	// The first export has a file per project.
+	export(&Params{OutputDir: dir, OutputFormat: OutputFormatNDJSON, ProjectIDs: []string{"p1", "p2"}},
+		mcutil.ProjectAndLocation{Project: "p1", Location: "us-central1"},
+		mcutil.ProjectAndLocation{Project: "p2", Location: "us-central1"})
+	want := []string{"groups.p1.ndjson", "groups.p2.ndjson", manifestFileName}
+	if diff := cmp.Diff(want, readDir(t, dir)); diff != "" {
+		t.Errorf("unexpected files (-want, +got):\n%s", diff)
+	}
+
+	// A file that isn't part of an export is kept.
+	err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The files of the previous export are replaced.
+	export(&Params{OutputDir: dir, OutputFormat: OutputFormatNDJSON, Force: true},
+		mcutil.ProjectAndLocation{Project: "p3", Location: "us-central1"})
+	want = []string{"groups.ndjson", manifestFileName, "notes.txt"}
+	if diff := cmp.Diff(want, readDir(t, dir)); diff != "" {
+		t.Errorf("unexpected files (-want, +got):\n%s", diff)
+	}
+}
+
+// TestFileSinkCommitError checks that the previous export is left complete if
+// the files of the new export can't be moved to the output directory.
+func TestFileSinkCommitError(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	export := func(params *Params, scope mcutil.ProjectAndLocation) error {
+		t.Helper()
+		sink := NewFileSink()
+		err := sink.Create(ctx, params)
+		if err != nil {
+			t.Fatalf("Create(): unexpected error: %v", err)
+		}
+		stream, err := sink.Stream(ctx, newGroupTestTable("g1"), scope)
+		if err == nil {
+			err = stream.Write(ctx)
+		}
+		if err != nil {
+			t.Fatalf("Write(): unexpected error: %v", err)
+		}
+		err = sink.Commit(ctx, nil)
+		if err != nil {
+			sink.Abort(ctx)
+		}
+		return err
+	}
+
+	err := export(&Params{OutputDir: dir, OutputFormat: OutputFormatNDJSON, ProjectIDs: []string{"p1", "p2"}},
+		mcutil.ProjectAndLocation{Project: "p1", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("Commit(): unexpected error: %v", err)
+	}
+	// The file of the next export can't replace a directory that isn't
+	// empty.
+	err = os.MkdirAll(filepath.Join(dir, "groups.ndjson", "notes"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = export(&Params{OutputDir: dir, OutputFormat: OutputFormatNDJSON, Force: true},
+		mcutil.ProjectAndLocation{Project: "p3", Location: "us-central1"})
+	if err == nil {
+		t.Fatalf("Commit() succeeded, want an error")
+	}
+	want := []string{"groups.ndjson", "groups.p1.ndjson", manifestFileName}
+	if diff := cmp.Diff(want, readDir(t, dir)); diff != "" {
+		t.Errorf("unexpected files (-want, +got):\n%s", diff)
+	}
+	previous, err := readManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(previous.Files) != 1 || previous.Files[0].Path != "groups.p1.ndjson" {
+		t.Errorf("the manifest of the previous export was replaced: %+v", previous)
+	}
+}
+
+// TestFileSinkFailedProject checks that the files of a failed project are
+// replaced by the files of the previous export.
+func TestFileSinkFailedProject(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	firstExport := time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC)
+	export := func(exportTime time.Time, group string, failed []string) {
+		t.Helper()
+		sink := NewFileSink()
+		err := sink.Create(ctx, &Params{OutputDir: dir, OutputFormat: OutputFormatNDJSON, ProjectIDs: []string{"p1", "p2"}, ExportTime: exportTime, Force: true})
+		if err != nil {
+			t.Fatalf("Create(): unexpected error: %v", err)
+		}
+		for _, project := range []string{"p1", "p2"} {
+			stream, err := sink.Stream(ctx, newGroupTestTable(group), mcutil.ProjectAndLocation{Project: project, Location: "us-central1"})
+			if err != nil {
+				t.Fatalf("Stream(): unexpected error: %v", err)
+			}
+			err = stream.Write(ctx)
+			if err != nil {
+				t.Fatalf("Write(): unexpected error: %v", err)
+			}
+		}
+		err = sink.Commit(ctx, failed)
+		if err != nil {
+			t.Fatalf("Commit(): unexpected error: %v", err)
+		}
+	}
+
+	export(firstExport, "g1", nil)
+	export(firstExport.Add(24*time.Hour), "g2", []string{"p2"})
+
+	want := []string{"groups.p1.ndjson", "groups.p2.ndjson", manifestFileName}
+	if diff := cmp.Diff(want, readDir(t, dir)); diff != "" {
+		t.Errorf("unexpected files (-want, +got):\n%s", diff)
+	}
+	for name, want := range map[string]string{"groups.p1.ndjson": "g2", "groups.p2.ndjson": "g1"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s = %q, want the rows of %s", name, data, want)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, mf := range m.Files {
+		exportTime := "current"
+		if mf.ExportTime != nil {
+			exportTime = mf.ExportTime.Format(time.RFC3339)
+		}
+		got = append(got, fmt.Sprintf("%s %s", mf.Path, exportTime))
+	}
+	want = []string{"groups.p1.ndjson current", "groups.p2.ndjson 2023-10-01T00:00:00Z"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected manifest files (-want, +got):\n%s", diff)
+	}
+}
+
+func TestFileSinkChildFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	params := &Params{OutputDir: dir, OutputFormat: OutputFormatCSV}
+	sink := NewFileSink()
+	err := sink.Create(ctx, params)
+	if err != nil {
+		t.Fatalf("Create(): unexpected error: %v", err)
+	}
+	table := newGroupTestTable("g1")
+	table.Schema = bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "key", Type: bigquery.StringFieldType},
+			{Name: "value", Type: bigquery.StringFieldType},
+		}},
+	}
+	table.def.schema = table.Schema
+	table.def.newSource = func(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+		it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{
+			{Name: "g1", Labels: map[string]string{"env": "prod"}},
+		}}
+		return newObjectReader[*migrationcenterpb.Group](it, "group", table.Schema)
+	}
+	stream, err := sink.Stream(ctx, table, mcutil.ProjectAndLocation{Project: "project", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("Stream(): unexpected error: %v", err)
+	}
+	err = stream.Write(ctx)
+	if err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+	err = sink.Commit(ctx, nil)
+	if err != nil {
+		t.Fatalf("Commit(): unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, mf := range m.Files {
+		got = append(got, fmt.Sprintf("%s %s %d", mf.Path, mf.Table, mf.Rows))
+	}
+	want := []string{"groups.csv groups 1", "groups__labels.csv groups__labels 1"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected manifest files (-want, +got):\n%s", diff)
+	}
+}
+
+func TestFileSinkAbort(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	params := &Params{OutputDir: dir, OutputFormat: OutputFormatCSV}
+	sink := NewFileSink()
+	err := sink.Create(ctx, params)
+	if err != nil {
+		t.Fatalf("Create(): unexpected error: %v", err)
+	}
+	stream, err := sink.Stream(ctx, newGroupTestTable("g1"), mcutil.ProjectAndLocation{Project: "project", Location: "us-central1"})
+	if err != nil {
+		t.Fatalf("Stream(): unexpected error: %v", err)
+	}
+	err = stream.Write(ctx)
+	if err != nil {
+		t.Fatalf("Write(): unexpected error: %v", err)
+	}
+
+	sink.Abort(ctx)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Abort() left %d files in the output directory", len(entries))
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/iterator"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// AssetFilter selects the assets that are exported, the groups and preference
+// sets are narrowed to the matching assets. The conditions are combined with
+// AND, an empty filter matches all the assets.
+type AssetFilter struct {
+	// Expression is a Migration Center filter expression, see
+	// https://google.aip.dev/160.
+	Expression string
+	// Groups are the IDs or names of groups, the assets that are assigned
+	// to any of the groups match.
+	Groups []string
+	// Labels match the assets that have all the labels.
+	Labels map[string]string
+	// Sources are the IDs or names of sources, the assets that were
+	// reported by any of the sources match.
+	Sources []string
+}
+
+// ParseLabels parses labels in the key=value form.
+func ParseLabels(labels []string) (map[string]string, error) {
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	res := make(map[string]string, len(labels))
+	for _, label := range labels {
+		key, value, ok := strings.Cut(label, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, messages.NewError(messages.InvalidLabel{Label: label})
+		}
+		res[key] = strings.TrimSpace(value)
+	}
+
+	return res, nil
+}
+
+// IsEmpty returns true if the filter matches all the assets.
+func (f *AssetFilter) IsEmpty() bool {
+	return f.Expression == "" && len(f.Groups) == 0 && len(f.Labels) == 0 && len(f.Sources) == 0
+}
+
+// expression returns the list filter that matches the assets of pal, group
+// and source IDs are resolved to names in pal.
+func (f *AssetFilter) expression(pal mcutil.ProjectAndLocation) string {
+	var conditions []string
+	if f.Expression != "" {
+		conditions = append(conditions, f.Expression)
+	}
+	if len(f.Groups) > 0 {
+		conditions = append(conditions, anyOf("assigned_groups", resourceNames(pal, "groups", f.Groups)))
+	}
+	if len(f.Sources) > 0 {
+		conditions = append(conditions, anyOf("sources", resourceNames(pal, "sources", f.Sources)))
+	}
+	keys := make([]string, 0, len(f.Labels))
+	for key := range f.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		conditions = append(conditions, fmt.Sprintf("labels.%s = %q", key, f.Labels[key]))
+	}
+
+	return andFilters(conditions...)
+}
+
+// groupNames returns the names of the groups of pal that are selected by
+// f.Groups, nil is returned if f.Groups is empty.
+func (f *AssetFilter) groupNames(pal mcutil.ProjectAndLocation) map[string]bool {
+	if len(f.Groups) == 0 {
+		return nil
+	}
+
+	res := map[string]bool{}
+	for _, name := range resourceNames(pal, "groups", f.Groups) {
+		res[name] = true
+	}
+	return res
+}
+
+// resourceNames returns the full names of the resources of collection in pal,
+// the ids that are already full names are returned as is.
+func resourceNames(pal mcutil.ProjectAndLocation, collection string, ids []string) []string {
+	res := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !strings.Contains(id, "/") {
+			id = path.Join(pal.Path(), collection, id)
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+// anyOf returns a filter that matches the objects whose repeated field
+// contains any of values.
+func anyOf(field string, values []string) string {
+	var res []string
+	for _, value := range values {
+		res = append(res, fmt.Sprintf("%s:%q", field, value))
+	}
+	return strings.Join(res, " OR ")
+}
+
+// andFilters combines the non empty filters with AND.
+func andFilters(filters ...string) string {
+	var nonEmpty []string
+	for _, filter := range filters {
+		if filter != "" {
+			nonEmpty = append(nonEmpty, filter)
+		}
+	}
+	if len(nonEmpty) == 1 {
+		return nonEmpty[0]
+	}
+
+	var res []string
+	for _, filter := range nonEmpty {
+		res = append(res, "("+filter+")")
+	}
+	return strings.Join(res, " AND ")
+}
+
+// filterIterator returns the objects of it that keep returns true for.
+type filterIterator[T any] struct {
+	it   iterable[T]
+	keep func(obj T) (bool, error)
+}
+
+func (it *filterIterator[T]) Next() (T, error) {
+	for {
+		obj, err := it.it.Next()
+		if err != nil {
+			return obj, err
+		}
+		ok, err := it.keep(obj)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		if ok {
+			return obj, nil
+		}
+	}
+}
+
+// collectStrings returns the set of the strings returned by values for every
+// object of it.
+func collectStrings[T any](it iterable[T], values func(obj T) []string) (map[string]bool, error) {
+	res := map[string]bool{}
+	for {
+		obj, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values(obj) {
+			res[value] = true
+		}
+	}
+}
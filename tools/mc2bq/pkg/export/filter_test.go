@@ -0,0 +1,146 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+)
+
+func TestAssetFilterExpression(t *testing.T) {
+	pal := mcutil.ProjectAndLocation{Project: "p", Location: "us-central1"}
+	tests := []struct {
+		name   string
+		filter AssetFilter
+		want   string
+	}{
+		{name: "empty", want: ""},
+		{
+			name:   "expression",
+			filter: AssetFilter{Expression: `create_time > "2023-01-01T00:00:00Z"`},
+			want:   `create_time > "2023-01-01T00:00:00Z"`,
+		},
+		{
+			name:   "groups",
+			filter: AssetFilter{Groups: []string{"g1", "projects/other/locations/us-central1/groups/g2"}},
+			want:   `assigned_groups:"projects/p/locations/us-central1/groups/g1" OR assigned_groups:"projects/other/locations/us-central1/groups/g2"`,
+		},
+		{
+			name: "all",
+			filter: AssetFilter{
+				Expression: `name = "a" OR name = "b"`,
+				Groups:     []string{"g1"},
+				Labels:     map[string]string{"team": "payments", "env": "prod"},
+				Sources:    []string{"s1", "s2"},
+			},
+			want: `(name = "a" OR name = "b") AND ` +
+				`(assigned_groups:"projects/p/locations/us-central1/groups/g1") AND ` +
+				`(sources:"projects/p/locations/us-central1/sources/s1" OR sources:"projects/p/locations/us-central1/sources/s2") AND ` +
+				`(labels.env = "prod") AND ` +
+				`(labels.team = "payments")`,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.filter.expression(pal); got != tc.want {
+				t.Errorf("expression() = %s, want %s", got, tc.want)
+			}
+			if got := tc.filter.IsEmpty(); got != (tc.want == "") {
+				t.Errorf("IsEmpty() = %v, want %v", got, tc.want == "")
+			}
+		})
+	}
+
+	// The filter is combined with the filter of incremental exports.
+	filter := AssetFilter{Labels: map[string]string{"team": "payments"}}
+	want := `(labels.team = "payments") AND (update_time >= "2023-01-01T00:00:00Z")`
+	if got := andFilters(filter.expression(pal), `update_time >= "2023-01-01T00:00:00Z"`); got != want {
+		t.Errorf("andFilters() = %s, want %s", got, want)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	got, err := ParseLabels([]string{"team=payments", " env = prod ", "empty="})
+	if err != nil {
+		t.Fatalf("ParseLabels(): unexpected error: %v", err)
+	}
+	want := map[string]string{"team": "payments", "env": "prod", "empty": ""}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseLabels(): unexpected labels (-want, +got):\n%s", diff)
+	}
+
+	for _, label := range []string{"team", "=payments"} {
+		if _, err := ParseLabels([]string{label}); err == nil {
+			t.Errorf("ParseLabels(%q): expected an error", label)
+		}
+	}
+}
+
+func TestNarrow(t *testing.T) {
+	groups := func() *sliceIterator[*migrationcenterpb.Group] {
+		return &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{
+			{Name: "g1"}, {Name: "g2"}, {Name: "g3"},
+		}}
+	}
+
+	calls := 0
+	it := narrow[*migrationcenterpb.Group](groups(), func() (map[string]bool, error) {
+		calls++
+		return map[string]bool{"g1": true, "g3": true}, nil
+	})
+	got, err := readGroupNames(it)
+	if err != nil {
+		t.Fatalf("narrow(): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"g1", "g3"}, got); diff != "" {
+		t.Errorf("narrow(): unexpected groups (-want, +got):\n%s", diff)
+	}
+	if calls != 1 {
+		t.Errorf("narrow() listed the names %d times, want 1", calls)
+	}
+
+	// A nil set keeps all the objects.
+	it = narrow[*migrationcenterpb.Group](groups(), func() (map[string]bool, error) { return nil, nil })
+	got, err = readGroupNames(it)
+	if err != nil || len(got) != 3 {
+		t.Errorf("narrow() with a nil set = %v, %v, want all the groups", got, err)
+	}
+
+	errList := errors.New("list failed")
+	it = narrow[*migrationcenterpb.Group](groups(), func() (map[string]bool, error) { return nil, errList })
+	if _, err := it.Next(); !errors.Is(err, errList) {
+		t.Errorf("narrow().Next() = %v, want %v", err, errList)
+	}
+}
+
+func readGroupNames(it iterable[*migrationcenterpb.Group]) ([]string, error) {
+	var names []string
+	for {
+		group, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, group.Name)
+	}
+}
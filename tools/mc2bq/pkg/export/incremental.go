@@ -0,0 +1,433 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+const (
+	// updateTimeColumn is the column that is used to find the objects that
+	// were updated since the previous export.
+	updateTimeColumn = "update_time"
+	// deleteTimeColumn is set on objects that no longer exist in Migration
+	// Center, it's only present in tables that are exported incrementally.
+	deleteTimeColumn = "delete_time"
+)
+
+var errMissingUpdateTime = messages.NewError(messages.ErrMsgIncrementalMissingUpdateTime)
+
+// incrementalTable merges the objects that were updated since the previous
+// export into an existing table. The objects are first loaded to a staging
+// table of every scope and then merged into the table by promote, objects
+// that no longer exist are marked as deleted by setting the delete_time
+// column.
+type incrementalTable struct {
+	bq     *bigquery.Client
+	tbl    *bigquery.Table
+	params *Params
+	// writeObjects writes the objects of a source to a staging table.
+	writeObjects func(ctx context.Context, tbl *bigquery.Table, src mcutil.ObjectSource) error
+	// layout is the partitioning and clustering of the table.
+	layout *TableLayout
+	// md is the metadata the table is created with if it doesn't exist,
+	// see prepare.
+	md *bigquery.TableMetadata
+	// watermarks is the table the watermarks are saved to, see
+	// saveWatermark.
+	watermarks *bigquery.Table
+
+	// schema is the schema of the table, it's set by apply.
+	schema bigquery.Schema
+	// created is set if the table was created by apply.
+	created bool
+
+	// watermark is the time the objects of every scope were listed by the
+	// previous export.
+	watermark map[mcutil.ProjectAndLocation]time.Time
+
+	mu sync.Mutex
+	// pending are the merges that are done by promote.
+	pending []pendingMerge
+}
+
+func newIncrementalTable(bq *bigquery.Client, tbl *bigquery.Table, params *Params) *incrementalTable {
+	return &incrementalTable{
+		bq:           bq,
+		tbl:          tbl,
+		params:       params,
+		writeObjects: exportObjects,
+	}
+}
+
+// prepare checks that the rows of schema can be merged into an existing
+// table and that its layout can be kept, and loads the watermark of every
+// scope. The table is created, or the delete_time column is added to a
+// table that was created by a full export, by apply.
+func (it *incrementalTable) prepare(ctx context.Context, schema bigquery.Schema) error {
+	if !hasColumn(schema, updateTimeColumn) {
+		return errMissingUpdateTime
+	}
+	it.md = it.layout.metadata(append(schema, &bigquery.FieldSchema{Name: deleteTimeColumn, Type: bigquery.TimestampFieldType}))
+
+	md, err := it.tbl.Metadata(ctx)
+	if gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		messages.Print(messages.ExportingDataToTable{TableName: it.tbl.TableID})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = it.layout.drift(md, it.layout.timePartitioning()).err(it.tbl)
+	if err == nil {
+		err = checkSchema(it.tbl, md, it.md.Schema)
+	}
+	if err != nil {
+		return err
+	}
+
+	it.watermark, err = loadWatermarks(ctx, it.bq, it.watermarks, it.tbl.TableID)
+	return err
+}
+
+func (it *incrementalTable) apply(ctx context.Context) error {
+	var err error
+	it.schema, it.created, err = applySchema(ctx, it.tbl, it.md)
+	if err != nil {
+		return err
+	}
+	return createWatermarkTable(ctx, it.watermarks)
+}
+
+// scopeColumns returns the columns that identify the scope of the objects.
+func (it *incrementalTable) scopeColumns() []string {
+	return it.params.scopeColumns()
+}
+
+// scopeKey returns the key of the rows of pal, see Params.scopeKey.
+func (it *incrementalTable) scopeKey(pal mcutil.ProjectAndLocation) mcutil.ProjectAndLocation {
+	return it.params.scopeKey(pal)
+}
+
+// scopeFilter returns a condition that matches the rows of pal.
+func (it *incrementalTable) scopeFilter(pal mcutil.ProjectAndLocation) (string, []bigquery.QueryParameter) {
+	cond := []string{"TRUE"}
+	var params []bigquery.QueryParameter
+	key := it.scopeKey(pal)
+	for _, col := range it.scopeColumns() {
+		value := key.Project
+		if col == "location" {
+			value = key.Location
+		}
+		cond = append(cond, fmt.Sprintf("%s = @%s", quoteIdentifier(col), col))
+		params = append(params, bigquery.QueryParameter{Name: col, Value: value})
+	}
+	return strings.Join(cond, " AND "), params
+}
+
+// newSource returns the source of the objects of pal that were updated since
+// the previous export and a function that loads them to staging tables, the
+// objects are merged into the table by promote.
+// All the objects are returned if pal has no watermark, in which case partial
+// is false.
+// The objects are listed after newSource returns, so its time is saved as the
+// watermark of pal and the next export lists the objects that were updated
+// since the watermark, minus watermarkSkew. The latest update time of the
+// exported objects can't be used instead: the objects aren't listed in update
+// time order, so an object that was updated after it was listed can have an
+// earlier update time than an object that was listed later.
+func (it *incrementalTable) newSource(ctx context.Context, mc mcutil.MC, pal mcutil.ProjectAndLocation) (src mcutil.ObjectSource, load func(ctx context.Context) error, partial bool) {
+	listingTime := time.Now()
+	watermark, ok := it.watermark[pal]
+	if !ok {
+		src = mc.AssetSource(ctx, pal)
+		return src, func(ctx context.Context) error {
+			return it.load(ctx, pal, listingTime, src, nil)
+		}, false
+	}
+
+	since := watermark.Add(-watermarkSkew)
+	projectID, location := it.params.displayScope(pal)
+	messages.Print(messages.ExportTableIncremental{
+		TableName: it.tbl.TableID,
+		ProjectID: projectID,
+		Location:  location,
+		Since:     since,
+	})
+	src = mc.UpdatedAssetSource(ctx, pal, since)
+	return src, func(ctx context.Context) error {
+		return it.load(ctx, pal, listingTime, src, mc.AssetNameSource(ctx, pal))
+	}, true
+}
+
+// pendingMerge are the staging tables of a scope that are merged into the
+// table by promote.
+type pendingMerge struct {
+	pal     mcutil.ProjectAndLocation
+	schema  bigquery.Schema
+	staging *bigquery.Table
+	// names is nil if the scope has no watermark, in which case all its
+	// objects are in staging.
+	names *bigquery.Table
+	// listingTime is saved as the watermark of pal, see newSource.
+	listingTime time.Time
+}
+
+// load loads the objects from src into a staging table, and the names of all
+// the objects from names into another staging table if names is not nil.
+func (it *incrementalTable) load(ctx context.Context, pal mcutil.ProjectAndLocation, listingTime time.Time, src mcutil.ObjectSource, names mcutil.ObjectSource) error {
+	merge := pendingMerge{pal: pal, listingTime: listingTime, schema: src.Schema()}
+	var err error
+	merge.staging, err = it.stagingTable(ctx, pal, "merge", src.Schema())
+	if err != nil {
+		return err
+	}
+	it.addPending(merge)
+
+	err = it.writeObjects(ctx, merge.staging, src)
+	if err != nil {
+		return err
+	}
+
+	if names != nil {
+		merge.names, err = it.stagingTable(ctx, pal, "names", names.Schema())
+		if err != nil {
+			return err
+		}
+		it.addPending(merge)
+
+		err = it.writeObjects(ctx, merge.names, names)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addPending adds or updates the pending merge of a scope.
+func (it *incrementalTable) addPending(merge pendingMerge) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+	for i := range it.pending {
+		if it.pending[i].pal == merge.pal {
+			it.pending[i] = merge
+			return
+		}
+	}
+	it.pending = append(it.pending, merge)
+}
+
+// promote merges the staging tables of every scope into the table in tx,
+// objects that are not in the names staging table of their scope are marked
+// as deleted, and saves the watermarks of the scopes. The scopes of the failed
+// projects are skipped, their rows and watermarks are left as they were saved
+// by the previous export.
+func (it *incrementalTable) promote(ctx context.Context, tx *transaction, failed []string) error {
+	for _, merge := range it.pending {
+		if isFailed(failed, merge.pal.Project) {
+			continue
+		}
+		err := it.merge(ctx, tx, merge)
+		if err != nil {
+			return err
+		}
+		err = saveWatermark(ctx, tx, it.watermarks, it.tbl.TableID, merge.pal, merge.listingTime)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// merge merges the staging tables of a scope into the table in tx.
+func (it *incrementalTable) merge(ctx context.Context, tx *transaction, merge pendingMerge) error {
+	columns, exprs := selectColumns(it.schema, merge.schema)
+	_, err := tx.run(ctx, mergeQuery(sqlTableName(it.tbl), sqlTableName(merge.staging), columns, exprs), nil)
+	if err != nil {
+		return fmt.Errorf("merge %s: %w", it.tbl.TableID, err)
+	}
+
+	names := merge.names
+	if names == nil && !it.created {
+		// The table may have rows of a scope that has no watermark
+		// from an export that didn't save watermarks.
+		names = merge.staging
+	}
+	if names == nil {
+		return nil
+	}
+
+	cond, params := it.scopeFilter(merge.pal)
+	deleted, err := tx.run(ctx, tombstoneQuery(sqlTableName(it.tbl), sqlTableName(names), cond), params)
+	if err != nil {
+		return fmt.Errorf("mark deleted objects of %s: %w", it.tbl.TableID, err)
+	}
+
+	projectID, location := it.params.displayScope(merge.pal)
+	messages.Print(messages.ExportTableDeletedRecords{
+		TableName:   it.tbl.TableID,
+		ProjectID:   projectID,
+		Location:    location,
+		RecordCount: deleted,
+	})
+	return nil
+}
+
+// finish updates the clustering of the table, which isn't changed by
+// promote.
+func (it *incrementalTable) finish(ctx context.Context) error {
+	if it.layout == nil {
+		return nil
+	}
+	md, err := it.tbl.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("promote %s: %w", it.tbl.TableID, err)
+	}
+	return it.layout.ensure(ctx, it.tbl, md, it.layout.timePartitioning())
+}
+
+func (it *incrementalTable) revert() {
+	if it.created {
+		deleteTable(it.tbl)
+	}
+}
+
+// cleanup deletes the staging tables.
+func (it *incrementalTable) cleanup() {
+	for _, merge := range it.pending {
+		deleteTable(merge.staging)
+		if merge.names != nil {
+			deleteTable(merge.names)
+		}
+	}
+}
+
+// stagingTable creates an empty staging table for the objects of pal, kind
+// distinguishes between the staging tables of the same scope.
+func (it *incrementalTable) stagingTable(ctx context.Context, pal mcutil.ProjectAndLocation, kind string, schema bigquery.Schema) (*bigquery.Table, error) {
+	name := []string{kind}
+	key := it.scopeKey(pal)
+	for _, part := range []string{key.Project, key.Location} {
+		if part != "" {
+			name = append(name, sanitizeTableID(part))
+		}
+	}
+
+	return createStagingTable(ctx, it.bq, it.tbl, strings.Join(name, "_"), &bigquery.TableMetadata{Schema: schema}, false)
+}
+
+// runQuery runs q and waits for it to complete, it returns the number of rows
+// modified by DML statements. The job is recorded in the jobRecorder of ctx.
+func runQuery(ctx context.Context, q *bigquery.Query) (int64, error) {
+	job, err := q.Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+	recordJob(ctx, job)
+
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := status.Err(); err != nil {
+		return 0, err
+	}
+
+	if stats, ok := status.Statistics.Details.(*bigquery.QueryStatistics); ok {
+		return stats.NumDMLAffectedRows, nil
+	}
+	return 0, nil
+}
+
+// mergeQuery returns a statement that merges the rows of the staging table
+// into the target table, rows are matched by name. The columns of the target
+// table are set to exprs, see selectColumns. The staging tables don't have the
+// delete_time column so it's set to NULL, an object that was deleted and
+// created again is no longer deleted.
+func mergeQuery(target string, staging string, columns []string, exprs []string) string {
+	var set []string
+	for i, col := range columns {
+		set = append(set, fmt.Sprintf("%s = %s", col, exprs[i]))
+	}
+
+	return fmt.Sprintf("MERGE %s AS target USING %s AS source ON target.name = source.name "+
+		"WHEN MATCHED THEN UPDATE SET %s "+
+		"WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		target, staging, strings.Join(set, ", "), strings.Join(columns, ", "), strings.Join(exprs, ", "))
+}
+
+// tombstoneQuery returns a statement that marks the rows of the target table
+// that match cond and whose name is not in the names table as deleted.
+func tombstoneQuery(target string, names string, cond string) string {
+	return fmt.Sprintf("UPDATE %s SET %s = CURRENT_TIMESTAMP() WHERE %s IS NULL AND %s AND name NOT IN (SELECT name FROM %s)",
+		target, quoteIdentifier(deleteTimeColumn), quoteIdentifier(deleteTimeColumn), cond, names)
+}
+
+// sqlTableName returns the quoted name of tbl for use in Standard SQL.
+func sqlTableName(tbl *bigquery.Table) string {
+	return fmt.Sprintf("`%s.%s.%s`", tbl.ProjectID, tbl.DatasetID, tbl.TableID)
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + name + "`"
+}
+
+// sanitizeTableID replaces the characters that are not allowed in table IDs.
+func sanitizeTableID(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// rowScope returns the scope key of a row whose first values are the
+// scopeColumns, see Params.scopeKey.
+func rowScope(scopeColumns []string, row []bigquery.Value) mcutil.ProjectAndLocation {
+	var key mcutil.ProjectAndLocation
+	for i, col := range scopeColumns {
+		value, _ := row[i].(string)
+		if col == "project_id" {
+			key.Project = value
+		} else {
+			key.Location = value
+		}
+	}
+	return key
+}
+
+func hasColumn(schema bigquery.Schema, name string) bool {
+	for _, field := range schema {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
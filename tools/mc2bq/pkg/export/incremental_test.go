@@ -0,0 +1,154 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+)
+
+func TestIncrementalQueries(t *testing.T) {
+	columns, exprs := selectColumns(bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "update_time", Type: bigquery.TimestampFieldType},
+		{Name: "delete_time", Type: bigquery.TimestampFieldType},
+	}, bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "update_time", Type: bigquery.TimestampFieldType},
+	})
+
+	tCases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{
+			name: "watermark",
+			got:  watermarkQuery("`p.d._mc2bq_watermarks`"),
+			want: "SELECT project_id, location, listing_time FROM `p.d._mc2bq_watermarks` WHERE table_name = @table_name",
+		},
+		{
+			name: "save watermark",
+			got:  saveWatermarkQuery("`p.d._mc2bq_watermarks`"),
+			want: "MERGE `p.d._mc2bq_watermarks` AS target " +
+				"USING (SELECT @table_name AS table_name, @project_id AS project_id, @location AS location, @listing_time AS listing_time) AS source " +
+				"ON target.table_name = source.table_name AND target.project_id = source.project_id AND target.location = source.location " +
+				"WHEN MATCHED THEN UPDATE SET listing_time = source.listing_time " +
+				"WHEN NOT MATCHED THEN INSERT (table_name, project_id, location, listing_time) " +
+				"VALUES (source.table_name, source.project_id, source.location, source.listing_time)",
+		},
+		{
+			name: "merge",
+			got:  mergeQuery("`p.d.assets`", "`p.d.assets_staging`", columns, exprs),
+			want: "MERGE `p.d.assets` AS target USING `p.d.assets_staging` AS source ON target.name = source.name " +
+				"WHEN MATCHED THEN UPDATE SET `name` = source.`name`, `update_time` = source.`update_time`, `delete_time` = CAST(NULL AS TIMESTAMP) " +
+				"WHEN NOT MATCHED THEN INSERT (`name`, `update_time`, `delete_time`) VALUES (source.`name`, source.`update_time`, CAST(NULL AS TIMESTAMP))",
+		},
+		{
+			name: "tombstone",
+			got:  tombstoneQuery("`p.d.assets`", "`p.d.assets_names`", "TRUE"),
+			want: "UPDATE `p.d.assets` SET `delete_time` = CURRENT_TIMESTAMP() WHERE `delete_time` IS NULL AND TRUE AND name NOT IN (SELECT name FROM `p.d.assets_names`)",
+		},
+		{
+			name: "updated since filter",
+			got:  updatedSinceFilter(time.Date(2023, 10, 1, 12, 30, 0, 500, time.FixedZone("UTC+2", 2*60*60))),
+			want: `update_time >= "2023-10-01T10:30:00.0000005Z"`,
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			if tCase.got != tCase.want {
+				t.Errorf("unexpected query\nwant: %s\ngot:  %s", tCase.want, tCase.got)
+			}
+		})
+	}
+}
+
+func TestIncrementalTableScope(t *testing.T) {
+	pal := mcutil.ProjectAndLocation{Project: "my-project", Location: "us-central1"}
+	tbl := &bigquery.Table{ProjectID: "p", DatasetID: "d", TableID: "assets"}
+
+	single := newIncrementalTable(nil, tbl, &Params{ProjectID: "my-project"})
+	cond, params := single.scopeFilter(pal)
+	if cond != "TRUE" || len(params) != 0 {
+		t.Errorf("scopeFilter() for a single scope = %q, %v, want TRUE without parameters", cond, params)
+	}
+	if key := single.scopeKey(pal); key != (mcutil.ProjectAndLocation{}) {
+		t.Errorf("scopeKey() for a single scope = %+v, want an empty key", key)
+	}
+
+	multi := newIncrementalTable(nil, tbl, &Params{ProjectIDs: []string{"my-project"}, AllRegions: true})
+	cond, params = multi.scopeFilter(pal)
+	wantCond := "TRUE AND `project_id` = @project_id AND `location` = @location"
+	if cond != wantCond {
+		t.Errorf("scopeFilter() = %q, want %q", cond, wantCond)
+	}
+	wantParams := []bigquery.QueryParameter{
+		{Name: "project_id", Value: "my-project"},
+		{Name: "location", Value: "us-central1"},
+	}
+	if diff := cmp.Diff(wantParams, params); diff != "" {
+		t.Errorf("scopeFilter() unexpected parameters (-want, +got):\n%s", diff)
+	}
+	if key := multi.scopeKey(pal); key != pal {
+		t.Errorf("scopeKey() = %+v, want %+v", key, pal)
+	}
+	if got, want := sanitizeTableID(pal.Location), "us_central1"; got != want {
+		t.Errorf("sanitizeTableID(%q) = %q, want %q", pal.Location, got, want)
+	}
+}
+
+// sinceMC records the time the updated assets are listed since.
+type sinceMC struct {
+	mcutil.MC
+	since *time.Time
+}
+
+func (mc sinceMC) AssetSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	return nil
+}
+
+func (mc sinceMC) UpdatedAssetSource(ctx context.Context, pal mcutil.ProjectAndLocation, since time.Time) mcutil.ObjectSource {
+	*mc.since = since
+	return nil
+}
+
+func TestIncrementalTableNewSource(t *testing.T) {
+	exported := mcutil.ProjectAndLocation{Project: "exported", Location: "us-central1"}
+	added := mcutil.ProjectAndLocation{Project: "added", Location: "us-central1"}
+	watermark := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	it := newIncrementalTable(nil, &bigquery.Table{TableID: "assets"}, &Params{ProjectIDs: []string{"exported", "added"}})
+	it.watermark = map[mcutil.ProjectAndLocation]time.Time{exported: watermark}
+	var since time.Time
+	mc := sinceMC{since: &since}
+
+	if _, _, partial := it.newSource(context.Background(), mc, added); partial {
+		t.Errorf("newSource() of a scope without a watermark is partial, want all the objects")
+	}
+	if _, _, partial := it.newSource(context.Background(), mc, exported); !partial {
+		t.Errorf("newSource() of a scope with a watermark isn't partial")
+	}
+	if want := watermark.Add(-watermarkSkew); !since.Equal(want) {
+		t.Errorf("newSource() listed the assets updated since %v, want %v", since, want)
+	}
+}
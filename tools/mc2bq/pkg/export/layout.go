@@ -0,0 +1,304 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+	exporterschema "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+)
+
+// maxClusteringColumns is the maximum number of columns a BigQuery table can
+// be clustered by.
+const maxClusteringColumns = 4
+
+// TableLayout is the partitioning and clustering of a BigQuery table. The
+// layout is applied when the table is created, on later exports the layout of
+// the table is compared to it and updated if possible.
+type TableLayout struct {
+	// Partitioning partitions the table by a TIMESTAMP or DATE column, in
+	// ModeSnapshot the tables are always partitioned by day on
+	// export_time.
+	Partitioning *TablePartitioning `json:"partitioning,omitempty"`
+	// Clustering are the top level columns the table is clustered by.
+	Clustering []string `json:"clustering,omitempty"`
+	// RequirePartitionFilter rejects the queries of the table that don't
+	// filter on the partitioning column.
+	RequirePartitionFilter bool `json:"require_partition_filter,omitempty"`
+}
+
+// TablePartitioning is the time partitioning of a table.
+type TablePartitioning struct {
+	// Field is the column the table is partitioned by, e.g. update_time.
+	Field string `json:"field"`
+	// Type is the granularity of the partitions, one of DAY (the default),
+	// HOUR, MONTH or YEAR.
+	Type string `json:"type,omitempty"`
+}
+
+// TableLayouts are the layouts of the tables, keyed by the name of the table
+// without the table prefix (e.g. assets).
+type TableLayouts map[string]*TableLayout
+
+// layoutTableNames returns the names of the tables that can have a layout.
+func layoutTableNames() []string {
+	var res []string
+	t := reflect.TypeOf(exporterschema.ExporterSchema{})
+	for i := 0; i < t.NumField(); i++ {
+		res = append(res, t.Field(i).Tag.Get("bq"))
+	}
+	return res
+}
+
+// validate checks that the layouts are of known tables.
+func (layouts TableLayouts) validate() error {
+	names := layoutTableNames()
+	var tables []string
+	for table := range layouts {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		known := false
+		for _, name := range names {
+			known = known || name == table
+		}
+		if !known {
+			return messages.NewError(messages.UnknownLayoutTable{Table: table, Tables: names})
+		}
+	}
+	return nil
+}
+
+// partitioningType returns the type of the partitioning, DAY if it isn't set.
+func (p *TablePartitioning) partitioningType() bigquery.TimePartitioningType {
+	if p.Type == "" {
+		return bigquery.DayPartitioningType
+	}
+	return bigquery.TimePartitioningType(strings.ToUpper(p.Type))
+}
+
+// validate checks that the layout can be applied to the table name with schema
+// in mode, incremental is set if the table is exported incrementally.
+func (l *TableLayout) validate(name string, schema bigquery.Schema, mode Mode, incremental bool) error {
+	if l == nil {
+		return nil
+	}
+	invalid := func(reason messages.Message) error {
+		return messages.NewError(messages.InvalidTableLayout{Table: name, Reason: reason})
+	}
+
+	if p := l.Partitioning; p != nil {
+		switch p.partitioningType() {
+		case bigquery.DayPartitioningType, bigquery.HourPartitioningType, bigquery.MonthPartitioningType, bigquery.YearPartitioningType:
+		default:
+			return invalid(messages.LayoutInvalidPartitionType{Type: p.Type})
+		}
+		if mode == ModeSnapshot && (p.Field != exportTimeColumn || p.partitioningType() != bigquery.DayPartitioningType) {
+			return invalid(messages.ErrMsgLayoutSnapshotPartition)
+		}
+
+		field := findColumn(schema, p.Field)
+		if field == nil {
+			return invalid(messages.LayoutColumnNotFound{Column: p.Field})
+		}
+		if field.Repeated || (field.Type != bigquery.TimestampFieldType && field.Type != bigquery.DateFieldType) {
+			return invalid(messages.LayoutInvalidPartitionColumn{Column: p.Field})
+		}
+	}
+
+	if len(l.Clustering) > maxClusteringColumns {
+		return invalid(messages.ErrMsgLayoutTooManyClustering)
+	}
+	for _, column := range l.Clustering {
+		if strings.Contains(column, ".") {
+			// Nested fields can't be used for clustering.
+			return invalid(messages.LayoutInvalidClusteringColumn{Column: column})
+		}
+		field := findColumn(schema, column)
+		if field == nil {
+			return invalid(messages.LayoutColumnNotFound{Column: column})
+		}
+		switch {
+		case field.Repeated, field.Type == bigquery.RecordFieldType, field.Type == bigquery.FloatFieldType, field.Type == bigquery.JSONFieldType:
+			return invalid(messages.LayoutInvalidClusteringColumn{Column: column})
+		}
+	}
+
+	if l.RequirePartitionFilter {
+		if l.Partitioning == nil && mode != ModeSnapshot {
+			return invalid(messages.ErrMsgLayoutFilterNoPartition)
+		}
+		if mode == ModeSnapshot || (mode == ModeIncremental && incremental) {
+			return invalid(messages.ErrMsgLayoutFilterUnsupported)
+		}
+	}
+
+	return nil
+}
+
+// findColumn returns the top level column name of schema, nil if it doesn't
+// exist.
+func findColumn(schema bigquery.Schema, name string) *bigquery.FieldSchema {
+	for _, field := range schema {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// timePartitioning returns the partitioning of the layout, nil if the table
+// isn't partitioned. It isn't used in ModeSnapshot, see prepareSnapshotTable.
+func (l *TableLayout) timePartitioning() *bigquery.TimePartitioning {
+	if l == nil || l.Partitioning == nil {
+		return nil
+	}
+	return &bigquery.TimePartitioning{
+		Type:  l.Partitioning.partitioningType(),
+		Field: l.Partitioning.Field,
+	}
+}
+
+// clustering returns the clustering of the layout, nil if the table isn't
+// clustered.
+func (l *TableLayout) clustering() *bigquery.Clustering {
+	if l == nil || len(l.Clustering) == 0 {
+		return nil
+	}
+	return &bigquery.Clustering{Fields: l.Clustering}
+}
+
+// metadata returns the metadata of a new table with schema and the layout.
+func (l *TableLayout) metadata(schema bigquery.Schema) *bigquery.TableMetadata {
+	return &bigquery.TableMetadata{
+		Schema:                 schema,
+		TimePartitioning:       l.timePartitioning(),
+		Clustering:             l.clustering(),
+		RequirePartitionFilter: l != nil && l.RequirePartitionFilter,
+	}
+}
+
+// layoutDrift are the differences between the layout of a table and its
+// TableLayout.
+type layoutDrift struct {
+	differences []messages.LayoutDifference
+	// recreate is set if the table must be recreated to match the layout,
+	// BigQuery can't change the partitioning of a table or remove its
+	// clustering.
+	recreate bool
+}
+
+// drift compares the layout of the table with md to l, partitioning is the
+// partitioning the table should have. There is no drift if l is nil.
+func (l *TableLayout) drift(md *bigquery.TableMetadata, partitioning *bigquery.TimePartitioning) layoutDrift {
+	var res layoutDrift
+	if l == nil {
+		return res
+	}
+
+	want, got := formatPartitioning(partitioning), formatPartitioning(md.TimePartitioning)
+	if want != got {
+		res.differences = append(res.differences, messages.LayoutDifference{Property: "partitioning", Want: want, Got: got})
+		res.recreate = true
+	}
+
+	var gotClustering []string
+	if md.Clustering != nil {
+		gotClustering = md.Clustering.Fields
+	}
+	if !reflect.DeepEqual(l.Clustering, gotClustering) && (len(l.Clustering) > 0 || len(gotClustering) > 0) {
+		res.differences = append(res.differences, messages.LayoutDifference{
+			Property: "clustering",
+			Want:     formatClustering(l.Clustering),
+			Got:      formatClustering(gotClustering),
+		})
+		res.recreate = res.recreate || len(l.Clustering) == 0
+	}
+
+	if l.RequirePartitionFilter != md.RequirePartitionFilter {
+		res.differences = append(res.differences, messages.LayoutDifference{
+			Property: "require_partition_filter",
+			Want:     fmt.Sprint(l.RequirePartitionFilter),
+			Got:      fmt.Sprint(md.RequirePartitionFilter),
+		})
+	}
+
+	return res
+}
+
+func formatPartitioning(p *bigquery.TimePartitioning) string {
+	if p == nil {
+		return "none"
+	}
+	field := p.Field
+	if field == "" {
+		field = "_PARTITIONTIME"
+	}
+	return fmt.Sprintf("%s(%s)", p.Type, field)
+}
+
+func formatClustering(columns []string) string {
+	if len(columns) == 0 {
+		return "none"
+	}
+	return strings.Join(columns, ",")
+}
+
+// update updates the clustering and the partition filter requirement
+// of tbl with md to match l, the differences must not require recreating the
+// table.
+func (l *TableLayout) update(ctx context.Context, tbl *bigquery.Table, md *bigquery.TableMetadata, drift layoutDrift) error {
+	if len(drift.differences) == 0 {
+		return nil
+	}
+
+	messages.Print(messages.ExportUpdatingTableLayout{TableName: tbl.TableID, Differences: drift.differences})
+	_, err := tbl.Update(ctx, bigquery.TableMetadataToUpdate{
+		Clustering:             l.clustering(),
+		RequirePartitionFilter: l.RequirePartitionFilter,
+	}, md.ETag)
+	if err != nil {
+		return fmt.Errorf("update layout of %s: %w", tbl.TableID, err)
+	}
+	return nil
+}
+
+// err returns a TableLayoutDrift error if the table tbl must be recreated to
+// match the layout.
+func (d layoutDrift) err(tbl *bigquery.Table) error {
+	if !d.recreate {
+		return nil
+	}
+	return messages.NewError(messages.TableLayoutDrift{TableName: tbl.TableID, Differences: d.differences})
+}
+
+// ensure checks the layout of the existing table tbl and updates it if
+// possible, an error is returned if the table must be recreated.
+func (l *TableLayout) ensure(ctx context.Context, tbl *bigquery.Table, md *bigquery.TableMetadata, partitioning *bigquery.TimePartitioning) error {
+	drift := l.drift(md, partitioning)
+	err := drift.err(tbl)
+	if err != nil {
+		return err
+	}
+	return l.update(ctx, tbl, md, drift)
+}
@@ -0,0 +1,167 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+var layoutTestSchema = bigquery.Schema{
+	{Name: exportTimeColumn, Type: bigquery.TimestampFieldType},
+	{Name: "name", Type: bigquery.StringFieldType},
+	{Name: "create_time", Type: bigquery.TimestampFieldType},
+	{Name: "update_time", Type: bigquery.TimestampFieldType},
+	{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "key", Type: bigquery.StringFieldType},
+		{Name: "value", Type: bigquery.StringFieldType},
+	}},
+	{Name: "machine_details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "platform", Type: bigquery.StringFieldType},
+	}},
+}
+
+func TestTableLayoutValidate(t *testing.T) {
+	partitioned := &TablePartitioning{Field: "update_time"}
+	tests := []struct {
+		name        string
+		layout      *TableLayout
+		mode        Mode
+		incremental bool
+		wantErr     string
+	}{
+		{name: "no layout", layout: nil},
+		{name: "partitioning and clustering", layout: &TableLayout{
+			Partitioning:           &TablePartitioning{Field: "create_time", Type: "month"},
+			Clustering:             []string{"name"},
+			RequirePartitionFilter: true,
+		}},
+		{name: "snapshot", mode: ModeSnapshot, layout: &TableLayout{
+			Partitioning: &TablePartitioning{Field: exportTimeColumn},
+			Clustering:   []string{"name"},
+		}},
+		{name: "incremental", mode: ModeIncremental, incremental: true, layout: &TableLayout{Partitioning: partitioned}},
+		{name: "unknown partitioning column", layout: &TableLayout{Partitioning: &TablePartitioning{Field: "delete_time"}},
+			wantErr: "column delete_time doesn't exist"},
+		{name: "partitioning by a string", layout: &TableLayout{Partitioning: &TablePartitioning{Field: "name"}},
+			wantErr: "can't be used for partitioning"},
+		{name: "partitioning type", layout: &TableLayout{Partitioning: &TablePartitioning{Field: "update_time", Type: "WEEK"}},
+			wantErr: `invalid partitioning type "WEEK"`},
+		{name: "snapshot partitioning", mode: ModeSnapshot, layout: &TableLayout{Partitioning: partitioned},
+			wantErr: string(messages.ErrMsgLayoutSnapshotPartition)},
+		{name: "nested clustering column", layout: &TableLayout{Clustering: []string{"machine_details.platform"}},
+			wantErr: "column machine_details.platform can't be used for clustering"},
+		{name: "repeated clustering column", layout: &TableLayout{Clustering: []string{"labels"}},
+			wantErr: "column labels can't be used for clustering"},
+		{name: "too many clustering columns", layout: &TableLayout{Clustering: []string{"name", "name", "name", "name", "name"}},
+			wantErr: string(messages.ErrMsgLayoutTooManyClustering)},
+		{name: "partition filter without partitioning", layout: &TableLayout{RequirePartitionFilter: true},
+			wantErr: string(messages.ErrMsgLayoutFilterNoPartition)},
+		{name: "partition filter in incremental mode", mode: ModeIncremental, incremental: true,
+			layout:  &TableLayout{Partitioning: partitioned, RequirePartitionFilter: true},
+			wantErr: string(messages.ErrMsgLayoutFilterUnsupported)},
+		{name: "partition filter of a replaced table in incremental mode", mode: ModeIncremental,
+			layout: &TableLayout{Partitioning: partitioned, RequirePartitionFilter: true}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mode := tc.mode
+			if mode == "" {
+				mode = ModeFull
+			}
+			err := tc.layout.validate("assets", layoutTestSchema, mode, tc.incremental)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate(): unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("validate() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestTableLayoutsValidate(t *testing.T) {
+	err := TableLayouts{"assets": {}, "groups": {}}.validate()
+	if err != nil {
+		t.Errorf("validate(): unexpected error: %v", err)
+	}
+	err = TableLayouts{"asset": {}}.validate()
+	if err == nil || !strings.Contains(err.Error(), `"asset"`) {
+		t.Errorf("validate() = %v, want an unknown table error", err)
+	}
+}
+
+func TestTableLayoutDrift(t *testing.T) {
+	layout := &TableLayout{
+		Partitioning:           &TablePartitioning{Field: "update_time"},
+		Clustering:             []string{"name"},
+		RequirePartitionFilter: true,
+	}
+	tests := []struct {
+		name         string
+		layout       *TableLayout
+		md           *bigquery.TableMetadata
+		wantDiffs    []string
+		wantRecreate bool
+	}{
+		{name: "same", layout: layout, md: layout.metadata(nil)},
+		{name: "no layout", md: layout.metadata(nil)},
+		{name: "new clustering and filter", layout: layout,
+			md: &bigquery.TableMetadata{TimePartitioning: layout.timePartitioning()},
+			wantDiffs: []string{
+				"clustering is none instead of name",
+				"require_partition_filter is false instead of true",
+			}},
+		{name: "partitioning", layout: layout,
+			md: &bigquery.TableMetadata{
+				TimePartitioning:       &bigquery.TimePartitioning{Type: bigquery.MonthPartitioningType, Field: "create_time"},
+				Clustering:             layout.clustering(),
+				RequirePartitionFilter: true,
+			},
+			wantDiffs:    []string{"partitioning is MONTH(create_time) instead of DAY(update_time)"},
+			wantRecreate: true},
+		{name: "removed clustering", layout: &TableLayout{},
+			md:           &bigquery.TableMetadata{Clustering: &bigquery.Clustering{Fields: []string{"name"}}},
+			wantDiffs:    []string{"clustering is name instead of none"},
+			wantRecreate: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			drift := tc.layout.drift(tc.md, tc.layout.timePartitioning())
+			var got []string
+			for _, diff := range drift.differences {
+				got = append(got, diff.String())
+			}
+			if diff := cmp.Diff(tc.wantDiffs, got); diff != "" {
+				t.Errorf("drift(): unexpected differences (-want, +got):\n%s", diff)
+			}
+			if drift.recreate != tc.wantRecreate {
+				t.Errorf("drift(): recreate = %v, want %v", drift.recreate, tc.wantRecreate)
+			}
+			// A table that must be recreated fails the export instead.
+			if err := drift.err(&bigquery.Table{TableID: "assets"}); (err != nil) != tc.wantRecreate {
+				t.Errorf("err() = %v, want an error: %v", err, tc.wantRecreate)
+			}
+		})
+	}
+}
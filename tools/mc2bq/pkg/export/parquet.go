@@ -0,0 +1,211 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/apache/arrow/go/v12/parquet"
+	"github.com/apache/arrow/go/v12/parquet/compress"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// parquetRowGroupRows is the number of rows in every row group.
+const parquetRowGroupRows = 10000
+
+// parquetWriter writes the rows as a Parquet file with the Arrow Parquet
+// writer. Nested records are written as groups and repeated fields as LIST
+// columns, the same way BigQuery exports tables to Parquet.
+type parquetWriter struct {
+	fw      *pqarrow.FileWriter
+	schema  bigquery.Schema
+	builder *array.RecordBuilder
+}
+
+func newParquetWriter(w io.Writer, schema bigquery.Schema) (*parquetWriter, error) {
+	if len(schema) == 0 {
+		return nil, messages.NewError(messages.ErrorInvalidSchema)
+	}
+
+	arrowSchema := arrow.NewSchema(arrowFields(schema), nil)
+	props := parquet.NewWriterProperties(
+		parquet.WithCompression(compress.Codecs.Snappy),
+		parquet.WithMaxRowGroupLength(parquetRowGroupRows),
+		parquet.WithCreatedBy(messages.UserAgent),
+	)
+	fw, err := pqarrow.NewFileWriter(arrowSchema, w, props, pqarrow.DefaultWriterProps())
+	if err != nil {
+		return nil, err
+	}
+
+	return &parquetWriter{
+		fw:      fw,
+		schema:  schema,
+		builder: array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema),
+	}, nil
+}
+
+// arrowFields returns the Arrow fields of schema, fields that aren't
+// required are nullable.
+func arrowFields(schema bigquery.Schema) []arrow.Field {
+	res := make([]arrow.Field, 0, len(schema))
+	for _, field := range schema {
+		res = append(res, arrow.Field{
+			Name:     field.Name,
+			Type:     arrowType(field),
+			Nullable: !field.Required && !field.Repeated,
+		})
+	}
+	return res
+}
+
+// arrowType returns the Arrow type of field, types that don't have an
+// equivalent are written as strings.
+func arrowType(field *bigquery.FieldSchema) arrow.DataType {
+	var res arrow.DataType
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		res = arrow.PrimitiveTypes.Int64
+	case bigquery.FloatFieldType:
+		res = arrow.PrimitiveTypes.Float64
+	case bigquery.BooleanFieldType:
+		res = arrow.FixedWidthTypes.Boolean
+	case bigquery.TimestampFieldType:
+		res = arrow.FixedWidthTypes.Timestamp_us
+	case bigquery.RecordFieldType:
+		res = arrow.StructOf(arrowFields(field.Schema)...)
+	default:
+		res = arrow.BinaryTypes.String
+	}
+
+	if field.Repeated {
+		return arrow.ListOfNonNullable(res)
+	}
+	return res
+}
+
+func (w *parquetWriter) writeRow(row []byte) error {
+	obj, err := decodeRow(row)
+	if err != nil {
+		return err
+	}
+
+	for i, field := range w.schema {
+		err = appendArrowValue(w.builder.Field(i), field, obj[field.Name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.Name, err)
+		}
+	}
+
+	if w.builder.Field(0).Len() >= parquetRowGroupRows {
+		return w.flush()
+	}
+	return nil
+}
+
+// appendArrowValue appends the decoded JSON value of field to b, repeated
+// fields that are missing are empty lists.
+func appendArrowValue(b array.Builder, field *bigquery.FieldSchema, value any) error {
+	if field.Repeated {
+		lb := b.(*array.ListBuilder)
+		lb.Append(true)
+		items, _ := value.([]any)
+		elem := *field
+		elem.Repeated = false
+		elem.Required = true
+		for _, item := range items {
+			err := appendArrowValue(lb.ValueBuilder(), &elem, item)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if value == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.Int64Builder:
+		n, err := jsonInt(value)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Float64Builder:
+		f, err := jsonFloat(value)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+	case *array.BooleanBuilder:
+		v, _ := value.(bool)
+		b.Append(v)
+	case *array.TimestampBuilder:
+		t, err := jsonTimestamp(value)
+		if err != nil {
+			return err
+		}
+		b.Append(arrow.Timestamp(t.UnixMicro()))
+	case *array.StructBuilder:
+		obj, _ := value.(map[string]any)
+		b.Append(true)
+		for i, child := range field.Schema {
+			err := appendArrowValue(b.FieldBuilder(i), child, obj[child.Name])
+			if err != nil {
+				return fmt.Errorf("%s: %w", child.Name, err)
+			}
+		}
+	case *array.StringBuilder:
+		s, err := csvValue(value)
+		if err != nil {
+			return err
+		}
+		b.Append(s)
+	default:
+		return fmt.Errorf("unsupported column type %s", b.Type())
+	}
+
+	return nil
+}
+
+// flush writes the buffered rows as a row group.
+func (w *parquetWriter) flush() error {
+	rec := w.builder.NewRecord()
+	defer rec.Release()
+	if rec.NumRows() == 0 {
+		return nil
+	}
+	return w.fw.Write(rec)
+}
+
+// close writes the remaining rows and the footer of the file.
+func (w *parquetWriter) close() error {
+	defer w.builder.Release()
+	err := w.flush()
+	if err != nil {
+		return err
+	}
+	return w.fw.Close()
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/lib/pq"
+)
+
+// postgresDialect writes the data to PostgreSQL, the URL is passed to the
+// driver as is.
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string {
+	return "postgres"
+}
+
+func (postgresDialect) dataSourceName(url string) string {
+	return url
+}
+
+// maxIdentifierLength is NAMEDATALEN - 1, longer names are truncated by
+// PostgreSQL which may make them collide.
+func (postgresDialect) maxIdentifierLength() int {
+	return 63
+}
+
+func (postgresDialect) columnType(field *bigquery.FieldSchema) string {
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		return "BIGINT"
+	case bigquery.FloatFieldType:
+		return "DOUBLE PRECISION"
+	case bigquery.BooleanFieldType:
+		return "BOOLEAN"
+	case bigquery.TimestampFieldType:
+		return "TIMESTAMPTZ"
+	}
+	return "TEXT"
+}
+
+func (postgresDialect) idType() string {
+	return "BIGINT"
+}
+
+func (postgresDialect) tableExistsQuery() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+}
+
+func (postgresDialect) dropTableStatement(table string) string {
+	return "DROP TABLE IF EXISTS " + quoteSQLName(table) + " CASCADE"
+}
+
+func (postgresDialect) placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// insertRows writes the rows with a single COPY statement.
+func (postgresDialect) insertRows(ctx context.Context, tx *sql.Tx, rel *sqlRelation, rows [][]any) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(rel.name, rel.columnNames()...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		_, err = stmt.ExecContext(ctx, row...)
+		if err != nil {
+			return err
+		}
+	}
+	// Executing the statement without arguments flushes the rows.
+	_, err = stmt.ExecContext(ctx)
+	return err
+}
@@ -0,0 +1,385 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// ReconcileMode is what happens when the exported assets don't match
+// Migration Center, see Params.Reconcile.
+type ReconcileMode string
+
+const (
+	// ReconcileNone doesn't check the exported assets.
+	ReconcileNone ReconcileMode = "none"
+	// ReconcileWarn prints a warning if a check fails.
+	ReconcileWarn ReconcileMode = "warn"
+	// ReconcileFail fails the export if a check fails, the tables are
+	// left untouched.
+	ReconcileFail ReconcileMode = "fail"
+)
+
+// ParseReconcileMode parses a reconcile mode, the empty string is ReconcileNone.
+func ParseReconcileMode(name string) (ReconcileMode, error) {
+	if name == "" {
+		return ReconcileNone, nil
+	}
+	mode := ReconcileMode(strings.ToLower(name))
+	switch mode {
+	case ReconcileNone, ReconcileWarn, ReconcileFail:
+		return mode, nil
+	}
+
+	return "", messages.NewError(messages.InvalidReconcileMode{Mode: name})
+}
+
+// DefaultReconcileFields are the asset fields that are summed by default, the
+// total cores and memory.
+var DefaultReconcileFields = []string{"machine_details.core_count", "machine_details.memory_mb"}
+
+// ReconcileOptions configure the checks of the exported assets. After the
+// export the number of rows of the assets table and the sums of Fields are
+// compared with the values that Migration Center aggregates over the same
+// assets.
+type ReconcileOptions struct {
+	Mode ReconcileMode
+	// TolerancePercent is the largest difference between a value in
+	// BigQuery and in Migration Center that passes, in percent of the
+	// value in Migration Center.
+	TolerancePercent float64
+	// Fields are the dotted paths of the numeric asset fields that are
+	// summed, DefaultReconcileFields are used if it's empty.
+	Fields []string
+}
+
+const (
+	// validationTableSuffix is the suffix of the table that the results of
+	// the checks are appended to, the table prefix is added to it.
+	validationTableSuffix = "_mc2bq_validation"
+	// rowCountMetric is the metric of the check of the number of rows.
+	rowCountMetric = "row_count"
+)
+
+// validationSchema is the schema of the validation table, every row is a check
+// of a metric of a scope.
+var validationSchema = bigquery.Schema{
+	{Name: exportTimeColumn, Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "table_name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "project_id", Type: bigquery.StringFieldType, Required: true},
+	{Name: "location", Type: bigquery.StringFieldType, Required: true},
+	{Name: "metric", Type: bigquery.StringFieldType, Required: true},
+	{Name: "expected", Type: bigquery.FloatFieldType, Required: true},
+	{Name: "actual", Type: bigquery.FloatFieldType, Required: true},
+	{Name: "difference_percent", Type: bigquery.FloatFieldType, Required: true},
+	{Name: "tolerance_percent", Type: bigquery.FloatFieldType, Required: true},
+	{Name: "passed", Type: bigquery.BooleanFieldType, Required: true},
+}
+
+// reconcileCheck is the comparison of a metric of a scope.
+type reconcileCheck struct {
+	scope    mcutil.ProjectAndLocation
+	metric   string
+	expected float64
+	actual   float64
+	// differencePercent is the difference between actual and expected in
+	// percent of expected.
+	differencePercent float64
+	passed            bool
+}
+
+// newReconcileCheck compares actual to expected, the check passes if the
+// difference is at most tolerancePercent percent of expected. A value that is
+// expected to be zero must be zero.
+func newReconcileCheck(scope mcutil.ProjectAndLocation, metric string, expected float64, actual float64, tolerancePercent float64) reconcileCheck {
+	check := reconcileCheck{scope: scope, metric: metric, expected: expected, actual: actual}
+	switch {
+	case expected == actual:
+	case expected == 0:
+		check.differencePercent = 100
+	default:
+		check.differencePercent = math.Abs(actual-expected) * 100 / math.Abs(expected)
+	}
+	check.passed = check.differencePercent <= tolerancePercent
+	return check
+}
+
+// reconcileFields returns the fields that can be summed in the assets table,
+// the fields that aren't in schema, are repeated or aren't numeric are
+// skipped.
+func reconcileFields(schema bigquery.Schema, fields []string) []string {
+	var res []string
+	for _, field := range fields {
+		if isNumericPath(schema, field) {
+			res = append(res, field)
+			continue
+		}
+		messages.Print(messages.ReconcileFieldSkipped{Field: field})
+	}
+	return res
+}
+
+// isNumericPath returns true if path is the dotted path of a numeric column
+// of schema that isn't repeated or nested in a repeated record.
+func isNumericPath(schema bigquery.Schema, path string) bool {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		field := findColumn(schema, name)
+		if field == nil || field.Repeated {
+			return false
+		}
+		if i == len(names)-1 {
+			switch field.Type {
+			case bigquery.IntegerFieldType, bigquery.FloatFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+				return true
+			}
+			return false
+		}
+		schema = field.Schema
+	}
+	return false
+}
+
+// reconcileQuery returns a query that selects the number of rows of the table
+// that match cond and the sums of fields for every combination of
+// scopeColumns.
+func reconcileQuery(table string, scopeColumns []string, fields []string, cond string) string {
+	var columns []string
+	for _, col := range scopeColumns {
+		columns = append(columns, quoteIdentifier(col))
+	}
+	selected := append(append([]string{}, columns...), "COUNT(*)")
+	for _, field := range fields {
+		var parts []string
+		for _, name := range strings.Split(field, ".") {
+			parts = append(parts, quoteIdentifier(name))
+		}
+		selected = append(selected, fmt.Sprintf("SUM(%s)", strings.Join(parts, ".")))
+	}
+
+	q := fmt.Sprintf("SELECT %s FROM %s WHERE %s", strings.Join(selected, ", "), table, cond)
+	if len(columns) > 0 {
+		q += " GROUP BY " + strings.Join(columns, ", ")
+	}
+	return q
+}
+
+// reconcileCondition returns the condition that matches the rows of the
+// assets table that were exported by the export, the deleted assets in
+// ModeIncremental and the previous snapshots in ModeSnapshot are excluded.
+func reconcileCondition(params *Params) (string, []bigquery.QueryParameter) {
+	switch params.Mode {
+	case ModeIncremental:
+		return quoteIdentifier(deleteTimeColumn) + " IS NULL", nil
+	case ModeSnapshot:
+		// The export time is stored with microsecond precision.
+		return fmt.Sprintf("%s = @%s", quoteIdentifier(exportTimeColumn), exportTimeColumn), []bigquery.QueryParameter{
+			{Name: exportTimeColumn, Value: params.ExportTime.Truncate(time.Microsecond)},
+		}
+	}
+	return "TRUE", nil
+}
+
+// reconciler compares the assets that are exported to the assets table of
+// BigQuery with the aggregations of Migration Center over the same assets for
+// every scope. The checks run in the transaction that promotes the tables
+// (see bigQuerySink.promote), before it's committed, so in ReconcileFail the
+// tables are left untouched if a check fails.
+type reconciler struct {
+	mc     mcutil.MC
+	params *Params
+	// scopes are the scopes whose assets are checked, the number of
+	// assets of a scope was counted when the scopes were listed.
+	scopes []exportScope
+	fields []string
+	// sums are the sums of fields of every scope in Migration Center, see
+	// fetchSums.
+	sums []map[string]float64
+}
+
+// reconcilingSink is a Sink that reconciles the exported assets before Commit
+// makes them available, see Params.Reconcile.
+type reconcilingSink interface {
+	Sink
+	setReconciler(r *reconciler)
+}
+
+func newReconciler(mc mcutil.MC, params *Params, scopes []exportScope) *reconciler {
+	return &reconciler{
+		mc:     mc,
+		params: params,
+		scopes: scopes,
+		fields: reconcileFields(params.Schema.AssetTable, params.Reconcile.Fields),
+	}
+}
+
+// fetchSums fetches the sums of the fields of every scope from Migration
+// Center, it's called before the transaction begins.
+func (r *reconciler) fetchSums(ctx context.Context) error {
+	r.sums = make([]map[string]float64, len(r.scopes))
+	for i, scope := range r.scopes {
+		sums, err := r.mc.AssetSums(ctx, scope.path, r.fields)
+		if err != nil {
+			return fmt.Errorf("reconcile: fetch asset sums: %w", err)
+		}
+		r.sums[i] = sums
+	}
+	return nil
+}
+
+// check compares the rows of the assets table of dataset as seen by tx, which
+// already wrote the data of the export to it, with Migration Center. The
+// results are appended to the validation table, an error is returned if a
+// check fails in ReconcileFail.
+func (r *reconciler) check(ctx context.Context, tx *transaction, dataset *bigquery.Dataset) error {
+	opts := r.params.Reconcile
+	tbl := dataset.Table(r.params.TablePrefix + "assets")
+	messages.Print(messages.ReconcileStarted{TableName: tbl.TableID})
+
+	actual, err := readReconcileTotals(ctx, tx, tbl, r.params, r.fields)
+	if err != nil {
+		return fmt.Errorf("reconcile %s: %w", tbl.TableID, err)
+	}
+
+	var checks []reconcileCheck
+	for i, scope := range r.scopes {
+		// A scope without rows has no totals, all its values are zero.
+		totals := actual[r.params.scopeKey(scope.path)]
+		if totals == nil {
+			totals = make([]float64, len(r.fields)+1)
+		}
+		checks = append(checks, newReconcileCheck(scope.path, rowCountMetric, float64(scope.assetCount), totals[0], opts.TolerancePercent))
+		for j, field := range r.fields {
+			checks = append(checks, newReconcileCheck(scope.path, field, r.sums[i][field], totals[j+1], opts.TolerancePercent))
+		}
+	}
+
+	failed := 0
+	for _, check := range checks {
+		projectID, location := r.params.displayScope(check.scope)
+		messages.Print(messages.ReconcileCheck{
+			TableName:         tbl.TableID,
+			ProjectID:         projectID,
+			Location:          location,
+			Metric:            check.metric,
+			Expected:          check.expected,
+			Actual:            check.actual,
+			DifferencePercent: check.differencePercent,
+			Passed:            check.passed,
+		})
+		if !check.passed {
+			failed++
+		}
+	}
+
+	// The checks are saved by a load job, which can't be part of the
+	// transaction, so they are kept even if the transaction fails.
+	err = saveReconcileChecks(ctx, dataset.Table(r.params.TablePrefix+validationTableSuffix), r.params, tbl.TableID, checks)
+	if err != nil {
+		return err
+	}
+
+	if failed == 0 {
+		return nil
+	}
+	msg := messages.ReconcileFailed{FailedCount: failed, CheckCount: len(checks), TolerancePercent: opts.TolerancePercent}
+	if opts.Mode == ReconcileFail {
+		return messages.NewError(msg)
+	}
+	messages.Print(messages.ReconcileWarning{Failure: msg})
+	return nil
+}
+
+// readReconcileTotals returns the number of rows and the sums of fields of
+// every scope of tbl as seen by tx, keyed by Params.scopeKey.
+func readReconcileTotals(ctx context.Context, tx *transaction, tbl *bigquery.Table, params *Params, fields []string) (map[mcutil.ProjectAndLocation][]float64, error) {
+	cond, queryParams := reconcileCondition(params)
+	scopeColumns := params.scopeColumns()
+	rows, err := tx.read(ctx, reconcileQuery(sqlTableName(tbl), scopeColumns, fields, cond), queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[mcutil.ProjectAndLocation][]float64{}
+	for {
+		var row []bigquery.Value
+		err := rows.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var totals []float64
+		for _, value := range row[len(scopeColumns):] {
+			totals = append(totals, numericValue(value))
+		}
+		res[rowScope(scopeColumns, row)] = totals
+	}
+}
+
+// numericValue converts a numeric value returned by BigQuery to a float, NULL
+// (the sum of no values) is zero.
+func numericValue(value bigquery.Value) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case interface{ Float64() (float64, bool) }:
+		// NUMERIC and BIGNUMERIC values are *big.Rat.
+		f, _ := v.Float64()
+		return f
+	}
+	return 0
+}
+
+// saveReconcileChecks appends the checks of the table tableName to the
+// validation table, which is created if it doesn't exist.
+func saveReconcileChecks(ctx context.Context, tbl *bigquery.Table, params *Params, tableName string, checks []reconcileCheck) error {
+	var rows []any
+	for _, check := range checks {
+		rows = append(rows, map[string]any{
+			exportTimeColumn:     params.ExportTime.UTC().Format(time.RFC3339Nano),
+			"table_name":         tableName,
+			"project_id":         check.scope.Project,
+			"location":           check.scope.Location,
+			"metric":             check.metric,
+			"expected":           check.expected,
+			"actual":             check.actual,
+			"difference_percent": check.differencePercent,
+			"tolerance_percent":  params.Reconcile.TolerancePercent,
+			"passed":             check.passed,
+		})
+	}
+
+	err := appendJSONRows(ctx, tbl, validationSchema, rows)
+	if err != nil {
+		return fmt.Errorf("save validation: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+func TestParseReconcileMode(t *testing.T) {
+	for name, want := range map[string]ReconcileMode{"": ReconcileNone, "none": ReconcileNone, "Warn": ReconcileWarn, "fail": ReconcileFail} {
+		got, err := ParseReconcileMode(name)
+		if err != nil || got != want {
+			t.Errorf("ParseReconcileMode(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseReconcileMode("strict"); err == nil {
+		t.Errorf("ParseReconcileMode(%q): expected an error", "strict")
+	}
+}
+
+func TestReconcileQuery(t *testing.T) {
+	tCases := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{
+			name: "count",
+			got:  reconcileQuery("`p.d.assets`", nil, nil, "TRUE"),
+			want: "SELECT COUNT(*) FROM `p.d.assets` WHERE TRUE",
+		},
+		{
+			name: "sums with scope",
+			got:  reconcileQuery("`p.d.assets`", []string{"project_id", "location"}, DefaultReconcileFields, "`delete_time` IS NULL"),
+			want: "SELECT `project_id`, `location`, COUNT(*), SUM(`machine_details`.`core_count`), SUM(`machine_details`.`memory_mb`) " +
+				"FROM `p.d.assets` WHERE `delete_time` IS NULL GROUP BY `project_id`, `location`",
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			if tCase.got != tCase.want {
+				t.Errorf("unexpected query\nwant: %s\ngot:  %s", tCase.want, tCase.got)
+			}
+		})
+	}
+}
+
+func TestReconcileCondition(t *testing.T) {
+	exportTime := time.Date(2023, 10, 1, 12, 30, 0, 1500, time.UTC)
+	tCases := []struct {
+		mode       Mode
+		wantCond   string
+		wantParams []bigquery.QueryParameter
+	}{
+		{mode: ModeFull, wantCond: "TRUE"},
+		{mode: ModeIncremental, wantCond: "`delete_time` IS NULL"},
+		{mode: ModeSnapshot, wantCond: "`export_time` = @export_time", wantParams: []bigquery.QueryParameter{
+			{Name: "export_time", Value: time.Date(2023, 10, 1, 12, 30, 0, 1000, time.UTC)},
+		}},
+	}
+	for _, tCase := range tCases {
+		t.Run(string(tCase.mode), func(t *testing.T) {
+			cond, params := reconcileCondition(&Params{Mode: tCase.mode, ExportTime: exportTime})
+			if cond != tCase.wantCond {
+				t.Errorf("reconcileCondition() = %q, want %q", cond, tCase.wantCond)
+			}
+			if diff := cmp.Diff(tCase.wantParams, params); diff != "" {
+				t.Errorf("reconcileCondition() unexpected parameters (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewReconcileCheck(t *testing.T) {
+	pal := mcutil.ProjectAndLocation{Project: "p", Location: "us-central1"}
+	tCases := []struct {
+		name      string
+		expected  float64
+		actual    float64
+		tolerance float64
+		wantDiff  float64
+		wantPass  bool
+	}{
+		{name: "equal", expected: 100, actual: 100, wantPass: true},
+		{name: "both zero", wantPass: true},
+		{name: "within tolerance", expected: 200, actual: 199, tolerance: 0.5, wantDiff: 0.5, wantPass: true},
+		{name: "over tolerance", expected: 200, actual: 202, tolerance: 0.5, wantDiff: 1},
+		{name: "expected zero", expected: 0, actual: 1, tolerance: 50, wantDiff: 100},
+	}
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			check := newReconcileCheck(pal, rowCountMetric, tCase.expected, tCase.actual, tCase.tolerance)
+			if check.differencePercent != tCase.wantDiff || check.passed != tCase.wantPass {
+				t.Errorf("newReconcileCheck(%v, %v, %v) = %v%%, passed %v, want %v%%, passed %v",
+					tCase.expected, tCase.actual, tCase.tolerance, check.differencePercent, check.passed, tCase.wantDiff, tCase.wantPass)
+			}
+		})
+	}
+}
+
+func TestReconcileFields(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "machine_details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "core_count", Type: bigquery.IntegerFieldType},
+			{Name: "machine_name", Type: bigquery.StringFieldType},
+			{Name: "disks", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+				{Name: "capacity_bytes", Type: bigquery.IntegerFieldType},
+			}},
+		}},
+	}
+	got := reconcileFields(schema, []string{
+		"machine_details.core_count",
+		"machine_details.memory_mb",
+		"machine_details.machine_name",
+		"machine_details.disks.capacity_bytes",
+		"machine_details",
+	})
+	if diff := cmp.Diff([]string{"machine_details.core_count"}, got); diff != "" {
+		t.Errorf("reconcileFields(): unexpected fields (-want, +got):\n%s", diff)
+	}
+}
+
+func TestNumericValue(t *testing.T) {
+	for _, tCase := range []struct {
+		value bigquery.Value
+		want  float64
+	}{
+		{int64(4), 4},
+		{2.5, 2.5},
+		{big.NewRat(3, 2), 1.5},
+		{nil, 0},
+	} {
+		if got := numericValue(tCase.value); got != tCase.want {
+			t.Errorf("numericValue(%v) = %v, want %v", tCase.value, got, tCase.want)
+		}
+	}
+}
+
+func TestReconcileUnsupported(t *testing.T) {
+	params := &Params{ProjectID: "p", Reconcile: ReconcileOptions{Mode: ReconcileWarn}, OutputDir: t.TempDir()}
+	err := normalizeParams(params)
+	if err == nil || !strings.Contains(err.Error(), string(messages.ErrMsgReconcileUnsupported)) {
+		t.Errorf("normalizeParams() = %v, want %q", err, messages.ErrMsgReconcileUnsupported)
+	}
+
+	params = &Params{ProjectID: "p", Reconcile: ReconcileOptions{Mode: ReconcileWarn, TolerancePercent: -1}}
+	err = normalizeParams(params)
+	if err == nil || !strings.Contains(err.Error(), string(messages.ErrMsgReconcileTolerance)) {
+		t.Errorf("normalizeParams() = %v, want %q", err, messages.ErrMsgReconcileTolerance)
+	}
+}
@@ -0,0 +1,271 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+	exporterschema "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+)
+
+const (
+	// runsTableSuffix is the suffix of the table that a row is appended to
+	// for every export, the table prefix is added to it.
+	runsTableSuffix = "_mc2bq_runs"
+
+	runStatusSucceeded = "succeeded"
+	runStatusFailed    = "failed"
+)
+
+// runsSchema is the schema of the runs table.
+var runsSchema = bigquery.Schema{
+	{Name: "start_time", Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "end_time", Type: bigquery.TimestampFieldType, Required: true},
+	{Name: exportTimeColumn, Type: bigquery.TimestampFieldType, Required: true},
+	{Name: "version", Type: bigquery.StringFieldType, Required: true},
+	{Name: "user_email", Type: bigquery.StringFieldType},
+	{Name: "status", Type: bigquery.StringFieldType, Required: true},
+	{Name: "error", Type: bigquery.StringFieldType},
+	{Name: "parameters", Type: bigquery.StringFieldType, Required: true},
+	{Name: "schema_hash", Type: bigquery.StringFieldType, Required: true},
+	{Name: "tables", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "table_name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "record_count", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "byte_count", Type: bigquery.IntegerFieldType, Required: true},
+	}},
+	{Name: "job_ids", Type: bigquery.StringFieldType, Repeated: true},
+}
+
+// runRecord collects what an export did, it's appended to the runs table
+// once the export completes or fails.
+type runRecord struct {
+	startTime time.Time
+	jobs      jobRecorder
+
+	mu sync.Mutex
+	// tableNames are the names of the exported tables in the order they
+	// were created.
+	tableNames []string
+	// sources are the sources of the objects that were written to every
+	// table.
+	sources map[string][]mcutil.ObjectSource
+}
+
+func newRunRecord() *runRecord {
+	return &runRecord{
+		startTime: time.Now(),
+		sources:   map[string][]mcutil.ObjectSource{},
+	}
+}
+
+// addTable records that the table is exported.
+func (r *runRecord) addTable(table string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tableNames = append(r.tableNames, table)
+}
+
+// addSource records that the objects of src are written to the table.
+func (r *runRecord) addSource(table string, src mcutil.ObjectSource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources[table] = append(r.sources[table], src)
+}
+
+// row returns the row of the runs table, err is the error of the export.
+func (r *runRecord) row(params *Params, endTime time.Time, err error) (map[string]any, error) {
+	parameters, marshalErr := json.Marshal(newRunParameters(params))
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	schemaHash, hashErr := schemaHash(params.Schema)
+	if hashErr != nil {
+		return nil, hashErr
+	}
+
+	row := map[string]any{
+		"start_time":     r.startTime.UTC().Format(time.RFC3339Nano),
+		"end_time":       endTime.UTC().Format(time.RFC3339Nano),
+		exportTimeColumn: params.ExportTime.UTC().Format(time.RFC3339Nano),
+		"version":        messages.Version,
+		"status":         runStatusSucceeded,
+		"parameters":     string(parameters),
+		"schema_hash":    schemaHash,
+	}
+	if err != nil {
+		row["status"] = runStatusFailed
+		row["error"] = err.Error()
+	}
+
+	r.mu.Lock()
+	tables := []map[string]any{}
+	for _, name := range r.tableNames {
+		var records, bytes uint64
+		for _, src := range r.sources[name] {
+			records += src.ObjectsRead()
+			bytes += src.BytesRead()
+		}
+		tables = append(tables, map[string]any{"table_name": name, "record_count": records, "byte_count": bytes})
+	}
+	r.mu.Unlock()
+	row["tables"] = tables
+
+	ids, email := r.jobs.snapshot()
+	row["job_ids"] = ids
+	if email != "" {
+		row["user_email"] = email
+	}
+	return row, nil
+}
+
+// runParameters are the parameters of an export that are recorded in the runs
+// table, the options of the clients and the sink aren't recorded.
+type runParameters struct {
+	ProjectIDs         []string          `json:"project_ids"`
+	Region             string            `json:"region,omitempty"`
+	AllRegions         bool              `json:"all_regions,omitempty"`
+	TargetProjectID    string            `json:"target_project_id"`
+	DatasetID          string            `json:"dataset_id"`
+	TablePrefix        string            `json:"table_prefix,omitempty"`
+	Mode               Mode              `json:"mode"`
+	AssetView          AssetView         `json:"asset_view"`
+	AssetFields        []string          `json:"asset_fields,omitempty"`
+	AssetFilter        string            `json:"asset_filter,omitempty"`
+	Groups             []string          `json:"groups,omitempty"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	Sources            []string          `json:"sources,omitempty"`
+	WriteMethod        WriteMethod       `json:"write_method"`
+	Force              bool              `json:"force,omitempty"`
+	Resume             bool              `json:"resume,omitempty"`
+	AssetShards        int               `json:"asset_shards,omitempty"`
+	SnapshotRetention  string            `json:"snapshot_retention,omitempty"`
+	Reconcile          ReconcileMode     `json:"reconcile,omitempty"`
+	ReconcileTolerance float64           `json:"reconcile_tolerance,omitempty"`
+}
+
+func newRunParameters(params *Params) runParameters {
+	res := runParameters{
+		ProjectIDs:      params.projects(),
+		AllRegions:      params.AllRegions,
+		TargetProjectID: params.TargetProjectID,
+		DatasetID:       params.DatasetID,
+		TablePrefix:     params.TablePrefix,
+		Mode:            params.Mode,
+		AssetView:       params.AssetView,
+		AssetFields:     params.AssetFields,
+		AssetFilter:     params.AssetFilter.Expression,
+		Groups:          params.AssetFilter.Groups,
+		Labels:          params.AssetFilter.Labels,
+		Sources:         params.AssetFilter.Sources,
+		WriteMethod:     params.WriteMethod,
+		Force:           params.Force,
+		Resume:          params.Resume,
+		AssetShards:     params.AssetShards,
+	}
+	if !params.AllRegions {
+		res.Region = params.Region
+	}
+	if params.SnapshotRetention > 0 {
+		res.SnapshotRetention = params.SnapshotRetention.String()
+	}
+	if params.Reconcile.Mode != ReconcileNone {
+		res.Reconcile = params.Reconcile.Mode
+		res.ReconcileTolerance = params.Reconcile.TolerancePercent
+	}
+	return res
+}
+
+// schemaHash returns the SHA-256 of the JSON encoding of schema.
+func schemaHash(schema *exporterschema.ExporterSchema) (string, error) {
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recordRun appends the row of run to the runs table, err is the error of the
+// export. The export isn't failed if the row can't be written.
+func recordRun(ctx context.Context, params *Params, run *runRecord, err error) {
+	row, rowErr := run.row(params, time.Now(), err)
+	if rowErr == nil {
+		rowErr = saveRun(ctx, params, row)
+	}
+	if rowErr != nil {
+		messages.Print(messages.ExportRunNotRecorded{Err: rowErr})
+	}
+}
+
+func saveRun(ctx context.Context, params *Params, row map[string]any) error {
+	bq, err := bigquery.NewClient(ctx, params.TargetProjectID, buildClientOptions(params)...)
+	if err != nil {
+		return fmt.Errorf("create bigquery client: %w", err)
+	}
+	defer bq.Close()
+
+	tbl := bq.Dataset(params.DatasetID).Table(params.TablePrefix + runsTableSuffix)
+	return appendJSONRows(ctx, tbl, runsSchema, []any{row})
+}
+
+// jobRecorder records the BigQuery jobs that are run by an export, see
+// withJobRecorder.
+type jobRecorder struct {
+	mu  sync.Mutex
+	ids []string
+	// email is the email of the user that created the jobs.
+	email string
+}
+
+func (r *jobRecorder) record(job *bigquery.Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids = append(r.ids, job.ID())
+	if r.email == "" {
+		r.email = job.Email()
+	}
+}
+
+// snapshot returns the IDs of the recorded jobs and the email of their user.
+func (r *jobRecorder) snapshot() ([]string, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.ids...), r.email
+}
+
+type jobRecorderKey struct{}
+
+// withJobRecorder returns a context that records the jobs that are waited for
+// with it in r.
+func withJobRecorder(ctx context.Context, r *jobRecorder) context.Context {
+	return context.WithValue(ctx, jobRecorderKey{}, r)
+}
+
+// recordJob records job in the jobRecorder of ctx, if any.
+func recordJob(ctx context.Context, job *bigquery.Job) {
+	if r, ok := ctx.Value(jobRecorderKey{}).(*jobRecorder); ok {
+		r.record(job)
+	}
+}
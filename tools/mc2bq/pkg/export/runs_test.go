@@ -0,0 +1,133 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+	exporterschema "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+)
+
+func TestRunRecordRow(t *testing.T) {
+	params := &Params{
+		ProjectIDs:      []string{"p1", "p2"},
+		AllRegions:      true,
+		TargetProjectID: "target",
+		DatasetID:       "dataset",
+		Mode:            ModeSnapshot,
+		AssetView:       AssetViewFull,
+		WriteMethod:     WriteMethodLoad,
+		AssetFilter:     AssetFilter{Labels: map[string]string{"team": "payments"}},
+		Schema:          &exporterschema.ExporterSchema{AssetTable: bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}},
+		ExportTime:      time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+	run := newRunRecord()
+	run.startTime = time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+	run.addTable("assets")
+	run.addTable("groups")
+	run.addSource("assets", &rowSource{strings.NewReader("0123456789"), nil, 2})
+	run.addSource("assets", &rowSource{strings.NewReader("01234"), nil, 1})
+
+	row, err := run.row(params, time.Date(2023, 10, 1, 12, 5, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatalf("row(): unexpected error: %v", err)
+	}
+	hash, err := schemaHash(params.Schema)
+	if err != nil {
+		t.Fatalf("schemaHash(): unexpected error: %v", err)
+	}
+	want := map[string]any{
+		"start_time":  "2023-10-01T12:00:00Z",
+		"end_time":    "2023-10-01T12:05:00Z",
+		"export_time": "2023-10-01T12:00:00Z",
+		"version":     messages.Version,
+		"status":      runStatusSucceeded,
+		"parameters": `{"project_ids":["p1","p2"],"all_regions":true,"target_project_id":"target","dataset_id":"dataset",` +
+			`"mode":"snapshot","asset_view":"full","labels":{"team":"payments"},"write_method":"load"}`,
+		"schema_hash": hash,
+		"tables": []map[string]any{
+			{"table_name": "assets", "record_count": uint64(3), "byte_count": uint64(15)},
+			{"table_name": "groups", "record_count": uint64(0), "byte_count": uint64(0)},
+		},
+		"job_ids": []string{},
+	}
+	if diff := cmp.Diff(want, row); diff != "" {
+		t.Errorf("row(): unexpected row (-want, +got):\n%s", diff)
+	}
+
+	row, err = run.row(params, time.Now(), errors.New("export failed"))
+	if err != nil {
+		t.Fatalf("row(): unexpected error: %v", err)
+	}
+	if row["status"] != runStatusFailed || row["error"] != "export failed" {
+		t.Errorf("row() of a failed export: status %v, error %v, want %s, export failed", row["status"], row["error"], runStatusFailed)
+	}
+
+	// The row can be loaded with the schema of the runs table.
+	data, err := json.Marshal(row)
+	if err != nil {
+		t.Fatalf("json.Marshal(): unexpected error: %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for name := range decoded {
+		if findColumn(runsSchema, name) == nil {
+			t.Errorf("row(): column %s isn't in the runs schema", name)
+		}
+	}
+}
+
+func TestSchemaHash(t *testing.T) {
+	hash, err := schemaHash(&exporterschema.EmbeddedSchema)
+	if err != nil {
+		t.Fatalf("schemaHash(): unexpected error: %v", err)
+	}
+	again, _ := schemaHash(&exporterschema.EmbeddedSchema)
+	if hash != again || len(hash) != 64 {
+		t.Errorf("schemaHash() = %s, %s, want the same SHA-256", hash, again)
+	}
+
+	projected, err := exporterschema.EmbeddedSchema.ProjectAssetTable([]string{"labels"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := schemaHash(projected); other == hash {
+		t.Errorf("schemaHash() of a projected schema = %s, want a different hash", other)
+	}
+}
+
+func TestJobRecorder(t *testing.T) {
+	// Jobs aren't recorded without a recorder.
+	recordJob(context.Background(), &bigquery.Job{})
+
+	var r jobRecorder
+	ctx := withJobRecorder(context.Background(), &r)
+	recordJob(ctx, &bigquery.Job{})
+	recordJob(ctx, &bigquery.Job{})
+	if ids, _ := r.snapshot(); len(ids) != 2 {
+		t.Errorf("snapshot() = %v, want 2 jobs", ids)
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// shardPageSize is the number of objects of a shard that are buffered
+	// at once if its iterator doesn't list them in pages.
+	shardPageSize = 1000
+	// shardMemoryObjects is the number of objects a shard buffers in
+	// memory, the objects that are listed after them are spilled to a
+	// temporary file until the reader catches up.
+	shardMemoryObjects = 10000
+)
+
+// shardCodec encodes the objects that a shard spills to disk.
+type shardCodec[T any] struct {
+	marshal   func(obj T) ([]byte, error)
+	unmarshal func(data []byte) (T, error)
+}
+
+// protoCodec returns a shardCodec that encodes the objects as protocol
+// buffers, newObject returns an empty object to decode into.
+func protoCodec[T proto.Message](newObject func() T) *shardCodec[T] {
+	return &shardCodec[T]{
+		marshal: func(obj T) ([]byte, error) {
+			return proto.Marshal(obj)
+		},
+		unmarshal: func(data []byte) (T, error) {
+			obj := newObject()
+			return obj, proto.Unmarshal(data, obj)
+		},
+	}
+}
+
+// shardedIterator lists objects with an iterator per shard concurrently and
+// concatenates them into a single iterator. Every shard lists the objects that
+// match its filter, the filters must not overlap and together match all the
+// objects. The objects are returned in the order of the shards, the objects of
+// a shard only after all the objects of the previous shards, so shards of
+// consecutive ranges of the sort order return the objects in the same order
+// as a single iterator.
+//
+// The shards are listed in the background as soon as Next is called, every
+// shard lists all its objects into a buffer of its own while the previous
+// shards are read, so the shards don't wait for the reader. If the iterator
+// isn't read until iterator.Done is returned the context passed to list must
+// be canceled to stop the shards and delete their buffers.
+type shardedIterator[T any] struct {
+	ctx context.Context
+	// shards returns the filters of the shards, it's called once by the
+	// first call to Next.
+	shards func(ctx context.Context) ([]string, error)
+	// list returns an iterator over the objects that match filter.
+	list func(ctx context.Context, filter string) iterable[T]
+	// codec encodes the objects that are spilled to disk once a shard
+	// buffers more than memoryObjects objects, the objects are only
+	// buffered in memory if it's nil.
+	codec         *shardCodec[T]
+	memoryObjects int
+
+	started bool
+	cancel  context.CancelFunc
+	// buffers are the objects of every shard.
+	buffers []*shardBuffer[T]
+	// current is the index of the shard that is read.
+	current int
+	items   []T
+	err     error
+}
+
+func newShardedIterator[T any](ctx context.Context, shards func(ctx context.Context) ([]string, error), list func(ctx context.Context, filter string) iterable[T]) *shardedIterator[T] {
+	return &shardedIterator[T]{
+		ctx:           ctx,
+		shards:        shards,
+		list:          list,
+		memoryObjects: shardMemoryObjects,
+	}
+}
+
+// start starts listing every shard in a separate goroutine.
+func (it *shardedIterator[T]) start() error {
+	filters, err := it.shards(it.ctx)
+	if err != nil {
+		return fmt.Errorf("shard objects: %w", err)
+	}
+
+	var ctx context.Context
+	ctx, it.cancel = context.WithCancel(it.ctx)
+	for _, filter := range filters {
+		buf := newShardBuffer(it.codec, it.memoryObjects)
+		it.buffers = append(it.buffers, buf)
+		go it.listShard(ctx, filter, buf)
+	}
+	go func() {
+		<-ctx.Done()
+		it.closeBuffers()
+	}()
+	return nil
+}
+
+// stop stops the shards and deletes their buffers.
+func (it *shardedIterator[T]) stop() {
+	it.cancel()
+	it.closeBuffers()
+}
+
+func (it *shardedIterator[T]) closeBuffers() {
+	for _, buf := range it.buffers {
+		buf.close()
+	}
+}
+
+// listShard adds the objects that match filter to buf, a page is added at the
+// end of every page of the shard's iterator.
+func (it *shardedIterator[T]) listShard(ctx context.Context, filter string, buf *shardBuffer[T]) {
+	shard := it.list(ctx, filter)
+	p, paged := shard.(pager)
+	var items []T
+	for {
+		obj, err := shard.Next()
+		if errors.Is(err, iterator.Done) {
+			if len(items) > 0 {
+				err = buf.add(items)
+			}
+			buf.finish(err)
+			return
+		}
+		if err != nil {
+			buf.finish(err)
+			return
+		}
+
+		items = append(items, obj)
+		if (paged && p.PageInfo().Remaining() == 0) || len(items) >= shardPageSize {
+			err = buf.add(items)
+			if err != nil {
+				buf.finish(err)
+				return
+			}
+			items = nil
+		}
+	}
+}
+
+// Next returns the next object of the current shard, iterator.Done is returned
+// once all the objects of all the shards have been returned. The shards are
+// stopped if any of them fails.
+func (it *shardedIterator[T]) Next() (T, error) {
+	var zero T
+	if it.err != nil {
+		return zero, it.err
+	}
+	if !it.started {
+		it.started = true
+		err := it.start()
+		if err != nil {
+			it.err = err
+			return zero, err
+		}
+	}
+
+	for len(it.items) == 0 {
+		if it.current == len(it.buffers) {
+			it.stop()
+			it.err = iterator.Done
+			return zero, it.err
+		}
+		items, err := it.buffers[it.current].next()
+		if errors.Is(err, iterator.Done) {
+			it.current++
+			continue
+		}
+		if errors.Is(err, errShardBufferClosed) && it.ctx.Err() != nil {
+			err = it.ctx.Err()
+		}
+		if err != nil {
+			it.stop()
+			it.err = err
+			return zero, it.err
+		}
+		it.items = items
+	}
+
+	obj := it.items[0]
+	it.items = it.items[1:]
+	return obj, nil
+}
+
+var errShardBufferClosed = errors.New("shard buffer closed")
+
+// shardBuffer holds the objects listed by a shard until they are read. The
+// first objects are held in memory, once there are more than memoryObjects the
+// objects are appended to a temporary file until all the objects of the file
+// were read. The objects in memory are always listed before the objects in
+// the file.
+type shardBuffer[T any] struct {
+	codec         *shardCodec[T]
+	memoryObjects int
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// items are the objects in memory.
+	items []T
+	// file holds the objects that were spilled, every object is prefixed
+	// by its length. The objects from readOffset to writeOffset weren't
+	// read yet.
+	file        *os.File
+	readOffset  int64
+	writeOffset int64
+	// done is set once the shard was listed, err is the error that stopped
+	// it.
+	done   bool
+	err    error
+	closed bool
+}
+
+func newShardBuffer[T any](codec *shardCodec[T], memoryObjects int) *shardBuffer[T] {
+	b := &shardBuffer[T]{codec: codec, memoryObjects: memoryObjects}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// add buffers items, it fails if the buffer was closed.
+func (b *shardBuffer[T]) add(items []T) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return errShardBufferClosed
+	}
+	defer b.cond.Broadcast()
+
+	spilled := b.writeOffset > b.readOffset
+	if b.codec == nil || (!spilled && len(b.items)+len(items) <= b.memoryObjects) {
+		b.items = append(b.items, items...)
+		return nil
+	}
+	return b.spill(items)
+}
+
+// spill appends items to the file, b.mu must be held.
+func (b *shardBuffer[T]) spill(items []T) error {
+	if b.file == nil {
+		var err error
+		b.file, err = os.CreateTemp("", "mc2bq-shard-*")
+		if err != nil {
+			return fmt.Errorf("buffer shard: %w", err)
+		}
+	}
+
+	var data []byte
+	for _, obj := range items {
+		encoded, err := b.codec.marshal(obj)
+		if err != nil {
+			return fmt.Errorf("buffer shard: %w", err)
+		}
+		data = binary.AppendUvarint(data, uint64(len(encoded)))
+		data = append(data, encoded...)
+	}
+	n, err := b.file.WriteAt(data, b.writeOffset)
+	b.writeOffset += int64(n)
+	if err != nil {
+		return fmt.Errorf("buffer shard: %w", err)
+	}
+	return nil
+}
+
+// finish records that the shard was listed, err is the error that stopped it.
+func (b *shardBuffer[T]) finish(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+// next waits for objects to be buffered and returns them, iterator.Done is
+// returned once all the objects were returned.
+func (b *shardBuffer[T]) next() ([]T, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.items) == 0 && b.writeOffset == b.readOffset && !b.done && !b.closed {
+		b.cond.Wait()
+	}
+
+	switch {
+	case b.closed:
+		return nil, errShardBufferClosed
+	case len(b.items) > 0:
+		items := b.items
+		b.items = nil
+		return items, nil
+	case b.writeOffset > b.readOffset:
+		return b.unspill()
+	case b.err != nil:
+		return nil, b.err
+	default:
+		return nil, iterator.Done
+	}
+}
+
+// unspill reads up to shardPageSize objects from the file, b.mu must be held.
+// The file is truncated once all its objects were read.
+func (b *shardBuffer[T]) unspill() ([]T, error) {
+	r := bufio.NewReader(io.NewSectionReader(b.file, b.readOffset, b.writeOffset-b.readOffset))
+	var items []T
+	for len(items) < shardPageSize && b.readOffset < b.writeOffset {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("read shard buffer: %w", err)
+		}
+		data := make([]byte, size)
+		_, err = io.ReadFull(r, data)
+		if err != nil {
+			return nil, fmt.Errorf("read shard buffer: %w", err)
+		}
+		obj, err := b.codec.unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("read shard buffer: %w", err)
+		}
+		items = append(items, obj)
+		b.readOffset += int64(len(binary.AppendUvarint(nil, size)) + len(data))
+	}
+
+	if b.readOffset == b.writeOffset {
+		err := b.file.Truncate(0)
+		if err != nil {
+			return nil, fmt.Errorf("read shard buffer: %w", err)
+		}
+		b.readOffset, b.writeOffset = 0, 0
+	}
+	return items, nil
+}
+
+// close deletes the file of the buffer, the objects that are added after it
+// are dropped.
+func (b *shardBuffer[T]) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.items = nil
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+	}
+	b.cond.Broadcast()
+}
+
+// createTimeShards returns n filters that split the objects created between
+// first and last into ranges of equal duration. The first and last range are
+// open ended so objects outside of [first, last] (e.g. created while listing)
+// are matched too, every object matches exactly one filter. filter is added
+// to all the filters if it's set.
+func createTimeShards(first, last time.Time, n int, filter string) []string {
+	if n <= 1 || !last.After(first) {
+		return []string{filter}
+	}
+	step := last.Sub(first) / time.Duration(n)
+	if step <= 0 {
+		return []string{filter}
+	}
+
+	bound := func(i int) string {
+		return fmt.Sprintf("%q", first.Add(time.Duration(i)*step).UTC().Format(time.RFC3339Nano))
+	}
+	var res []string
+	for i := 0; i < n; i++ {
+		var shard string
+		switch i {
+		case 0:
+			shard = "create_time < " + bound(1)
+		case n - 1:
+			shard = "create_time >= " + bound(i)
+		default:
+			shard = fmt.Sprintf("create_time >= %s AND create_time < %s", bound(i), bound(i+1))
+		}
+		if filter != "" {
+			shard = fmt.Sprintf("(%s) AND %s", filter, shard)
+		}
+		res = append(res, shard)
+	}
+	return res
+}
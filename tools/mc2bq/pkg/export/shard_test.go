@@ -0,0 +1,294 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var createTimeFilterRE = regexp.MustCompile(`create_time (>=|<) "([^"]+)"`)
+
+// matchesCreateTimeFilter evaluates the create_time conditions of a filter
+// returned by createTimeShards, it's called by the goroutines of the shards.
+func matchesCreateTimeFilter(t *testing.T, asset *migrationcenterpb.Asset, filter string) bool {
+	t.Helper()
+	created := asset.CreateTime.AsTime()
+	for _, m := range createTimeFilterRE.FindAllStringSubmatch(filter, -1) {
+		bound, err := time.Parse(time.RFC3339Nano, m[2])
+		if err != nil {
+			t.Errorf("invalid filter %s: %v", filter, err)
+			return false
+		}
+		if (m[1] == ">=" && created.Before(bound)) || (m[1] == "<" && !created.Before(bound)) {
+			return false
+		}
+	}
+	return true
+}
+
+func testAssets(count int) []*migrationcenterpb.Asset {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var assets []*migrationcenterpb.Asset
+	for i := 0; i < count; i++ {
+		assets = append(assets, &migrationcenterpb.Asset{
+			Name: fmt.Sprintf("asset-%04d", i),
+			// The create times aren't evenly distributed.
+			CreateTime: timestamppb.New(start.Add(time.Duration(i*i) * time.Second)),
+		})
+	}
+	return assets
+}
+
+func readAssetNames(it iterable[*migrationcenterpb.Asset]) ([]string, error) {
+	var names []string
+	for {
+		asset, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		names = append(names, asset.Name)
+	}
+}
+
+func TestShardedIterator(t *testing.T) {
+	assets := testAssets(2500)
+	first, last := assets[0].CreateTime.AsTime(), assets[len(assets)-1].CreateTime.AsTime()
+	want, _ := readAssetNames(&sliceIterator[*migrationcenterpb.Asset]{items: assets})
+
+	for _, shards := range []int{1, 2, 7} {
+		t.Run(fmt.Sprint(shards), func(t *testing.T) {
+			it := newShardedIterator(context.Background(), func(ctx context.Context) ([]string, error) {
+				return createTimeShards(first, last, shards, ""), nil
+			}, func(ctx context.Context, filter string) iterable[*migrationcenterpb.Asset] {
+				res := &sliceIterator[*migrationcenterpb.Asset]{}
+				for _, asset := range assets {
+					if matchesCreateTimeFilter(t, asset, filter) {
+						res.items = append(res.items, asset)
+					}
+				}
+				return res
+			})
+			got, err := readAssetNames(it)
+			if err != nil {
+				t.Fatalf("Next(): unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("the shards listed different assets than a single iterator (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// signalIterator closes done when its items were listed.
+type signalIterator[T any] struct {
+	sliceIterator[T]
+	done chan struct{}
+}
+
+func (it *signalIterator[T]) Next() (T, error) {
+	obj, err := it.sliceIterator.Next()
+	if errors.Is(err, iterator.Done) {
+		close(it.done)
+	}
+	return obj, err
+}
+
+// waitIterator waits for wait to be closed before listing its items.
+type waitIterator[T any] struct {
+	sliceIterator[T]
+	wait <-chan struct{}
+}
+
+func (it *waitIterator[T]) Next() (T, error) {
+	<-it.wait
+	return it.sliceIterator.Next()
+}
+
+// TestShardedIteratorOrder checks that the assets are returned in the order of
+// a serial run even if the first shard is listed after the second.
+func TestShardedIteratorOrder(t *testing.T) {
+	assets := testAssets(20)
+	first, last := assets[0].CreateTime.AsTime(), assets[len(assets)-1].CreateTime.AsTime()
+	want, _ := readAssetNames(&sliceIterator[*migrationcenterpb.Asset]{items: assets})
+	filters := createTimeShards(first, last, 2, "")
+
+	secondListed := make(chan struct{})
+	it := newShardedIterator(context.Background(), func(ctx context.Context) ([]string, error) {
+		return filters, nil
+	}, func(ctx context.Context, filter string) iterable[*migrationcenterpb.Asset] {
+		var items []*migrationcenterpb.Asset
+		for _, asset := range assets {
+			if matchesCreateTimeFilter(t, asset, filter) {
+				items = append(items, asset)
+			}
+		}
+		if filter == filters[0] {
+			return &waitIterator[*migrationcenterpb.Asset]{sliceIterator: sliceIterator[*migrationcenterpb.Asset]{items: items}, wait: secondListed}
+		}
+		return &signalIterator[*migrationcenterpb.Asset]{sliceIterator: sliceIterator[*migrationcenterpb.Asset]{items: items}, done: secondListed}
+	})
+	got, err := readAssetNames(it)
+	if err != nil {
+		t.Fatalf("Next(): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("the shards returned the assets in another order than a single iterator (-want, +got):\n%s", diff)
+	}
+}
+
+// TestShardedIteratorBuffer checks that all the shards are listed before the
+// first shard is drained, the objects that don't fit in memory are spilled to a
+// temporary file that is deleted once the iterator is done.
+func TestShardedIteratorBuffer(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	assets := testAssets(3 * 3500)
+	want, _ := readAssetNames(&sliceIterator[*migrationcenterpb.Asset]{items: assets})
+	filters := []string{"a", "b", "c"}
+
+	var listed []chan struct{}
+	for range filters {
+		listed = append(listed, make(chan struct{}))
+	}
+	it := newShardedIterator(context.Background(), func(ctx context.Context) ([]string, error) {
+		return filters, nil
+	}, func(ctx context.Context, filter string) iterable[*migrationcenterpb.Asset] {
+		for i, f := range filters {
+			if f == filter {
+				items := assets[i*3500 : (i+1)*3500]
+				return &signalIterator[*migrationcenterpb.Asset]{sliceIterator: sliceIterator[*migrationcenterpb.Asset]{items: items}, done: listed[i]}
+			}
+		}
+		t.Errorf("unexpected filter %q", filter)
+		return &sliceIterator[*migrationcenterpb.Asset]{}
+	})
+	it.codec = protoCodec(func() *migrationcenterpb.Asset { return &migrationcenterpb.Asset{} })
+	it.memoryObjects = 100
+
+	first, err := it.Next()
+	if err != nil {
+		t.Fatalf("Next(): unexpected error: %v", err)
+	}
+	for i, done := range listed {
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("shard %d wasn't listed before the first shard was drained", i)
+		}
+	}
+
+	rest, err := readAssetNames(it)
+	if err != nil {
+		t.Fatalf("Next(): unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, append([]string{first.Name}, rest...)); diff != "" {
+		t.Errorf("the shards returned the assets in another order than a single iterator (-want, +got):\n%s", diff)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("the temporary files of the shards weren't deleted: %v", files)
+	}
+}
+
+// errorIterator returns err after the items of sliceIterator.
+type errorIterator[T any] struct {
+	sliceIterator[T]
+	err error
+}
+
+func (it *errorIterator[T]) Next() (T, error) {
+	obj, err := it.sliceIterator.Next()
+	if errors.Is(err, iterator.Done) {
+		return obj, it.err
+	}
+	return obj, err
+}
+
+func TestShardedIteratorError(t *testing.T) {
+	listErr := errors.New("list failed")
+	assets := testAssets(10)
+	it := newShardedIterator(context.Background(), func(ctx context.Context) ([]string, error) {
+		return []string{"a", "b"}, nil
+	}, func(ctx context.Context, filter string) iterable[*migrationcenterpb.Asset] {
+		if filter == "b" {
+			return &errorIterator[*migrationcenterpb.Asset]{err: listErr}
+		}
+		return &sliceIterator[*migrationcenterpb.Asset]{items: assets}
+	})
+	_, err := readAssetNames(it)
+	if !errors.Is(err, listErr) {
+		t.Errorf("Next() = %v, want %v", err, listErr)
+	}
+	_, err = it.Next()
+	if !errors.Is(err, listErr) {
+		t.Errorf("Next() after the error = %v, want %v", err, listErr)
+	}
+
+	shardErr := errors.New("shard failed")
+	it = newShardedIterator(context.Background(), func(ctx context.Context) ([]string, error) {
+		return nil, shardErr
+	}, func(ctx context.Context, filter string) iterable[*migrationcenterpb.Asset] {
+		t.Error("a shard was listed")
+		return nil
+	})
+	_, err = it.Next()
+	if !errors.Is(err, shardErr) {
+		t.Errorf("Next() = %v, want %v", err, shardErr)
+	}
+}
+
+func TestCreateTimeShards(t *testing.T) {
+	first := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	last := first.Add(3 * time.Hour)
+
+	got := createTimeShards(first, last, 3, `update_time >= "2023-01-01T00:00:00Z"`)
+	want := []string{
+		`(update_time >= "2023-01-01T00:00:00Z") AND create_time < "2023-01-01T01:00:00Z"`,
+		`(update_time >= "2023-01-01T00:00:00Z") AND create_time >= "2023-01-01T01:00:00Z" AND create_time < "2023-01-01T02:00:00Z"`,
+		`(update_time >= "2023-01-01T00:00:00Z") AND create_time >= "2023-01-01T02:00:00Z"`,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("createTimeShards(): unexpected filters (-want, +got):\n%s", diff)
+	}
+
+	// A single shard lists all the objects.
+	for _, got := range [][]string{
+		createTimeShards(first, last, 1, ""),
+		createTimeShards(first, first, 4, ""),
+		createTimeShards(time.Time{}, time.Time{}, 4, ""),
+	} {
+		if diff := cmp.Diff([]string{""}, got); diff != "" {
+			t.Errorf("createTimeShards(): unexpected filters (-want, +got):\n%s", diff)
+		}
+	}
+}
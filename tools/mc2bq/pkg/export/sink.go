@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// Sink is a destination that the exported tables are written to, see
+// Params.Sink.
+//
+// Export calls Create, then CreateTable for every table and then writes the
+// streams of all the tables, multiple streams are written concurrently. If
+// all the streams were written Commit is called, otherwise Abort is called.
+// When exporting multiple projects the streams of a project may fail without
+// failing the export, the data of the failed projects isn't committed.
+type Sink interface {
+	// Create prepares the destination before anything is exported. It may
+	// update params, e.g. to restore the export time of a resumed export.
+	Create(ctx context.Context, params *Params) error
+	// CreateTable prepares the destination of table, it's called for all
+	// the tables before any stream is written.
+	CreateTable(ctx context.Context, table *Table) error
+	// Stream returns the stream of the objects of table in scope. A nil
+	// stream isn't written, e.g. because it was written by the export
+	// that is resumed.
+	Stream(ctx context.Context, table *Table, scope mcutil.ProjectAndLocation) (*Stream, error)
+	// Commit makes the data of all the tables available, it's called once
+	// all the streams were written. The data of the failed projects, whose
+	// streams may have been written partially, is discarded and the data
+	// the previous export wrote for them is kept.
+	Commit(ctx context.Context, failed []string) error
+	// Abort is called instead of Commit if the export fails after Create
+	// succeeded, the data that was written should be discarded.
+	Abort(ctx context.Context)
+}
+
+// Table is a table that is written to a Sink.
+type Table struct {
+	// Name is the name of the table including Params.TablePrefix.
+	Name string
+	// Schema is the schema of the rows of the table, including the columns
+	// that are added to every row (e.g. project_id).
+	Schema bigquery.Schema
+	// Incremental is set if the table can be exported incrementally, see
+	// ModeIncremental.
+	Incremental bool
+	// Layout is the partitioning and clustering of the table, it's only
+	// used by BigQuery. The table has no layout if it's nil.
+	Layout *TableLayout
+
+	def exportTable
+	mc  mcutil.MC
+}
+
+// NewSource returns the source of all the objects of the table in scope.
+func (t *Table) NewSource(ctx context.Context, scope mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	return t.def.newSource(ctx, scope)
+}
+
+// Stream is a stream of objects that is written to a table.
+type Stream struct {
+	// Source is the source of the objects that are written, it's used to
+	// report the progress of the stream.
+	Source mcutil.ObjectSource
+	// Write reads all the objects from Source and writes them.
+	Write func(ctx context.Context) error
+	// Partial is set if only some of the objects are written (e.g. the
+	// objects that were updated since the previous export), in which case
+	// the number of objects isn't known in advance.
+	Partial bool
+}
+
+// defaultSink returns the sink that is used if Params.Sink isn't set.
+func defaultSink(params *Params) (Sink, error) {
+	if params.OutputDir != "" && params.OutputDB != "" {
+		return nil, messages.NewError(messages.ErrMsgOutputConflict)
+	}
+	if params.OutputDir != "" {
+		return NewFileSink(), nil
+	}
+	if params.OutputDB != "" {
+		return newOutputDBSink(params.OutputDB)
+	}
+
+	return NewBigQuerySink(), nil
+}
+
+// isFailed returns true if project is one of the failed projects passed to
+// Sink.Commit.
+func isFailed(failed []string, project string) bool {
+	for _, p := range failed {
+		if p == project {
+			return true
+		}
+	}
+	return false
+}
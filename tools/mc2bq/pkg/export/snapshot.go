@@ -0,0 +1,178 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+const (
+	// exportTimeColumn is the time of the export that created a snapshot,
+	// all the rows that were exported in the same export have the same
+	// export time.
+	exportTimeColumn = "export_time"
+	// latestViewSuffix is the suffix of the views that select the latest
+	// snapshot of a table.
+	latestViewSuffix = "_latest"
+)
+
+var errNotSnapshotTable = messages.NewError(messages.ErrMsgExportTableNotSnapshot)
+
+// snapshotTable is a table that snapshots are appended to, the table is
+// partitioned by day on the export_time column and a view selects the latest
+// snapshot in the table.
+type snapshotTable struct {
+	*stagedTable
+	bq *bigquery.Client
+	// retention is the partition expiration of the table, see
+	// Params.SnapshotRetention.
+	retention time.Duration
+}
+
+// prepareSnapshotTable checks that an existing tbl contains snapshots and
+// creates the staging table that the snapshot is loaded to. The table is
+// created by apply if it doesn't exist, partitioned by day on the export_time
+// column and clustered according to layout. Partitions older than
+// params.SnapshotRetention are deleted by BigQuery.
+func prepareSnapshotTable(ctx context.Context, bq *bigquery.Client, tbl *bigquery.Table, params *Params, schema bigquery.Schema, layout *TableLayout, resume bool) (*snapshotTable, error) {
+	partitioning := &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: exportTimeColumn}
+
+	md, err := tbl.Metadata(ctx)
+	if err != nil && !gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		// A table that was created by another mode can't be
+		// partitioned by export_time.
+		if !isSnapshotTable(md) {
+			return nil, errNotSnapshotTable
+		}
+		err = layout.drift(md, partitioning).err(tbl)
+		if err == nil {
+			err = checkSchema(tbl, md, schema)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	messages.Print(messages.ExportingDataToTable{TableName: tbl.TableID})
+	// The staging table is partitioned the same way as the table.
+	staging, err := createStagingTable(ctx, bq, tbl, "staging", &bigquery.TableMetadata{
+		Schema:           schema,
+		TimePartitioning: partitioning,
+		Clustering:       layout.clustering(),
+	}, resume)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshotTable{
+		stagedTable: &stagedTable{
+			tbl:     tbl,
+			staging: staging,
+			md: &bigquery.TableMetadata{
+				Schema: schema,
+				TimePartitioning: &bigquery.TimePartitioning{
+					Type:       partitioning.Type,
+					Field:      partitioning.Field,
+					Expiration: params.SnapshotRetention,
+				},
+				Clustering: layout.clustering(),
+			},
+			partitioning: partitioning,
+			appendRows:   true,
+			layout:       layout,
+		},
+		bq:        bq,
+		retention: params.SnapshotRetention,
+	}, nil
+}
+
+// finish updates the partition expiration of the table, creates the view of
+// the latest snapshot and updates the layout of the table.
+func (st *snapshotTable) finish(ctx context.Context) error {
+	md, err := st.tbl.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("promote %s: %w", st.tbl.TableID, err)
+	}
+	if partitioning := expirationUpdate(md, st.retention); partitioning != nil {
+		_, err = st.tbl.Update(ctx, bigquery.TableMetadataToUpdate{TimePartitioning: partitioning}, md.ETag)
+		if err != nil {
+			return fmt.Errorf("update partition expiration of %s: %w", st.tbl.TableID, err)
+		}
+	}
+
+	view := st.bq.DatasetInProject(st.tbl.ProjectID, st.tbl.DatasetID).Table(st.tbl.TableID + latestViewSuffix)
+	var scopeColumns []string
+	for _, col := range []string{"project_id", "location"} {
+		if hasColumn(st.schema, col) {
+			scopeColumns = append(scopeColumns, col)
+		}
+	}
+	_, err = runQuery(ctx, st.bq.Query(latestViewQuery(sqlTableName(view), sqlTableName(st.tbl), scopeColumns)))
+	if err != nil {
+		return fmt.Errorf("create view %s: %w", view.TableID, err)
+	}
+
+	return st.stagedTable.finish(ctx)
+}
+
+// expirationUpdate returns the time partitioning of the table with md whose
+// partitions expire after retention, a zero retention removes the expiration
+// so the snapshots are kept forever. It returns nil if the partitions already
+// expire after retention.
+func expirationUpdate(md *bigquery.TableMetadata, retention time.Duration) *bigquery.TimePartitioning {
+	if md.TimePartitioning == nil || md.TimePartitioning.Expiration == retention {
+		return nil
+	}
+	res := *md.TimePartitioning
+	res.Expiration = retention
+	return &res
+}
+
+// isSnapshotTable returns true if md is a table created in ModeSnapshot.
+func isSnapshotTable(md *bigquery.TableMetadata) bool {
+	return md.TimePartitioning != nil && md.TimePartitioning.Field == exportTimeColumn
+}
+
+// latestViewQuery returns a statement that creates a view of the rows of the
+// latest snapshot in table. The latest snapshot of every combination of
+// scopeColumns is selected if they are set, so a project or region that
+// failed or wasn't exported by the latest export keeps its previous snapshot.
+func latestViewQuery(view string, table string, scopeColumns []string) string {
+	exportTime := quoteIdentifier(exportTimeColumn)
+	if len(scopeColumns) == 0 {
+		return fmt.Sprintf("CREATE OR REPLACE VIEW %s AS SELECT * FROM %s WHERE %s = (SELECT MAX(%s) FROM %s)",
+			view, table, exportTime, exportTime, table)
+	}
+
+	var partition []string
+	for _, col := range scopeColumns {
+		partition = append(partition, quoteIdentifier(col))
+	}
+	// QUALIFY must be used with a WHERE, GROUP BY or HAVING clause.
+	return fmt.Sprintf("CREATE OR REPLACE VIEW %s AS SELECT * FROM %s WHERE TRUE QUALIFY %s = MAX(%s) OVER (PARTITION BY %s)",
+		view, table, exportTime, exportTime, strings.Join(partition, ", "))
+}
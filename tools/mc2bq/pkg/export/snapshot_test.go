@@ -0,0 +1,115 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+)
+
+func TestLatestViewQuery(t *testing.T) {
+	tCases := []struct {
+		name         string
+		scopeColumns []string
+		want         string
+	}{
+		{
+			name: "single scope",
+			want: "CREATE OR REPLACE VIEW `p.d.assets_latest` AS SELECT * FROM `p.d.assets` WHERE `export_time` = (SELECT MAX(`export_time`) FROM `p.d.assets`)",
+		},
+		{
+			name:         "scope columns",
+			scopeColumns: []string{"project_id", "location"},
+			want: "CREATE OR REPLACE VIEW `p.d.assets_latest` AS SELECT * FROM `p.d.assets` WHERE TRUE " +
+				"QUALIFY `export_time` = MAX(`export_time`) OVER (PARTITION BY `project_id`, `location`)",
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			got := latestViewQuery("`p.d.assets_latest`", "`p.d.assets`", tCase.scopeColumns)
+			if got != tCase.want {
+				t.Errorf("latestViewQuery() unexpected query\nwant: %s\ngot:  %s", tCase.want, got)
+			}
+		})
+	}
+}
+
+func TestExpirationUpdate(t *testing.T) {
+	day := 24 * time.Hour
+	tCases := []struct {
+		name       string
+		expiration time.Duration
+		retention  time.Duration
+		want       *bigquery.TimePartitioning
+	}{
+		{name: "same retention", expiration: 7 * day, retention: 7 * day},
+		{name: "no retention", expiration: 0, retention: 0},
+		{
+			name:       "changed retention",
+			expiration: 7 * day,
+			retention:  30 * day,
+			want:       &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: exportTimeColumn, Expiration: 30 * day},
+		},
+		{
+			name:       "retention removed",
+			expiration: 7 * day,
+			retention:  0,
+			want:       &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: exportTimeColumn},
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			md := &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{
+				Type:       bigquery.DayPartitioningType,
+				Field:      exportTimeColumn,
+				Expiration: tCase.expiration,
+			}}
+			got := expirationUpdate(md, tCase.retention)
+			if diff := cmp.Diff(tCase.want, got); diff != "" {
+				t.Errorf("expirationUpdate() unexpected partitioning (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestSnapshotExportTimeColumn checks that the export_time column is added as
+// a TIMESTAMP in UTC.
+func TestSnapshotExportTimeColumn(t *testing.T) {
+	mc := &MCv1{exportTime: time.Date(2023, 10, 1, 14, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))}
+	it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{{}}}
+	r := newObjectReader[*migrationcenterpb.Group](it, "group", bigquery.Schema{}).withColumns(mc.columns(mcutil.ProjectAndLocation{}))
+
+	if len(r.Schema()) != 1 || r.Schema()[0].Name != exportTimeColumn || r.Schema()[0].Type != bigquery.TimestampFieldType {
+		t.Errorf("unexpected schema: %+v", r.Schema()[0])
+	}
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read objects: %v", err)
+	}
+	want := "{\"export_time\":\"2023-10-01T12:00:00Z\"}\n"
+	if string(got) != want {
+		t.Errorf("unexpected objects\nwant: %s\ngot:  %s", want, got)
+	}
+}
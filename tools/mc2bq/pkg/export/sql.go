@@ -0,0 +1,644 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+const (
+	// sqlNameSeparator separates the names of nested fields in the names of
+	// child tables and of the columns of flattened records.
+	sqlNameSeparator = "__"
+	// sqlIDColumn is the primary key of every table.
+	sqlIDColumn = "_id"
+	// sqlParentIDColumn references the row of the parent table in child
+	// tables.
+	sqlParentIDColumn = "_parent_id"
+	// sqlIndexColumn is the position of the row in the repeated field of
+	// the parent row in child tables.
+	sqlIndexColumn = "_index"
+	// sqlBatchSize is the number of rows of a table that are written at once.
+	sqlBatchSize = 1000
+)
+
+// sqlDialect is the database specific part of sqlSink.
+type sqlDialect interface {
+	// driverName is the database/sql driver that is used.
+	driverName() string
+	// dataSourceName converts the database URL to the data source name
+	// passed to the driver.
+	dataSourceName(url string) string
+	// maxIdentifierLength is the maximal length of table and column
+	// names, zero if there is no limit.
+	maxIdentifierLength() int
+	// columnType returns the type of the column of field, which isn't a
+	// record.
+	columnType(field *bigquery.FieldSchema) string
+	// idType is the type of the id columns.
+	idType() string
+	// tableExistsQuery returns a query that counts the tables with the name
+	// that is passed as the only argument.
+	tableExistsQuery() string
+	// dropTableStatement returns a statement that drops table if it exists.
+	dropTableStatement(table string) string
+	// placeholder returns the placeholder of the n-th argument of a
+	// statement, starting at 1.
+	placeholder(n int) string
+	// insertRows writes rows to the columns of rel.
+	insertRows(ctx context.Context, tx *sql.Tx, rel *sqlRelation, rows [][]any) error
+}
+
+// sqlRelation is a table in a relational database that holds the fields of
+// a BigQuery table or of a repeated field of it.
+//
+// Non-repeated records are flattened to a column per field, repeated fields
+// (including maps such as labels) are written to a child table with a
+// foreign key to the row of the parent table.
+type sqlRelation struct {
+	name   string
+	parent *sqlRelation
+	// path is the path of the repeated field in the rows of the parent.
+	path     []string
+	columns  []sqlColumn
+	children []*sqlRelation
+
+	mu     sync.Mutex
+	nextID int64
+}
+
+// sqlColumn is a column of a sqlRelation, path is the path of the field in
+// the row of the relation. The path of the only column of a repeated field
+// that isn't a record is empty.
+type sqlColumn struct {
+	name  string
+	path  []string
+	field *bigquery.FieldSchema
+}
+
+// newSQLRelation returns the relation of a table with schema.
+func newSQLRelation(d sqlDialect, name string, schema bigquery.Schema) *sqlRelation {
+	rel := &sqlRelation{name: sqlName(d, name)}
+	rel.addFields(d, schema, nil)
+	return rel
+}
+
+func (rel *sqlRelation) addFields(d sqlDialect, schema bigquery.Schema, prefix []string) {
+	for _, field := range schema {
+		path := append(append([]string{}, prefix...), field.Name)
+		switch {
+		case field.Repeated:
+			child := &sqlRelation{
+				name:   sqlName(d, rel.name+sqlNameSeparator+strings.Join(path, sqlNameSeparator)),
+				parent: rel,
+				path:   path,
+			}
+			if field.Type == bigquery.RecordFieldType {
+				child.addFields(d, field.Schema, nil)
+			} else {
+				child.columns = append(child.columns, sqlColumn{name: "value", field: field})
+			}
+			rel.children = append(rel.children, child)
+		case field.Type == bigquery.RecordFieldType:
+			rel.addFields(d, field.Schema, path)
+		default:
+			rel.columns = append(rel.columns, sqlColumn{
+				name:  sqlName(d, strings.Join(path, sqlNameSeparator)),
+				path:  path,
+				field: field,
+			})
+		}
+	}
+}
+
+// relations returns rel and all its descendants, every relation is returned
+// before its children.
+func (rel *sqlRelation) relations() []*sqlRelation {
+	res := []*sqlRelation{rel}
+	for _, child := range rel.children {
+		res = append(res, child.relations()...)
+	}
+	return res
+}
+
+// columnNames returns the names of all the columns of rel including the id
+// columns, in the order of the values of the rows of sqlRowWriter.
+func (rel *sqlRelation) columnNames() []string {
+	names := []string{sqlIDColumn}
+	if rel.parent != nil {
+		names = append(names, sqlParentIDColumn, sqlIndexColumn)
+	}
+	for _, col := range rel.columns {
+		names = append(names, col.name)
+	}
+	return names
+}
+
+// lastID returns the last id that was allocated.
+func (rel *sqlRelation) lastID() int64 {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	return rel.nextID
+}
+
+// allocateIDs reserves n consecutive ids and returns the first one.
+func (rel *sqlRelation) allocateIDs(n int) int64 {
+	rel.mu.Lock()
+	defer rel.mu.Unlock()
+	id := rel.nextID + 1
+	rel.nextID += int64(n)
+	return id
+}
+
+// createStatements returns the statements that create the tables of rel and
+// its descendants.
+func (rel *sqlRelation) createStatements(d sqlDialect) []string {
+	var res []string
+	for _, r := range rel.relations() {
+		cols := []string{fmt.Sprintf("%s %s PRIMARY KEY", quoteSQLName(sqlIDColumn), d.idType())}
+		if r.parent != nil {
+			cols = append(cols,
+				fmt.Sprintf("%s %s NOT NULL REFERENCES %s (%s) ON DELETE CASCADE",
+					quoteSQLName(sqlParentIDColumn), d.idType(), quoteSQLName(r.parent.name), quoteSQLName(sqlIDColumn)),
+				fmt.Sprintf("%s %s NOT NULL", quoteSQLName(sqlIndexColumn), d.idType()))
+		}
+		for _, col := range r.columns {
+			cols = append(cols, fmt.Sprintf("%s %s", quoteSQLName(col.name), d.columnType(col.field)))
+		}
+		res = append(res, fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", quoteSQLName(r.name), strings.Join(cols, ",\n\t")))
+		if r.parent != nil {
+			res = append(res, fmt.Sprintf("CREATE INDEX %s ON %s (%s)",
+				quoteSQLName(sqlName(d, r.name+sqlNameSeparator+"parent")), quoteSQLName(r.name), quoteSQLName(sqlParentIDColumn)))
+		}
+	}
+	return res
+}
+
+// sqlName returns name shortened to the maximal identifier length of d, the
+// end of names that are too long is replaced by a hash of the name to keep
+// them unique.
+func sqlName(d sqlDialect, name string) string {
+	max := d.maxIdentifierLength()
+	if max == 0 || len(name) <= max {
+		return name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	suffix := fmt.Sprintf("_%08x", h.Sum32())
+	return name[:max-len(suffix)] + suffix
+}
+
+// containsString returns true if names contains name.
+func containsString(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// quoteSQLName quotes a table or column name.
+func quoteSQLName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlSink exports the data to the tables of a relational database, see
+// Params.OutputDB.
+//
+// The tables are created and written in a single transaction that is only
+// committed by Commit, so if the export fails the database is left untouched.
+// When exporting multiple projects the rows of the tables that are replaced
+// are copied to temporary tables first, so the rows of the projects that fail
+// can be restored.
+type sqlSink struct {
+	dialect sqlDialect
+	params  *Params
+	db      *sql.DB
+
+	// mu serializes the use of tx, the objects are still read from
+	// Migration Center concurrently.
+	mu        sync.Mutex
+	tx        *sql.Tx
+	relations map[string]*sqlRelation
+	// previous are the temporary tables with the rows that every relation
+	// had before the export.
+	previous map[*sqlRelation]string
+}
+
+// newOutputDBSink returns the sink of the database at url, the database is
+// selected by the scheme of the URL.
+func newOutputDBSink(url string) (Sink, error) {
+	switch {
+	case strings.HasPrefix(url, "postgres://"), strings.HasPrefix(url, "postgresql://"):
+		return NewPostgresSink(), nil
+	case strings.HasPrefix(url, sqliteScheme):
+		return NewSQLiteSink(), nil
+	}
+
+	scheme, _, _ := strings.Cut(url, ":")
+	return nil, messages.NewError(messages.InvalidOutputDB{Scheme: scheme})
+}
+
+// NewPostgresSink returns a Sink that exports the data to the PostgreSQL
+// database in Params.OutputDB. Rows are written with COPY in batches.
+func NewPostgresSink() Sink {
+	return &sqlSink{dialect: postgresDialect{}, relations: map[string]*sqlRelation{}, previous: map[*sqlRelation]string{}}
+}
+
+// NewSQLiteSink returns a Sink that exports the data to the SQLite database
+// file in Params.OutputDB.
+func NewSQLiteSink() Sink {
+	return &sqlSink{dialect: sqliteDialect{}, relations: map[string]*sqlRelation{}, previous: map[*sqlRelation]string{}}
+}
+
+func (s *sqlSink) Create(ctx context.Context, params *Params) error {
+	if params.Mode != ModeFull || params.Resume {
+		return messages.NewError(messages.ErrMsgOutputDBUnsupported)
+	}
+	s.params = params
+
+	db, err := sql.Open(s.dialect.driverName(), s.dialect.dataSourceName(params.OutputDB))
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	err = db.PingContext(ctx)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("connect to database: %w", err)
+	}
+
+	s.tx, err = db.BeginTx(ctx, nil)
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	s.db = db
+
+	return nil
+}
+
+func (s *sqlSink) CreateTable(ctx context.Context, table *Table) error {
+	rel := newSQLRelation(s.dialect, table.Name, table.Schema)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int
+	err := s.tx.QueryRowContext(ctx, s.dialect.tableExistsQuery(), rel.name).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 && !s.params.Force {
+		return errTableExists
+	}
+
+	messages.Print(messages.ExportingDataToTable{TableName: table.Name})
+	relations := rel.relations()
+	if count > 0 && s.params.isMultiProject() {
+		err = s.savePrevious(ctx, relations)
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(relations) - 1; i >= 0; i-- {
+		_, err = s.tx.ExecContext(ctx, s.dialect.dropTableStatement(relations[i].name))
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range rel.createStatements(s.dialect) {
+		_, err = s.tx.ExecContext(ctx, stmt)
+		if err != nil {
+			return fmt.Errorf("create table: %w", err)
+		}
+	}
+
+	s.relations[table.Name] = rel
+	return nil
+}
+
+func (s *sqlSink) Stream(ctx context.Context, table *Table, scope mcutil.ProjectAndLocation) (*Stream, error) {
+	s.mu.Lock()
+	rel := s.relations[table.Name]
+	s.mu.Unlock()
+
+	src := table.NewSource(ctx, scope)
+	return &Stream{
+		Source: src,
+		Write: func(ctx context.Context) error {
+			w := &sqlRowWriter{ctx: ctx, sink: s, rel: rel}
+			err := copyRows(w, src)
+			if err != nil {
+				return err
+			}
+			return w.close()
+		},
+	}, nil
+}
+
+// insertRows writes the rows of a batch to the tables in the order of the
+// relations, so the parent rows are written before their children.
+func (s *sqlSink) insertRows(ctx context.Context, relations []*sqlRelation, rows map[*sqlRelation][][]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rel := range relations {
+		if len(rows[rel]) == 0 {
+			continue
+		}
+		err := s.dialect.insertRows(ctx, s.tx, rel, rows[rel])
+		if err != nil {
+			return fmt.Errorf("write %s: %w", rel.name, err)
+		}
+	}
+	return nil
+}
+
+// savePrevious copies the tables of relations that exist to temporary
+// tables, s.mu must be held.
+func (s *sqlSink) savePrevious(ctx context.Context, relations []*sqlRelation) error {
+	for _, rel := range relations {
+		var count int
+		err := s.tx.QueryRowContext(ctx, s.dialect.tableExistsQuery(), rel.name).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			continue
+		}
+
+		previous := sqlName(s.dialect, "_mc2bq_previous"+sqlNameSeparator+rel.name)
+		_, err = s.tx.ExecContext(ctx, fmt.Sprintf("CREATE TEMPORARY TABLE %s AS SELECT * FROM %s", quoteSQLName(previous), quoteSQLName(rel.name)))
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", rel.name, err)
+		}
+		s.previous[rel] = previous
+	}
+	return nil
+}
+
+// Commit replaces the rows of the failed projects with the rows they had
+// before the export and commits the transaction, the rows of the child tables
+// are deleted by their foreign keys.
+func (s *sqlSink) Commit(ctx context.Context, failed []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rel := range s.relations {
+		stmt := fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
+			quoteSQLName(rel.name), quoteSQLName(sqlName(s.dialect, "project_id")), s.dialect.placeholder(1))
+		for _, project := range failed {
+			_, err := s.tx.ExecContext(ctx, stmt, project)
+			if err != nil {
+				return fmt.Errorf("delete rows of project %s: %w", project, err)
+			}
+		}
+		if len(failed) > 0 {
+			err := s.restorePrevious(ctx, rel, failed)
+			if err != nil {
+				return fmt.Errorf("restore rows of projects %s: %w", strings.Join(failed, ", "), err)
+			}
+		}
+	}
+	for _, previous := range s.previous {
+		_, err := s.tx.ExecContext(ctx, "DROP TABLE "+quoteSQLName(previous))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.tx.Commit()
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return s.db.Close()
+}
+
+// restorePrevious copies the rows of the failed projects and their child rows
+// from the temporary tables of root and its descendants, s.mu must be held.
+// The ids of the copied rows are offset by the last id of their relation so
+// they don't collide with the ids of the rows of the export. The columns that
+// the tables don't have anymore are dropped.
+func (s *sqlSink) restorePrevious(ctx context.Context, root *sqlRelation, failed []string) error {
+	previous, ok := s.previous[root]
+	if !ok {
+		return nil
+	}
+	columns, err := s.tableColumns(ctx, previous)
+	if err != nil {
+		return err
+	}
+	projectColumn := sqlName(s.dialect, "project_id")
+	if !containsString(columns, projectColumn) {
+		// The rows weren't exported by multiple projects.
+		return nil
+	}
+
+	var placeholders []string
+	var args []any
+	for i, project := range failed {
+		placeholders = append(placeholders, s.dialect.placeholder(i+1))
+		args = append(args, project)
+	}
+	_, err = s.tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s IS NULL OR %s NOT IN (%s)",
+		quoteSQLName(previous), quoteSQLName(projectColumn), quoteSQLName(projectColumn), strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return err
+	}
+
+	// restored are the temporary tables of the relations whose parent rows
+	// are restored too.
+	restored := map[*sqlRelation]string{root: previous}
+	relations := root.relations()
+	for _, rel := range relations[1:] {
+		previous, ok := s.previous[rel]
+		parent, parentOK := restored[rel.parent]
+		if !ok || !parentOK {
+			continue
+		}
+		_, err = s.tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s NOT IN (SELECT %s FROM %s)",
+			quoteSQLName(previous), quoteSQLName(sqlParentIDColumn), quoteSQLName(sqlIDColumn), quoteSQLName(parent)))
+		if err != nil {
+			return err
+		}
+		restored[rel] = previous
+	}
+
+	for _, rel := range relations {
+		previous, ok := restored[rel]
+		if !ok {
+			continue
+		}
+		columns, err := s.tableColumns(ctx, previous)
+		if err != nil {
+			return err
+		}
+		var names, exprs []string
+		for _, name := range rel.columnNames() {
+			if !containsString(columns, name) {
+				continue
+			}
+			expr := quoteSQLName(name)
+			switch name {
+			case sqlIDColumn:
+				expr = fmt.Sprintf("%s + %d", expr, rel.lastID())
+			case sqlParentIDColumn:
+				expr = fmt.Sprintf("%s + %d", expr, rel.parent.lastID())
+			}
+			names = append(names, quoteSQLName(name))
+			exprs = append(exprs, expr)
+		}
+		_, err = s.tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s",
+			quoteSQLName(rel.name), strings.Join(names, ", "), strings.Join(exprs, ", "), quoteSQLName(previous)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the names of the columns of table, s.mu must be held.
+func (s *sqlSink) tableColumns(ctx context.Context, table string) ([]string, error) {
+	rows, err := s.tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s WHERE 1 = 0", quoteSQLName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rows.Columns()
+}
+
+// Abort rolls back the transaction, which also drops the tables that were
+// created.
+func (s *sqlSink) Abort(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.tx.Rollback()
+	s.db.Close()
+}
+
+// sqlRowWriter splits the rows of a table to the rows of its relations and
+// writes them in batches of sqlBatchSize rows.
+type sqlRowWriter struct {
+	ctx  context.Context
+	sink *sqlSink
+	rel  *sqlRelation
+
+	count int
+	rows  map[*sqlRelation][][]any
+}
+
+func (w *sqlRowWriter) writeRow(row []byte) error {
+	obj, err := decodeRow(row)
+	if err != nil {
+		return err
+	}
+	if w.rows == nil {
+		w.rows = map[*sqlRelation][][]any{}
+	}
+	err = w.add(w.rel, obj, w.rel.allocateIDs(1), 0, 0)
+	if err != nil {
+		return err
+	}
+
+	w.count++
+	if w.count >= sqlBatchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// add adds the row of rel with obj and the rows of its children.
+func (w *sqlRowWriter) add(rel *sqlRelation, obj any, id int64, parentID int64, index int64) error {
+	row := []any{id}
+	if rel.parent != nil {
+		row = append(row, parentID, index)
+	}
+	for _, col := range rel.columns {
+		value, err := sqlValue(col.field, lookupPath(obj, col.path))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", rel.name, col.name, err)
+		}
+		row = append(row, value)
+	}
+	w.rows[rel] = append(w.rows[rel], row)
+
+	for _, child := range rel.children {
+		items, _ := lookupPath(obj, child.path).([]any)
+		if len(items) == 0 {
+			continue
+		}
+		childID := child.allocateIDs(len(items))
+		for i, item := range items {
+			err := w.add(child, item, childID+int64(i), id, int64(i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *sqlRowWriter) flush() error {
+	err := w.sink.insertRows(w.ctx, w.rel.relations(), w.rows)
+	w.count = 0
+	w.rows = nil
+	return err
+}
+
+func (w *sqlRowWriter) close() error {
+	if w.count == 0 {
+		return nil
+	}
+	return w.flush()
+}
+
+// lookupPath returns the value at path in obj, nil if it doesn't exist.
+func lookupPath(obj any, path []string) any {
+	for _, name := range path {
+		m, ok := obj.(map[string]any)
+		if !ok {
+			return nil
+		}
+		obj = m[name]
+	}
+	return obj
+}
+
+// sqlValue converts a decoded JSON value of field to a column value.
+func sqlValue(field *bigquery.FieldSchema, value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		return jsonInt(value)
+	case bigquery.FloatFieldType:
+		return jsonFloat(value)
+	case bigquery.TimestampFieldType:
+		return jsonTimestamp(value)
+	case bigquery.BooleanFieldType:
+		return value, nil
+	}
+	return fmt.Sprint(value), nil
+}
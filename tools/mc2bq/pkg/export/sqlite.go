@@ -0,0 +1,110 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	// The SQLite driver is written in Go, it doesn't require cgo.
+	_ "modernc.org/sqlite"
+)
+
+// sqliteScheme is the prefix of the URLs of SQLite databases, followed by
+// the path of the database file.
+const sqliteScheme = "sqlite:"
+
+// sqliteDialect writes the data to a SQLite database file.
+type sqliteDialect struct{}
+
+func (sqliteDialect) driverName() string {
+	return "sqlite"
+}
+
+// dataSourceName enables foreign keys, which SQLite doesn't enforce by
+// default.
+func (sqliteDialect) dataSourceName(url string) string {
+	path := strings.TrimPrefix(strings.TrimPrefix(url, sqliteScheme), "//")
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return "file:" + path + sep + "_pragma=foreign_keys(1)"
+}
+
+func (sqliteDialect) maxIdentifierLength() int {
+	return 0
+}
+
+// columnType returns the type affinity of field. SQLite has no boolean or
+// timestamp types, booleans are stored as 0 or 1 and timestamps as RFC 3339
+// strings in UTC.
+func (sqliteDialect) columnType(field *bigquery.FieldSchema) string {
+	switch field.Type {
+	case bigquery.IntegerFieldType, bigquery.BooleanFieldType:
+		return "INTEGER"
+	case bigquery.FloatFieldType:
+		return "REAL"
+	}
+	return "TEXT"
+}
+
+func (sqliteDialect) idType() string {
+	return "INTEGER"
+}
+
+func (sqliteDialect) tableExistsQuery() string {
+	return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+}
+
+func (sqliteDialect) dropTableStatement(table string) string {
+	return "DROP TABLE IF EXISTS " + quoteSQLName(table)
+}
+
+func (sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) insertRows(ctx context.Context, tx *sql.Tx, rel *sqlRelation, rows [][]any) error {
+	names := rel.columnNames()
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = quoteSQLName(name)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteSQLName(rel.name), strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range rows {
+		for i, value := range row {
+			if t, ok := value.(time.Time); ok {
+				row[i] = t.UTC().Format(time.RFC3339Nano)
+			}
+		}
+		_, err = stmt.ExecContext(ctx, row...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// stagingTableExpiration is the time after which staging tables that weren't
+// deleted (e.g. because the export was interrupted) are deleted by BigQuery.
+const stagingTableExpiration = 24 * time.Hour
+
+// promoter writes data that was loaded to staging tables to the exported
+// tables. The exported tables are only modified once the data of all the
+// tables was loaded successfully: apply prepares the tables, promote writes
+// the data of all the tables in a single transaction and finish updates the
+// properties of the tables that can't be changed in a transaction.
+type promoter interface {
+	// apply creates the table if it doesn't exist and adds the columns
+	// of the staging tables that it doesn't have, the data of the table
+	// isn't changed.
+	apply(ctx context.Context) error
+	// promote writes the data of the staging tables to the table in tx,
+	// except the data of the failed projects.
+	promote(ctx context.Context, tx *transaction, failed []string) error
+	// finish updates the layout of the table once tx was committed.
+	finish(ctx context.Context) error
+	// revert deletes the table if it was created by apply, it's called
+	// if the transaction fails.
+	revert()
+	// cleanup deletes the staging tables.
+	cleanup()
+}
+
+// stagedTable is a table whose data is loaded to a staging table, the data of
+// the staging table replaces the data of the table (or is appended to it) only
+// after the data of all the tables was loaded, see promote.
+type stagedTable struct {
+	tbl     *bigquery.Table
+	staging *bigquery.Table
+	// md is the metadata the table is created with if it doesn't exist,
+	// its schema is the schema of the staging table.
+	md *bigquery.TableMetadata
+	// partitioning is the partitioning the table must have, see
+	// TableLayout.drift.
+	partitioning *bigquery.TimePartitioning
+	// appendRows is set if the rows of the staging table are appended to
+	// the table, the rows of the table are replaced otherwise.
+	appendRows bool
+	// layout is checked and updated after the table is promoted, see
+	// TableLayout.ensure.
+	layout *TableLayout
+
+	// schema is the schema of the table, it's set by apply.
+	schema bigquery.Schema
+	// created is set if the table was created by apply.
+	created bool
+}
+
+func (st *stagedTable) apply(ctx context.Context) error {
+	var err error
+	st.schema, st.created, err = applySchema(ctx, st.tbl, st.md)
+	return err
+}
+
+// promote writes the rows of the staging table to the table. The columns of
+// the table that the staging table doesn't have are NULL. The rows of the
+// failed projects are left as they were exported by the previous export.
+func (st *stagedTable) promote(ctx context.Context, tx *transaction, failed []string) error {
+	if !st.appendRows {
+		q, params := clearQuery(sqlTableName(st.tbl), failed)
+		_, err := tx.run(ctx, q, params)
+		if err != nil {
+			return fmt.Errorf("promote %s: %w", st.tbl.TableID, err)
+		}
+	}
+
+	cond, params := failedFilter(failed)
+	_, err := tx.run(ctx, insertQuery(sqlTableName(st.tbl), sqlTableName(st.staging), st.schema, st.md.Schema, cond), params)
+	if err != nil {
+		return fmt.Errorf("promote %s: %w", st.tbl.TableID, err)
+	}
+	return nil
+}
+
+// finish updates the clustering and the partition filter requirement of the
+// table, which aren't changed by promote.
+func (st *stagedTable) finish(ctx context.Context) error {
+	if st.layout == nil {
+		return nil
+	}
+	md, err := st.tbl.Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("promote %s: %w", st.tbl.TableID, err)
+	}
+	return st.layout.ensure(ctx, st.tbl, md, st.partitioning)
+}
+
+func (st *stagedTable) revert() {
+	if st.created {
+		deleteTable(st.tbl)
+	}
+}
+
+func (st *stagedTable) cleanup() {
+	if st.staging != nil {
+		deleteTable(st.staging)
+	}
+}
+
+// applySchema creates tbl with md if it doesn't exist, otherwise the columns
+// of md.Schema are added to the table, see mergeSchema. It returns the schema
+// of the table and whether it was created.
+func applySchema(ctx context.Context, tbl *bigquery.Table, md *bigquery.TableMetadata) (bigquery.Schema, bool, error) {
+	existing, err := tbl.Metadata(ctx)
+	if gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		err = tbl.Create(ctx, md)
+		if err != nil {
+			return nil, false, fmt.Errorf("create %s: %w", tbl.TableID, err)
+		}
+		return md.Schema, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	schema, changed, err := mergeSchema(tbl.TableID, existing.Schema, md.Schema)
+	if err != nil || !changed {
+		return schema, false, err
+	}
+	_, err = tbl.Update(ctx, bigquery.TableMetadataToUpdate{Schema: schema}, existing.ETag)
+	if err != nil {
+		return nil, false, fmt.Errorf("update schema of %s: %w", tbl.TableID, err)
+	}
+	return schema, false, nil
+}
+
+// checkSchema checks that the rows of schema can be written to the existing
+// table tbl with md, see mergeSchema.
+func checkSchema(tbl *bigquery.Table, md *bigquery.TableMetadata, schema bigquery.Schema) error {
+	_, _, err := mergeSchema(tbl.TableID, md.Schema, schema)
+	return err
+}
+
+// insertQuery returns a statement that inserts the rows of the staging table
+// with schema that match cond into the target table with targetSchema.
+func insertQuery(target string, staging string, targetSchema bigquery.Schema, schema bigquery.Schema, cond string) string {
+	columns, exprs := selectColumns(targetSchema, schema)
+	return fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s AS source WHERE %s",
+		target, strings.Join(columns, ", "), strings.Join(exprs, ", "), staging, cond)
+}
+
+// clearQuery returns a statement that deletes the rows of the table that are
+// replaced by the export, all of them unless some projects failed.
+func clearQuery(table string, failed []string) (string, []bigquery.QueryParameter) {
+	if len(failed) == 0 {
+		return "TRUNCATE TABLE " + table, nil
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE `project_id` IS NULL OR `project_id` NOT IN UNNEST(@failed_projects)", table),
+		[]bigquery.QueryParameter{{Name: "failed_projects", Value: failed}}
+}
+
+// failedFilter returns a condition that excludes the rows of the failed
+// projects from a staging table that is aliased as source.
+func failedFilter(failed []string) (string, []bigquery.QueryParameter) {
+	if len(failed) == 0 {
+		return "TRUE", nil
+	}
+	return "source.`project_id` NOT IN UNNEST(@failed_projects)", []bigquery.QueryParameter{{Name: "failed_projects", Value: failed}}
+}
+
+// createStagingTable creates an empty staging table for tbl, suffix is added
+// to the name of the table. A staging table that was left by a previous
+// export is replaced, unless reuse is set in which case the data that was
+// loaded to it by the previous export is kept. The staging table expires after
+// stagingTableExpiration.
+func createStagingTable(ctx context.Context, bq *bigquery.Client, tbl *bigquery.Table, suffix string, md *bigquery.TableMetadata, reuse bool) (*bigquery.Table, error) {
+	staging := bq.DatasetInProject(tbl.ProjectID, tbl.DatasetID).Table(tbl.TableID + "_" + suffix)
+	if reuse {
+		_, err := staging.Metadata(ctx)
+		if err == nil {
+			return staging, nil
+		}
+		if !gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+			return nil, fmt.Errorf("resume staging table: %w", err)
+		}
+		return nil, messages.NewError(messages.ErrMsgStagingTableExpired)
+	}
+
+	err := gapiutil.IgnoreErrorWithCode(staging.Delete(ctx), http.StatusNotFound)
+	if err != nil {
+		return nil, fmt.Errorf("create staging table: %w", err)
+	}
+
+	stagingMD := *md
+	stagingMD.ExpirationTime = time.Now().Add(stagingTableExpiration)
+	err = staging.Create(ctx, &stagingMD)
+	if err != nil {
+		return nil, fmt.Errorf("create staging table: %w", err)
+	}
+
+	return staging, nil
+}
+
+// deleteTable deletes a table that doesn't contain exported data, either a
+// staging table or a table that was created by apply. Errors are ignored
+// because staging tables expire anyway and a table created by apply is empty.
+func deleteTable(tbl *bigquery.Table) {
+	// Use a new context so the table is deleted even if the export is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_ = tbl.Delete(ctx)
+}
@@ -0,0 +1,484 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	storage "cloud.google.com/go/bigquery/storage/apiv1"
+	"cloud.google.com/go/bigquery/storage/apiv1/storagepb"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// WriteMethod is the way the data is written to BigQuery.
+type WriteMethod string
+
+// Supported write methods
+const (
+	// WriteMethodLoad loads the objects serialized as JSON with load jobs.
+	WriteMethodLoad WriteMethod = "load"
+	// WriteMethodStorageWrite streams the objects serialized as protos
+	// with the Storage Write API. The rows are written to pending streams
+	// that are committed together when all the objects of all the tables
+	// were written.
+	WriteMethodStorageWrite WriteMethod = "storage-write"
+)
+
+// ParseWriteMethod parses a write method name, an empty name is
+// WriteMethodLoad.
+func ParseWriteMethod(name string) (WriteMethod, error) {
+	switch method := WriteMethod(strings.ToLower(name)); method {
+	case "":
+		return WriteMethodLoad, nil
+	case WriteMethodLoad, WriteMethodStorageWrite:
+		return method, nil
+	}
+
+	return "", messages.NewError(messages.InvalidWriteMethod{Method: name})
+}
+
+const (
+	// storageWriteRequestBytes is the maximal size of the rows of an
+	// AppendRows request, the API rejects requests larger than 10MB.
+	storageWriteRequestBytes = 8 << 20
+	// storageWriteInflight is the maximal number of AppendRows requests of
+	// a stream that wait for a response.
+	storageWriteInflight = 8
+)
+
+// encodedSource is implemented by sources that can encode their objects
+// without serializing them to JSON first, see objectReader.readEncoded.
+type encodedSource interface {
+	readEncoded(encode func(row map[string]any) ([]byte, error)) ([]byte, error)
+}
+
+// storageWriter writes objects to tables with the Storage Write API in
+// pending mode, the rows of a table become visible only when its streams are
+// committed.
+type storageWriter struct {
+	client *storage.BigQueryWriteClient
+
+	mu sync.Mutex
+	// streams are the finalized streams of every table, by the path of
+	// the table.
+	streams map[string][]string
+}
+
+func newStorageWriter(ctx context.Context, params *Params) (*storageWriter, error) {
+	client, err := storage.NewBigQueryWriteClient(ctx, buildClientOptions(params)...)
+	if err != nil {
+		return nil, fmt.Errorf("create bigquery storage client: %w", err)
+	}
+
+	return &storageWriter{client: client, streams: map[string][]string{}}, nil
+}
+
+// tablePath returns the resource path of tbl in the Storage Write API.
+func tablePath(tbl *bigquery.Table) string {
+	return fmt.Sprintf("projects/%s/datasets/%s/tables/%s", tbl.ProjectID, tbl.DatasetID, tbl.TableID)
+}
+
+// write appends all the objects of src to a new pending stream of tbl and
+// finalizes the stream, it's committed by commit.
+func (w *storageWriter) write(ctx context.Context, tbl *bigquery.Table, src mcutil.ObjectSource) error {
+	parent := tablePath(tbl)
+	stream, err := w.client.CreateWriteStream(ctx, &storagepb.CreateWriteStreamRequest{
+		Parent:      parent,
+		WriteStream: &storagepb.WriteStream{Type: storagepb.WriteStream_PENDING},
+	})
+	if err != nil {
+		return fmt.Errorf("create write stream: %w", err)
+	}
+
+	rowCount, err := w.appendRows(ctx, stream.Name, src)
+	if err != nil {
+		return err
+	}
+
+	res, err := w.client.FinalizeWriteStream(ctx, &storagepb.FinalizeWriteStreamRequest{Name: stream.Name})
+	if err != nil {
+		return fmt.Errorf("finalize write stream: %w", err)
+	}
+	if res.RowCount != rowCount {
+		return fmt.Errorf("write stream %s contains %d rows, want %d", stream.Name, res.RowCount, rowCount)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.streams[parent] = append(w.streams[parent], stream.Name)
+	return nil
+}
+
+// appendRows appends the objects of src to stream in requests of up to
+// storageWriteRequestBytes, it returns the number of rows that were appended.
+func (w *storageWriter) appendRows(ctx context.Context, stream string, src mcutil.ObjectSource) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	client, err := w.client.AppendRows(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("append rows: %w", err)
+	}
+
+	// The responses are received concurrently, the number of requests that
+	// wait for a response is limited by inflight.
+	inflight := make(chan struct{}, storageWriteInflight)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			res, err := client.Recv()
+			if errors.Is(err, io.EOF) {
+				recvErr <- nil
+				return
+			}
+			if err == nil {
+				err = appendRowsError(res)
+			}
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			<-inflight
+		}
+	}()
+
+	schema := &storagepb.ProtoSchema{ProtoDescriptor: storageDescriptor("row", src.Schema())}
+	encode := func(row map[string]any) ([]byte, error) {
+		return appendStorageRow(nil, src.Schema(), row)
+	}
+	next := storageRows(src, encode)
+
+	var offset int64
+	var rows [][]byte
+	size := 0
+	send := func() error {
+		req := &storagepb.AppendRowsRequest{
+			Offset: wrapperspb.Int64(offset),
+			Rows: &storagepb.AppendRowsRequest_ProtoRows{
+				ProtoRows: &storagepb.AppendRowsRequest_ProtoData{
+					Rows: &storagepb.ProtoRows{SerializedRows: rows},
+				},
+			},
+		}
+		if offset == 0 {
+			// The stream and the schema are only sent in the first
+			// request of the connection.
+			req.WriteStream = stream
+			req.GetProtoRows().WriterSchema = schema
+		}
+		select {
+		case inflight <- struct{}{}:
+		case err := <-recvErr:
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		err := client.Send(req)
+		if err != nil {
+			return err
+		}
+
+		offset += int64(len(rows))
+		rows = nil
+		size = 0
+		return nil
+	}
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if size+len(row) > storageWriteRequestBytes && len(rows) > 0 {
+			err = send()
+			if err != nil {
+				return 0, err
+			}
+		}
+		rows = append(rows, row)
+		size += len(row)
+	}
+	if len(rows) > 0 {
+		err = send()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = client.CloseSend()
+	if err != nil {
+		return 0, err
+	}
+	err = <-recvErr
+	if err != nil {
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// appendRowsError returns the error of an AppendRows response.
+func appendRowsError(res *storagepb.AppendRowsResponse) error {
+	if status := res.GetError(); status != nil {
+		return fmt.Errorf("append rows: %s", status.GetMessage())
+	}
+	if len(res.RowErrors) > 0 {
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "encountered errors during export:")
+		for _, rowErr := range res.RowErrors {
+			fmt.Fprintf(&sb, "\n\trow %d: %s", rowErr.Index, rowErr.Message)
+		}
+		return errors.New(sb.String())
+	}
+	return nil
+}
+
+// storageRows returns a function that returns the next object of src encoded
+// by encode, the objects are decoded from JSON if src can't encode them.
+func storageRows(src mcutil.ObjectSource, encode func(row map[string]any) ([]byte, error)) func() ([]byte, error) {
+	if encoded, ok := src.(encodedSource); ok {
+		return func() ([]byte, error) {
+			return encoded.readEncoded(encode)
+		}
+	}
+
+	br := bufio.NewReaderSize(src, 64*1024)
+	return func() ([]byte, error) {
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) > 0 {
+				row, derr := decodeRow(line)
+				if derr != nil {
+					return nil, derr
+				}
+				return encode(row)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// commit commits the streams of every table, the streams of a table are
+// committed atomically.
+func (w *storageWriter) commit(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tables := make([]string, 0, len(w.streams))
+	for table := range w.streams {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		res, err := w.client.BatchCommitWriteStreams(ctx, &storagepb.BatchCommitWriteStreamsRequest{
+			Parent:       table,
+			WriteStreams: w.streams[table],
+		})
+		if err != nil {
+			return fmt.Errorf("commit write streams of %s: %w", table, err)
+		}
+		if len(res.StreamErrors) > 0 {
+			return fmt.Errorf("commit write streams of %s: %s", table, res.StreamErrors[0].ErrorMessage)
+		}
+		delete(w.streams, table)
+	}
+
+	return nil
+}
+
+func (w *storageWriter) close() error {
+	return w.client.Close()
+}
+
+// storageDescriptor returns the descriptor of the proto2 message of the rows
+// of a table with schema. The field numbers are the positions of the columns
+// in the schema and records are nested messages.
+func storageDescriptor(name string, schema bigquery.Schema) *descriptorpb.DescriptorProto {
+	msg := &descriptorpb.DescriptorProto{Name: proto.String(name)}
+	for i, field := range schema {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(field.Name),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if field.Repeated {
+			fd.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		}
+
+		switch field.Type {
+		case bigquery.RecordFieldType:
+			nested := storageDescriptor(name+"_"+field.Name, field.Schema)
+			msg.NestedType = append(msg.NestedType, nested)
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = nested.Name
+		case bigquery.IntegerFieldType, bigquery.TimestampFieldType:
+			// Timestamps are microseconds since the epoch.
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_INT64.Enum()
+		case bigquery.FloatFieldType:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE.Enum()
+		case bigquery.BooleanFieldType:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_BOOL.Enum()
+		default:
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()
+		}
+		msg.Field = append(msg.Field, fd)
+	}
+
+	return msg
+}
+
+// appendStorageRow appends row encoded as the message of storageDescriptor
+// to b. Values that are missing or nil aren't encoded.
+func appendStorageRow(b []byte, schema bigquery.Schema, row map[string]any) ([]byte, error) {
+	for i, field := range schema {
+		value, ok := row[field.Name]
+		if !ok || value == nil {
+			continue
+		}
+
+		num := protowire.Number(i + 1)
+		var err error
+		if !field.Repeated {
+			b, err = appendStorageValue(b, num, field, value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field.Name, err)
+			}
+			continue
+		}
+
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("%s: invalid repeated value %v", field.Name, value)
+		}
+		for j := 0; j < items.Len(); j++ {
+			b, err = appendStorageValue(b, num, field, items.Index(j).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s[%d]: %w", field.Name, j, err)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// appendStorageValue appends a single value of field to b.
+func appendStorageValue(b []byte, num protowire.Number, field *bigquery.FieldSchema, value any) ([]byte, error) {
+	switch field.Type {
+	case bigquery.RecordFieldType:
+		record, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid record %v", value)
+		}
+		nested, err := appendStorageRow(nil, field.Schema, record)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, nested), nil
+	case bigquery.IntegerFieldType:
+		n, err := storageInt(value)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(n)), nil
+	case bigquery.TimestampFieldType:
+		t, ok := value.(time.Time)
+		if !ok {
+			var err error
+			t, err = jsonTimestamp(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, uint64(t.UnixMicro())), nil
+	case bigquery.FloatFieldType:
+		f, err := storageFloat(value)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+		return protowire.AppendFixed64(b, math.Float64bits(f)), nil
+	case bigquery.BooleanFieldType:
+		v, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("invalid boolean %v", value)
+		}
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v)), nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		s = fmt.Sprint(value)
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s), nil
+}
+
+// storageInt converts a normalized or decoded JSON value to an integer.
+func storageInt(value any) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case uint64:
+		return int64(v), nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	}
+	return jsonInt(value)
+}
+
+// storageFloat converts a normalized or decoded JSON value to a float.
+func storageFloat(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	return jsonFloat(value)
+}
@@ -0,0 +1,332 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+	exporterschema "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+)
+
+// decodeStorageRow decodes a row encoded by appendStorageRow, it's the
+// inverse of appendStorageRow for the values of decodeRow.
+func decodeStorageRow(t *testing.T, b []byte, schema bigquery.Schema) map[string]any {
+	t.Helper()
+	row := map[string]any{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		field := schema[num-1]
+
+		var value any
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			b = b[n:]
+			switch field.Type {
+			case bigquery.BooleanFieldType:
+				value = protowire.DecodeBool(v)
+			case bigquery.TimestampFieldType:
+				value = time.UnixMicro(int64(v)).UTC().Format(time.RFC3339)
+			default:
+				value = fmt.Sprint(int64(v))
+			}
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			b = b[n:]
+			value = fmt.Sprint(math.Float64frombits(v))
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			b = b[n:]
+			if field.Type == bigquery.RecordFieldType {
+				value = decodeStorageRow(t, v, field.Schema)
+			} else {
+				value = string(v)
+			}
+		default:
+			t.Fatalf("unexpected wire type %v of %s", typ, field.Name)
+		}
+
+		if field.Repeated {
+			items, _ := row[field.Name].([]any)
+			value = append(items, value)
+		}
+		row[field.Name] = value
+	}
+	return row
+}
+
+func TestStorageDescriptor(t *testing.T) {
+	desc := storageDescriptor("row", fileTestSchema)
+	_, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        proto.String("row.proto"),
+		Syntax:      proto.String("proto2"),
+		MessageType: []*descriptorpb.DescriptorProto{desc},
+	}, nil)
+	if err != nil {
+		t.Fatalf("storageDescriptor() isn't a valid descriptor: %v", err)
+	}
+
+	if got := desc.NestedType[0].GetName(); got != "row_details" {
+		t.Errorf("unexpected nested type %s", got)
+	}
+	if got := desc.Field[3].GetLabel(); got != descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+		t.Errorf("labels has label %v, want repeated", got)
+	}
+}
+
+func TestAppendStorageRow(t *testing.T) {
+	row, err := decodeRow([]byte(fileTestRows[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := appendStorageRow(nil, fileTestSchema, row)
+	if err != nil {
+		t.Fatalf("appendStorageRow(): unexpected error: %v", err)
+	}
+
+	got := decodeStorageRow(t, b, fileTestSchema)
+	want := map[string]any{
+		"name":  "a",
+		"count": "1",
+		"details": map[string]any{
+			"enabled":     true,
+			"update_time": "2023-10-01T10:30:00Z",
+		},
+		"labels": []any{map[string]any{"key": "env", "value": "prod"}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("appendStorageRow(): unexpected row (-want, +got):\n%s", diff)
+	}
+}
+
+func TestStorageRows(t *testing.T) {
+	schema := bigquery.Schema{{Name: "name", Type: bigquery.StringFieldType}}
+	encode := func(row map[string]any) ([]byte, error) {
+		return appendStorageRow(nil, schema, row)
+	}
+	newSources := map[string]func() mcutil.ObjectSource{
+		"objects": func() mcutil.ObjectSource {
+			it := &sliceIterator[*migrationcenterpb.Group]{items: []*migrationcenterpb.Group{{Name: "g1"}, {Name: "g2"}}}
+			return newObjectReader[*migrationcenterpb.Group](it, "group", schema)
+		},
+		"json": func() mcutil.ObjectSource {
+			return &rowSource{strings.NewReader(`{"name":"g1"}` + "\n" + `{"name":"g2"}` + "\n"), schema, 2}
+		},
+	}
+	for name, newSource := range newSources {
+		t.Run(name, func(t *testing.T) {
+			next := storageRows(newSource(), encode)
+			var got []any
+			for {
+				b, err := next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("next(): unexpected error: %v", err)
+				}
+				got = append(got, decodeStorageRow(t, b, schema)["name"])
+			}
+			if diff := cmp.Diff([]any{"g1", "g2"}, got); diff != "" {
+				t.Errorf("unexpected rows (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseWriteMethod(t *testing.T) {
+	for name, want := range map[string]WriteMethod{
+		"":              WriteMethodLoad,
+		"load":          WriteMethodLoad,
+		"Storage-Write": WriteMethodStorageWrite,
+	} {
+		got, err := ParseWriteMethod(name)
+		if err != nil || got != want {
+			t.Errorf("ParseWriteMethod(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	_, err := ParseWriteMethod("insert")
+	if err == nil {
+		t.Errorf("ParseWriteMethod(insert) succeeded")
+	}
+}
+
+// benchmarkAssetCount is the number of synthetic assets that every iteration
+// of the write method benchmarks writes.
+const benchmarkAssetCount = 100000
+
+// syntheticAssets returns an iterator of count assets that resemble the
+// assets of a discovery client.
+func syntheticAssets(count int) *sliceIterator[*migrationcenterpb.Asset] {
+	created := timestamppb.New(time.Date(2023, 10, 1, 10, 30, 0, 0, time.UTC))
+	it := &sliceIterator[*migrationcenterpb.Asset]{}
+	for i := 0; i < count; i++ {
+		it.items = append(it.items, &migrationcenterpb.Asset{
+			Name:       fmt.Sprintf("projects/p/locations/us-central1/assets/asset-%d", i),
+			CreateTime: created,
+			UpdateTime: created,
+			Labels:     map[string]string{"env": "prod", "team": fmt.Sprintf("team-%d", i%10)},
+			Attributes: map[string]string{"source": "discovery-client"},
+			AssetDetails: &migrationcenterpb.Asset_MachineDetails{MachineDetails: &migrationcenterpb.MachineDetails{
+				Uuid:        fmt.Sprintf("uuid-%d", i),
+				MachineName: fmt.Sprintf("vm-%d", i),
+				CreateTime:  created,
+				CoreCount:   int32(2 + i%16),
+				MemoryMb:    int32(4096 * (1 + i%8)),
+				PowerState:  migrationcenterpb.MachineDetails_ACTIVE,
+			}},
+		})
+	}
+	return it
+}
+
+// knownFields returns the fields of schema that are known to desc, the
+// embedded schema may be ahead of the vendored client.
+func knownFields(schema bigquery.Schema, desc protoreflect.MessageDescriptor) bigquery.Schema {
+	var res bigquery.Schema
+	for _, field := range schema {
+		fd := desc.Fields().ByName(protoreflect.Name(field.Name))
+		if fd == nil {
+			continue
+		}
+		if field.Type == bigquery.RecordFieldType && fd.Message() != nil {
+			known := *field
+			known.Schema = knownFields(field.Schema, fd.Message())
+			field = &known
+		}
+		res = append(res, field)
+	}
+	return res
+}
+
+// BenchmarkWriteMethod compares the encoding cost of the write methods, load
+// serializes the assets to the JSON that is uploaded by the load jobs and
+// storage-write encodes them to the protos that are appended to the write
+// streams.
+//
+// If MC2BQ_BENCH_DATASET is set to a project.dataset the assets are also
+// written to a table in the dataset with each method, the table is deleted
+// afterwards.
+func BenchmarkWriteMethod(b *testing.B) {
+	schema := knownFields(exporterschema.EmbeddedSchema.AssetTable, (&migrationcenterpb.Asset{}).ProtoReflect().Descriptor())
+	assets := syntheticAssets(benchmarkAssetCount)
+	newSource := func() *objectReader[*migrationcenterpb.Asset] {
+		it := &sliceIterator[*migrationcenterpb.Asset]{items: assets.items}
+		return newObjectReader[*migrationcenterpb.Asset](it, "asset", schema).withColumns([]column{
+			{name: "project_id", value: "p"},
+		})
+	}
+
+	b.Run("encode/load", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			n, err := io.Copy(io.Discard, newSource())
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(n)
+		}
+	})
+	b.Run("encode/storage-write", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			src := newSource()
+			next := storageRows(src, func(row map[string]any) ([]byte, error) {
+				return appendStorageRow(nil, src.Schema(), row)
+			})
+			for {
+				_, err := next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.SetBytes(int64(src.BytesRead()))
+		}
+	})
+
+	dataset := os.Getenv("MC2BQ_BENCH_DATASET")
+	if dataset == "" {
+		return
+	}
+	projectID, datasetID, _ := strings.Cut(dataset, ".")
+	ctx := context.Background()
+	params := &Params{TargetProjectID: projectID}
+	bq, err := bigquery.NewClient(ctx, projectID, buildClientOptions(params)...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer bq.Close()
+	storage, err := newStorageWriter(ctx, params)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer storage.close()
+
+	writeMethods := map[WriteMethod]func(tbl *bigquery.Table) error{
+		WriteMethodLoad: func(tbl *bigquery.Table) error {
+			return exportObjects(ctx, tbl, newSource())
+		},
+		WriteMethodStorageWrite: func(tbl *bigquery.Table) error {
+			err := storage.write(ctx, tbl, newSource())
+			if err != nil {
+				return err
+			}
+			return storage.commit(ctx)
+		},
+	}
+	for method, write := range writeMethods {
+		b.Run("bigquery/"+string(method), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				tbl := bq.Dataset(datasetID).Table(fmt.Sprintf("mc2bq_bench_%d", time.Now().UnixNano()))
+				err := tbl.Create(ctx, &bigquery.TableMetadata{Schema: newSource().Schema()})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				err = write(tbl)
+				b.StopTimer()
+				_ = tbl.Delete(ctx)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// mergeSchema returns the schema of the existing table that can hold the rows
+// of schema, changed is false if the table doesn't have to be updated. The
+// columns of the table keep their order and the columns that are only in
+// schema are appended, every new column is nullable because the table may
+// already have rows. Required columns are relaxed if schema doesn't require
+// them. BigQuery can't change the type of a column, a column whose type
+// differs fails with a TableSchemaIncompatible error.
+func mergeSchema(table string, existing bigquery.Schema, schema bigquery.Schema) (merged bigquery.Schema, changed bool, err error) {
+	return mergeFields(table, "", existing, schema)
+}
+
+func mergeFields(table string, prefix string, existing bigquery.Schema, schema bigquery.Schema) (bigquery.Schema, bool, error) {
+	var res bigquery.Schema
+	changed := false
+	for _, got := range existing {
+		field := *got
+		want := findColumn(schema, got.Name)
+		if want == nil {
+			changed = changed || field.Required
+			field.Required = false
+			res = append(res, &field)
+			continue
+		}
+
+		if got.Type != want.Type || got.Repeated != want.Repeated {
+			return nil, false, messages.NewError(messages.TableSchemaIncompatible{
+				TableName: table,
+				Column:    prefix + got.Name,
+				Want:      fieldType(want),
+				Got:       fieldType(got),
+			})
+		}
+		if field.Required && !want.Required {
+			field.Required = false
+			changed = true
+		}
+		if got.Type == bigquery.RecordFieldType {
+			nested, nestedChanged, err := mergeFields(table, prefix+got.Name+".", got.Schema, want.Schema)
+			if err != nil {
+				return nil, false, err
+			}
+			field.Schema = nested
+			changed = changed || nestedChanged
+		}
+		res = append(res, &field)
+	}
+
+	for _, want := range schema {
+		if findColumn(existing, want.Name) == nil {
+			res = append(res, nullableField(want))
+			changed = true
+		}
+	}
+
+	return res, changed, nil
+}
+
+// nullableField returns a copy of field whose nested fields aren't required.
+func nullableField(field *bigquery.FieldSchema) *bigquery.FieldSchema {
+	res := *field
+	res.Required = false
+	res.Schema = nil
+	for _, nested := range field.Schema {
+		res.Schema = append(res.Schema, nullableField(nested))
+	}
+	return &res
+}
+
+// selectColumns returns the columns of the table with target schema and the
+// expressions that select them from the rows of a staging table with schema,
+// the staging table is aliased as source. The columns that aren't in schema
+// are NULL (or empty arrays). Records are rebuilt if their fields differ, so
+// the rows of schema can be written to a table whose schema was merged with
+// mergeSchema.
+func selectColumns(target bigquery.Schema, schema bigquery.Schema) (columns []string, exprs []string) {
+	for _, field := range target {
+		columns = append(columns, quoteIdentifier(field.Name))
+		exprs = append(exprs, convertExpr("source."+quoteIdentifier(field.Name), field, findColumn(schema, field.Name), 0))
+	}
+	return columns, exprs
+}
+
+// convertExpr returns an expression of the type of target that converts expr,
+// whose type is the type of field. field is nil if the column is missing,
+// depth is the nesting of repeated records and keeps the aliases unique.
+func convertExpr(expr string, target *bigquery.FieldSchema, field *bigquery.FieldSchema, depth int) string {
+	if field == nil {
+		return fmt.Sprintf("CAST(NULL AS %s)", sqlType(target))
+	}
+	if target.Type != bigquery.RecordFieldType || sameFields(target.Schema, field.Schema) {
+		return expr
+	}
+
+	if target.Repeated {
+		elem := fmt.Sprintf("e%d", depth)
+		offset := fmt.Sprintf("o%d", depth)
+		return fmt.Sprintf("ARRAY(SELECT AS STRUCT %s FROM UNNEST(%s) AS %s WITH OFFSET AS %s ORDER BY %s)",
+			structFields(elem, target.Schema, field.Schema, depth+1), expr, elem, offset, offset)
+	}
+	return fmt.Sprintf("IF(%s IS NULL, NULL, STRUCT(%s))", expr, structFields(expr, target.Schema, field.Schema, depth))
+}
+
+// structFields returns the fields of a struct of the target schema whose
+// values are selected from the record expr with schema.
+func structFields(expr string, target bigquery.Schema, schema bigquery.Schema, depth int) string {
+	var res []string
+	for _, field := range target {
+		value := convertExpr(expr+"."+quoteIdentifier(field.Name), field, findColumn(schema, field.Name), depth)
+		res = append(res, value+" AS "+quoteIdentifier(field.Name))
+	}
+	return strings.Join(res, ", ")
+}
+
+// sameFields returns true if the fields of a and b have the same names, types
+// and order, so the records of b can be written to a column of a.
+func sameFields(a bigquery.Schema, b bigquery.Schema) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Type != b[i].Type || a[i].Repeated != b[i].Repeated {
+			return false
+		}
+		if a[i].Type == bigquery.RecordFieldType && !sameFields(a[i].Schema, b[i].Schema) {
+			return false
+		}
+	}
+	return true
+}
+
+// sqlType returns the Standard SQL type of field, e.g. ARRAY<INT64>.
+func sqlType(field *bigquery.FieldSchema) string {
+	var res string
+	switch field.Type {
+	case bigquery.IntegerFieldType:
+		res = "INT64"
+	case bigquery.FloatFieldType:
+		res = "FLOAT64"
+	case bigquery.BooleanFieldType:
+		res = "BOOL"
+	case bigquery.RecordFieldType:
+		var fields []string
+		for _, nested := range field.Schema {
+			fields = append(fields, quoteIdentifier(nested.Name)+" "+sqlType(nested))
+		}
+		res = "STRUCT<" + strings.Join(fields, ", ") + ">"
+	default:
+		res = string(field.Type)
+	}
+
+	if field.Repeated {
+		return "ARRAY<" + res + ">"
+	}
+	return res
+}
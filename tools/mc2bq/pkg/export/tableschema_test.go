@@ -0,0 +1,216 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+func TestMergeSchema(t *testing.T) {
+	existing := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType, Required: true},
+		{Name: "old", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "cores", Type: bigquery.IntegerFieldType},
+		}},
+	}
+
+	tCases := []struct {
+		name        string
+		schema      bigquery.Schema
+		want        bigquery.Schema
+		wantChanged bool
+	}{
+		{
+			name:   "same",
+			schema: existing,
+			want:   existing,
+		},
+		{
+			name: "added and removed columns",
+			schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType, Required: true},
+				{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "memory", Type: bigquery.IntegerFieldType, Required: true},
+					{Name: "cores", Type: bigquery.IntegerFieldType},
+				}},
+				{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+					{Name: "key", Type: bigquery.StringFieldType, Required: true},
+				}},
+			},
+			want: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType, Required: true},
+				{Name: "old", Type: bigquery.IntegerFieldType},
+				{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "cores", Type: bigquery.IntegerFieldType},
+					{Name: "memory", Type: bigquery.IntegerFieldType},
+				}},
+				{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+					{Name: "key", Type: bigquery.StringFieldType},
+				}},
+			},
+			wantChanged: true,
+		},
+		{
+			name: "relaxed column",
+			schema: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType},
+				{Name: "old", Type: bigquery.IntegerFieldType, Required: true},
+				{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "cores", Type: bigquery.IntegerFieldType},
+				}},
+			},
+			want: bigquery.Schema{
+				{Name: "name", Type: bigquery.StringFieldType},
+				{Name: "old", Type: bigquery.IntegerFieldType, Required: true},
+				{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "cores", Type: bigquery.IntegerFieldType},
+				}},
+			},
+			wantChanged: true,
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			got, changed, err := mergeSchema("assets", existing, tCase.schema)
+			if err != nil {
+				t.Fatalf("mergeSchema() failed: %v", err)
+			}
+			if changed != tCase.wantChanged {
+				t.Errorf("mergeSchema() changed = %v, want %v", changed, tCase.wantChanged)
+			}
+			if diff := cmp.Diff(tCase.want, got); diff != "" {
+				t.Errorf("mergeSchema() unexpected schema (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeSchemaIncompatible(t *testing.T) {
+	existing := bigquery.Schema{
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "cores", Type: bigquery.IntegerFieldType},
+		}},
+	}
+	schema := bigquery.Schema{
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "cores", Type: bigquery.IntegerFieldType, Repeated: true},
+		}},
+	}
+
+	_, _, err := mergeSchema("assets", existing, schema)
+	want := messages.TableSchemaIncompatible{TableName: "assets", Column: "details.cores", Want: "REPEATED INTEGER", Got: "INTEGER"}
+	if err == nil || err.Error() != want.String() {
+		t.Errorf("mergeSchema() error = %v, want %q", err, want.String())
+	}
+}
+
+func TestInsertQuery(t *testing.T) {
+	labels := &bigquery.FieldSchema{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+		{Name: "key", Type: bigquery.StringFieldType},
+		{Name: "value", Type: bigquery.StringFieldType},
+	}}
+	schema := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "memory", Type: bigquery.IntegerFieldType},
+			{Name: "cores", Type: bigquery.IntegerFieldType},
+		}},
+		labels,
+	}
+	target := bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "old", Type: bigquery.FloatFieldType},
+		{Name: "details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "cores", Type: bigquery.IntegerFieldType},
+			{Name: "memory", Type: bigquery.IntegerFieldType},
+			{Name: "disks", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+				{Name: "size", Type: bigquery.IntegerFieldType},
+				{Name: "active", Type: bigquery.BooleanFieldType},
+			}},
+		}},
+		labels,
+	}
+
+	tCases := []struct {
+		name   string
+		target bigquery.Schema
+		schema bigquery.Schema
+		want   string
+	}{
+		{
+			name:   "same schema",
+			target: schema,
+			schema: schema,
+			want:   "INSERT INTO `p.d.assets` (`name`, `details`, `labels`) SELECT source.`name`, source.`details`, source.`labels` FROM `p.d.assets_staging` AS source WHERE TRUE",
+		},
+		{
+			name:   "merged schema",
+			target: target,
+			schema: schema,
+			want: "INSERT INTO `p.d.assets` (`name`, `old`, `details`, `labels`) SELECT source.`name`, CAST(NULL AS FLOAT64), " +
+				"IF(source.`details` IS NULL, NULL, STRUCT(source.`details`.`cores` AS `cores`, source.`details`.`memory` AS `memory`, " +
+				"CAST(NULL AS ARRAY<STRUCT<`size` INT64, `active` BOOL>>) AS `disks`)), source.`labels` FROM `p.d.assets_staging` AS source WHERE TRUE",
+		},
+		{
+			name: "repeated record",
+			target: bigquery.Schema{
+				{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+					{Name: "value", Type: bigquery.StringFieldType},
+					{Name: "key", Type: bigquery.StringFieldType},
+				}},
+			},
+			schema: bigquery.Schema{labels},
+			want: "INSERT INTO `p.d.assets` (`labels`) SELECT ARRAY(SELECT AS STRUCT e0.`value` AS `value`, e0.`key` AS `key` " +
+				"FROM UNNEST(source.`labels`) AS e0 WITH OFFSET AS o0 ORDER BY o0) FROM `p.d.assets_staging` AS source WHERE TRUE",
+		},
+	}
+
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			got := insertQuery("`p.d.assets`", "`p.d.assets_staging`", tCase.target, tCase.schema, "TRUE")
+			if got != tCase.want {
+				t.Errorf("insertQuery() unexpected query\nwant: %s\ngot:  %s", tCase.want, got)
+			}
+			if strings.Count(got, "(") != strings.Count(got, ")") {
+				t.Errorf("insertQuery() unbalanced parentheses: %s", got)
+			}
+		})
+	}
+}
+
+func TestClearQuery(t *testing.T) {
+	q, params := clearQuery("`p.d.assets`", nil)
+	if want := "TRUNCATE TABLE `p.d.assets`"; q != want || len(params) != 0 {
+		t.Errorf("clearQuery() without failed projects = %q, %v, want %q without parameters", q, params, want)
+	}
+
+	q, params = clearQuery("`p.d.assets`", []string{"failed"})
+	want := "DELETE FROM `p.d.assets` WHERE `project_id` IS NULL OR `project_id` NOT IN UNNEST(@failed_projects)"
+	if q != want {
+		t.Errorf("clearQuery() = %q, want %q", q, want)
+	}
+	wantParams := []bigquery.QueryParameter{{Name: "failed_projects", Value: []string{"failed"}}}
+	if diff := cmp.Diff(wantParams, params); diff != "" {
+		t.Errorf("clearQuery() unexpected parameters (-want, +got):\n%s", diff)
+	}
+}
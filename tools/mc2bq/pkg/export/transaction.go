@@ -0,0 +1,100 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// transaction is a BigQuery multi-statement transaction. The statements run
+// as separate query jobs in a session, their changes are visible to other
+// readers only once the transaction is committed. BigQuery doesn't allow DDL
+// statements in transactions, only DML.
+type transaction struct {
+	bq        *bigquery.Client
+	location  string
+	sessionID string
+}
+
+// beginTransaction creates a session in location and begins a transaction in
+// it, end must be called once the transaction is committed or failed.
+func beginTransaction(ctx context.Context, bq *bigquery.Client, location string) (*transaction, error) {
+	q := bq.Query("BEGIN TRANSACTION")
+	q.Location = location
+	q.CreateSession = true
+	job, err := q.Run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	recordJob(ctx, job)
+
+	status, err := job.Wait(ctx)
+	if err == nil {
+		err = status.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	if status.Statistics == nil || status.Statistics.SessionInfo == nil {
+		return nil, errors.New("begin transaction: the job didn't create a session")
+	}
+
+	return &transaction{bq: bq, location: location, sessionID: status.Statistics.SessionInfo.SessionID}, nil
+}
+
+// run runs the statement q with params in the transaction, it returns the
+// number of rows modified by DML statements.
+func (tx *transaction) run(ctx context.Context, q string, params []bigquery.QueryParameter) (int64, error) {
+	return runQuery(ctx, tx.query(q, params))
+}
+
+// read runs the query q with params in the transaction and returns its rows,
+// the query sees the changes of the transaction that aren't committed yet.
+func (tx *transaction) read(ctx context.Context, q string, params []bigquery.QueryParameter) (*bigquery.RowIterator, error) {
+	return tx.query(q, params).Read(ctx)
+}
+
+func (tx *transaction) query(q string, params []bigquery.QueryParameter) *bigquery.Query {
+	query := tx.bq.Query(q)
+	query.Location = tx.location
+	query.Parameters = params
+	query.ConnectionProperties = []*bigquery.ConnectionProperty{{Key: "session_id", Value: tx.sessionID}}
+	return query
+}
+
+// commit commits the transaction, if it fails none of the statements are
+// applied.
+func (tx *transaction) commit(ctx context.Context) error {
+	_, err := tx.run(ctx, "COMMIT TRANSACTION", nil)
+	if err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// end terminates the session, a transaction that wasn't committed is rolled
+// back. Errors are ignored because BigQuery terminates idle sessions anyway.
+func (tx *transaction) end() {
+	// Use a new context so the transaction is rolled back even if the
+	// export is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, _ = tx.run(ctx, "CALL BQ.ABORT_SESSION()", nil)
+}
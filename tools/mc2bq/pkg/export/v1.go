@@ -16,63 +16,512 @@ package export
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 	migrationcenter "cloud.google.com/go/migrationcenter/apiv1"
 	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/iterator"
+	locationpb "google.golang.org/genproto/googleapis/cloud/location"
+
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
 	exporterschema "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/schema"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/telemetry"
 )
 
 type MCv1 struct {
-	client *migrationcenter.Client
-	schema *exporterschema.ExporterSchema
+	client    *migrationcenter.Client
+	schema    *exporterschema.ExporterSchema
+	assetView migrationcenterpb.AssetView
+	// projectColumn adds the project of the objects to every row.
+	projectColumn bool
+	// locationColumn adds the location of the objects to every row.
+	locationColumn bool
+	// exportTime is added to every row if it's set.
+	exportTime time.Time
+	// assetShards is the number of iterators that list the assets
+	// concurrently, see Params.AssetShards.
+	assetShards int
+	// filter selects the assets that are exported, see Params.AssetFilter.
+	filter AssetFilter
+
+	mu sync.Mutex
+	// groupScopes are the groups that match filter in every project and
+	// region, see matchingGroups.
+	groupScopes map[mcutil.ProjectAndLocation]*groupScope
+	// reportScopes are the reports of every project and region, see
+	// listReports.
+	reportScopes map[mcutil.ProjectAndLocation]*reportScope
+}
+
+// groupScope is the set of groups of a project and region that are assigned
+// to the assets that match the filter, it's listed once.
+type groupScope struct {
+	once   sync.Once
+	groups map[string]bool
+	err    error
+}
+
+// reportScope are the reports of all the report configs of a project and
+// region, they are listed once for the report and report summary tables.
+type reportScope struct {
+	once    sync.Once
+	reports []*migrationcenterpb.Report
+	err     error
 }
 
 var _ mcutil.MC = &MCv1{}
 
+// countRetries records the retries of the calls that the export makes in the
+// metrics. The retry settings of the client are kept, calls that the client
+// doesn't retry aren't changed.
+func countRetries(opts *migrationcenter.CallOptions) {
+	calls := map[string]*[]gax.CallOption{
+		"ListLocations":         &opts.ListLocations,
+		"ListAssets":            &opts.ListAssets,
+		"AggregateAssetsValues": &opts.AggregateAssetsValues,
+		"ListGroups":            &opts.ListGroups,
+		"ListPreferenceSets":    &opts.ListPreferenceSets,
+		"ListSources":           &opts.ListSources,
+		"ListErrorFrames":       &opts.ListErrorFrames,
+		"ListReportConfigs":     &opts.ListReportConfigs,
+		"ListReports":           &opts.ListReports,
+		"ListImportJobs":        &opts.ListImportJobs,
+		"ListImportDataFiles":   &opts.ListImportDataFiles,
+	}
+	for method, callOpts := range calls {
+		var settings gax.CallSettings
+		for _, opt := range *callOpts {
+			opt.Resolve(&settings)
+		}
+		if settings.Retry == nil {
+			continue
+		}
+
+		method, newRetryer := method, settings.Retry
+		// The last retry option replaces the previous ones.
+		*callOpts = append(*callOpts, gax.WithRetry(func() gax.Retryer {
+			return &countingRetryer{Retryer: newRetryer(), method: method}
+		}))
+	}
+}
+
+// countingRetryer records the retries of the calls to method.
+type countingRetryer struct {
+	gax.Retryer
+	method string
+}
+
+func (r *countingRetryer) Retry(err error) (time.Duration, bool) {
+	pause, retry := r.Retryer.Retry(err)
+	if retry {
+		telemetry.RecordRetry(context.Background(), r.method)
+	}
+	return pause, retry
+}
+
+// columns returns the columns that are added to the objects exported from pal.
+func (mc *MCv1) columns(pal mcutil.ProjectAndLocation) []column {
+	var res []column
+	if !mc.exportTime.IsZero() {
+		res = append(res, column{
+			name:      exportTimeColumn,
+			value:     mc.exportTime.UTC().Format(time.RFC3339Nano),
+			fieldType: bigquery.TimestampFieldType,
+		})
+	}
+	if mc.projectColumn {
+		res = append(res, column{name: "project_id", value: pal.Project})
+	}
+	if mc.locationColumn {
+		res = append(res, column{name: "location", value: pal.Location})
+	}
+
+	return res
+}
+
+func (mc *MCv1) Locations(ctx context.Context, project string) ([]string, error) {
+	it := tracePages[*locationpb.Location](ctx, "ListLocations", mc.client.ListLocations(ctx, &locationpb.ListLocationsRequest{
+		Name:     path.Join("projects", project),
+		PageSize: 1000,
+	}))
+	var res []string
+	for {
+		loc, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return res, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, loc.LocationId)
+	}
+}
+
 func (mc *MCv1) AssetSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
-	it := mc.client.ListAssets(ctx, &migrationcenterpb.ListAssetsRequest{
+	return mc.assetSource(ctx, pal, "")
+}
+
+// UpdatedAssetSource lists the assets of pal that were updated at or after since.
+func (mc *MCv1) UpdatedAssetSource(ctx context.Context, pal mcutil.ProjectAndLocation, since time.Time) mcutil.ObjectSource {
+	return mc.assetSource(ctx, pal, updatedSinceFilter(since))
+}
+
+func (mc *MCv1) assetSource(ctx context.Context, pal mcutil.ProjectAndLocation, filter string) mcutil.ObjectSource {
+	filter = andFilters(mc.filter.expression(pal), filter)
+	var it iterable[*migrationcenterpb.Asset]
+	if mc.assetShards > 1 {
+		sharded := newShardedIterator(ctx, func(ctx context.Context) ([]string, error) {
+			return mc.assetShardFilters(ctx, pal, filter)
+		}, func(ctx context.Context, filter string) iterable[*migrationcenterpb.Asset] {
+			return mc.listAssets(ctx, pal, filter, assetOrder)
+		})
+		sharded.codec = protoCodec(func() *migrationcenterpb.Asset { return &migrationcenterpb.Asset{} })
+		it = sharded
+	} else {
+		it = mc.listAssets(ctx, pal, filter, "")
+	}
+	r := newObjectReader[*migrationcenterpb.Asset](it, "asset", mc.schema.AssetTable).withColumns(mc.columns(pal))
+	return r
+}
+
+// assetOrder is the order the shards list the assets in. The shards of
+// assetShardFilters are ranges of create_time, so the assets of all the shards
+// are listed in the same order as by a single ordered listing. A single
+// listing doesn't set an order, so the server doesn't sort the assets.
+const assetOrder = "create_time, name"
+
+// listAssets lists the assets of pal that match filter, in the order of
+// orderBy if it's set.
+func (mc *MCv1) listAssets(ctx context.Context, pal mcutil.ProjectAndLocation, filter string, orderBy string) *pageTracer[*migrationcenterpb.Asset] {
+	return tracePages[*migrationcenterpb.Asset](ctx, "ListAssets", mc.client.ListAssets(ctx, &migrationcenterpb.ListAssetsRequest{
 		Parent:   pal.String(),
 		PageSize: 1000,
+		Filter:   filter,
+		OrderBy:  orderBy,
+		View:     mc.assetView,
+	}))
+}
+
+// assetShardFilters splits the assets of pal that match filter into
+// mc.assetShards ranges of create_time, between the create time of the first
+// and the last asset.
+func (mc *MCv1) assetShardFilters(ctx context.Context, pal mcutil.ProjectAndLocation, filter string) ([]string, error) {
+	first, err := mc.assetCreateTime(ctx, pal, filter, "create_time")
+	if err != nil {
+		return nil, err
+	}
+	last, err := mc.assetCreateTime(ctx, pal, filter, "create_time desc")
+	if err != nil {
+		return nil, err
+	}
+
+	return createTimeShards(first, last, mc.assetShards, filter), nil
+}
+
+// assetCreateTime returns the create time of the first asset of pal that
+// matches filter in orderBy order, the zero time is returned if there are no
+// assets.
+func (mc *MCv1) assetCreateTime(ctx context.Context, pal mcutil.ProjectAndLocation, filter string, orderBy string) (time.Time, error) {
+	it := tracePages[*migrationcenterpb.Asset](ctx, "ListAssets", mc.client.ListAssets(ctx, &migrationcenterpb.ListAssetsRequest{
+		Parent:   pal.String(),
+		PageSize: 1,
+		Filter:   filter,
+		OrderBy:  orderBy,
+		View:     migrationcenterpb.AssetView_ASSET_VIEW_BASIC,
+	}))
+	asset, err := it.Next()
+	if errors.Is(err, iterator.Done) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return asset.CreateTime.AsTime(), nil
+}
+
+// assetNameSchema is the schema of the rows returned by AssetNameSource.
+var assetNameSchema = bigquery.Schema{
+	{Name: "name", Type: bigquery.StringFieldType, Required: true},
+}
+
+// AssetNameSource lists the names of all the assets of pal that match the
+// filter, the names are used to detect the assets that were deleted since the
+// previous export.
+func (mc *MCv1) AssetNameSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	it := tracePages[*migrationcenterpb.Asset](ctx, "ListAssets", mc.client.ListAssets(ctx, &migrationcenterpb.ListAssetsRequest{
+		Parent:   pal.String(),
+		PageSize: 1000,
+		Filter:   mc.filter.expression(pal),
+		View:     migrationcenterpb.AssetView_ASSET_VIEW_BASIC,
+	}))
+	r := newObjectReader[*migrationcenterpb.Asset](it, "asset", assetNameSchema).withColumns(mc.columns(pal))
+	return r
+}
+
+// updatedSinceFilter returns a list filter that matches the objects that
+// were updated at or after since.
+func updatedSinceFilter(since time.Time) string {
+	return fmt.Sprintf("update_time >= %q", since.UTC().Format(time.RFC3339Nano))
+}
+
+// matchingGroups returns the names of the groups of pal that the groups are
+// narrowed to, nil is returned if the filter is empty. These are the groups
+// that were named in the filter or, if there are none, the groups that are
+// assigned to the assets that match the filter.
+func (mc *MCv1) matchingGroups(ctx context.Context, pal mcutil.ProjectAndLocation) (map[string]bool, error) {
+	if mc.filter.IsEmpty() {
+		return nil, nil
+	}
+	if names := mc.filter.groupNames(pal); names != nil {
+		return names, nil
+	}
+
+	mc.mu.Lock()
+	scope, ok := mc.groupScopes[pal]
+	if !ok {
+		if mc.groupScopes == nil {
+			mc.groupScopes = map[mcutil.ProjectAndLocation]*groupScope{}
+		}
+		scope = &groupScope{}
+		mc.groupScopes[pal] = scope
+	}
+	mc.mu.Unlock()
+
+	// The groups and preference sets of pal share the listing.
+	scope.once.Do(func() {
+		it := tracePages[*migrationcenterpb.Asset](ctx, "ListAssets", mc.client.ListAssets(ctx, &migrationcenterpb.ListAssetsRequest{
+			Parent:   pal.String(),
+			PageSize: 1000,
+			Filter:   mc.filter.expression(pal),
+			View:     migrationcenterpb.AssetView_ASSET_VIEW_BASIC,
+		}))
+		scope.groups, scope.err = collectStrings[*migrationcenterpb.Asset](it, func(asset *migrationcenterpb.Asset) []string {
+			return asset.AssignedGroups
+		})
 	})
-	r := newObjectReader[*migrationcenterpb.Asset](it, "asset", mc.schema.AssetTable)
-	src := newMigrationCenterLoadSource(r)
-	return &struct {
-		bigquery.LoadSource
-		*objectReader[*migrationcenterpb.Asset]
-	}{src, r}
+	return scope.groups, scope.err
 }
 
-func (mc *MCv1) GroupSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
-	it := mc.client.ListGroups(ctx, &migrationcenterpb.ListGroupsRequest{
+// matchingPreferenceSets returns the names of the preference sets of pal that
+// are assigned to the matching groups in the report configs, nil is returned
+// if the filter is empty.
+func (mc *MCv1) matchingPreferenceSets(ctx context.Context, pal mcutil.ProjectAndLocation) (map[string]bool, error) {
+	groups, err := mc.matchingGroups(ctx, pal)
+	if groups == nil || err != nil {
+		return nil, err
+	}
+
+	configs := tracePages[*migrationcenterpb.ReportConfig](ctx, "ListReportConfigs", mc.client.ListReportConfigs(ctx, &migrationcenterpb.ListReportConfigsRequest{
 		Parent:   pal.String(),
 		PageSize: 1000,
+	}))
+	return collectStrings[*migrationcenterpb.ReportConfig](configs, func(cfg *migrationcenterpb.ReportConfig) []string {
+		var res []string
+		for _, assignment := range cfg.GroupPreferencesetAssignments {
+			if groups[assignment.Group] {
+				res = append(res, assignment.PreferenceSet)
+			}
+		}
+		return res
 	})
-	r := newObjectReader[*migrationcenterpb.Group](it, "group", mc.schema.GroupTable)
-	src := newMigrationCenterLoadSource(r)
-	return &struct {
-		bigquery.LoadSource
-		*objectReader[*migrationcenterpb.Group]
-	}{src, r}
+}
+
+// narrow returns the objects of it whose name is in the set returned by
+// names, names is called once before the first object is returned and all
+// the objects are returned if it returns nil.
+func narrow[T interface{ GetName() string }](it iterable[T], names func() (map[string]bool, error)) iterable[T] {
+	var (
+		set  map[string]bool
+		done bool
+	)
+	return &filterIterator[T]{it: it, keep: func(obj T) (bool, error) {
+		if !done {
+			var err error
+			set, err = names()
+			if err != nil {
+				return false, err
+			}
+			done = true
+		}
+		return set == nil || set[obj.GetName()], nil
+	}}
+}
+
+func (mc *MCv1) GroupSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	var it iterable[*migrationcenterpb.Group] = tracePages[*migrationcenterpb.Group](ctx, "ListGroups", mc.client.ListGroups(ctx, &migrationcenterpb.ListGroupsRequest{
+		Parent:   pal.String(),
+		PageSize: 1000,
+	}))
+	if !mc.filter.IsEmpty() {
+		it = narrow(it, func() (map[string]bool, error) { return mc.matchingGroups(ctx, pal) })
+	}
+	r := newObjectReader[*migrationcenterpb.Group](it, "group", mc.schema.GroupTable).withColumns(mc.columns(pal))
+	return r
 }
 
 func (mc *MCv1) PreferenceSetSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
-	it := mc.client.ListPreferenceSets(ctx, &migrationcenterpb.ListPreferenceSetsRequest{
+	var it iterable[*migrationcenterpb.PreferenceSet] = tracePages[*migrationcenterpb.PreferenceSet](ctx, "ListPreferenceSets", mc.client.ListPreferenceSets(ctx, &migrationcenterpb.ListPreferenceSetsRequest{
+		Parent:   pal.String(),
+		PageSize: 1000,
+	}))
+	if !mc.filter.IsEmpty() {
+		it = narrow(it, func() (map[string]bool, error) { return mc.matchingPreferenceSets(ctx, pal) })
+	}
+	r := newObjectReader[*migrationcenterpb.PreferenceSet](it, "preference_set", mc.schema.PreferenceSetTable).withColumns(mc.columns(pal))
+	return r
+}
+
+func (mc *MCv1) ErrorFrameSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	sources := tracePages[*migrationcenterpb.Source](ctx, "ListSources", mc.client.ListSources(ctx, &migrationcenterpb.ListSourcesRequest{
+		Parent:   pal.String(),
+		PageSize: 1000,
+	}))
+	it := newNestedIterator[*migrationcenterpb.Source, *migrationcenterpb.ErrorFrame](sources, func(src *migrationcenterpb.Source) iterable[*migrationcenterpb.ErrorFrame] {
+		return tracePages[*migrationcenterpb.ErrorFrame](ctx, "ListErrorFrames", mc.client.ListErrorFrames(ctx, &migrationcenterpb.ListErrorFramesRequest{
+			Parent:   src.Name,
+			PageSize: 1000,
+			View:     migrationcenterpb.ErrorFrameView_ERROR_FRAME_VIEW_FULL,
+		}))
+	})
+	r := newObjectReader[*migrationcenterpb.ErrorFrame](it, "error_frame", mc.schema.ErrorFrameTable).withColumns(mc.columns(pal))
+	return r
+}
+
+func (mc *MCv1) ReportConfigSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	it := tracePages[*migrationcenterpb.ReportConfig](ctx, "ListReportConfigs", mc.client.ListReportConfigs(ctx, &migrationcenterpb.ListReportConfigsRequest{
+		Parent:   pal.String(),
+		PageSize: 1000,
+	}))
+	r := newObjectReader[*migrationcenterpb.ReportConfig](it, "report_config", mc.schema.ReportConfigTable).withColumns(mc.columns(pal))
+	return r
+}
+
+// listReports lists the reports of all the report configs in pal, they are
+// listed with the full view so they include their summary. The report and
+// report summary tables share the listing, it's made when the first of them
+// is read.
+func (mc *MCv1) listReports(ctx context.Context, pal mcutil.ProjectAndLocation) iterable[*migrationcenterpb.Report] {
+	return &lazySliceIterator[*migrationcenterpb.Report]{load: func() ([]*migrationcenterpb.Report, error) {
+		scope := mc.reportScope(ctx, pal)
+		return scope.reports, scope.err
+	}}
+}
+
+// reportScope returns the reports of pal, they are listed by the first call.
+func (mc *MCv1) reportScope(ctx context.Context, pal mcutil.ProjectAndLocation) *reportScope {
+	mc.mu.Lock()
+	scope, ok := mc.reportScopes[pal]
+	if !ok {
+		if mc.reportScopes == nil {
+			mc.reportScopes = map[mcutil.ProjectAndLocation]*reportScope{}
+		}
+		scope = &reportScope{}
+		mc.reportScopes[pal] = scope
+	}
+	mc.mu.Unlock()
+
+	scope.once.Do(func() {
+		configs := tracePages[*migrationcenterpb.ReportConfig](ctx, "ListReportConfigs", mc.client.ListReportConfigs(ctx, &migrationcenterpb.ListReportConfigsRequest{
+			Parent:   pal.String(),
+			PageSize: 1000,
+		}))
+		it := newNestedIterator[*migrationcenterpb.ReportConfig, *migrationcenterpb.Report](configs, func(cfg *migrationcenterpb.ReportConfig) iterable[*migrationcenterpb.Report] {
+			return tracePages[*migrationcenterpb.Report](ctx, "ListReports", mc.client.ListReports(ctx, &migrationcenterpb.ListReportsRequest{
+				Parent:   cfg.Name,
+				PageSize: 1000,
+				View:     migrationcenterpb.ReportView_REPORT_VIEW_FULL,
+			}))
+		})
+		scope.reports, scope.err = collectItems[*migrationcenterpb.Report](it)
+	})
+	return scope
+}
+
+func (mc *MCv1) ReportSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	r := newObjectReader[*migrationcenterpb.Report](mc.listReports(ctx, pal), "report", mc.schema.ReportTable).withColumns(mc.columns(pal))
+	return r
+}
+
+func (mc *MCv1) ReportSummarySource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	it := newNestedIterator[*migrationcenterpb.Report, exporterschema.Row](mc.listReports(ctx, pal), func(report *migrationcenterpb.Report) iterable[exporterschema.Row] {
+		return &sliceIterator[exporterschema.Row]{items: reportSummaryRows(report)}
+	})
+	r := newRowReader(it, "report_summary", mc.schema.ReportSummaryTable).withColumns(mc.columns(pal))
+	return r
+}
+
+// reportSummaryRows flattens the summary of report to a row per group and
+// preference set combination.
+func reportSummaryRows(report *migrationcenterpb.Report) []exporterschema.Row {
+	var rows []exporterschema.Row
+	for _, group := range report.GetSummary().GetGroupFindings() {
+		if len(group.PreferenceSetFindings) == 0 {
+			rows = append(rows, exporterschema.Row{
+				"report":        report.Name,
+				"group_finding": group,
+			})
+			continue
+		}
+
+		for _, preferenceSet := range group.PreferenceSetFindings {
+			rows = append(rows, exporterschema.Row{
+				"report":                 report.Name,
+				"group_finding":          group,
+				"preference_set_finding": preferenceSet,
+			})
+		}
+	}
+
+	return rows
+}
+
+func (mc *MCv1) SourceSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	it := tracePages[*migrationcenterpb.Source](ctx, "ListSources", mc.client.ListSources(ctx, &migrationcenterpb.ListSourcesRequest{
+		Parent:   pal.String(),
+		PageSize: 1000,
+	}))
+	r := newObjectReader[*migrationcenterpb.Source](it, "source", mc.schema.SourceTable).withColumns(mc.columns(pal))
+	return r
+}
+
+// listImportJobs lists the import jobs in pal, the full view is used so the
+// validation and execution reports are included.
+func (mc *MCv1) listImportJobs(ctx context.Context, pal mcutil.ProjectAndLocation) iterable[*migrationcenterpb.ImportJob] {
+	return tracePages[*migrationcenterpb.ImportJob](ctx, "ListImportJobs", mc.client.ListImportJobs(ctx, &migrationcenterpb.ListImportJobsRequest{
 		Parent:   pal.String(),
 		PageSize: 1000,
+		View:     migrationcenterpb.ImportJobView_IMPORT_JOB_VIEW_FULL,
+	}))
+}
+
+func (mc *MCv1) ImportJobSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	r := newObjectReader[*migrationcenterpb.ImportJob](mc.listImportJobs(ctx, pal), "import_job", mc.schema.ImportJobTable).withColumns(mc.columns(pal))
+	return r
+}
+
+func (mc *MCv1) ImportDataFileSource(ctx context.Context, pal mcutil.ProjectAndLocation) mcutil.ObjectSource {
+	it := newNestedIterator[*migrationcenterpb.ImportJob, *migrationcenterpb.ImportDataFile](mc.listImportJobs(ctx, pal), func(job *migrationcenterpb.ImportJob) iterable[*migrationcenterpb.ImportDataFile] {
+		return tracePages[*migrationcenterpb.ImportDataFile](ctx, "ListImportDataFiles", mc.client.ListImportDataFiles(ctx, &migrationcenterpb.ListImportDataFilesRequest{
+			Parent:   job.Name,
+			PageSize: 1000,
+		}))
 	})
-	r := newObjectReader[*migrationcenterpb.PreferenceSet](it, "preference_set", mc.schema.PreferenceSetTable)
-	src := newMigrationCenterLoadSource(r)
-	return &struct {
-		bigquery.LoadSource
-		*objectReader[*migrationcenterpb.PreferenceSet]
-	}{src, r}
+	r := newObjectReader[*migrationcenterpb.ImportDataFile](it, "import_data_file", mc.schema.ImportDataFileTable).withColumns(mc.columns(pal))
+	return r
 }
 
 func (mc *MCv1) AssetCount(ctx context.Context, pal mcutil.ProjectAndLocation) (int64, error) {
 	resp, err := mc.client.AggregateAssetsValues(ctx, &migrationcenterpb.AggregateAssetsValuesRequest{
 		Parent: pal.Path(),
+		Filter: mc.filter.expression(pal),
 		Aggregations: []*migrationcenterpb.Aggregation{
 			{
 				Field:               "*",
@@ -86,3 +535,34 @@ func (mc *MCv1) AssetCount(ctx context.Context, pal mcutil.ProjectAndLocation) (
 
 	return resp.Results[0].GetCount().Value, nil
 }
+
+func (mc *MCv1) AssetSums(ctx context.Context, pal mcutil.ProjectAndLocation, fields []string) (map[string]float64, error) {
+	if len(fields) == 0 {
+		return map[string]float64{}, nil
+	}
+
+	req := &migrationcenterpb.AggregateAssetsValuesRequest{
+		Parent: pal.Path(),
+		Filter: mc.filter.expression(pal),
+	}
+	for _, field := range fields {
+		req.Aggregations = append(req.Aggregations, &migrationcenterpb.Aggregation{
+			Field:               field,
+			AggregationFunction: &migrationcenterpb.Aggregation_Sum_{Sum: &migrationcenterpb.Aggregation_Sum{}},
+		})
+	}
+	resp, err := mc.client.AggregateAssetsValues(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := map[string]float64{}
+	for i, result := range resp.Results {
+		field := result.Field
+		if field == "" && i < len(fields) {
+			field = fields[i]
+		}
+		res[field] = result.GetSum().GetValue()
+	}
+	return res, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/gapiutil"
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/mcutil"
+)
+
+const (
+	// watermarkTableSuffix is the suffix of the table that stores the time
+	// the objects of every scope were listed by the previous incremental
+	// export, the table prefix is added to it.
+	watermarkTableSuffix = "_mc2bq_watermarks"
+	// watermarkSkew is subtracted from the watermark to allow for the
+	// difference between the local clock and the clock of Migration Center.
+	watermarkSkew = 10 * time.Minute
+)
+
+// watermarkSchema is the schema of the watermark table, it has a row for every
+// incremental table and scope.
+var watermarkSchema = bigquery.Schema{
+	{Name: "table_name", Type: bigquery.StringFieldType, Required: true},
+	{Name: "project_id", Type: bigquery.StringFieldType, Required: true},
+	{Name: "location", Type: bigquery.StringFieldType, Required: true},
+	{Name: "listing_time", Type: bigquery.TimestampFieldType, Required: true},
+}
+
+// loadWatermarks returns the time the objects of every scope of table were
+// listed by the previous export, it's empty if the watermark table doesn't
+// exist.
+func loadWatermarks(ctx context.Context, bq *bigquery.Client, tbl *bigquery.Table, table string) (map[mcutil.ProjectAndLocation]time.Time, error) {
+	res := map[mcutil.ProjectAndLocation]time.Time{}
+	_, err := tbl.Metadata(ctx)
+	if gapiutil.IsErrorWithCode(err, http.StatusNotFound) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read watermarks: %w", err)
+	}
+
+	q := bq.Query(watermarkQuery(sqlTableName(tbl)))
+	q.Parameters = []bigquery.QueryParameter{{Name: "table_name", Value: table}}
+	rows, err := q.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("read watermarks: %w", err)
+	}
+	for {
+		var row []bigquery.Value
+		err := rows.Next(&row)
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read watermarks: %w", err)
+		}
+
+		project, _ := row[0].(string)
+		location, _ := row[1].(string)
+		listingTime, _ := row[2].(time.Time)
+		res[mcutil.ProjectAndLocation{Project: project, Location: location}] = listingTime
+	}
+	return res, nil
+}
+
+// createWatermarkTable creates the watermark table if it doesn't exist, it
+// can't be created in the transaction that saves the watermarks.
+func createWatermarkTable(ctx context.Context, tbl *bigquery.Table) error {
+	err := tbl.Create(ctx, &bigquery.TableMetadata{Schema: watermarkSchema})
+	err = gapiutil.IgnoreErrorWithCode(err, http.StatusConflict)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", tbl.TableID, err)
+	}
+	return nil
+}
+
+// saveWatermark records in tx that the objects of pal that are exported to
+// table were listed at listingTime.
+func saveWatermark(ctx context.Context, tx *transaction, tbl *bigquery.Table, table string, pal mcutil.ProjectAndLocation, listingTime time.Time) error {
+	_, err := tx.run(ctx, saveWatermarkQuery(sqlTableName(tbl)), []bigquery.QueryParameter{
+		{Name: "table_name", Value: table},
+		{Name: "project_id", Value: pal.Project},
+		{Name: "location", Value: pal.Location},
+		{Name: "listing_time", Value: listingTime},
+	})
+	if err != nil {
+		return fmt.Errorf("save watermark of %s: %w", table, err)
+	}
+	return nil
+}
+
+// watermarkQuery returns a query that selects the watermarks of the table
+// named by the table_name parameter.
+func watermarkQuery(watermarks string) string {
+	return fmt.Sprintf("SELECT project_id, location, listing_time FROM %s WHERE table_name = @table_name", watermarks)
+}
+
+// saveWatermarkQuery returns a statement that sets the watermark of a table
+// and scope to the values of the parameters.
+func saveWatermarkQuery(watermarks string) string {
+	return fmt.Sprintf("MERGE %s AS target "+
+		"USING (SELECT @table_name AS table_name, @project_id AS project_id, @location AS location, @listing_time AS listing_time) AS source "+
+		"ON target.table_name = source.table_name AND target.project_id = source.project_id AND target.location = source.location "+
+		"WHEN MATCHED THEN UPDATE SET listing_time = source.listing_time "+
+		"WHEN NOT MATCHED THEN INSERT (table_name, project_id, location, listing_time) "+
+		"VALUES (source.table_name, source.project_id, source.location, source.listing_time)", watermarks)
+}
@@ -16,7 +16,9 @@ package mcutil
 
 import (
 	"context"
+	"io"
 	"path"
+	"time"
 
 	"cloud.google.com/go/bigquery"
 )
@@ -43,17 +45,37 @@ func (p *SourcePath) String() string {
 	return path.Join(p.ProjectAndLocation.String(), "sources", p.SourceID)
 }
 
-// ObjectSource is a source of objects that can be
+// ObjectSource is a source of objects, reading from it returns the objects as
+// newline delimited JSON that matches the schema.
 type ObjectSource interface {
-	bigquery.LoadSource
+	io.Reader
 
+	// Schema is the schema of the table the objects are exported to.
+	Schema() bigquery.Schema
 	ObjectsRead() uint64
 	BytesRead() uint64
 }
 
 type MC interface {
+	// Locations lists the ids of the locations where Migration Center is available for project.
+	Locations(ctx context.Context, project string) ([]string, error)
 	AssetCount(ctx context.Context, pal ProjectAndLocation) (int64, error)
+	// AssetSums returns the sums of numeric asset fields over the same
+	// assets as AssetCount, the fields are dotted paths such as
+	// machine_details.core_count.
+	AssetSums(ctx context.Context, pal ProjectAndLocation, fields []string) (map[string]float64, error)
 	AssetSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	// UpdatedAssetSource is the same as AssetSource but only returns the assets that were updated at or after since.
+	UpdatedAssetSource(ctx context.Context, pal ProjectAndLocation, since time.Time) ObjectSource
+	// AssetNameSource returns only the names of the assets.
+	AssetNameSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
 	GroupSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
 	PreferenceSetSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	ErrorFrameSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	ReportConfigSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	ReportSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	ReportSummarySource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	SourceSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	ImportJobSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
+	ImportDataFileSource(ctx context.Context, pal ProjectAndLocation) ObjectSource
 }
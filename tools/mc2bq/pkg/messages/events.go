@@ -0,0 +1,249 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventSchemaVersion is the version of the JSON events, it's incremented when
+// a field of an event is removed or changes meaning. Adding events and fields
+// doesn't change the version.
+const EventSchemaVersion = 1
+
+// Output is the format of the messages that are printed.
+type Output string
+
+const (
+	// OutputText prints the messages as text.
+	OutputText Output = "text"
+	// OutputJSON prints every message as an Event on a line of its own
+	// (newline delimited JSON), errors are printed to stdout too.
+	OutputJSON Output = "json"
+)
+
+// ParseOutput parses an output format, the empty string is OutputText.
+func ParseOutput(name string) (Output, error) {
+	if name == "" {
+		return OutputText, nil
+	}
+	output := Output(strings.ToLower(name))
+	switch output {
+	case OutputText, OutputJSON:
+		return output, nil
+	}
+
+	return "", NewError(InvalidOutput{Output: name})
+}
+
+// Event levels.
+const (
+	LevelInfo    = "info"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Event is the JSON representation of a message.
+type Event struct {
+	SchemaVersion int       `json:"schema_version"`
+	Time          time.Time `json:"time"`
+	// Type identifies the message, e.g. table_progress.
+	Type  string `json:"type"`
+	Level string `json:"level"`
+	// Message is the text of the message.
+	Message string `json:"message"`
+	// Data are the fields of the message, their names are stable.
+	Data any `json:"data,omitempty"`
+}
+
+// eventMessage is implemented by the messages that have an event type,
+// the other messages are events of type message.
+type eventMessage interface {
+	Message
+	eventType() string
+}
+
+// leveledMessage is implemented by the messages that aren't LevelInfo.
+type leveledMessage interface {
+	eventLevel() string
+}
+
+// dataMessage is implemented by the messages whose fields can't be encoded
+// as JSON directly, e.g. because they contain errors.
+type dataMessage interface {
+	eventData() any
+}
+
+// NewEvent returns the event of msg at time t.
+func NewEvent(msg Message, t time.Time) Event {
+	ev := Event{
+		SchemaVersion: EventSchemaVersion,
+		Time:          t.UTC(),
+		Type:          "message",
+		Level:         LevelInfo,
+		Message:       msg.String(),
+	}
+	if m, ok := msg.(eventMessage); ok {
+		ev.Type = m.eventType()
+		ev.Data = msg
+	}
+	if m, ok := msg.(SimpleMessage); ok && simpleEventTypes[m] != "" {
+		ev.Type = simpleEventTypes[m]
+	}
+	if m, ok := msg.(leveledMessage); ok {
+		ev.Level = m.eventLevel()
+	}
+	if m, ok := msg.(dataMessage); ok {
+		ev.Data = m.eventData()
+	}
+	return ev
+}
+
+var (
+	printMu     sync.Mutex
+	printOutput           = OutputText
+	stdout      io.Writer = os.Stdout
+	stderr      io.Writer = os.Stderr
+)
+
+// SetOutput sets the format of the messages printed by Print and PrintError.
+func SetOutput(output Output) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	printOutput = output
+}
+
+// Print prints msg to stdout in the format set by SetOutput.
+func Print(msg Message) {
+	printMu.Lock()
+	defer printMu.Unlock()
+	if printOutput != OutputJSON {
+		fmt.Fprintln(stdout, msg)
+		return
+	}
+
+	data, err := json.Marshal(NewEvent(msg, time.Now()))
+	if err != nil {
+		// Fall back to the text of the message, it's still an event.
+		data, _ = json.Marshal(NewEvent(SimpleMessage(msg.String()), time.Now()))
+	}
+	stdout.Write(append(data, '\n'))
+}
+
+// PrintError prints err, which made the tool fail. It's printed to stderr as
+// text and as an event of type error to stdout as JSON.
+func PrintError(err error) {
+	printMu.Lock()
+	output := printOutput
+	printMu.Unlock()
+	if output != OutputJSON {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return
+	}
+
+	Print(Error{Err: err})
+}
+
+// Error is the message of an error that made the tool fail.
+type Error struct {
+	Err error
+}
+
+// String implements the String method that is part of the Message interface
+func (msg Error) String() string {
+	return msg.Err.Error()
+}
+
+func (Error) eventLevel() string { return LevelError }
+
+func (msg Error) eventData() any {
+	return struct {
+		Error string `json:"error"`
+	}{msg.Err.Error()}
+}
+
+// The event types of the messages, they are part of the event schema.
+func (Error) eventType() string                      { return "error" }
+func (ExportCreatingDataset) eventType() string      { return "dataset_create" }
+func (ExportUpdatingDataset) eventType() string      { return "dataset_update" }
+func (ExportFoundRegion) eventType() string          { return "region_found" }
+func (ExportingDataToTable) eventType() string       { return "table_start" }
+func (ExportUpdatingTableLayout) eventType() string  { return "table_layout_update" }
+func (ExportTableIncremental) eventType() string     { return "table_incremental" }
+func (ExportTableInProgress) eventType() string      { return "table_progress" }
+func (ExportTableComplete) eventType() string        { return "table_complete" }
+func (ExportTableDeletedRecords) eventType() string  { return "table_deleted_records" }
+func (ExportTableAlreadyExported) eventType() string { return "table_already_exported" }
+func (ExportResuming) eventType() string             { return "export_resume" }
+func (ExportPromotingTables) eventType() string      { return "tables_promote" }
+func (ExportProjectFailed) eventType() string        { return "project_failed" }
+func (ExportComplete) eventType() string             { return "export_complete" }
+func (ExportFilesComplete) eventType() string        { return "files_complete" }
+func (ExportRunNotRecorded) eventType() string       { return "run_not_recorded" }
+func (TelemetryNotFlushed) eventType() string        { return "telemetry_not_flushed" }
+func (ReconcileStarted) eventType() string           { return "reconcile_start" }
+func (ReconcileFieldSkipped) eventType() string      { return "reconcile_field_skipped" }
+func (ReconcileCheck) eventType() string             { return "reconcile_check" }
+func (ReconcileWarning) eventType() string           { return "reconcile_warning" }
+func (DryRunDataset) eventType() string              { return "dry_run_dataset" }
+func (DryRunDatasetDifference) eventType() string    { return "dry_run_dataset_difference" }
+func (DryRunTable) eventType() string                { return "dry_run_table" }
+func (SchemaDifference) eventType() string           { return "dry_run_schema_difference" }
+func (DryRunLayoutDifference) eventType() string     { return "dry_run_layout_difference" }
+
+// simpleEventTypes are the event types of the simple messages that have one.
+var simpleEventTypes = map[SimpleMessage]string{
+	ExportCanResume:      "export_can_resume",
+	ExportNoCheckpoint:   "export_no_checkpoint",
+	DryRunNothingWritten: "dry_run",
+}
+
+func (ExportProjectFailed) eventLevel() string   { return LevelError }
+func (ExportRunNotRecorded) eventLevel() string  { return LevelWarning }
+func (ReconcileFieldSkipped) eventLevel() string { return LevelWarning }
+func (TelemetryNotFlushed) eventLevel() string   { return LevelWarning }
+func (ReconcileWarning) eventLevel() string      { return LevelWarning }
+
+func (msg ReconcileCheck) eventLevel() string {
+	if msg.Passed {
+		return LevelInfo
+	}
+	return LevelWarning
+}
+
+func (msg ExportProjectFailed) eventData() any {
+	return struct {
+		ProjectID string `json:"project_id"`
+		Error     string `json:"error"`
+	}{msg.ProjectID, msg.Err.Error()}
+}
+
+func (msg ExportRunNotRecorded) eventData() any {
+	return struct {
+		Error string `json:"error"`
+	}{msg.Err.Error()}
+}
+
+func (msg TelemetryNotFlushed) eventData() any {
+	return struct {
+		Error string `json:"error"`
+	}{msg.Err.Error()}
+}
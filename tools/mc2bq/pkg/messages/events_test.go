@@ -0,0 +1,140 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package messages
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOutput(t *testing.T) {
+	for name, want := range map[string]Output{"": OutputText, "text": OutputText, "JSON": OutputJSON} {
+		got, err := ParseOutput(name)
+		if err != nil || got != want {
+			t.Errorf("ParseOutput(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseOutput("yaml"); err == nil {
+		t.Errorf("ParseOutput(%q): expected an error", "yaml")
+	}
+}
+
+// eventJSON returns the JSON encoding of the event of msg decoded as a map,
+// which is what the consumers of the events see.
+func eventJSON(t *testing.T, msg Message) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(NewEvent(msg, time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)))
+	if err != nil {
+		t.Fatalf("json.Marshal(): unexpected error: %v", err)
+	}
+	var res map[string]any
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestNewEvent(t *testing.T) {
+	tCases := []struct {
+		name string
+		msg  Message
+		want map[string]any
+	}{
+		{
+			name: "progress",
+			msg:  ExportTableInProgress{TableName: "assets", RecordsTransferred: 5, RecordCount: 10, BytesTransferred: 100},
+			want: map[string]any{
+				"type":  "table_progress",
+				"level": LevelInfo,
+				"data": map[string]any{
+					"table_name":          "assets",
+					"project_id":          "",
+					"location":            "",
+					"records_transferred": 5.0,
+					"record_count":        10.0,
+					"bytes_transferred":   100.0,
+				},
+			},
+		},
+		{
+			name: "simple message with a type",
+			msg:  ExportCanResume,
+			want: map[string]any{"type": "export_can_resume", "level": LevelInfo},
+		},
+		{
+			name: "simple message",
+			msg:  ExportSuccess,
+			want: map[string]any{"type": "message", "level": LevelInfo},
+		},
+		{
+			name: "warning with an error",
+			msg:  ExportRunNotRecorded{Err: errors.New("denied")},
+			want: map[string]any{"type": "run_not_recorded", "level": LevelWarning, "data": map[string]any{"error": "denied"}},
+		},
+	}
+	for _, tCase := range tCases {
+		t.Run(tCase.name, func(t *testing.T) {
+			tCase.want["schema_version"] = float64(EventSchemaVersion)
+			tCase.want["time"] = "2023-10-01T12:00:00Z"
+			tCase.want["message"] = tCase.msg.String()
+			if diff := cmp.Diff(tCase.want, eventJSON(t, tCase.msg)); diff != "" {
+				t.Errorf("NewEvent(): unexpected event (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPrint(t *testing.T) {
+	var out, errOut bytes.Buffer
+	defer func(w, errW io.Writer) { stdout, stderr = w, errW }(stdout, stderr)
+	defer SetOutput(OutputText)
+	stdout, stderr = &out, &errOut
+
+	Print(ExportCreatingDataset{DatasetID: "dataset"})
+	PrintError(errors.New("failed"))
+	if want := (ExportCreatingDataset{DatasetID: "dataset"}).String() + "\n"; out.String() != want {
+		t.Errorf("Print() with text output wrote %q, want %q", out.String(), want)
+	}
+	if errOut.String() != "failed\n" {
+		t.Errorf("PrintError() with text output wrote %q to stderr, want %q", errOut.String(), "failed\n")
+	}
+
+	out.Reset()
+	errOut.Reset()
+	SetOutput(OutputJSON)
+	Print(ExportCreatingDataset{DatasetID: "dataset"})
+	PrintError(errors.New("failed"))
+	if errOut.Len() != 0 {
+		t.Errorf("PrintError() with JSON output wrote %q to stderr, want nothing", errOut.String())
+	}
+	var types []string
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		var ev Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("Print() with JSON output wrote %q: %v", line, err)
+		}
+		types = append(types, ev.Type+"/"+ev.Level)
+	}
+	if diff := cmp.Diff([]string{"dataset_create/info", "error/error"}, types); diff != "" {
+		t.Errorf("Print() with JSON output: unexpected events (-want, +got):\n%s", diff)
+	}
+}
@@ -19,6 +19,10 @@ package messages
 import (
 	"errors"
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Version is the current version of the tool
@@ -50,18 +54,80 @@ const (
     PROJECT         Project you want to export Migration Center data from. (env: MC2BQ_PROJECT)
     DATASET         Dataset that will be used to store the tables in BigQuery. If a data set with that name does not exist, one will be created. (env: MC2BQ_DATASET)
     TABLE-PREFIX    A prefix to add to the table names, this can be done to store multiple exported tables in the same data set. (env: MC2BQ_TABLE_PREFIX)`
-	ParamDescriptionTargetProject SimpleMessage = "target project where the data should be exported to, if not set the project that contains the migration center data will be used. (env: MC2BQ_TARGET_PROJECT)"
-	ParamDescriptionForce         SimpleMessage = "force the export of the data even if the destination table exists, the operation will delete all the content in the original table. (env: MC2BQ_FORCE)"
-	ParamDescriptionSchemaPath    SimpleMessage = "use the schema at the specified path instead of using the embedded schema. (env: MC2BQ_SCHEMA_PATH)"
-	ParamDescriptionRegion        SimpleMessage = "migration center region. (env: MC2BQ_REGION)"
-	ParamDescriptionVersion       SimpleMessage = "print the version and exit."
-	ParamDescriptionDumpSchema    SimpleMessage = "write the schema file embedded in the current version to stdout."
-	ExportSuccess                 SimpleMessage = "Data exported successfully"
-	ErrMsgExportTableExists       SimpleMessage = "table already exists, use --force to force the data to be overwritten"
-	ErrorExportingData            SimpleMessage = "error exporting data"
-	ErrorLoadingSchema            SimpleMessage = "error loading schema"
-	ErrorParsingFlags             SimpleMessage = "error parsing flags"
-	ErrorInvalidSchema            SimpleMessage = "invaliad schema"
+	ParamDescriptionTargetProject      SimpleMessage = "target project where the data should be exported to, if not set the project that contains the migration center data (or the first project when exporting multiple projects) will be used. (env: MC2BQ_TARGET_PROJECT)"
+	ParamDescriptionForce              SimpleMessage = "force the export of the data even if the destination table exists, the operation will delete all the content in the original table. (env: MC2BQ_FORCE)"
+	ParamDescriptionSchemaPath         SimpleMessage = "use the schema at the specified path instead of using the embedded schema. (env: MC2BQ_SCHEMA_PATH)"
+	ParamDescriptionRegion             SimpleMessage = "migration center region. (env: MC2BQ_REGION)"
+	ParamDescriptionAllRegions         SimpleMessage = "export the data from all the regions that contain Migration Center data, the region of every record is stored in the location column. (env: MC2BQ_ALL_REGIONS)"
+	ParamDescriptionProject            SimpleMessage = "project to export Migration Center data from, can be repeated to export multiple projects to the same dataset. When set the PROJECT argument must be omitted and the project of every record is stored in the project_id column. (env: MC2BQ_PROJECTS, comma separated)"
+	ParamDescriptionProjectsFile       SimpleMessage = "path to a file with the projects to export, one project per line. Behaves as if every project was passed with -project. (env: MC2BQ_PROJECTS_FILE)"
+	ParamDescriptionAssetShards        SimpleMessage = "number of requests that list the assets of a project and region concurrently, the assets are split into ranges of creation time. Set it to more than 1 to speed up the export of very large inventories, sharded listing can't be resumed in chunks. (env: MC2BQ_ASSET_SHARDS)"
+	ParamDescriptionAssetFilter        SimpleMessage = "Migration Center filter expression that selects the assets to export, e.g. 'labels.team = \"payments\"'. It's combined with -group, -label and -source, the groups and preference sets are narrowed to the matching assets. (env: MC2BQ_ASSET_FILTER)"
+	ParamDescriptionAssetFields        SimpleMessage = "comma separated list of the asset fields to export as dotted paths, e.g. name,machine_details.core_count. Selecting a record exports all its nested fields and the name of the assets is always exported. If not set all the fields are exported. (env: MC2BQ_ASSET_FIELDS)"
+	ParamDescriptionGroup              SimpleMessage = "ID or name of a group whose assets are exported, can be repeated to export the assets of any of the groups. Only the named groups are exported. (env: MC2BQ_GROUPS, comma separated)"
+	ParamDescriptionLabel              SimpleMessage = "label of the assets to export as key=value, can be repeated to export the assets that have all the labels. (env: MC2BQ_LABELS, comma separated)"
+	ParamDescriptionSource             SimpleMessage = "ID or name of a source whose assets are exported, can be repeated to export the assets of any of the sources. (env: MC2BQ_SOURCES, comma separated)"
+	ParamDescriptionDatasetLocation    SimpleMessage = "location of the BigQuery dataset, e.g. europe-west1 or EU. If not set a new dataset is created in the default location of BigQuery. The export fails if the dataset already exists in another location. (env: MC2BQ_DATASET_LOCATION)"
+	ParamDescriptionKMSKey             SimpleMessage = "name of the Cloud KMS key that encrypts the tables of the dataset by default (CMEK), projects/<PROJECT>/locations/<LOCATION>/keyRings/<RING>/cryptoKeys/<KEY>. (env: MC2BQ_KMS_KEY)"
+	ParamDescriptionDatasetLabel       SimpleMessage = "label of the dataset as key=value, can be repeated. (env: MC2BQ_DATASET_LABELS, comma separated)"
+	ParamDescriptionTableLabel         SimpleMessage = "label of the exported tables as key=value, can be repeated. (env: MC2BQ_TABLE_LABELS, comma separated)"
+	ParamDescriptionUpdateDataset      SimpleMessage = "update the default KMS key, the labels and the default table expiration of an existing dataset to -kms-key, -dataset-label and -table-expiration-days. If not set the export fails if they differ. (env: MC2BQ_UPDATE_DATASET)"
+	ParamDescriptionTableExpiration    SimpleMessage = "default number of days the tables of the dataset are kept for, tables that are older are deleted by BigQuery. If not set tables never expire. (env: MC2BQ_TABLE_EXPIRATION_DAYS)"
+	ParamDescriptionTableLayout        SimpleMessage = "path to a JSON file with the partitioning and clustering of the BigQuery tables, keyed by table name without the prefix. The layout is applied when a table is created and checked on every export. (env: MC2BQ_TABLE_LAYOUT)"
+	ParamDescriptionProjectConcurrency SimpleMessage = "maximum number of projects that are exported concurrently. (env: MC2BQ_PROJECT_CONCURRENCY)"
+	ParamDescriptionMode               SimpleMessage = "how tables that already exist are updated, one of full, incremental or snapshot. full replaces the tables, incremental merges the assets that were updated since the previous export into the assets table and sets the delete_time column of assets that no longer exist, snapshot appends the data to the tables with the time of the export in the export_time column. (env: MC2BQ_MODE)"
+	ParamDescriptionSnapshotRetention  SimpleMessage = "number of days snapshots are kept for in snapshot mode, older snapshots are deleted. If not set snapshots are kept forever. (env: MC2BQ_SNAPSHOT_RETENTION_DAYS)"
+	ParamDescriptionResume             SimpleMessage = "resume the previous export if it didn't complete, the data that was already exported is not exported again. The other flags must be the same as in the previous export. (env: MC2BQ_RESUME)"
+	ParamDescriptionOutputDir          SimpleMessage = "write the data to files in the specified directory instead of BigQuery, the DATASET argument must be omitted. A manifest.json file with the row count and SHA-256 checksum of every file is written after all the files. (env: MC2BQ_OUTPUT_DIR)"
+	ParamDescriptionOutputFormat       SimpleMessage = "format of the files written to the output directory, one of ndjson, csv, parquet or avro. (env: MC2BQ_OUTPUT_FORMAT)"
+	ParamDescriptionWriteMethod        SimpleMessage = "how the data is written to BigQuery, either load or storage-write. load uses load jobs, storage-write streams the rows with the Storage Write API and commits the rows of every table at once. Exports that use storage-write can't be resumed. (env: MC2BQ_WRITE_METHOD)"
+	ParamDescriptionOutputDB           SimpleMessage = "write the data to a PostgreSQL (postgres://...) or SQLite (sqlite:<FILE>) database instead of BigQuery, the DATASET argument must be omitted. Repeated fields are written to child tables. (env: MC2BQ_OUTPUT_DB)"
+	ParamDescriptionAssetView          SimpleMessage = "the view used to list assets, either basic or full. The basic view doesn't include performance data and insights. (env: MC2BQ_ASSET_VIEW)"
+	ParamDescriptionDryRun             SimpleMessage = "print what the export would do without writing anything to BigQuery: whether the dataset and the tables exist, the number and estimated size of the assets, groups and preference sets, and the schema differences of the existing tables. (env: MC2BQ_DRY_RUN)"
+	ParamDescriptionReconcile          SimpleMessage = "before the tables are committed compare the number of rows of the assets table and the sums of -reconcile-fields with the values Migration Center aggregates over the same assets, one of none, warn or fail. warn prints a warning and fail fails the export without changing the tables if a value differs by more than -reconcile-tolerance, the results are appended to the _mc2bq_validation table. (env: MC2BQ_RECONCILE)"
+	ParamDescriptionReconcileTolerance SimpleMessage = "largest difference between a value in BigQuery and in Migration Center that passes reconciliation, in percent of the value in Migration Center. (env: MC2BQ_RECONCILE_TOLERANCE)"
+	ParamDescriptionReconcileFields    SimpleMessage = "comma separated list of the numeric asset fields that are summed by reconciliation as dotted paths. Fields that aren't exported are skipped. (env: MC2BQ_RECONCILE_FIELDS, default machine_details.core_count,machine_details.memory_mb)"
+	ParamDescriptionOutput             SimpleMessage = "format of the messages printed to stdout, either text or json. json prints every message as a JSON event on a line of its own, with a stable type, the fields of the message and the schema_version of the events, errors are printed as events of type error. (env: MC2BQ_OUTPUT)"
+	ParamDescriptionTracesExporter     SimpleMessage = "where the OpenTelemetry spans of the export are sent, one of none, stdout or otlp. otlp is configured with the standard OTEL_EXPORTER_OTLP_* environment variables. (env: MC2BQ_TRACES_EXPORTER)"
+	ParamDescriptionMetricsExporter    SimpleMessage = "where the OpenTelemetry metrics of the export are sent, one of none, stdout or otlp. otlp is configured with the standard OTEL_EXPORTER_OTLP_* environment variables. (env: MC2BQ_METRICS_EXPORTER)"
+	ParamDescriptionVersion            SimpleMessage = "print the version and exit."
+	ParamDescriptionDumpSchema         SimpleMessage = "write the schema file embedded in the current version to stdout."
+	DescribeSchemaCmdDescription       SimpleMessage = "Print a data dictionary of the tables and columns of the schema, the columns are described by the comments of the Migration Center API."
+	ParamDescriptionDictionaryFormat   SimpleMessage = "format of the data dictionary, either markdown or html."
+	DryRunNothingWritten               SimpleMessage = "Dry run, nothing will be written to BigQuery."
+	DryRunActionCreate                 SimpleMessage = "would be created"
+	DryRunActionReplace                SimpleMessage = "would be replaced"
+	DryRunActionAppend                 SimpleMessage = "exists, a snapshot would be appended"
+	DryRunActionMerge                  SimpleMessage = "exists, the updated assets would be merged and the deleted assets marked"
+	DryRunActionExists                 SimpleMessage = "already exists, the export would fail without --force"
+	DryRunActionNotSnapshot            SimpleMessage = "already exists and doesn't contain snapshots, the export would fail"
+	ExportSuccess                      SimpleMessage = "Data exported successfully"
+	ErrMsgExportTableExists            SimpleMessage = "table already exists, use --force to force the data to be overwritten"
+	ErrMsgIncrementalMissingUpdateTime SimpleMessage = "the assets table must have an update_time column to export incrementally"
+	ErrMsgExportTableNotSnapshot       SimpleMessage = "table already exists and doesn't contain snapshots, delete the table to export snapshots to it"
+	ErrMsgResumeIncremental            SimpleMessage = "resuming an export is not supported in incremental mode"
+	ErrMsgStagingTableExpired          SimpleMessage = "the staging table of the resumed export no longer exists, run the export without --resume"
+	ErrMsgExportFileExists             SimpleMessage = "the output directory already contains an export, use --force to force the files to be overwritten"
+	ErrMsgOutputDirUnsupported         SimpleMessage = "incremental mode and --resume are not supported when exporting to files"
+	ErrMsgResumeStorageWrite           SimpleMessage = "resuming an export is not supported with the storage-write method"
+	ErrMsgOutputDBUnsupported          SimpleMessage = "only full mode without --resume is supported when exporting to a database"
+	ErrMsgOutputConflict               SimpleMessage = "--output-dir and --output-db can't be used together"
+	ErrMsgLayoutTooManyClustering      SimpleMessage = "at most 4 clustering columns can be used"
+	ErrMsgLayoutFilterNoPartition      SimpleMessage = "require_partition_filter can only be used with partitioning"
+	ErrMsgLayoutSnapshotPartition      SimpleMessage = "tables are partitioned by day on export_time in snapshot mode"
+	ErrMsgLayoutFilterUnsupported      SimpleMessage = "require_partition_filter can't be used in incremental or snapshot mode because their queries read the whole table"
+	ErrMsgDryRunUnsupported            SimpleMessage = "--dry-run is only supported when exporting to BigQuery"
+	ErrMsgReconcileUnsupported         SimpleMessage = "--reconcile is only supported when exporting to BigQuery"
+	ErrMsgReconcileTolerance           SimpleMessage = "the reconcile tolerance can't be negative"
+	ErrMsgNoRegionsWithData            SimpleMessage = "no region contains Migration Center data"
+	ErrorExportingData                 SimpleMessage = "error exporting data"
+	ErrorLoadingSchema                 SimpleMessage = "error loading schema"
+	ExportCanResume                    SimpleMessage = "The export didn't complete, run it again with --resume to continue from where it stopped."
+	ExportNoCheckpoint                 SimpleMessage = "No export to resume was found, starting a new export."
+	ErrorParsingFlags                  SimpleMessage = "error parsing flags"
+	ErrorLoadingTableLayout            SimpleMessage = "error loading table layout"
+	ErrorLoadingProjects               SimpleMessage = "error loading projects file"
+	ErrorInvalidSchema                 SimpleMessage = "invaliad schema"
 )
 
 // MissingSchemaKey represents the message that is displayed when a required
@@ -75,10 +141,310 @@ func (msg MissingSchemaKey) String() string {
 	return fmt.Sprintf("missing required key `%s` in schema", msg.Key)
 }
 
+// InvalidMode represents the message that is displayed when an unknown
+// export mode is requested
+type InvalidMode struct {
+	Mode string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidMode) String() string {
+	return fmt.Sprintf("invalid mode %q, must be one of full, incremental or snapshot", msg.Mode)
+}
+
+// InvalidReconcileMode represents the message that is displayed when an
+// unknown reconcile mode is requested
+type InvalidReconcileMode struct {
+	Mode string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidReconcileMode) String() string {
+	return fmt.Sprintf("invalid reconcile mode %q, must be one of none, warn or fail", msg.Mode)
+}
+
+// InvalidOutput represents the message that is displayed when an unknown
+// format of the messages is requested
+type InvalidOutput struct {
+	Output string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidOutput) String() string {
+	return fmt.Sprintf("invalid output %q, must be either text or json", msg.Output)
+}
+
+// InvalidTelemetryExporter represents the message that is displayed when an
+// unknown OpenTelemetry exporter is requested
+type InvalidTelemetryExporter struct {
+	Exporter string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidTelemetryExporter) String() string {
+	return fmt.Sprintf("invalid telemetry exporter %q, must be one of none, stdout or otlp", msg.Exporter)
+}
+
+// InvalidOutputFormat represents the message that is displayed when an
+// unknown output format is requested
+type InvalidOutputFormat struct {
+	Format string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidOutputFormat) String() string {
+	return fmt.Sprintf("invalid output format %q, must be one of ndjson, csv, parquet or avro", msg.Format)
+}
+
+// InvalidWriteMethod represents the message that is displayed when an unknown
+// write method is requested
+type InvalidWriteMethod struct {
+	Method string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidWriteMethod) String() string {
+	return fmt.Sprintf("invalid write method %q, must be either load or storage-write", msg.Method)
+}
+
+// InvalidOutputDB represents the message that is displayed when the database
+// URL has an unsupported scheme, the URL itself isn't displayed because it
+// may contain a password
+type InvalidOutputDB struct {
+	Scheme string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidOutputDB) String() string {
+	return fmt.Sprintf("unsupported database URL scheme %q, must be postgres:// or sqlite:", msg.Scheme)
+}
+
+// UnknownLayoutTable represents the message that is displayed when the table
+// layout configures a table that isn't exported
+type UnknownLayoutTable struct {
+	Table  string
+	Tables []string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg UnknownLayoutTable) String() string {
+	return fmt.Sprintf("table layout of unknown table %q, must be one of %s", msg.Table, strings.Join(msg.Tables, ", "))
+}
+
+// InvalidTableLayout represents the message that is displayed when the layout
+// of a table can't be applied to the table
+type InvalidTableLayout struct {
+	Table  string
+	Reason Message
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidTableLayout) String() string {
+	return fmt.Sprintf("invalid layout of table %s: %s", msg.Table, msg.Reason)
+}
+
+// LayoutColumnNotFound is the reason of InvalidTableLayout when a column
+// doesn't exist
+type LayoutColumnNotFound struct {
+	Column string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg LayoutColumnNotFound) String() string {
+	return fmt.Sprintf("column %s doesn't exist", msg.Column)
+}
+
+// LayoutInvalidPartitionColumn is the reason of InvalidTableLayout when a
+// column can't be used for partitioning
+type LayoutInvalidPartitionColumn struct {
+	Column string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg LayoutInvalidPartitionColumn) String() string {
+	return fmt.Sprintf("column %s can't be used for partitioning, it must be a top level TIMESTAMP or DATE column", msg.Column)
+}
+
+// LayoutInvalidClusteringColumn is the reason of InvalidTableLayout when a
+// column can't be used for clustering
+type LayoutInvalidClusteringColumn struct {
+	Column string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg LayoutInvalidClusteringColumn) String() string {
+	return fmt.Sprintf("column %s can't be used for clustering, BigQuery only clusters tables by top level columns that aren't repeated, records or floats", msg.Column)
+}
+
+// LayoutInvalidPartitionType is the reason of InvalidTableLayout when the
+// partitioning type is unknown
+type LayoutInvalidPartitionType struct {
+	Type string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg LayoutInvalidPartitionType) String() string {
+	return fmt.Sprintf("invalid partitioning type %q, must be one of DAY, HOUR, MONTH or YEAR", msg.Type)
+}
+
+// LayoutDifference is a property of a table that differs from the table
+// layout
+type LayoutDifference struct {
+	Property string `json:"property"`
+	Want     string `json:"want"`
+	Got      string `json:"got"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg LayoutDifference) String() string {
+	return fmt.Sprintf("%s is %s instead of %s", msg.Property, msg.Got, msg.Want)
+}
+
+// TableLayoutDrift represents the message that is displayed when the layout
+// of an existing table differs from the table layout and can't be changed
+type TableLayoutDrift struct {
+	TableName   string
+	Differences []LayoutDifference
+}
+
+// String implements the String method that is part of the Message interface
+func (msg TableLayoutDrift) String() string {
+	return fmt.Sprintf("the layout of table %s differs from the table layout (%s), the partitioning can't be changed and clustering can't be removed from an existing table. Delete the table to export it with the new layout",
+		msg.TableName, joinMessages(msg.Differences))
+}
+
+// TableSchemaIncompatible represents the message that is displayed when the
+// type of a column of an existing table differs from the schema
+type TableSchemaIncompatible struct {
+	TableName string
+	Column    string
+	Want      string
+	Got       string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg TableSchemaIncompatible) String() string {
+	return fmt.Sprintf("column %s of table %s is %s instead of %s, the type of a column can't be changed. Delete the table to export it with the new schema",
+		msg.Column, msg.TableName, msg.Got, msg.Want)
+}
+
+// ExportUpdatingTableLayout is the message that is displayed when the layout
+// of an existing table is updated to match the table layout
+type ExportUpdatingTableLayout struct {
+	TableName   string             `json:"table_name"`
+	Differences []LayoutDifference `json:"differences"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg ExportUpdatingTableLayout) String() string {
+	return fmt.Sprintf("Updating the layout of table %s, %s.", msg.TableName, joinMessages(msg.Differences))
+}
+
+// joinMessages joins msgs with commas.
+func joinMessages[T Message](msgs []T) string {
+	var res []string
+	for _, msg := range msgs {
+		res = append(res, msg.String())
+	}
+	return strings.Join(res, ", ")
+}
+
+// InvalidDictionaryFormat represents the message that is displayed when an
+// unknown data dictionary format is requested
+type InvalidDictionaryFormat struct {
+	Format string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidDictionaryFormat) String() string {
+	return fmt.Sprintf("invalid data dictionary format %q, must be either markdown or html", msg.Format)
+}
+
+// DatasetLocationMismatch represents the message that is displayed when the
+// dataset already exists in another location than the requested one
+type DatasetLocationMismatch struct {
+	DatasetID    string
+	Location     string
+	WantLocation string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg DatasetLocationMismatch) String() string {
+	return fmt.Sprintf("dataset %s already exists in location %s instead of %s, the location of a dataset can't be changed", msg.DatasetID, msg.Location, msg.WantLocation)
+}
+
+// DatasetSettingsMismatch represents the message that is displayed when the
+// settings of an existing dataset differ from the requested ones
+type DatasetSettingsMismatch struct {
+	DatasetID   string
+	Differences []LayoutDifference
+}
+
+// String implements the String method that is part of the Message interface
+func (msg DatasetSettingsMismatch) String() string {
+	return fmt.Sprintf("the settings of dataset %s differ from the requested ones (%s), use -update-dataset to update them", msg.DatasetID, joinMessages(msg.Differences))
+}
+
+// ExportUpdatingDataset is the message that is displayed when the settings of
+// an existing dataset are updated
+type ExportUpdatingDataset struct {
+	DatasetID   string             `json:"dataset_id"`
+	Differences []LayoutDifference `json:"differences"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg ExportUpdatingDataset) String() string {
+	return fmt.Sprintf("Updating the settings of dataset %s (%s)...", msg.DatasetID, joinMessages(msg.Differences))
+}
+
+// InvalidLabel represents the message that is displayed when a label filter
+// isn't in the key=value form
+type InvalidLabel struct {
+	Label string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidLabel) String() string {
+	return fmt.Sprintf("invalid label %q, must be key=value", msg.Label)
+}
+
+// UnknownAssetField represents the message that is displayed when a selected
+// asset field isn't a field of the Asset message
+type UnknownAssetField struct {
+	Path string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg UnknownAssetField) String() string {
+	return fmt.Sprintf("unknown asset field %q, the fields are dotted paths of the fields of the Asset message such as machine_details.core_count", msg.Path)
+}
+
+// AssetFieldNotInSchema represents the message that is displayed when a
+// selected asset field isn't a column of the assets table
+type AssetFieldNotInSchema struct {
+	Path string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg AssetFieldNotInSchema) String() string {
+	return fmt.Sprintf("asset field %q is not a column of the assets table in the schema", msg.Path)
+}
+
+// InvalidAssetView represents the message that is displayed when an unknown
+// asset view is requested
+type InvalidAssetView struct {
+	View string
+}
+
+// String implements the String method that is part of the Message interface
+func (msg InvalidAssetView) String() string {
+	return fmt.Sprintf("invalid asset view %q, must be either basic or full", msg.View)
+}
+
 // ExportCreatingDataset represents the message that is displayed when creating
 // a dataset
 type ExportCreatingDataset struct {
-	DatasetID string
+	DatasetID string `json:"dataset_id"`
 }
 
 // String implements the String method that is part of the Message interface
@@ -86,10 +452,23 @@ func (msg ExportCreatingDataset) String() string {
 	return fmt.Sprintf("Creating dataset %s...", msg.DatasetID)
 }
 
+// ExportFoundRegion represents the message that is displayed when a region
+// with data is found while exporting all regions
+type ExportFoundRegion struct {
+	ProjectID  string `json:"project_id"`
+	Region     string `json:"region"`
+	AssetCount uint64 `json:"asset_count"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg ExportFoundRegion) String() string {
+	return fmt.Sprintf("Found %d assets in region %s of project %s.", msg.AssetCount, msg.Region, msg.ProjectID)
+}
+
 // ExportingDataToTable represents the message that is displayed when exporting
 // data to a table
 type ExportingDataToTable struct {
-	TableName string
+	TableName string `json:"table_name"`
 }
 
 // String implements the String method that is part of the Message interface
@@ -97,6 +476,89 @@ func (msg ExportingDataToTable) String() string {
 	return fmt.Sprintf("Exporting data to table %s...", msg.TableName)
 }
 
+// DryRunDataset represents the message that is displayed by a dry run for
+// the dataset
+type DryRunDataset struct {
+	DatasetID string `json:"dataset_id"`
+	Exists    bool   `json:"exists"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg DryRunDataset) String() string {
+	if msg.Exists {
+		return fmt.Sprintf("Dataset %s exists.", msg.DatasetID)
+	}
+	return fmt.Sprintf("Dataset %s doesn't exist and would be created.", msg.DatasetID)
+}
+
+// DryRunTable represents the message that is displayed by a dry run for
+// every table
+type DryRunTable struct {
+	TableName string  `json:"table_name"`
+	Action    Message `json:"action"`
+	// Counted is set if the records of the table were counted.
+	Counted     bool   `json:"counted"`
+	RecordCount uint64 `json:"record_count"`
+	Bytes       uint64 `json:"bytes"`
+	// Estimated is set if Bytes is estimated from a sample of the records.
+	Estimated bool `json:"estimated"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg DryRunTable) String() string {
+	res := fmt.Sprintf("Table %s %s", msg.TableName, msg.Action)
+	switch {
+	case !msg.Counted:
+		return res + "."
+	case msg.Estimated:
+		return fmt.Sprintf("%s, %d records, about %s.", res, msg.RecordCount, formatDataAmount(msg.Bytes))
+	}
+	return fmt.Sprintf("%s, %d records, %s.", res, msg.RecordCount, formatDataAmount(msg.Bytes))
+}
+
+// SchemaDifference represents the message that is displayed by a dry run for
+// a column whose type in an existing table differs from the schema. Want is
+// empty if the column isn't in the schema, Got is empty if the column isn't
+// in the table
+type SchemaDifference struct {
+	Column string `json:"column"`
+	Want   string `json:"want"`
+	Got    string `json:"got"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg SchemaDifference) String() string {
+	switch {
+	case msg.Got == "":
+		return fmt.Sprintf("  + %s %s", msg.Column, msg.Want)
+	case msg.Want == "":
+		return fmt.Sprintf("  - %s %s", msg.Column, msg.Got)
+	}
+	return fmt.Sprintf("  ~ %s %s -> %s", msg.Column, msg.Got, msg.Want)
+}
+
+// DryRunLayoutDifference represents the message that is displayed by a dry
+// run when the layout of an existing table differs from the table layout
+type DryRunLayoutDifference struct {
+	Difference LayoutDifference `json:"difference"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg DryRunLayoutDifference) String() string {
+	return fmt.Sprintf("  layout: %s", msg.Difference)
+}
+
+// DryRunDatasetDifference represents the message that is displayed by a dry
+// run for every setting of the dataset that differs from the requested one
+type DryRunDatasetDifference struct {
+	Difference LayoutDifference `json:"difference"`
+}
+
+// String implements the String method that is part of the Message interface
+func (msg DryRunDatasetDifference) String() string {
+	return fmt.Sprintf("  dataset: %s", msg.Difference)
+}
+
 // NewError create an error from message
 func NewError(msg Message) error {
 	return errors.New(msg.String())
@@ -109,39 +571,237 @@ func WrapError(msg Message, err error) error {
 
 // ExportTableComplete is the message that is displayed when an export of a table completes
 type ExportTableComplete struct {
-	TableName        string
-	RecordCount      uint64
-	BytesTransferred uint64
+	TableName        string `json:"table_name"`
+	ProjectID        string `json:"project_id"`
+	Location         string `json:"location"`
+	RecordCount      uint64 `json:"record_count"`
+	BytesTransferred uint64 `json:"bytes_transferred"`
 }
 
 func (msg ExportTableComplete) String() string {
-	return fmt.Sprintf("Export of %s complete. %d records, %s transferred.", msg.TableName, msg.RecordCount, formatDataAmount(msg.BytesTransferred))
+	return fmt.Sprintf("Export of %s complete. %d records, %s transferred.", formatTableName(msg.TableName, msg.ProjectID, msg.Location), msg.RecordCount, formatDataAmount(msg.BytesTransferred))
 }
 
 // ExportTableInProgress is the message that is displayed when an exporting to a table
 type ExportTableInProgress struct {
-	TableName          string
-	RecordsTransferred uint64
-	RecordCount        uint64
-	BytesTransferred   uint64
+	TableName          string `json:"table_name"`
+	ProjectID          string `json:"project_id"`
+	Location           string `json:"location"`
+	RecordsTransferred uint64 `json:"records_transferred"`
+	RecordCount        uint64 `json:"record_count"`
+	BytesTransferred   uint64 `json:"bytes_transferred"`
 }
 
 func (msg ExportTableInProgress) String() string {
+	tableName := formatTableName(msg.TableName, msg.ProjectID, msg.Location)
 	if msg.RecordCount > 0 {
-		return fmt.Sprintf("Export of %s in progress. %d records of %d (%d%%), %s transferred.", msg.TableName, msg.RecordsTransferred, msg.RecordCount, (msg.RecordsTransferred*100)/msg.RecordCount, formatDataAmount(msg.BytesTransferred))
+		return fmt.Sprintf("Export of %s in progress. %d records of %d (%d%%), %s transferred.", tableName, msg.RecordsTransferred, msg.RecordCount, (msg.RecordsTransferred*100)/msg.RecordCount, formatDataAmount(msg.BytesTransferred))
 	}
-	return fmt.Sprintf("Export of %s in progress. %d records, %s transferred.", msg.TableName, msg.RecordsTransferred, formatDataAmount(msg.BytesTransferred))
+	return fmt.Sprintf("Export of %s in progress. %d records, %s transferred.", tableName, msg.RecordsTransferred, formatDataAmount(msg.BytesTransferred))
+}
+
+// ExportTableIncremental is the message that is displayed when only the
+// records that were updated since the previous export are exported
+type ExportTableIncremental struct {
+	TableName string    `json:"table_name"`
+	ProjectID string    `json:"project_id"`
+	Location  string    `json:"location"`
+	Since     time.Time `json:"since"`
+}
+
+func (msg ExportTableIncremental) String() string {
+	return fmt.Sprintf("Exporting records of %s updated since %s.", formatTableName(msg.TableName, msg.ProjectID, msg.Location), msg.Since.Format(time.RFC3339))
+}
+
+// ExportTableDeletedRecords is the message that is displayed after records
+// that no longer exist were marked as deleted
+type ExportTableDeletedRecords struct {
+	TableName   string `json:"table_name"`
+	ProjectID   string `json:"project_id"`
+	Location    string `json:"location"`
+	RecordCount int64  `json:"record_count"`
+}
+
+func (msg ExportTableDeletedRecords) String() string {
+	return fmt.Sprintf("Marked %d records of %s as deleted.", msg.RecordCount, formatTableName(msg.TableName, msg.ProjectID, msg.Location))
+}
+
+// ExportResuming is the message that is displayed when resuming an export
+type ExportResuming struct {
+	ExportTime time.Time `json:"export_time"`
+}
+
+func (msg ExportResuming) String() string {
+	return fmt.Sprintf("Resuming the export that started at %s.", msg.ExportTime.Format(time.RFC3339))
+}
+
+// ExportTableAlreadyExported is the message that is displayed when resuming
+// an export and the data of a table was already exported
+type ExportTableAlreadyExported struct {
+	TableName string `json:"table_name"`
+	ProjectID string `json:"project_id"`
+	Location  string `json:"location"`
+}
+
+func (msg ExportTableAlreadyExported) String() string {
+	return fmt.Sprintf("Export of %s was completed by the resumed export, skipping.", formatTableName(msg.TableName, msg.ProjectID, msg.Location))
+}
+
+// ExportPromotingTables is the message that is displayed when the data is
+// copied from the staging tables to the exported tables
+type ExportPromotingTables struct {
+	TableCount int `json:"table_count"`
+}
+
+func (msg ExportPromotingTables) String() string {
+	return fmt.Sprintf("All the data was loaded, writing it to %d tables...", msg.TableCount)
+}
+
+// ExportProjectFailed is the message that is displayed when the export of
+// a project fails while exporting multiple projects
+type ExportProjectFailed struct {
+	ProjectID string `json:"project_id"`
+	Err       error  `json:"-"`
+}
+
+func (msg ExportProjectFailed) String() string {
+	return fmt.Sprintf("Export of project %s failed: %v", msg.ProjectID, msg.Err)
+}
+
+// ExportProjectsFailed is the message that summarizes the projects that
+// failed to export while exporting multiple projects
+type ExportProjectsFailed struct {
+	ProjectIDs   []string
+	ProjectCount int
+}
+
+func (msg ExportProjectsFailed) String() string {
+	return fmt.Sprintf("export failed for %d of %d projects: %s", len(msg.ProjectIDs), msg.ProjectCount, strings.Join(msg.ProjectIDs, ", "))
 }
 
 // ExportComplete is the message that is displayed when the entire export completes
 type ExportComplete struct {
-	BytesTransferred uint64
+	BytesTransferred uint64 `json:"bytes_transferred"`
 }
 
 func (msg ExportComplete) String() string {
 	return fmt.Sprintf("Export complete. %s transferred.", formatDataAmount(msg.BytesTransferred))
 }
 
+// ReconcileStarted is the message that is displayed before the exported
+// table is compared with Migration Center
+type ReconcileStarted struct {
+	TableName string `json:"table_name"`
+}
+
+func (msg ReconcileStarted) String() string {
+	return fmt.Sprintf("Reconciling %s with Migration Center...", msg.TableName)
+}
+
+// ReconcileFieldSkipped is the message that is displayed when a field can't
+// be summed in the exported table
+type ReconcileFieldSkipped struct {
+	Field string `json:"field"`
+}
+
+func (msg ReconcileFieldSkipped) String() string {
+	return fmt.Sprintf("Skipping reconciliation of %s, it isn't a numeric column of the assets table.", msg.Field)
+}
+
+// ReconcileCheck is the message that is displayed for every value that is
+// compared with Migration Center
+type ReconcileCheck struct {
+	TableName         string  `json:"table_name"`
+	ProjectID         string  `json:"project_id"`
+	Location          string  `json:"location"`
+	Metric            string  `json:"metric"`
+	Expected          float64 `json:"expected"`
+	Actual            float64 `json:"actual"`
+	DifferencePercent float64 `json:"difference_percent"`
+	Passed            bool    `json:"passed"`
+}
+
+func (msg ReconcileCheck) String() string {
+	result := "ok"
+	if !msg.Passed {
+		result = "MISMATCH"
+	}
+	return fmt.Sprintf("Reconciliation of %s %s: %s, expected %s, got %s (%.2f%%).", formatTableName(msg.TableName, msg.ProjectID, msg.Location), msg.Metric, result, formatNumber(msg.Expected), formatNumber(msg.Actual), msg.DifferencePercent)
+}
+
+// ReconcileFailed is the error that is returned when values of the exported
+// table differ from Migration Center by more than the tolerance
+type ReconcileFailed struct {
+	FailedCount      int     `json:"failed_count"`
+	CheckCount       int     `json:"check_count"`
+	TolerancePercent float64 `json:"tolerance_percent"`
+}
+
+func (msg ReconcileFailed) String() string {
+	return fmt.Sprintf("reconciliation failed, %d of %d values differ from Migration Center by more than %g%%", msg.FailedCount, msg.CheckCount, msg.TolerancePercent)
+}
+
+// ReconcileWarning is the message that is displayed when reconciliation
+// fails and the export doesn't
+type ReconcileWarning struct {
+	Failure ReconcileFailed `json:"failure"`
+}
+
+func (msg ReconcileWarning) String() string {
+	return fmt.Sprintf("Warning: %s.", msg.Failure)
+}
+
+// ExportRunNotRecorded is the message that is displayed when the export
+// couldn't be recorded in the runs table
+type ExportRunNotRecorded struct {
+	Err error `json:"-"`
+}
+
+func (msg ExportRunNotRecorded) String() string {
+	return fmt.Sprintf("Warning: the export couldn't be recorded in the runs table: %v", msg.Err)
+}
+
+// TelemetryNotFlushed is the message that is displayed when the spans and
+// metrics couldn't be sent to the exporters at the end of the export
+type TelemetryNotFlushed struct {
+	Err error `json:"-"`
+}
+
+func (msg TelemetryNotFlushed) String() string {
+	return fmt.Sprintf("Warning: the telemetry of the export couldn't be sent: %v", msg.Err)
+}
+
+// ExportFilesComplete is the message that is displayed when the files of an
+// export to files were written
+type ExportFilesComplete struct {
+	FileCount int    `json:"file_count"`
+	OutputDir string `json:"output_dir"`
+}
+
+func (msg ExportFilesComplete) String() string {
+	return fmt.Sprintf("Wrote %d files and the manifest to %s.", msg.FileCount, msg.OutputDir)
+}
+
+// formatTableName formats the name of a table that is exported from a
+// project and location. The project and location are empty when exporting a
+// single project or region respectively.
+func formatTableName(tableName string, projectID string, location string) string {
+	scope := projectID
+	if location != "" {
+		scope = path.Join(projectID, location)
+	}
+	if scope == "" {
+		return tableName
+	}
+
+	return fmt.Sprintf("%s (%s)", tableName, scope)
+}
+
+// formatNumber formats a count or a sum without a fraction when it's whole.
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
 func formatDataAmount(nBytes uint64) string {
 	suffixes := []string{" bytes", "KiB", "MiB", "GiB", "TiB"}
 	amount := nBytes
@@ -0,0 +1,192 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+//go:generate go run gen_descriptions.go
+
+import (
+	_ "embed"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxDescriptionLength is the maximum length of the description of a BigQuery
+// column.
+const maxDescriptionLength = 1024
+
+// protoDoc is the documentation of a proto message or enum, it's extracted
+// from the proto comments by gen_descriptions.go.
+type protoDoc struct {
+	Description string `json:"description,omitempty"`
+	// Fields are the comments of the fields of a message.
+	Fields map[string]string `json:"fields,omitempty"`
+	// Values are the comments of the values of an enum.
+	Values map[string]string `json:"values,omitempty"`
+}
+
+//go:embed descriptions.json
+var rawDescriptions []byte
+
+// protoDocs are the docs of the Migration Center messages and enums keyed by
+// their full name.
+var protoDocs map[string]*protoDoc
+
+func init() {
+	err := json.Unmarshal(rawDescriptions, &protoDocs)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// docOf returns the doc of the message or enum name, it's empty if the proto
+// has no comments.
+func docOf(name protoreflect.FullName) *protoDoc {
+	if doc, ok := protoDocs[string(name)]; ok {
+		return doc
+	}
+	return &protoDoc{}
+}
+
+// tableMessages are the messages the tables of ExporterSchema are exported
+// from, keyed by the json key of the table.
+var tableMessages = map[string]protoreflect.MessageDescriptor{
+	"asset_table":            (&migrationcenterpb.Asset{}).ProtoReflect().Descriptor(),
+	"group_table":            (&migrationcenterpb.Group{}).ProtoReflect().Descriptor(),
+	"preference_set_table":   (&migrationcenterpb.PreferenceSet{}).ProtoReflect().Descriptor(),
+	"error_frame_table":      (&migrationcenterpb.ErrorFrame{}).ProtoReflect().Descriptor(),
+	"report_config_table":    (&migrationcenterpb.ReportConfig{}).ProtoReflect().Descriptor(),
+	"report_table":           (&migrationcenterpb.Report{}).ProtoReflect().Descriptor(),
+	"report_summary_table":   (&migrationcenterpb.ReportSummary{}).ProtoReflect().Descriptor(),
+	"source_table":           (&migrationcenterpb.Source{}).ProtoReflect().Descriptor(),
+	"import_job_table":       (&migrationcenterpb.ImportJob{}).ProtoReflect().Descriptor(),
+	"import_data_file_table": (&migrationcenterpb.ImportDataFile{}).ProtoReflect().Descriptor(),
+}
+
+// reportSummaryColumns are the fields that the columns of the report summary
+// rows are taken from, every row is a finding of a group of a report.
+var reportSummaryColumns = map[protoreflect.Name]protoreflect.FieldDescriptor{
+	"report":                 (&migrationcenterpb.Report{}).ProtoReflect().Descriptor().Fields().ByName("name"),
+	"group_finding":          (&migrationcenterpb.ReportSummary{}).ProtoReflect().Descriptor().Fields().ByName("group_findings"),
+	"preference_set_finding": (&migrationcenterpb.ReportSummary_GroupFinding{}).ProtoReflect().Descriptor().Fields().ByName("preference_set_findings"),
+}
+
+// tableColumns returns a function that finds the field a top level column of
+// the table key is exported from.
+func tableColumns(key string) func(name protoreflect.Name) protoreflect.FieldDescriptor {
+	if key == "report_summary_table" {
+		return func(name protoreflect.Name) protoreflect.FieldDescriptor {
+			return reportSummaryColumns[name]
+		}
+	}
+	if msg, ok := tableMessages[key]; ok {
+		return msg.Fields().ByName
+	}
+	return func(protoreflect.Name) protoreflect.FieldDescriptor { return nil }
+}
+
+// WithDescriptions returns a copy of the schema where the columns that don't
+// have a description are described by the comments of the proto fields they
+// are exported from. The description of enum columns lists the values of the
+// enum.
+func (s *ExporterSchema) WithDescriptions() *ExporterSchema {
+	res := *s
+	myType := reflect.TypeOf(&res).Elem()
+	myValue := reflect.ValueOf(&res).Elem()
+	for i := 0; i < myType.NumField(); i++ {
+		columns := tableColumns(myType.Field(i).Tag.Get("json"))
+		schema := myValue.Field(i).Interface().(bigquery.Schema)
+		myValue.Field(i).Set(reflect.ValueOf(describeSchema(schema, columns)))
+	}
+	return &res
+}
+
+// describeSchema returns a copy of schema with the descriptions of the fields
+// found by fields.
+func describeSchema(schema bigquery.Schema, fields func(name protoreflect.Name) protoreflect.FieldDescriptor) bigquery.Schema {
+	if schema == nil {
+		return nil
+	}
+
+	res := make(bigquery.Schema, len(schema))
+	for i, field := range schema {
+		described := *field
+		if fd := fields(protoreflect.Name(field.Name)); fd != nil {
+			if described.Description == "" {
+				described.Description = fieldDescription(fd)
+			}
+			if msg := fd.Message(); msg != nil && len(field.Schema) > 0 {
+				// Maps are records of the key and value fields of
+				// their entry message.
+				described.Schema = describeSchema(field.Schema, msg.Fields().ByName)
+			}
+		}
+		res[i] = &described
+	}
+	return res
+}
+
+// fieldDescription returns the description of a column that is exported from
+// fd.
+func fieldDescription(fd protoreflect.FieldDescriptor) string {
+	desc := docOf(fd.ContainingMessage().FullName()).Fields[string(fd.Name())]
+	if enum := fd.Enum(); enum != nil {
+		doc := docOf(enum.FullName())
+		var values []string
+		for i := 0; i < enum.Values().Len(); i++ {
+			name := string(enum.Values().Get(i).Name())
+			if comment := strings.TrimSuffix(doc.Values[name], "."); comment != "" {
+				name += ": " + comment
+			}
+			values = append(values, name)
+		}
+		if desc != "" {
+			desc += " "
+		}
+		desc += "Values: " + strings.Join(values, "; ") + "."
+	}
+
+	return truncateDescription(desc)
+}
+
+// truncateDescription truncates desc to maxDescriptionLength bytes, the
+// descriptions of enums with many values can be longer.
+func truncateDescription(desc string) string {
+	if len(desc) <= maxDescriptionLength {
+		return desc
+	}
+	// Cut on a rune boundary.
+	cut := maxDescriptionLength - len("...")
+	for cut > 0 && !utf8.RuneStart(desc[cut]) {
+		cut--
+	}
+	return desc[:cut] + "..."
+}
+
+// tableDescription returns the description of the table key of
+// ExporterSchema, e.g. asset_table. It's the comment of the message the rows
+// of the table are exported from.
+func tableDescription(key string) string {
+	msg, ok := tableMessages[key]
+	if !ok {
+		return ""
+	}
+	return docOf(msg.FullName()).Description
+}
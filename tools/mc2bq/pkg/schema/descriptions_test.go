@@ -0,0 +1,121 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"cloud.google.com/go/bigquery"
+)
+
+var descriptionTestSchema = ExporterSchema{
+	AssetTable: bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "key", Type: bigquery.StringFieldType},
+			{Name: "value", Type: bigquery.StringFieldType},
+		}},
+		{Name: "machine_details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "power_state", Type: bigquery.StringFieldType},
+		}},
+		{Name: "not_in_proto", Type: bigquery.StringFieldType},
+		{Name: "sources", Type: bigquery.StringFieldType, Repeated: true, Description: "Custom description."},
+	},
+	ReportSummaryTable: bigquery.Schema{
+		{Name: "report", Type: bigquery.StringFieldType},
+		{Name: "group_finding", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "display_name", Type: bigquery.StringFieldType},
+		}},
+	},
+}
+
+// findField returns the field of schema at the dotted path.
+func findField(t *testing.T, schema bigquery.Schema, path string) *bigquery.FieldSchema {
+	t.Helper()
+	name, rest, nested := strings.Cut(path, ".")
+	for _, field := range schema {
+		if field.Name != name {
+			continue
+		}
+		if nested {
+			return findField(t, field.Schema, rest)
+		}
+		return field
+	}
+	t.Fatalf("field %s not found", path)
+	return nil
+}
+
+func TestWithDescriptions(t *testing.T) {
+	s := descriptionTestSchema.WithDescriptions()
+
+	tests := []struct {
+		table bigquery.Schema
+		path  string
+		want  string
+	}{
+		{s.AssetTable, "name", "Output only. The full name of the asset."},
+		{s.AssetTable, "labels", "Labels as key value pairs."},
+		{s.AssetTable, "labels.key", ""},
+		{s.AssetTable, "machine_details.power_state", "Power state of the machine. Values: POWER_STATE_UNSPECIFIED: Power state is unknown; "},
+		{s.AssetTable, "not_in_proto", ""},
+		{s.AssetTable, "sources", "Custom description."},
+		{s.ReportSummaryTable, "group_finding", "Findings for each Group included in this report."},
+		{s.ReportSummaryTable, "group_finding.display_name", "Display Name for the Group."},
+	}
+	for _, tc := range tests {
+		got := findField(t, tc.table, tc.path).Description
+		if (tc.want == "" && got != "") || !strings.HasPrefix(got, tc.want) {
+			t.Errorf("description of %s = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+
+	// The original schema must not be modified.
+	if got := descriptionTestSchema.AssetTable[0].Description; got != "" {
+		t.Errorf("WithDescriptions() modified the schema, description of name = %q", got)
+	}
+	if got := descriptionTestSchema.AssetTable[2].Schema[0].Description; got != "" {
+		t.Errorf("WithDescriptions() modified the schema, description of machine_details.power_state = %q", got)
+	}
+}
+
+func TestTruncateDescription(t *testing.T) {
+	if got := truncateDescription("short"); got != "short" {
+		t.Errorf("truncateDescription(short) = %q", got)
+	}
+	long := strings.Repeat("é", maxDescriptionLength)
+	got := truncateDescription(long)
+	if len(got) > maxDescriptionLength || !utf8.ValidString(got) || !strings.HasSuffix(got, "...") {
+		t.Errorf("truncateDescription() = %q, want a valid string of at most %d bytes", got, maxDescriptionLength)
+	}
+
+	// BigQuery rejects longer descriptions, the enums with many values
+	// must be truncated.
+	var walk func(prefix string, schema bigquery.Schema)
+	walk = func(prefix string, schema bigquery.Schema) {
+		for _, field := range schema {
+			if n := len(field.Description); n > maxDescriptionLength {
+				t.Errorf("description of %s%s has %d bytes, want at most %d", prefix, field.Name, n, maxDescriptionLength)
+			}
+			walk(prefix+field.Name+".", field.Schema)
+		}
+	}
+	s := EmbeddedSchema.WithDescriptions()
+	for _, table := range []bigquery.Schema{s.AssetTable, s.ReportTable, s.ReportSummaryTable, s.ImportJobTable} {
+		walk("", table)
+	}
+}
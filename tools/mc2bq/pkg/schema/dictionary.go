@@ -0,0 +1,154 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"reflect"
+	"strings"
+	"text/template"
+
+	"cloud.google.com/go/bigquery"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// DictionaryFormat is the format of a data dictionary.
+type DictionaryFormat string
+
+const (
+	// DictionaryFormatMarkdown writes the data dictionary as Markdown
+	// tables.
+	DictionaryFormatMarkdown DictionaryFormat = "markdown"
+	// DictionaryFormatHTML writes the data dictionary as an HTML page.
+	DictionaryFormatHTML DictionaryFormat = "html"
+)
+
+// ParseDictionaryFormat parses a data dictionary format name, an empty name is
+// DictionaryFormatMarkdown.
+func ParseDictionaryFormat(name string) (DictionaryFormat, error) {
+	switch format := DictionaryFormat(strings.ToLower(name)); format {
+	case "":
+		return DictionaryFormatMarkdown, nil
+	case DictionaryFormatMarkdown, DictionaryFormatHTML:
+		return format, nil
+	}
+
+	return "", messages.NewError(messages.InvalidDictionaryFormat{Format: name})
+}
+
+// dictionaryTable is a table of a data dictionary.
+type dictionaryTable struct {
+	Name        string
+	Description string
+	Columns     []dictionaryColumn
+}
+
+// dictionaryColumn is a column of a data dictionary, the nested fields of
+// records are columns with a dotted name.
+type dictionaryColumn struct {
+	Name        string
+	Type        bigquery.FieldType
+	Mode        string
+	Description string
+}
+
+var markdownDictionary = template.Must(template.New("markdown").Funcs(template.FuncMap{
+	"cell": markdownCell,
+}).Parse(`# Migration Center data dictionary
+{{range .}}
+## {{.Name}}
+{{if .Description}}
+{{.Description}}
+{{end}}
+| Column | Type | Mode | Description |
+| --- | --- | --- | --- |
+{{range .Columns}}| ` + "`{{.Name}}`" + ` | {{.Type}} | {{.Mode}} | {{cell .Description}} |
+{{end}}{{end}}`))
+
+var htmlDictionary = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Migration Center data dictionary</title>
+</head>
+<body>
+<h1>Migration Center data dictionary</h1>
+{{range .}}<h2 id="{{.Name}}">{{.Name}}</h2>
+{{if .Description}}<p>{{.Description}}</p>
+{{end}}<table>
+<thead><tr><th>Column</th><th>Type</th><th>Mode</th><th>Description</th></tr></thead>
+<tbody>
+{{range .Columns}}<tr><td><code>{{.Name}}</code></td><td>{{.Type}}</td><td>{{.Mode}}</td><td>{{.Description}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}</body>
+</html>
+`))
+
+// markdownCell escapes s so it can be used in a cell of a Markdown table.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// WriteDictionary writes a data dictionary of every table and column of the
+// schema to w in format. The tables are named as in BigQuery with
+// tablePrefix.
+func (s *ExporterSchema) WriteDictionary(w io.Writer, format DictionaryFormat, tablePrefix string) error {
+	var tables []dictionaryTable
+	myType := reflect.TypeOf(s).Elem()
+	myValue := reflect.ValueOf(s).Elem()
+	for i := 0; i < myType.NumField(); i++ {
+		schema := myValue.Field(i).Interface().(bigquery.Schema)
+		if len(schema) == 0 {
+			// schema older than this entity
+			continue
+		}
+		tables = append(tables, dictionaryTable{
+			Name:        tablePrefix + myType.Field(i).Tag.Get("bq"),
+			Description: tableDescription(myType.Field(i).Tag.Get("json")),
+			Columns:     dictionaryColumns(nil, "", schema),
+		})
+	}
+
+	if format == DictionaryFormatHTML {
+		return htmlDictionary.Execute(w, tables)
+	}
+	return markdownDictionary.Execute(w, tables)
+}
+
+// dictionaryColumns appends the columns of schema to res, the names of the
+// columns are prefixed with prefix.
+func dictionaryColumns(res []dictionaryColumn, prefix string, schema bigquery.Schema) []dictionaryColumn {
+	for _, field := range schema {
+		mode := "NULLABLE"
+		switch {
+		case field.Repeated:
+			mode = "REPEATED"
+		case field.Required:
+			mode = "REQUIRED"
+		}
+		res = append(res, dictionaryColumn{
+			Name:        prefix + field.Name,
+			Type:        field.Type,
+			Mode:        mode,
+			Description: field.Description,
+		})
+		res = dictionaryColumns(res, prefix+field.Name+".", field.Schema)
+	}
+	return res
+}
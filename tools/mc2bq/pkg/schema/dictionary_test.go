@@ -0,0 +1,58 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/test/golden"
+)
+
+// TestWriteDictionary tests the data dictionary formats.
+// The tests uses golden output files.
+// To update them run: go test -test.generate-golden-files $PWD
+func TestWriteDictionary(t *testing.T) {
+	s := descriptionTestSchema.WithDescriptions()
+	for _, format := range []DictionaryFormat{DictionaryFormatMarkdown, DictionaryFormatHTML} {
+		t.Run(string(format), func(t *testing.T) {
+			var got strings.Builder
+			err := s.WriteDictionary(&got, format, "mc_")
+			if err != nil {
+				t.Fatalf("WriteDictionary(): unexpected error: %v", err)
+			}
+			if diff := golden.Compare(t, "dictionary."+string(format), got.String()); diff != "" {
+				t.Errorf("WriteDictionary(): mismatch (-want, +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseDictionaryFormat(t *testing.T) {
+	for name, want := range map[string]DictionaryFormat{
+		"":         DictionaryFormatMarkdown,
+		"markdown": DictionaryFormatMarkdown,
+		"HTML":     DictionaryFormatHTML,
+	} {
+		got, err := ParseDictionaryFormat(name)
+		if err != nil || got != want {
+			t.Errorf("ParseDictionaryFormat(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	_, err := ParseDictionaryFormat("pdf")
+	if err == nil {
+		t.Errorf("ParseDictionaryFormat(pdf) succeeded")
+	}
+}
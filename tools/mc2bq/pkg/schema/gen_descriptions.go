@@ -0,0 +1,250 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+
+// gen_descriptions extracts the comments of the Migration Center protos from
+// the generated Go code of migrationcenterpb and writes them to
+// descriptions.json. The proto descriptors that are compiled into the client
+// don't contain the comments, but protoc-gen-go copies them to the Go code.
+//
+// Run it with go generate after updating the Migration Center client.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const (
+	protoPackage = "cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	output       = "descriptions.json"
+)
+
+// protoNameRE extracts the proto field name from a protobuf struct tag.
+var protoNameRE = regexp.MustCompile(`(?:^|,)name=([^,]+)`)
+
+// protoDoc matches the type of the same name in descriptions.go.
+type protoDoc struct {
+	Description string            `json:"description,omitempty"`
+	Fields      map[string]string `json:"fields,omitempty"`
+	Values      map[string]string `json:"values,omitempty"`
+}
+
+// goDocs are the comments of the generated Go code keyed by Go type name.
+type goDocs struct {
+	types map[string]string
+	// fields are keyed by the proto field name.
+	fields map[string]map[string]string
+	// values are keyed by the enum number.
+	values map[string]map[int32]string
+}
+
+func main() {
+	out, err := exec.Command("go", "list", "-f", "{{.Dir}}", protoPackage).Output()
+	if err != nil {
+		log.Fatalf("locate %s: %v", protoPackage, err)
+	}
+	dir := strings.TrimSpace(string(out))
+	docs, err := parseDocs(filepath.Join(dir, "migrationcenter.pb.go"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	res := map[string]*protoDoc{}
+	fd := migrationcenterpb.File_google_cloud_migrationcenter_v1_migrationcenter_proto
+	protoregistry.GlobalTypes.RangeMessages(func(mt protoreflect.MessageType) bool {
+		desc := mt.Descriptor()
+		if desc.ParentFile() != fd {
+			return true
+		}
+		goName := reflect.TypeOf(mt.Zero().Interface()).Elem().Name()
+		doc := &protoDoc{Description: docs.types[goName], Fields: docs.fields[goName]}
+		if doc.Description != "" || len(doc.Fields) > 0 {
+			res[string(desc.FullName())] = doc
+		}
+		return true
+	})
+	protoregistry.GlobalTypes.RangeEnums(func(et protoreflect.EnumType) bool {
+		desc := et.Descriptor()
+		if desc.ParentFile() != fd {
+			return true
+		}
+		goName := reflect.TypeOf(et.New(0)).Name()
+		doc := &protoDoc{Description: docs.types[goName], Values: map[string]string{}}
+		for number, comment := range docs.values[goName] {
+			if value := desc.Values().ByNumber(protoreflect.EnumNumber(number)); value != nil {
+				doc.Values[string(value.Name())] = comment
+			}
+		}
+		res[string(desc.FullName())] = doc
+		return true
+	})
+
+	b, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = os.WriteFile(output, append(b, '\n'), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parseDocs collects the comments of the types, fields and enum values of the
+// generated Go file path.
+func parseDocs(path string) (*goDocs, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	docs := &goDocs{
+		types:  map[string]string{},
+		fields: map[string]map[string]string{},
+		values: map[string]map[int32]string{},
+	}
+	// oneofFields maps the interfaces of the oneofs to the message that has
+	// the oneof, wrappers maps the wrapper types to the interface they
+	// implement.
+	oneofFields := map[string]string{}
+	wrappers := map[string]string{}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			switch decl.Tok {
+			case token.TYPE:
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.TypeSpec)
+					docs.types[spec.Name.Name] = cleanComment(decl.Doc)
+					st, ok := spec.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					for _, field := range st.Fields.List {
+						if ident, ok := field.Type.(*ast.Ident); ok && strings.HasPrefix(ident.Name, "is") {
+							oneofFields[ident.Name] = spec.Name.Name
+						}
+						name := protoFieldName(field)
+						if name == "" || field.Doc == nil {
+							continue
+						}
+						if docs.fields[spec.Name.Name] == nil {
+							docs.fields[spec.Name.Name] = map[string]string{}
+						}
+						docs.fields[spec.Name.Name][name] = cleanComment(field.Doc)
+					}
+				}
+			case token.CONST:
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.ValueSpec)
+					typ, ok := spec.Type.(*ast.Ident)
+					if !ok || len(spec.Values) != 1 || spec.Doc == nil {
+						continue
+					}
+					lit, ok := spec.Values[0].(*ast.BasicLit)
+					if !ok {
+						continue
+					}
+					number, err := strconv.ParseInt(lit.Value, 0, 32)
+					if err != nil {
+						continue
+					}
+					if docs.values[typ.Name] == nil {
+						docs.values[typ.Name] = map[int32]string{}
+					}
+					docs.values[typ.Name][int32(number)] = cleanComment(spec.Doc)
+				}
+			}
+		case *ast.FuncDecl:
+			// The wrappers of the oneof fields implement an unexported
+			// method named after the interface of the oneof.
+			if decl.Recv == nil || !strings.HasPrefix(decl.Name.Name, "is") || len(decl.Recv.List) != 1 {
+				continue
+			}
+			if star, ok := decl.Recv.List[0].Type.(*ast.StarExpr); ok {
+				if ident, ok := star.X.(*ast.Ident); ok {
+					wrappers[ident.Name] = decl.Name.Name
+				}
+			}
+		}
+	}
+
+	// The fields of the oneof wrappers are fields of the message that has the
+	// oneof.
+	for wrapper, iface := range wrappers {
+		msg, ok := oneofFields[iface]
+		if !ok {
+			continue
+		}
+		for name, comment := range docs.fields[wrapper] {
+			if docs.fields[msg] == nil {
+				docs.fields[msg] = map[string]string{}
+			}
+			docs.fields[msg][name] = comment
+		}
+		delete(docs.fields, wrapper)
+	}
+
+	return docs, nil
+}
+
+// protoFieldName returns the proto name of a field of a generated message,
+// empty if the field isn't a proto field.
+func protoFieldName(field *ast.Field) string {
+	if field.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		return ""
+	}
+	m := protoNameRE.FindStringSubmatch(reflect.StructTag(tag).Get("protobuf"))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// cleanComment joins the lines of a comment, protoc-gen-go appends a notice
+// to the comments of deprecated fields that isn't part of the proto comment.
+func cleanComment(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+	var lines []string
+	for _, line := range strings.Split(cg.Text(), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "Deprecated: Marked as deprecated") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, " ")
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// assetKeyField is the asset field that is always exported, it identifies the
+// assets.
+const assetKeyField = "name"
+
+// projection is a tree of the selected fields, a field without children
+// selects all its nested fields.
+type projection map[string]projection
+
+// ProjectAssetTable returns a copy of the schema where the asset table only
+// contains the columns selected by paths, the dotted paths of asset fields
+// such as machine_details.core_count. Selecting a record selects all its
+// nested fields, the name of the assets is always selected. The paths are
+// validated against the Asset message and the schema.
+func (s *ExporterSchema) ProjectAssetTable(paths []string) (*ExporterSchema, error) {
+	tree := projection{assetKeyField: nil}
+	asset := (&migrationcenterpb.Asset{}).ProtoReflect().Descriptor()
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		err := validateAssetPath(asset, path)
+		if err != nil {
+			return nil, err
+		}
+		tree.add(strings.Split(path, "."))
+	}
+
+	res := *s
+	var err error
+	res.AssetTable, err = tree.apply("", s.AssetTable)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// validateAssetPath returns an error if path isn't the path of a field of msg.
+func validateAssetPath(msg protoreflect.MessageDescriptor, path string) error {
+	for _, name := range strings.Split(path, ".") {
+		var fd protoreflect.FieldDescriptor
+		if msg != nil {
+			fd = msg.Fields().ByName(protoreflect.Name(name))
+		}
+		if fd == nil {
+			return messages.NewError(messages.UnknownAssetField{Path: path})
+		}
+		// Maps are records of the key and value fields of their entry
+		// message.
+		msg = fd.Message()
+	}
+
+	return nil
+}
+
+// add selects the field at the path of names.
+func (p projection) add(names []string) {
+	children, ok := p[names[0]]
+	if ok && children == nil {
+		// The whole field is already selected.
+		return
+	}
+	if len(names) == 1 {
+		p[names[0]] = nil
+		return
+	}
+	if children == nil {
+		children = projection{}
+		p[names[0]] = children
+	}
+	children.add(names[1:])
+}
+
+// apply returns the fields of schema that are selected by p in the order of
+// schema, prefix is the path of schema.
+func (p projection) apply(prefix string, schema bigquery.Schema) (bigquery.Schema, error) {
+	for _, name := range p.names() {
+		field := fieldByName(schema, name)
+		if field == nil && name == assetKeyField && prefix == "" {
+			// The schema doesn't have a key, nothing to keep.
+			continue
+		}
+		if field == nil {
+			return nil, messages.NewError(messages.AssetFieldNotInSchema{Path: prefix + name})
+		}
+		if children := p[name]; children != nil && len(field.Schema) == 0 {
+			// The field isn't a record in the schema, e.g. timestamps.
+			return nil, messages.NewError(messages.AssetFieldNotInSchema{Path: prefix + name + "." + children.names()[0]})
+		}
+	}
+
+	var res bigquery.Schema
+	for _, field := range schema {
+		children, ok := p[field.Name]
+		if !ok {
+			continue
+		}
+		if children == nil {
+			res = append(res, field)
+			continue
+		}
+
+		nested, err := children.apply(prefix+field.Name+".", field.Schema)
+		if err != nil {
+			return nil, err
+		}
+		projected := *field
+		projected.Schema = nested
+		res = append(res, &projected)
+	}
+
+	return res, nil
+}
+
+// fieldByName returns the field of schema called name, nil if there is none.
+func fieldByName(schema bigquery.Schema, name string) *bigquery.FieldSchema {
+	for _, field := range schema {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// names returns the names of the fields selected by p in lexical order.
+func (p projection) names() []string {
+	res := make([]string, 0, len(p))
+	for name := range p {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
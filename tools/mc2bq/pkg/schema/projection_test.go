@@ -0,0 +1,133 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+var projectionTestSchema = ExporterSchema{
+	AssetTable: bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+		{Name: "create_time", Type: bigquery.TimestampFieldType},
+		{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "key", Type: bigquery.StringFieldType},
+			{Name: "value", Type: bigquery.StringFieldType},
+		}},
+		{Name: "machine_details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "core_count", Type: bigquery.IntegerFieldType},
+			{Name: "memory_mb", Type: bigquery.IntegerFieldType},
+			{Name: "platform", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+				{Name: "hypervisor_version", Type: bigquery.StringFieldType},
+			}},
+		}},
+	},
+	GroupTable: bigquery.Schema{
+		{Name: "name", Type: bigquery.StringFieldType},
+	},
+}
+
+func TestProjectAssetTable(t *testing.T) {
+	got, err := projectionTestSchema.ProjectAssetTable([]string{"machine_details.core_count", "labels", "machine_details.platform", "labels.key"})
+	if err != nil {
+		t.Fatalf("ProjectAssetTable(): unexpected error: %v", err)
+	}
+	want := ExporterSchema{
+		AssetTable: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "labels", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+				{Name: "key", Type: bigquery.StringFieldType},
+				{Name: "value", Type: bigquery.StringFieldType},
+			}},
+			{Name: "machine_details", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+				{Name: "core_count", Type: bigquery.IntegerFieldType},
+				{Name: "platform", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+					{Name: "hypervisor_version", Type: bigquery.StringFieldType},
+				}},
+			}},
+		},
+		GroupTable: projectionTestSchema.GroupTable,
+	}
+	if diff := cmp.Diff(want, *got); diff != "" {
+		t.Errorf("ProjectAssetTable(): unexpected schema (-want, +got):\n%s", diff)
+	}
+	if len(projectionTestSchema.AssetTable[3].Schema) != 3 {
+		t.Errorf("ProjectAssetTable(): modified the original schema")
+	}
+
+	// The pruned schema serializes only the selected fields.
+	asset := &migrationcenterpb.Asset{
+		Name:   "asset",
+		Labels: map[string]string{"team": "payments"},
+		AssetDetails: &migrationcenterpb.Asset_MachineDetails{MachineDetails: &migrationcenterpb.MachineDetails{
+			CoreCount: 4,
+			MemoryMb:  1024,
+		}},
+	}
+	data, err := SerializeObjectToBigQuery(asset.ProtoReflect(), "asset", got.AssetTable)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(): unexpected error: %v", err)
+	}
+	wantJSON := `{"labels":[{"key":"team","value":"payments"}],"machine_details":{"core_count":4},"name":"asset"}` + "\n"
+	if string(data) != wantJSON {
+		t.Errorf("SerializeObjectToBigQuery() = %s, want %s", data, wantJSON)
+	}
+}
+
+func TestProjectEmbeddedAssetTable(t *testing.T) {
+	got, err := EmbeddedSchema.ProjectAssetTable([]string{"machine_details.core_count", "labels", "update_time"})
+	if err != nil {
+		t.Fatalf("ProjectAssetTable(): unexpected error: %v", err)
+	}
+	var columns []string
+	for _, field := range got.AssetTable {
+		columns = append(columns, field.Name)
+	}
+	if diff := cmp.Diff([]string{"name", "update_time", "labels", "machine_details"}, columns); diff != "" {
+		t.Errorf("ProjectAssetTable(): unexpected columns (-want, +got):\n%s", diff)
+	}
+}
+
+func TestProjectAssetTableErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want messages.Message
+	}{
+		{name: "unknown field", path: "machine_details.cores", want: messages.UnknownAssetField{Path: "machine_details.cores"}},
+		{name: "unknown top level field", path: "hostname", want: messages.UnknownAssetField{Path: "hostname"}},
+		{name: "nested scalar", path: "name.first", want: messages.UnknownAssetField{Path: "name.first"}},
+		{name: "not in schema", path: "update_time", want: messages.AssetFieldNotInSchema{Path: "update_time"}},
+		{name: "nested not in schema", path: "machine_details.disks", want: messages.AssetFieldNotInSchema{Path: "machine_details.disks"}},
+		{name: "not a record", path: "create_time.seconds", want: messages.AssetFieldNotInSchema{Path: "create_time.seconds"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := projectionTestSchema.ProjectAssetTable([]string{tc.path})
+			if err == nil {
+				t.Fatalf("ProjectAssetTable(%q): expected an error", tc.path)
+			}
+			if err.Error() != tc.want.String() {
+				t.Errorf("ProjectAssetTable(%q) = %v, want %v", tc.path, err, tc.want)
+			}
+		})
+	}
+}
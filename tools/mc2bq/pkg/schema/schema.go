@@ -37,9 +37,16 @@ import (
 // ExporterSchema is the collection of the individual table schemas that will
 // be used during export
 type ExporterSchema struct {
-	AssetTable         bigquery.Schema `json:"asset_table" bq:"assets"`
-	GroupTable         bigquery.Schema `json:"group_table" bq:"groups"`
-	PreferenceSetTable bigquery.Schema `json:"preference_set_table" bq:"preference_sets"`
+	AssetTable          bigquery.Schema `json:"asset_table" bq:"assets"`
+	GroupTable          bigquery.Schema `json:"group_table" bq:"groups"`
+	PreferenceSetTable  bigquery.Schema `json:"preference_set_table" bq:"preference_sets"`
+	ErrorFrameTable     bigquery.Schema `json:"error_frame_table" bq:"error_frames"`
+	ReportConfigTable   bigquery.Schema `json:"report_config_table" bq:"report_configs"`
+	ReportTable         bigquery.Schema `json:"report_table" bq:"reports"`
+	ReportSummaryTable  bigquery.Schema `json:"report_summary_table" bq:"report_summaries"`
+	SourceTable         bigquery.Schema `json:"source_table" bq:"sources"`
+	ImportJobTable      bigquery.Schema `json:"import_job_table" bq:"import_jobs"`
+	ImportDataFileTable bigquery.Schema `json:"import_data_file_table" bq:"import_data_files"`
 }
 
 var _ json.Marshaler = &ExporterSchema{}
@@ -99,6 +106,34 @@ func (s *ExporterSchema) UnmarshalJSON(buf []byte) error {
 	return nil
 }
 
+// fullAssetViewFields are the top level asset fields that are only returned
+// when listing assets with the full view.
+var fullAssetViewFields = []string{"performance_data", "insight_list"}
+
+// BasicAssetView returns a copy of the schema where the asset table only
+// contains the fields that are returned by the basic asset view.
+func (s *ExporterSchema) BasicAssetView() *ExporterSchema {
+	res := *s
+	res.AssetTable = nil
+	for _, field := range s.AssetTable {
+		if !isFullAssetViewField(field.Name) {
+			res.AssetTable = append(res.AssetTable, field)
+		}
+	}
+
+	return &res
+}
+
+func isFullAssetViewField(name string) bool {
+	for _, field := range fullAssetViewFields {
+		if field == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 //go:embed migrationcenter_v1_latest.schema.json
 var rawEmbeddedSchema []byte
 
@@ -127,7 +162,24 @@ func NewSerializer[T protoreflect.ProtoMessage](root string, schema bigquery.Sch
 // resulting from a mismatch between the API object and the BigQuery schema and both are generated
 // from the same protobuf.
 func SerializeObjectToBigQuery(obj protoreflect.Message, root string, schema bigquery.Schema) ([]byte, error) {
-	serializedObj, err := normalizeToSchema(
+	serializedObj, err := NormalizeObjectToBigQuery(obj, root, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := json.Marshal(serializedObj)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(res, '\n'), err
+}
+
+// NormalizeObjectToBigQuery returns the value that SerializeObjectToBigQuery
+// serializes, records are maps and repeated fields are slices. It allows
+// encoding objects in formats other than JSON.
+func NormalizeObjectToBigQuery(obj protoreflect.Message, root string, schema bigquery.Schema) (map[string]any, error) {
+	res, err := normalizeToSchema(
 		obj,
 		&bigquery.FieldSchema{
 			Name:   root,
@@ -139,7 +191,26 @@ func SerializeObjectToBigQuery(obj protoreflect.Message, root string, schema big
 		return nil, err
 	}
 
-	res, err := json.Marshal(serializedObj)
+	m, _ := res.(map[string]any)
+	return m, nil
+}
+
+// Row is a synthetic row that is composed of several objects, it's used for
+// tables that don't map directly to a single Migration Center object.
+// Values that are proto messages are serialized according to the schema of
+// their column, all other values are serialized as is.
+type Row map[string]any
+
+// SerializeRowToBigQuery serializes a row as a BigQuery compatible JSON.
+// Only the columns that appear in the schema are serialized.
+// A '\n' is appended at the end of the json data.
+func SerializeRowToBigQuery(row Row, root string, schema bigquery.Schema) ([]byte, error) {
+	result, err := NormalizeRowToBigQuery(row, root, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := json.Marshal(result)
 	if err != nil {
 		return nil, err
 	}
@@ -147,6 +218,33 @@ func SerializeObjectToBigQuery(obj protoreflect.Message, root string, schema big
 	return append(res, '\n'), err
 }
 
+// NormalizeRowToBigQuery returns the value that SerializeRowToBigQuery
+// serializes, see NormalizeObjectToBigQuery.
+func NormalizeRowToBigQuery(row Row, root string, schema bigquery.Schema) (map[string]any, error) {
+	result := map[string]any{}
+	for _, col := range schema {
+		value, ok := row[col.Name]
+		if !ok || value == nil {
+			continue
+		}
+
+		if msg, ok := value.(protoreflect.ProtoMessage); ok {
+			var err error
+			value, err = normalizeToSchema(msg.ProtoReflect(), col)
+			if err != nil {
+				return nil, wrapWithSerializeError(col.Name, err)
+			}
+			if value == nil {
+				continue
+			}
+		}
+
+		result[col.Name] = value
+	}
+
+	return result, nil
+}
+
 func fieldConversionError(kind protoreflect.Kind, bqtype bigquery.FieldType) error {
 	return fmt.Errorf("convert proto kind %q to bigquery type %q", kind.String(), bqtype)
 }
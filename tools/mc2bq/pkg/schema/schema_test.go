@@ -23,6 +23,7 @@ import (
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/migrationcenter/apiv1/migrationcenterpb"
 	"github.com/google/go-cmp/cmp"
+	"google.golang.org/genproto/googleapis/type/money"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/test/golden"
@@ -78,6 +79,262 @@ func TestObjectSerializer(t *testing.T) {
 	}
 }
 
+// TestErrorFrameSerializer tests the serialization of error frames.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestErrorFrameSerializer(t *testing.T) {
+	frame := migrationcenterpb.ErrorFrame{
+		Name: "projects/p/locations/l/sources/s/errorFrames/f",
+		Violations: []*migrationcenterpb.FrameViolationEntry{
+			{Field: "machine_details.core_count", Violation: "must be positive"},
+			{Field: "machine_details.uuid", Violation: "missing"},
+		},
+		OriginalFrame: &migrationcenterpb.AssetFrame{
+			FrameData: &migrationcenterpb.AssetFrame_MachineDetails{
+				MachineDetails: &migrationcenterpb.MachineDetails{
+					MachineName: "foo",
+					CoreCount:   -1,
+				},
+			},
+			Labels: map[string]string{"key": "value"},
+		},
+		IngestionTime: timestamppb.New(time.Unix(10, 0)),
+	}
+
+	serializer := NewSerializer[*migrationcenterpb.ErrorFrame]("error_frame", EmbeddedSchema.ErrorFrameTable)
+
+	got, err := serializer(&frame)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): unexpected error: %v", &frame, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "error_frame.json", string(got)); diff != "" {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", &frame, diff)
+	}
+}
+
+// TestReportConfigSerializer tests the serialization of report configs.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestReportConfigSerializer(t *testing.T) {
+	cfg := migrationcenterpb.ReportConfig{
+		Name:        "projects/p/locations/l/reportConfigs/c",
+		CreateTime:  timestamppb.New(time.Unix(10, 0)),
+		DisplayName: "config",
+		GroupPreferencesetAssignments: []*migrationcenterpb.ReportConfig_GroupPreferenceSetAssignment{
+			{
+				Group:         "projects/p/locations/l/groups/g",
+				PreferenceSet: "projects/p/locations/l/preferenceSets/ps",
+			},
+		},
+	}
+
+	serializer := NewSerializer[*migrationcenterpb.ReportConfig]("report_config", EmbeddedSchema.ReportConfigTable)
+
+	got, err := serializer(&cfg)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): unexpected error: %v", &cfg, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "report_config.json", string(got)); diff != "" {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", &cfg, diff)
+	}
+}
+
+// TestReportSerializer tests the serialization of reports with a full view.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestReportSerializer(t *testing.T) {
+	report := migrationcenterpb.Report{
+		Name:        "projects/p/locations/l/reportConfigs/c/reports/r",
+		CreateTime:  timestamppb.New(time.Unix(10, 0)),
+		DisplayName: "report",
+		Type:        migrationcenterpb.Report_TOTAL_COST_OF_OWNERSHIP,
+		State:       migrationcenterpb.Report_SUCCEEDED,
+		Summary: &migrationcenterpb.ReportSummary{
+			AllAssetsStats: &migrationcenterpb.ReportSummary_AssetAggregateStats{
+				TotalCores:  16,
+				TotalAssets: 2,
+			},
+			GroupFindings: []*migrationcenterpb.ReportSummary_GroupFinding{
+				{
+					DisplayName: "group",
+					PreferenceSetFindings: []*migrationcenterpb.ReportSummary_GroupPreferenceSetFinding{
+						{
+							DisplayName:      "preference set",
+							MonthlyCostTotal: &money.Money{CurrencyCode: "USD", Units: 100, Nanos: 500_000_000},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	serializer := NewSerializer[*migrationcenterpb.Report]("report", EmbeddedSchema.ReportTable)
+
+	got, err := serializer(&report)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): unexpected error: %v", &report, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "report.json", string(got)); diff != "" {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", &report, diff)
+	}
+}
+
+// TestReportSummarySerializer tests the serialization of flattened report
+// summary rows.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestReportSummarySerializer(t *testing.T) {
+	row := Row{
+		"report": "projects/p/locations/l/reportConfigs/c/reports/r",
+		"group_finding": &migrationcenterpb.ReportSummary_GroupFinding{
+			DisplayName: "group",
+			AssetAggregateStats: &migrationcenterpb.ReportSummary_AssetAggregateStats{
+				TotalCores:       16,
+				TotalMemoryBytes: 1024,
+			},
+			// Preference set findings are exported as separate rows
+			PreferenceSetFindings: []*migrationcenterpb.ReportSummary_GroupPreferenceSetFinding{
+				{DisplayName: "ignored"},
+			},
+		},
+		"preference_set_finding": &migrationcenterpb.ReportSummary_GroupPreferenceSetFinding{
+			DisplayName:        "preference set",
+			MonthlyCostTotal:   &money.Money{CurrencyCode: "USD", Units: 100},
+			MonthlyCostCompute: &money.Money{CurrencyCode: "USD", Units: 60},
+			MonthlyCostStorage: &money.Money{CurrencyCode: "USD", Units: 40},
+			ComputeEngineFinding: &migrationcenterpb.ReportSummary_ComputeEngineFinding{
+				AllocatedRegions:    []string{"us-central1"},
+				AllocatedAssetCount: 2,
+				MachineSeriesAllocations: []*migrationcenterpb.ReportSummary_MachineSeriesAllocation{
+					{
+						MachineSeries:       &migrationcenterpb.MachineSeries{Code: "n2"},
+						AllocatedAssetCount: 2,
+					},
+				},
+				AllocatedDiskTypes: []migrationcenterpb.PersistentDiskType{
+					migrationcenterpb.PersistentDiskType_PERSISTENT_DISK_TYPE_SSD,
+				},
+			},
+		},
+	}
+
+	got, err := SerializeRowToBigQuery(row, "report_summary", EmbeddedSchema.ReportSummaryTable)
+	if err != nil {
+		t.Fatalf("SerializeRowToBigQuery(%+v, ...): unexpected error: %v", row, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "report_summary.json", string(got)); diff != "" {
+		t.Fatalf("SerializeRowToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", row, diff)
+	}
+}
+
+// TestSourceSerializer tests the serialization of sources.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestSourceSerializer(t *testing.T) {
+	src := migrationcenterpb.Source{
+		Name:            "projects/p/locations/l/sources/s",
+		CreateTime:      timestamppb.New(time.Unix(10, 0)),
+		DisplayName:     "rvtools",
+		Type:            migrationcenterpb.Source_SOURCE_TYPE_UPLOAD,
+		Priority:        1,
+		ErrorFrameCount: 2,
+		State:           migrationcenterpb.Source_ACTIVE,
+	}
+
+	serializer := NewSerializer[*migrationcenterpb.Source]("source", EmbeddedSchema.SourceTable)
+
+	got, err := serializer(&src)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): unexpected error: %v", &src, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "source.json", string(got)); diff != "" {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", &src, diff)
+	}
+}
+
+// TestImportJobSerializer tests the serialization of import jobs with a
+// validation report.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestImportJobSerializer(t *testing.T) {
+	job := migrationcenterpb.ImportJob{
+		Name:        "projects/p/locations/l/importJobs/j",
+		DisplayName: "job",
+		State:       migrationcenterpb.ImportJob_IMPORT_JOB_STATE_FAILED_VALIDATION,
+		Labels:      map[string]string{"team": "infra"},
+		AssetSource: "projects/p/locations/l/sources/s",
+		Report: &migrationcenterpb.ImportJob_ValidationReport{
+			ValidationReport: &migrationcenterpb.ValidationReport{
+				FileValidations: []*migrationcenterpb.FileValidationReport{
+					{
+						FileName:      "vms.csv",
+						PartialReport: true,
+						RowErrors: []*migrationcenterpb.ImportRowError{
+							{
+								RowNumber: 2,
+								VmName:    "vm",
+								Errors: []*migrationcenterpb.ImportError{
+									{
+										ErrorDetails: "invalid core count",
+										Severity:     migrationcenterpb.ImportError_ERROR,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	serializer := NewSerializer[*migrationcenterpb.ImportJob]("import_job", EmbeddedSchema.ImportJobTable)
+
+	got, err := serializer(&job)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): unexpected error: %v", &job, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "import_job.json", string(got)); diff != "" {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", &job, diff)
+	}
+}
+
+// TestImportDataFileSerializer tests the serialization of import data files.
+// The tests uses a golden output file.
+// To update it run: go test -test.generate-golden-files $PWD
+func TestImportDataFileSerializer(t *testing.T) {
+	file := migrationcenterpb.ImportDataFile{
+		Name:        "projects/p/locations/l/importJobs/j/importDataFiles/f",
+		DisplayName: "vms.csv",
+		Format:      migrationcenterpb.ImportJobFormat_IMPORT_JOB_FORMAT_RVTOOLS_CSV,
+		CreateTime:  timestamppb.New(time.Unix(10, 0)),
+		State:       migrationcenterpb.ImportDataFile_ACTIVE,
+	}
+
+	serializer := NewSerializer[*migrationcenterpb.ImportDataFile]("import_data_file", EmbeddedSchema.ImportDataFileTable)
+
+	got, err := serializer(&file)
+	if err != nil {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): unexpected error: %v", &file, err)
+	}
+
+	got = prettyPrintJSON(got)
+	if diff := golden.Compare(t, "import_data_file.json", string(got)); diff != "" {
+		t.Fatalf("SerializeObjectToBigQuery(%+v, ...): mismatch (-want, +got):\n%s", &file, diff)
+	}
+}
+
 // TestMarshalSchema tests the MarshalJSON override
 func TestMarshalSchema(t *testing.T) {
 	s := ExporterSchema{
@@ -90,6 +347,29 @@ func TestMarshalSchema(t *testing.T) {
 		PreferenceSetTable: bigquery.Schema{
 			{Name: "Foo", Type: bigquery.BooleanFieldType},
 		},
+		ErrorFrameTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.TimestampFieldType},
+		},
+		ReportConfigTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.FloatFieldType},
+		},
+		ReportTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+				{Name: "Bar", Type: bigquery.StringFieldType},
+			}},
+		},
+		ReportSummaryTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.StringFieldType, Repeated: true},
+		},
+		SourceTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.IntegerFieldType},
+		},
+		ImportJobTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.StringFieldType},
+		},
+		ImportDataFileTable: bigquery.Schema{
+			{Name: "Foo", Type: bigquery.TimestampFieldType},
+		},
 	}
 
 	got, err := json.MarshalIndent(&s, "", "  ")
@@ -131,6 +411,39 @@ func TestMinimalSchema(t *testing.T) {
 	}
 }
 
+// TestBasicAssetView checks that only the fields that are returned by the
+// full view are removed from the asset table.
+func TestBasicAssetView(t *testing.T) {
+	s := ExporterSchema{
+		AssetTable: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "insight_list", Type: bigquery.RecordFieldType},
+			{Name: "machine_details", Type: bigquery.RecordFieldType},
+			{Name: "performance_data", Type: bigquery.RecordFieldType},
+		},
+		GroupTable: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+		},
+	}
+	want := ExporterSchema{
+		AssetTable: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+			{Name: "machine_details", Type: bigquery.RecordFieldType},
+		},
+		GroupTable: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+		},
+	}
+
+	got := s.BasicAssetView()
+	if diff := cmp.Diff(want, *got); diff != "" {
+		t.Errorf("BasicAssetView(): unexpected schema (-want, +got):\n%s", diff)
+	}
+	if len(s.AssetTable) != 4 {
+		t.Errorf("BasicAssetView(): modified the original schema")
+	}
+}
+
 func prettyPrintJSON(buf []byte) []byte {
 	var tmp map[string]any
 	err := json.Unmarshal(buf, &tmp)
@@ -158,6 +471,13 @@ func ensureTypeSetCoverage(t testing.TB, testSchema bigquery.Schema) {
 	calculateTypeSet(EmbeddedSchema.AssetTable, embeddedTypeSet)
 	calculateTypeSet(EmbeddedSchema.GroupTable, embeddedTypeSet)
 	calculateTypeSet(EmbeddedSchema.PreferenceSetTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.ErrorFrameTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.ReportConfigTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.ReportTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.ReportSummaryTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.SourceTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.ImportJobTable, embeddedTypeSet)
+	calculateTypeSet(EmbeddedSchema.ImportDataFileTable, embeddedTypeSet)
 
 	coveredTypeSet := map[bigquery.FieldType]bool{}
 	calculateTypeSet(testSchema, coveredTypeSet)
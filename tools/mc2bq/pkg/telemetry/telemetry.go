@@ -0,0 +1,249 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package telemetry instruments the export with OpenTelemetry spans and
+// metrics. The instrumentation uses the global providers, which don't record
+// anything until Setup installs exporters.
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq/pkg/messages"
+)
+
+// instrumentationName is the name of the tracer and the meter.
+const instrumentationName = "github.com/GoogleCloudPlatform/migrationcenter-utils/tools/mc2bq"
+
+// Attributes of the spans and metrics.
+const (
+	AttrTable      = attribute.Key("mc2bq.table")
+	AttrProjectID  = attribute.Key("mc2bq.project_id")
+	AttrLocation   = attribute.Key("mc2bq.location")
+	AttrObjectType = attribute.Key("mc2bq.object_type")
+	AttrMethod     = attribute.Key("mc2bq.method")
+	AttrRecords    = attribute.Key("mc2bq.records")
+	AttrBytes      = attribute.Key("mc2bq.bytes")
+)
+
+// Exporter is where the spans or the metrics are sent.
+type Exporter string
+
+const (
+	// ExporterNone doesn't send anything.
+	ExporterNone Exporter = "none"
+	// ExporterStdout writes JSON to Options.Writer.
+	ExporterStdout Exporter = "stdout"
+	// ExporterOTLP sends OTLP over gRPC, it's configured with the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	ExporterOTLP Exporter = "otlp"
+)
+
+// ParseExporter parses the name of an exporter, the empty string is
+// ExporterNone.
+func ParseExporter(name string) (Exporter, error) {
+	if name == "" {
+		return ExporterNone, nil
+	}
+	exporter := Exporter(strings.ToLower(name))
+	switch exporter {
+	case ExporterNone, ExporterStdout, ExporterOTLP:
+		return exporter, nil
+	}
+
+	return "", messages.NewError(messages.InvalidTelemetryExporter{Exporter: name})
+}
+
+// Options selects the exporters of the spans and the metrics.
+type Options struct {
+	Traces  Exporter
+	Metrics Exporter
+	// Writer is where ExporterStdout writes, os.Stdout is used if it's
+	// nil.
+	Writer io.Writer
+}
+
+// Setup installs the global tracer and meter providers that send to the
+// exporters of opts, the global providers are left alone for ExporterNone.
+// The returned function flushes and stops the providers.
+func Setup(ctx context.Context, opts Options) (shutdown func(ctx context.Context) error, err error) {
+	w := opts.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	var shutdowns []func(ctx context.Context) error
+	shutdown = func(ctx context.Context) error {
+		var firstErr error
+		for _, fn := range shutdowns {
+			err := fn(ctx)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "mc2bq"),
+		attribute.String("service.version", messages.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var spanExporter sdktrace.SpanExporter
+	switch opts.Traces {
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		spanExporter, err = otlptracegrpc.New(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if spanExporter != nil {
+		tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(spanExporter), sdktrace.WithResource(res))
+		otel.SetTracerProvider(tp)
+		shutdowns = append(shutdowns, tp.Shutdown)
+	}
+
+	var metricExporter sdkmetric.Exporter
+	switch opts.Metrics {
+	case ExporterStdout:
+		metricExporter, err = stdoutmetric.New(stdoutmetric.WithEncoder(json.NewEncoder(w)))
+	case ExporterOTLP:
+		metricExporter, err = otlpmetricgrpc.New(ctx)
+	}
+	if err != nil {
+		_ = shutdown(ctx)
+		return nil, err
+	}
+	if metricExporter != nil {
+		mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)), sdkmetric.WithResource(res))
+		otel.SetMeterProvider(mp)
+		shutdowns = append(shutdowns, mp.Shutdown)
+	}
+
+	return shutdown, nil
+}
+
+// Start starts a span of the export, it must be ended with End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, the span fails if err isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// instruments are the metrics of the export, they are created from the
+// global meter provider.
+type instruments struct {
+	provider      metric.MeterProvider
+	objectsRead   metric.Int64Counter
+	bytesRead     metric.Int64Counter
+	serialization metric.Float64Histogram
+	retries       metric.Int64Counter
+}
+
+var current atomic.Pointer[instruments]
+
+// meters returns the instruments of the global meter provider, they are
+// created again when the provider changes.
+func meters() *instruments {
+	mp := otel.GetMeterProvider()
+	if m := current.Load(); m != nil && m.provider == mp {
+		return m
+	}
+
+	m, err := newInstruments(mp)
+	if err != nil {
+		otel.Handle(err)
+		m, _ = newInstruments(noop.NewMeterProvider())
+		m.provider = mp
+	}
+	current.Store(m)
+	return m
+}
+
+func newInstruments(mp metric.MeterProvider) (*instruments, error) {
+	meter := mp.Meter(instrumentationName, metric.WithInstrumentationVersion(messages.Version))
+	m := &instruments{provider: mp}
+	var err error
+	m.objectsRead, err = meter.Int64Counter("mc2bq.objects.read",
+		metric.WithDescription("Number of objects read from Migration Center."),
+		metric.WithUnit("{object}"))
+	if err != nil {
+		return nil, err
+	}
+	m.bytesRead, err = meter.Int64Counter("mc2bq.bytes.read",
+		metric.WithDescription("Size of the serialized objects read from Migration Center."),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+	m.serialization, err = meter.Float64Histogram("mc2bq.serialization.duration",
+		metric.WithDescription("Time to serialize an object to a row."),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	m.retries, err = meter.Int64Counter("mc2bq.retries",
+		metric.WithDescription("Number of Migration Center calls that were retried."),
+		metric.WithUnit("{retry}"))
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RecordObject records that an object of objectType was read and serialized
+// to size bytes in serialization.
+func RecordObject(ctx context.Context, objectType attribute.Set, size int, serialization time.Duration) {
+	m := meters()
+	attrs := metric.WithAttributeSet(objectType)
+	m.objectsRead.Add(ctx, 1, attrs)
+	m.bytesRead.Add(ctx, int64(size), attrs)
+	m.serialization.Record(ctx, serialization.Seconds(), attrs)
+}
+
+// RecordRetry records that a call to method is retried.
+func RecordRetry(ctx context.Context, method string) {
+	meters().retries.Add(ctx, 1, metric.WithAttributes(AttrMethod.String(method)))
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 Google LLC All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestParseExporter(t *testing.T) {
+	for name, want := range map[string]Exporter{"": ExporterNone, "none": ExporterNone, "STDOUT": ExporterStdout, "otlp": ExporterOTLP} {
+		got, err := ParseExporter(name)
+		if err != nil || got != want {
+			t.Errorf("ParseExporter(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseExporter("jaeger"); err == nil {
+		t.Errorf("ParseExporter(%q): expected an error", "jaeger")
+	}
+}
+
+func TestSetupStdout(t *testing.T) {
+	tp, mp := otel.GetTracerProvider(), otel.GetMeterProvider()
+	defer func() {
+		otel.SetTracerProvider(tp)
+		otel.SetMeterProvider(mp)
+	}()
+
+	ctx := context.Background()
+	var out bytes.Buffer
+	shutdown, err := Setup(ctx, Options{Traces: ExporterStdout, Metrics: ExporterStdout, Writer: &out})
+	if err != nil {
+		t.Fatalf("Setup(): unexpected error: %v", err)
+	}
+	_, span := Start(ctx, "mc2bq.table", AttrTable.String("assets"))
+	RecordObject(ctx, attribute.NewSet(AttrObjectType.String("asset")), 10, time.Millisecond)
+	RecordRetry(ctx, "ListAssets")
+	End(span, errors.New("failed"))
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("shutdown(): unexpected error: %v", err)
+	}
+
+	for _, want := range []string{`"mc2bq.table"`, `"failed"`, `"mc2bq.objects.read"`, `"mc2bq.bytes.read"`, `"mc2bq.serialization.duration"`, `"mc2bq.retries"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Setup() with stdout exporters wrote %q, want it to contain %s", out.String(), want)
+		}
+	}
+}
+
+func TestSetupNone(t *testing.T) {
+	tp, mp := otel.GetTracerProvider(), otel.GetMeterProvider()
+	shutdown, err := Setup(context.Background(), Options{Traces: ExporterNone, Metrics: ExporterNone})
+	if err != nil {
+		t.Fatalf("Setup(): unexpected error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown(): unexpected error: %v", err)
+	}
+	if otel.GetTracerProvider() != tp || otel.GetMeterProvider() != mp {
+		t.Errorf("Setup() with no exporters replaced the global providers")
+	}
+}